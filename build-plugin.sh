@@ -37,7 +37,7 @@ echo "Go version: $GO_VERSION"
 
 # Build the plugin
 cd "$MODULE_DIR/handlers"
-go build -buildmode=plugin -ldflags "-X main.Platform=$OS -X main.Arch=$ARCH -X main.GoVersion=$GO_VERSION" -o "$OUTPUT_DIR/$PLUGIN_NAME" plugin.go sales_handler.go sales_handler_wrapper.go
+go build -buildmode=plugin -ldflags "-X main.Platform=$OS -X main.Arch=$ARCH -X main.GoVersion=$GO_VERSION" -o "$OUTPUT_DIR/$PLUGIN_NAME" *.go
 
 if [ $? -eq 0 ]; then
     echo "✓ Plugin built successfully: $OUTPUT_DIR/$PLUGIN_NAME"
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+// hostRequestIDKey is the request context key under which the host ERP passes the ID
+// of the request it is serving. Requests without one fall back to the X-Request-ID header.
+const hostRequestIDKey = "request_id"
+
+// requestIDSetting is the PostgreSQL setting the audit triggers record as the request
+// that made a change (migration 000012)
+const requestIDSetting = "app.request_id"
+
+// AuditEntry is one recorded change to a document or one of its lines
+type AuditEntry struct {
+	ID           int64                  `json:"id"`
+	DocumentType string                 `json:"document_type"`
+	DocumentID   int                    `json:"document_id"`
+	TableName    string                 `json:"table_name"`
+	RowID        int                    `json:"row_id"`
+	Action       string                 `json:"action"`
+	Changes      map[string]FieldChange `json:"changes"`
+	ActorID      *int                   `json:"actor_id"`
+	RequestID    *string                `json:"request_id"`
+	ChangedAt    time.Time              `json:"changed_at"`
+}
+
+// FieldChange holds the values of a field before and after a change; Before is null for
+// created rows and After for deleted ones
+type FieldChange struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// auditedDocument names a document type in the audit log and the table of its headers.
+// repColumn is the sales rep column of documents under the data scope, "" for the others.
+type auditedDocument struct {
+	documentType string
+	table        string
+	notFound     string
+	repColumn    string
+}
+
+var (
+	auditedOrder      = auditedDocument{"order", "sales_orders", "Sales order not found", "sales_rep_id"}
+	auditedQuote      = auditedDocument{"quote", "sales_quotes", "Sales quote not found", "sales_rep_id"}
+	auditedInvoice    = auditedDocument{"invoice", "sales_invoices", "Sales invoice not found", ""}
+	auditedPayment    = auditedDocument{"payment", "sales_payments", "Sales payment not found", ""}
+	auditedReturn     = auditedDocument{"return", "sales_returns", "Sales return not found", ""}
+	auditedCreditNote = auditedDocument{"credit_note", "sales_credit_notes", "Credit note not found", ""}
+)
+
+// requestID returns the ID of the request as passed by the host, or as sent by the client
+func requestID(r *http.Request) string {
+	if id, ok := r.Context().Value(hostRequestIDKey).(string); ok && id != "" {
+		return id
+	}
+	return r.Header.Get("X-Request-ID")
+}
+
+// documentHistory writes the audit trail of a document and its lines, oldest change first
+func (h *SalesHandler) documentHistory(w http.ResponseWriter, r *http.Request, doc auditedDocument) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid document ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch document history")
+		return
+	}
+	defer tx.Rollback()
+
+	var scopeCondition string
+	var scopeArgs []interface{}
+	if doc.repColumn != "" {
+		scopeCondition, scopeArgs = h.requestDataScope(tx, r).condition(doc.repColumn, 3)
+	}
+
+	var found int
+	err = tx.QueryRow(fmt.Sprintf("SELECT 1 FROM %s WHERE id = $1 AND tenant_id = $2", doc.table)+scopeCondition,
+		append([]interface{}{id, tenantID}, scopeArgs...)...).Scan(&found)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, doc.notFound)
+			return
+		}
+		h.logger.Error("Failed to fetch document", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch document history")
+		return
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, document_type, document_id, table_name, row_id, action, changes,
+		       actor_id, request_id, changed_at
+		FROM sales_audit_log
+		WHERE tenant_id = $1 AND document_type = $2 AND document_id = $3
+		ORDER BY id
+	`, tenantID, doc.documentType, id)
+	if err != nil {
+		h.logger.Error("Failed to fetch document history", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch document history")
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var changes []byte
+
+		err := rows.Scan(&entry.ID, &entry.DocumentType, &entry.DocumentID, &entry.TableName,
+			&entry.RowID, &entry.Action, &changes, &entry.ActorID, &entry.RequestID, &entry.ChangedAt)
+		if err != nil {
+			h.logger.Error("Failed to scan audit entry", zap.Error(err))
+			continue
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			h.logger.Error("Failed to decode audit entry changes", zap.Error(err))
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"history": entries,
+		"count":   len(entries),
+	})
+}
+
+// GetSalesOrderHistory retrieves the audit trail of a sales order
+func (h *SalesHandler) GetSalesOrderHistory(w http.ResponseWriter, r *http.Request) {
+	h.documentHistory(w, r, auditedOrder)
+}
+
+// GetSalesQuoteHistory retrieves the audit trail of a sales quote
+func (h *SalesHandler) GetSalesQuoteHistory(w http.ResponseWriter, r *http.Request) {
+	h.documentHistory(w, r, auditedQuote)
+}
+
+// GetSalesInvoiceHistory retrieves the audit trail of a sales invoice
+func (h *SalesHandler) GetSalesInvoiceHistory(w http.ResponseWriter, r *http.Request) {
+	h.documentHistory(w, r, auditedInvoice)
+}
+
+// GetSalesPaymentHistory retrieves the audit trail of a payment and its allocations
+func (h *SalesHandler) GetSalesPaymentHistory(w http.ResponseWriter, r *http.Request) {
+	h.documentHistory(w, r, auditedPayment)
+}
+
+// GetSalesReturnHistory retrieves the audit trail of a sales return
+func (h *SalesHandler) GetSalesReturnHistory(w http.ResponseWriter, r *http.Request) {
+	h.documentHistory(w, r, auditedReturn)
+}
+
+// GetCreditNoteHistory retrieves the audit trail of a credit note
+func (h *SalesHandler) GetCreditNoteHistory(w http.ResponseWriter, r *http.Request) {
+	h.documentHistory(w, r, auditedCreditNote)
+}
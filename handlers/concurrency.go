@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+// Orders, quotes, invoices and price lists carry a version, raised by a trigger on every
+// change to their row. GET responses return it as the ETag; updates must send it back in
+// If-Match, and are refused with 412 when the document changed since it was read.
+
+// versionedRoutes are the updates that require If-Match, keyed by "METHOD /pattern", with
+// the document whose ETag they must send. The route table refuses requests to them without
+// one; the handlers compare it with the version they lock.
+var versionedRoutes = map[string]string{
+	"PUT /orders/{id}":                     "sales order",
+	"PUT /quotes/{id}":                     "sales quote",
+	"PUT /invoices/{id}":                   "sales invoice",
+	"POST /invoices/{id}/items":            "sales invoice",
+	"PUT /invoices/{id}/items/{itemId}":    "sales invoice",
+	"DELETE /invoices/{id}/items/{itemId}": "sales invoice",
+	"PUT /price-lists/{id}":                "price list",
+	"DELETE /price-lists/{id}":             "price list",
+}
+
+// etag is the entity tag of a document version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag sets the ETag of the response to the document version
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// requireIfMatch refuses requests to handler without an If-Match header with 428
+func requireIfMatch(document string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if requestIfMatch(r) == "" {
+			writeError(w, http.StatusPreconditionRequired,
+				fmt.Sprintf("If-Match is required: send the ETag of the %s being updated", document))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requestIfMatch returns the If-Match header of r
+func requestIfMatch(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("If-Match"))
+}
+
+// ifMatches reports whether an If-Match header, "*" or a list of entity tags, names
+// version. Weak tags never match, as If-Match compares strongly.
+func ifMatches(ifMatch string, version int) bool {
+	current := etag(version)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// lockVersion locks row id of table for the rest of tx and returns its version. table is
+// always a constant.
+func lockVersion(tx *sqlx.Tx, table, tenantID string, id int) (int, error) {
+	var version int
+	err := tx.QueryRow("SELECT version FROM "+table+" WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+		id, tenantID).Scan(&version)
+	return version, err
+}
+
+// lockScopedVersion is lockVersion for an order or quote, finding it only within scope
+func lockScopedVersion(tx *sqlx.Tx, table string, scope dataScope, id int) (int, error) {
+	scopeCondition, scopeArgs := scope.condition("sales_rep_id", 3)
+	var version int
+	err := tx.QueryRow("SELECT version FROM "+table+" WHERE id = $1 AND tenant_id = $2"+scopeCondition+" FOR UPDATE",
+		append([]interface{}{id, scope.tenantID}, scopeArgs...)...).Scan(&version)
+	return version, err
+}
+
+// matchInvoiceVersion locks invoice id and checks its version against the If-Match header
+// of r. When they differ it refuses the update with 412 and the current invoice, and on
+// failure writes 500 with failure; either way it returns false.
+func (h *SalesHandler) matchInvoiceVersion(w http.ResponseWriter, r *http.Request, tx *sqlx.Tx, id int, failure string) bool {
+	tenantID := requestTenant(r)
+
+	version, err := lockVersion(tx, "sales_invoices", tenantID, id)
+	if err == nil && !ifMatches(requestIfMatch(r), version) {
+		var invoice SalesInvoice
+		if invoice, err = fetchSalesInvoice(tx, tenantID, id); err == nil {
+			writePreconditionFailed(w, version, invoice)
+			return false
+		}
+	}
+	if err != nil {
+		h.logger.Error("Failed to fetch sales invoice version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, failure)
+		return false
+	}
+	return true
+}
+
+// writePreconditionFailed refuses an update made against a stale version with 412, sending
+// current, the document as it is now, with its ETag
+func writePreconditionFailed(w http.ResponseWriter, version int, current interface{}) {
+	setETag(w, version)
+	sdk.WriteJSON(w, http.StatusPreconditionFailed, current)
+}
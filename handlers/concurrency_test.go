@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIfMatches(t *testing.T) {
+	tests := []struct {
+		ifMatch string
+		want    bool
+	}{
+		{`"3"`, true},
+		{`"2", "3"`, true},
+		{`*`, true},
+		{`"2"`, false},
+		{`W/"3"`, false},
+		{`3`, false},
+	}
+	for _, tt := range tests {
+		if got := ifMatches(tt.ifMatch, 3); got != tt.want {
+			t.Errorf("ifMatches(%q, 3) = %v, want %v", tt.ifMatch, got, tt.want)
+		}
+	}
+}
+
+func TestUpdatesRequireIfMatch(t *testing.T) {
+	p := testPlugin()
+
+	for _, tc := range routePermissions {
+		if _, ok := versionedRoutes[tc.pattern]; !ok {
+			continue
+		}
+		handler, err := p.GetHandler(tc.path, tc.method)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.method, tc.path, err)
+		}
+
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"notes":"x"}`)).
+			WithContext(hostContext([]string{tc.permission}))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != http.StatusPreconditionRequired {
+			t.Errorf("%s %s without If-Match: status = %d, want %d", tc.method, tc.path, rec.Code,
+				http.StatusPreconditionRequired)
+		}
+	}
+}
+
+// scriptVersionedDocuments gives the caller's tenant order 7, draft quote 8, draft invoice
+// 9 and price list 10, each at version
+func scriptVersionedDocuments(db *scriptDB, version int64) {
+	customer := []string{"first_name", "last_name", "company_name", "email", "phone"}
+	db.returns("FROM sales_orders so", strings.Join(columnNames(orderColumns, append(customer, "rep_first_name", "rep_last_name")...), " "),
+		append(documentRow(orderColumns, map[string]driver.Value{"id": int64(7), "status": "pending", "version": version}),
+			nil, nil, nil, nil, nil, nil, nil))
+	db.returns("FROM sales_invoices si", strings.Join(columnNames(invoiceColumns, customer...), " "),
+		append(documentRow(invoiceColumns, map[string]driver.Value{"id": int64(9), "version": version}),
+			nil, nil, nil, nil, nil))
+	db.returns("FROM sales_quotes sq", strings.Join(columnNames(quoteColumns, quoteExtra...), " "),
+		append(documentRow(quoteColumns, map[string]driver.Value{"id": int64(8), "version": version}),
+			nil, nil, nil, nil, nil, nil))
+	db.returns("SELECT status FROM sales_invoices", "status", []driver.Value{"draft"})
+	db.returns("FROM price_lists pl", strings.Join(priceListColumnNames, " "), priceListRow(10, version))
+	for _, table := range []string{"sales_orders", "sales_quotes", "sales_invoices", "price_lists"} {
+		db.returns("SELECT version FROM "+table, "version", []driver.Value{version})
+	}
+}
+
+// versionedUpdates are requests to each route of versionedRoutes, to the documents of
+// scriptVersionedDocuments
+var versionedUpdates = []struct {
+	pattern, method, path, body string
+}{
+	{"PUT /orders/{id}", "PUT", "/orders/7", `{"notes":"later"}`},
+	{"PUT /quotes/{id}", "PUT", "/quotes/8", `{"notes":"later"}`},
+	{"PUT /invoices/{id}", "PUT", "/invoices/9", `{"notes":"later"}`},
+	{"POST /invoices/{id}/items", "POST", "/invoices/9/items", `{"product_id":5,"quantity":1,"unit_price":10}`},
+	{"PUT /invoices/{id}/items/{itemId}", "PUT", "/invoices/9/items/3", `{"quantity":2}`},
+	{"DELETE /invoices/{id}/items/{itemId}", "DELETE", "/invoices/9/items/3", ""},
+	{"PUT /price-lists/{id}", "PUT", "/price-lists/10", `{"name":"Retail"}`},
+	{"DELETE /price-lists/{id}", "DELETE", "/price-lists/10", ""},
+}
+
+func TestVersionedUpdatesCoverVersionedRoutes(t *testing.T) {
+	covered := map[string]bool{}
+	for _, u := range versionedUpdates {
+		if _, ok := versionedRoutes[u.pattern]; !ok {
+			t.Errorf("%s is not a versioned route", u.pattern)
+		}
+		covered[u.pattern] = true
+	}
+	for key := range versionedRoutes {
+		if !covered[key] {
+			t.Errorf("%s has no request in versionedUpdates", key)
+		}
+	}
+}
+
+func TestStaleIfMatchIsRefused(t *testing.T) {
+	for _, u := range versionedUpdates {
+		t.Run(u.method+" "+u.path, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptVersionedDocuments(db, 2)
+
+			req := callerRequest(u.method, u.path, u.body)
+			req.Header.Set("If-Match", `"1"`)
+			rec := serve(t, db.plugin(), req)
+			if rec.Code != http.StatusPreconditionFailed {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusPreconditionFailed, rec.Body.String())
+			}
+			if got := rec.Header().Get("ETag"); got != `"2"` {
+				t.Errorf("ETag = %s, want the current version \"2\"", got)
+			}
+			var current struct {
+				Version int `json:"version"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &current); err != nil || current.Version != 2 {
+				t.Errorf("body = %s, want the current document", rec.Body.String())
+			}
+			for _, st := range db.log {
+				verb := strings.Fields(st.query)[0]
+				if verb == "INSERT" || verb == "UPDATE" || verb == "DELETE" {
+					t.Errorf("wrote to the database: %q", st.query)
+				}
+			}
+			if db.commits != 0 {
+				t.Errorf("commits = %d, want 0", db.commits)
+			}
+		})
+	}
+}
+
+func TestGetReturnsETag(t *testing.T) {
+	for _, path := range []string{"/orders/7", "/quotes/8", "/invoices/9", "/price-lists/10"} {
+		db := &scriptDB{}
+		scriptVersionedDocuments(db, 4)
+
+		rec := serve(t, db.plugin(), callerRequest("GET", path, ""))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status = %d, want %d: %s", path, rec.Code, http.StatusOK, rec.Body.String())
+			continue
+		}
+		if got := rec.Header().Get("ETag"); got != `"4"` {
+			t.Errorf("GET %s: ETag = %s, want \"4\"", path, got)
+		}
+	}
+}
+
+func TestCurrentIfMatchUpdates(t *testing.T) {
+	for _, u := range []struct{ method, path, body, write string }{
+		{"PUT", "/orders/7", `{"notes":"later"}`, "UPDATE sales_orders"},
+		{"PUT", "/quotes/8", `{"notes":"later"}`, "UPDATE sales_quotes"},
+		{"PUT", "/invoices/9", `{"notes":"later"}`, "UPDATE sales_invoices"},
+		{"PUT", "/price-lists/10", `{"name":"Retail"}`, "UPDATE price_lists"},
+	} {
+		db := &scriptDB{}
+		scriptVersionedDocuments(db, 2)
+
+		req := callerRequest(u.method, u.path, u.body)
+		req.Header.Set("If-Match", `"2"`)
+		rec := serve(t, db.plugin(), req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s %s: status = %d, want %d: %s", u.method, u.path, rec.Code, http.StatusOK, rec.Body.String())
+			continue
+		}
+		if len(db.ran(u.write)) != 1 || db.commits != 1 {
+			t.Errorf("%s %s: ran %q %d times with %d commits, want once with one commit", u.method, u.path,
+				u.write, len(db.ran(u.write)), db.commits)
+		}
+		if got := rec.Header().Get("ETag"); got != `"2"` {
+			t.Errorf("%s %s: ETag = %s, want the new version", u.method, u.path, got)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+type CustomerCreditEntry struct {
+	ID              int       `json:"id"`
+	CustomerID      int       `json:"customer_id"`
+	EntryType       string    `json:"entry_type"`
+	Amount          Decimal   `json:"amount"`
+	Currency        string    `json:"currency"`
+	PaymentID       *int      `json:"payment_id"`
+	CreditNoteID    *int      `json:"credit_note_id"`
+	InvoiceID       *int      `json:"invoice_id"`
+	RefundMethod    *string   `json:"refund_method"`
+	ReferenceNumber *string   `json:"reference_number"`
+	Notes           *string   `json:"notes"`
+	CreatedBy       int       `json:"created_by"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type CustomerCreditBalance struct {
+	Currency string  `json:"currency"`
+	Balance  Decimal `json:"balance"`
+}
+
+// creditSource is an amount of customer credit taken from one payment or credit note.
+// Exactly one of paymentID and creditNoteID is set.
+type creditSource struct {
+	paymentID    int
+	creditNoteID int
+	amount       Decimal
+}
+
+// ledgerEntry builds the ledger entry that consumes amount from this source
+func (s creditSource) ledgerEntry(entry CustomerCreditEntry, amount Decimal) CustomerCreditEntry {
+	entry.Amount = amount.Neg()
+	if s.paymentID != 0 {
+		paymentID := s.paymentID
+		entry.PaymentID = &paymentID
+	} else {
+		creditNoteID := s.creditNoteID
+		entry.CreditNoteID = &creditNoteID
+	}
+	return entry
+}
+
+// recordCreditEntry appends an entry to the tenant's customer credit ledger
+func recordCreditEntry(tx *sqlx.Tx, tenantID string, entry CustomerCreditEntry) error {
+	_, err := tx.Exec(`
+		INSERT INTO sales_customer_credit_ledger (tenant_id, customer_id, entry_type, amount, currency, payment_id,
+		                                          credit_note_id, invoice_id, refund_method, reference_number,
+		                                          notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, tenantID, entry.CustomerID, entry.EntryType, roundMoney(entry.Amount, entry.Currency), entry.Currency, entry.PaymentID,
+		entry.CreditNoteID, entry.InvoiceID, entry.RefundMethod, entry.ReferenceNumber, entry.Notes,
+		entry.CreatedBy)
+	return err
+}
+
+// customerCreditBalance returns the customer's available credit in one currency
+func customerCreditBalance(tx *sqlx.Tx, tenantID string, customerID int, currency string) (Decimal, error) {
+	// Serialise credit consumption per customer so concurrent refunds cannot overdraw
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1), $2)", tenantID, customerID); err != nil {
+		return Decimal{}, err
+	}
+
+	var balance Decimal
+	err := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0)
+		FROM sales_customer_credit_ledger
+		WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3
+	`, tenantID, customerID, currency).Scan(&balance)
+	return balance, err
+}
+
+// consumeCustomerCredit takes amount out of the unapplied balances of the customer's
+// payments and then credit notes, oldest first, and reports which documents funded it.
+func consumeCustomerCredit(tx *sqlx.Tx, tenantID string, customerID int, currency string, amount Decimal) ([]creditSource, error) {
+	rows, err := tx.Query(`
+		SELECT id, 0, unapplied_amount
+		FROM sales_payments
+		WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3 AND unapplied_amount > 0
+		UNION ALL
+		SELECT 0, id, unapplied_amount
+		FROM sales_credit_notes
+		WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3 AND unapplied_amount > 0
+		ORDER BY 2, 1
+	`, tenantID, customerID, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []creditSource
+	remaining := amount
+	for rows.Next() && remaining.Sign() > 0 {
+		var source creditSource
+		var unapplied Decimal
+		if err := rows.Scan(&source.paymentID, &source.creditNoteID, &unapplied); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		source.amount = minDecimal(unapplied, remaining)
+		sources = append(sources, source)
+		remaining = remaining.Sub(source.amount)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if remaining.Sign() > 0 {
+		return nil, &requestError{http.StatusConflict,
+			fmt.Sprintf("Insufficient customer credit: %.2f short", remaining)}
+	}
+
+	// The customer's advisory lock taken by customerCreditBalance keeps these balances
+	// stable, and the check constraints reject any update that would overdraw them.
+	for _, source := range sources {
+		if source.paymentID != 0 {
+			_, err = tx.Exec(`
+				UPDATE sales_payments SET unapplied_amount = unapplied_amount - $1
+				WHERE id = $2 AND tenant_id = $3
+			`, source.amount, source.paymentID, tenantID)
+		} else {
+			_, err = tx.Exec(`
+				UPDATE sales_credit_notes SET unapplied_amount = unapplied_amount - $1
+				WHERE id = $2 AND tenant_id = $3
+			`, source.amount, source.creditNoteID, tenantID)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sources, nil
+}
+
+// applyCreditSource settles amount of an invoice from one consumed credit source
+func applyCreditSource(tx *sqlx.Tx, tenantID string, source creditSource, customerID int, currency string, alloc paymentAllocationRequest, userID int) error {
+	if source.paymentID != 0 {
+		return allocatePayment(tx, tenantID, source.paymentID, customerID, currency, alloc, userID)
+	}
+
+	if err := lockInvoiceForSettlement(tx, tenantID, alloc.InvoiceID, customerID, currency, alloc.Amount); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE sales_credit_notes SET applied_amount = applied_amount + $1 WHERE id = $2 AND tenant_id = $3",
+		alloc.Amount, source.creditNoteID, tenantID)
+	if err != nil {
+		return err
+	}
+	return settleInvoice(tx, tenantID, alloc.InvoiceID, Decimal{}, alloc.Amount)
+}
+
+// Customer Credit Handlers
+
+// GetCustomerCredit returns a customer's credit balances and ledger history
+func (h *SalesHandler) GetCustomerCredit(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	customerID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid customer ID")
+		return
+	}
+
+	limit := r.URL.Query().Get("limit")
+	if limit == "" {
+		limit = "50"
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch customer credit")
+		return
+	}
+	defer tx.Rollback()
+
+	balanceRows, err := tx.Query(`
+		SELECT currency, SUM(amount)
+		FROM sales_customer_credit_ledger
+		WHERE tenant_id = $1 AND customer_id = $2
+		GROUP BY currency
+		ORDER BY currency
+	`, tenantID, customerID)
+	if err != nil {
+		h.logger.Error("Failed to fetch customer credit balance", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch customer credit")
+		return
+	}
+	defer balanceRows.Close()
+
+	balances := []CustomerCreditBalance{}
+	for balanceRows.Next() {
+		var balance CustomerCreditBalance
+		if err := balanceRows.Scan(&balance.Currency, &balance.Balance); err != nil {
+			continue
+		}
+		balances = append(balances, balance)
+	}
+
+	entryRows, err := tx.Query(`
+		SELECT id, customer_id, entry_type, amount, currency, payment_id, credit_note_id, invoice_id,
+		       refund_method, reference_number, notes, created_by, created_at
+		FROM sales_customer_credit_ledger
+		WHERE tenant_id = $1 AND customer_id = $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3
+	`, tenantID, customerID, limit)
+	if err != nil {
+		h.logger.Error("Failed to fetch customer credit ledger", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch customer credit")
+		return
+	}
+	defer entryRows.Close()
+
+	var entries []CustomerCreditEntry
+	for entryRows.Next() {
+		var entry CustomerCreditEntry
+		err := entryRows.Scan(&entry.ID, &entry.CustomerID, &entry.EntryType, &entry.Amount,
+			&entry.Currency, &entry.PaymentID, &entry.CreditNoteID, &entry.InvoiceID, &entry.RefundMethod,
+			&entry.ReferenceNumber, &entry.Notes, &entry.CreatedBy, &entry.CreatedAt)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"customer_id": customerID,
+		"balances":    balances,
+		"entries":     entries,
+	})
+}
+
+// createCustomerRefundRequest is the body of CreateCustomerRefund
+type createCustomerRefundRequest struct {
+	Amount          Decimal `json:"amount" validate:"gt=0"`
+	Currency        *string `json:"currency" validate:"currency"`
+	RefundMethod    string  `json:"refund_method" validate:"required"`
+	ReferenceNumber *string `json:"reference_number"`
+	Notes           *string `json:"notes"`
+}
+
+// CreateCustomerRefund pays customer credit back to the customer
+func (h *SalesHandler) CreateCustomerRefund(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	customerID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid customer ID")
+		return
+	}
+
+	var req createCustomerRefundRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !paymentMethods[req.RefundMethod] {
+		writeError(w, http.StatusBadRequest, "Invalid refund method")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record refund")
+		return
+	}
+	defer tx.Rollback()
+
+	currency := requestCurrency(req.Currency, h.loadSettings(tx, tenantID).DefaultCurrency)
+	balance, err := customerCreditBalance(tx, tenantID, customerID, currency)
+	if err != nil {
+		h.logger.Error("Failed to fetch customer credit balance", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record refund")
+		return
+	}
+
+	amount := roundMoney(req.Amount, currency)
+	if amount.GreaterThan(balance) {
+		writeError(w, http.StatusConflict,
+			fmt.Sprintf("Refund of %.2f exceeds available credit of %.2f", amount, balance))
+		return
+	}
+
+	sources, err := consumeCustomerCredit(tx, tenantID, customerID, currency, amount)
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to record refund")
+		return
+	}
+
+	for _, source := range sources {
+		err = recordCreditEntry(tx, tenantID, source.ledgerEntry(CustomerCreditEntry{
+			CustomerID:      customerID,
+			EntryType:       "refund",
+			Currency:        currency,
+			RefundMethod:    &req.RefundMethod,
+			ReferenceNumber: req.ReferenceNumber,
+			Notes:           req.Notes,
+			CreatedBy:       requestUser(r),
+		}, source.amount))
+		if err != nil {
+			h.logger.Error("Failed to record refund", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to record refund")
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record refund")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"customer_id":       customerID,
+		"refunded_amount":   amount,
+		"currency":          currency,
+		"remaining_balance": balance.Sub(amount),
+		"message":           "Refund recorded successfully",
+	})
+}
+
+// applyCustomerCreditRequest is the body of ApplyCustomerCredit
+type applyCustomerCreditRequest struct {
+	Currency     *string                    `json:"currency" validate:"currency"`
+	Allocations  []paymentAllocationRequest `json:"allocations"`
+	AutoAllocate bool                       `json:"auto_allocate"`
+}
+
+// ApplyCustomerCredit applies a customer's available credit against their open invoices
+func (h *SalesHandler) ApplyCustomerCredit(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	customerID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid customer ID")
+		return
+	}
+
+	var req applyCustomerCreditRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if req.AutoAllocate == (len(req.Allocations) > 0) {
+		writeError(w, http.StatusBadRequest, "Specify either allocations or auto_allocate")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to apply customer credit")
+		return
+	}
+	defer tx.Rollback()
+
+	currency := requestCurrency(req.Currency, h.loadSettings(tx, tenantID).DefaultCurrency)
+	balance, err := customerCreditBalance(tx, tenantID, customerID, currency)
+	if err != nil {
+		h.logger.Error("Failed to fetch customer credit balance", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to apply customer credit")
+		return
+	}
+
+	allocations := req.Allocations
+	if req.AutoAllocate {
+		allocations, err = openInvoiceAllocations(tx, tenantID, customerID, currency, balance)
+		if err != nil {
+			h.logger.Error("Failed to find open invoices", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to apply customer credit")
+			return
+		}
+	}
+
+	var total Decimal
+	for i, alloc := range allocations {
+		allocations[i].Amount = roundMoney(alloc.Amount, currency)
+		if allocations[i].Amount.Sign() <= 0 {
+			writeError(w, http.StatusBadRequest,
+				fmt.Sprintf("Allocation to invoice %d must be positive", alloc.InvoiceID))
+			return
+		}
+		total = total.Add(allocations[i].Amount)
+	}
+	if total.GreaterThan(balance) {
+		writeError(w, http.StatusConflict,
+			fmt.Sprintf("Allocations of %.2f exceed available credit of %.2f", total, balance))
+		return
+	}
+
+	sources, err := consumeCustomerCredit(tx, tenantID, customerID, currency, total)
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to apply customer credit")
+		return
+	}
+
+	// Fund each invoice allocation from the consumed credit, oldest first
+	for _, alloc := range allocations {
+		needed := alloc.Amount
+		for needed.Sign() > 0 && len(sources) > 0 {
+			take := minDecimal(sources[0].amount, needed)
+
+			invoiceID := alloc.InvoiceID
+			portion := paymentAllocationRequest{InvoiceID: alloc.InvoiceID, Amount: take}
+			if err := applyCreditSource(tx, tenantID, sources[0], customerID, currency, portion, requestUser(r)); err != nil {
+				h.writeRequestError(w, err, "Failed to apply customer credit")
+				return
+			}
+
+			err = recordCreditEntry(tx, tenantID, sources[0].ledgerEntry(CustomerCreditEntry{
+				CustomerID: customerID,
+				EntryType:  "application",
+				Currency:   currency,
+				InvoiceID:  &invoiceID,
+				CreatedBy:  requestUser(r),
+			}, take))
+			if err != nil {
+				h.logger.Error("Failed to record credit application", zap.Error(err))
+				writeError(w, http.StatusInternalServerError, "Failed to apply customer credit")
+				return
+			}
+
+			needed = needed.Sub(take)
+			sources[0].amount = sources[0].amount.Sub(take)
+			if sources[0].amount.Sign() <= 0 {
+				sources = sources[1:]
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to apply customer credit")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"customer_id":       customerID,
+		"allocations":       allocations,
+		"applied_amount":    total,
+		"remaining_balance": balance.Sub(total),
+		"message":           "Customer credit applied successfully",
+	})
+}
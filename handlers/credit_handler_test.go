@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// ledgerAmounts lists the amounts of the credit ledger entries a test recorded
+func ledgerAmounts(db *scriptDB) []driver.Value {
+	var amounts []driver.Value
+	for _, st := range db.ran("INSERT INTO sales_customer_credit_ledger") {
+		amounts = append(amounts, st.args[3])
+	}
+	return amounts
+}
+
+func TestCustomerRefundLimitedToCredit(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount string
+		want   int
+		ledger []driver.Value
+	}{
+		{"all of the credit", "50", http.StatusCreated, []driver.Value{"-30", "-20"}},
+		{"one cent over the credit", "50.01", http.StatusConflict, nil},
+		{"the first payment exactly", "30", http.StatusCreated, []driver.Value{"-30"}},
+		{"into the credit note", "30.01", http.StatusCreated, []driver.Value{"-30", "-0.01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptSales(db, salesFixture{})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/customers/1/refunds",
+				`{"amount":`+tt.amount+`,"refund_method":"bank_transfer"}`))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if len(db.ran("pg_advisory_xact_lock")) != 1 {
+				t.Error("credit read without the customer's lock")
+			}
+			if got := ledgerAmounts(db); fmt.Sprint(got) != fmt.Sprint(tt.ledger) {
+				t.Errorf("ledger entries %v, want %v", got, tt.ledger)
+			}
+			if tt.want != http.StatusCreated && db.commits != 0 {
+				t.Error("refused refund was committed")
+			}
+		})
+	}
+}
+
+func TestApplyCustomerCreditLimitedToCredit(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        string
+		want          int
+		paid, credits []driver.Value
+	}{
+		{"all of the credit", "50", http.StatusOK, []driver.Value{"30"}, []driver.Value{"20"}},
+		{"one cent over the credit", "50.01", http.StatusConflict, nil, nil},
+		{"the payment only", "30", http.StatusOK, []driver.Value{"30"}, nil},
+		{"part of the payment", "12.5", http.StatusOK, []driver.Value{"12.5"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptSales(db, salesFixture{})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/customers/1/credit/apply",
+				`{"allocations":[{"invoice_id":21,"amount":`+tt.amount+`}]}`))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			var paid, credits []driver.Value
+			for _, st := range db.ran("SET paid_amount = paid_amount + $1") {
+				if st.args[0] != "0" {
+					paid = append(paid, st.args[0])
+				}
+				if st.args[1] != "0" {
+					credits = append(credits, st.args[1])
+				}
+			}
+			if fmt.Sprint(paid) != fmt.Sprint(tt.paid) || fmt.Sprint(credits) != fmt.Sprint(tt.credits) {
+				t.Errorf("invoice settled by payments %v and credits %v, want %v and %v", paid, credits,
+					tt.paid, tt.credits)
+			}
+			if tt.want != http.StatusOK && db.commits != 0 {
+				t.Error("refused application was committed")
+			}
+		})
+	}
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+type CreditNote struct {
+	ID                     int              `json:"id"`
+	CreditNoteNumber       string           `json:"credit_note_number"`
+	InvoiceID              int              `json:"invoice_id"`
+	ReturnID               *int             `json:"return_id"`
+	CustomerID             int              `json:"customer_id"`
+	CreditDate             time.Time        `json:"credit_date"`
+	Status                 string           `json:"status"`
+	Reason                 *string          `json:"reason"`
+	Subtotal               Decimal          `json:"subtotal"`
+	DocumentDiscountAmount Decimal          `json:"document_discount_amount"`
+	ShippingAmount         Decimal          `json:"shipping_amount"`
+	TaxAmount              Decimal          `json:"tax_amount"`
+	PricesIncludeTax       bool             `json:"prices_include_tax"`
+	SellerVATID            *string          `json:"seller_vat_id"`
+	BuyerVATID             *string          `json:"buyer_vat_id"`
+	VATTreatment           *string          `json:"vat_treatment"`
+	VATLegend              *string          `json:"vat_legend"`
+	RestockingFee          Decimal          `json:"restocking_fee"`
+	TotalAmount            Decimal          `json:"total_amount"`
+	AppliedAmount          Decimal          `json:"applied_amount"`
+	UnappliedAmount        Decimal          `json:"unapplied_amount"`
+	Currency               string           `json:"currency"`
+	Notes                  *string          `json:"notes"`
+	CreatedBy              int              `json:"created_by"`
+	CreatedAt              time.Time        `json:"created_at"`
+	UpdatedAt              time.Time        `json:"updated_at"`
+	UpdatedBy              *int             `json:"updated_by"`
+	Customer               *Customer        `json:"customer,omitempty"`
+	Items                  []CreditNoteItem `json:"items,omitempty"`
+}
+
+type CreditNoteItem struct {
+	ID             int       `json:"id"`
+	CreditNoteID   int       `json:"credit_note_id"`
+	InvoiceItemID  int       `json:"invoice_item_id"`
+	ProductID      int       `json:"product_id"`
+	Quantity       int       `json:"quantity"`
+	UnitPrice      Decimal   `json:"unit_price"`
+	DiscountAmount Decimal   `json:"discount_amount"`
+	TaxAmount      Decimal   `json:"tax_amount"`
+	LineTotal      Decimal   `json:"line_total"`
+	Notes          *string   `json:"notes"`
+	CreatedAt      time.Time `json:"created_at"`
+	Product        *Product  `json:"product,omitempty"`
+}
+
+// creditableInvoiceStatuses are the invoice statuses against which credit notes may be issued
+var creditableInvoiceStatuses = map[string]bool{
+	"sent":    true,
+	"overdue": true,
+	"paid":    true,
+}
+
+// creditNoteLine selects a quantity of one invoice line to credit
+type creditNoteLine struct {
+	InvoiceItemID int `json:"invoice_item_id" validate:"required"`
+	Quantity      int `json:"quantity" validate:"gt=0"`
+}
+
+// creditNoteRequest describes a credit note to issue against an invoice. When Lines is
+// empty, every uncredited quantity on the invoice is credited. RestockingFee is withheld
+// from the credited total. Rounding is the tenant's rounding policy for the reversed tax,
+// and NumberPattern the pattern the note is numbered by.
+type creditNoteRequest struct {
+	CreditDate    time.Time
+	ReturnID      *int
+	Reason        *string
+	Notes         *string
+	Lines         []creditNoteLine
+	RestockingFee Decimal
+	Rounding      string
+	NumberPattern string
+}
+
+type creditableInvoiceItem struct {
+	id               int
+	productID        int
+	quantity         int
+	unitPrice        Decimal
+	lineTotal        Decimal
+	creditedQuantity int
+}
+
+type creditLineDraft struct {
+	invoiceItemID int
+	productID     int
+	quantity      int
+	unitPrice     Decimal
+	amount        Decimal
+	taxAmount     Decimal
+}
+
+const creditNoteColumns = `
+	cn.id, cn.credit_note_number, cn.invoice_id, cn.return_id, cn.customer_id, cn.credit_date,
+	cn.status, cn.reason, cn.subtotal, cn.document_discount_amount, cn.shipping_amount, cn.tax_amount,
+	cn.prices_include_tax, cn.seller_vat_id, cn.buyer_vat_id, cn.vat_treatment, cn.restocking_fee, cn.total_amount,
+	cn.applied_amount, cn.unapplied_amount, cn.currency, cn.notes, cn.created_by, cn.created_at,
+	cn.updated_at, cn.updated_by
+`
+
+func scanCreditNote(row rowScanner, note *CreditNote, extra ...interface{}) error {
+	dest := []interface{}{
+		&note.ID, &note.CreditNoteNumber, &note.InvoiceID, &note.ReturnID, &note.CustomerID,
+		&note.CreditDate, &note.Status, &note.Reason, &note.Subtotal, &note.DocumentDiscountAmount,
+		&note.ShippingAmount, &note.TaxAmount, &note.PricesIncludeTax, &note.SellerVATID, &note.BuyerVATID,
+		&note.VATTreatment,
+		&note.RestockingFee, &note.TotalAmount, &note.AppliedAmount, &note.UnappliedAmount,
+		&note.Currency, &note.Notes, &note.CreatedBy, &note.CreatedAt, &note.UpdatedAt,
+		&note.UpdatedBy,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	note.VATLegend = vatLegend(note.VATTreatment)
+	return nil
+}
+
+// creditedValue is the share of a line total covered by the first n credited units. Each
+// credit note takes the difference between two of these, so partial credits never drift
+// from the line total.
+func creditedValue(total Decimal, n, quantity, places int) Decimal {
+	return total.MulDiv(decimalFromInt(n), decimalFromInt(quantity), places)
+}
+
+// issueCreditNote credits all or part of an invoice inside tx. The credit first reduces
+// what is still due on the invoice; any remainder becomes customer credit.
+func issueCreditNote(tx *sqlx.Tx, tenantID string, invoiceID int, req creditNoteRequest, userID int) (int, string, error) {
+	var customerID int
+	var status, currency string
+	var invoiceSubtotal, invoiceDiscount, invoiceShipping, invoiceTax, balanceDue Decimal
+	var pricesIncludeTax bool
+	var sellerVATID, buyerVATID, vatTreatment *string
+
+	err := tx.QueryRow(`
+		SELECT customer_id, status, currency, subtotal, document_discount_amount, shipping_amount,
+		       tax_amount, prices_include_tax, seller_vat_id, buyer_vat_id, vat_treatment, balance_due
+		FROM sales_invoices
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE
+	`, invoiceID, tenantID).Scan(&customerID, &status, &currency, &invoiceSubtotal, &invoiceDiscount,
+		&invoiceShipping, &invoiceTax, &pricesIncludeTax, &sellerVATID, &buyerVATID, &vatTreatment, &balanceDue)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", &requestError{http.StatusNotFound, "Sales invoice not found"}
+		}
+		return 0, "", err
+	}
+
+	if !creditableInvoiceStatuses[status] {
+		return 0, "", &requestError{http.StatusConflict,
+			fmt.Sprintf("Cannot issue a credit note against an invoice with status %s", status)}
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, product_id, quantity, unit_price, line_total, credited_quantity
+		FROM sales_invoice_items
+		WHERE invoice_id = $1 AND tenant_id = $2
+		ORDER BY id
+		FOR UPDATE
+	`, invoiceID, tenantID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	items := map[int]*creditableInvoiceItem{}
+	var ordered []*creditableInvoiceItem
+	for rows.Next() {
+		item := &creditableInvoiceItem{}
+		err := rows.Scan(&item.id, &item.productID, &item.quantity, &item.unitPrice,
+			&item.lineTotal, &item.creditedQuantity)
+		if err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		items[item.id] = item
+		ordered = append(ordered, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, "", err
+	}
+
+	requested := map[int]int{}
+	if len(req.Lines) > 0 {
+		for _, line := range req.Lines {
+			if _, ok := items[line.InvoiceItemID]; !ok {
+				return 0, "", &requestError{http.StatusBadRequest,
+					fmt.Sprintf("Invoice line %d does not belong to this invoice", line.InvoiceItemID)}
+			}
+			if line.Quantity <= 0 {
+				return 0, "", &requestError{http.StatusBadRequest,
+					fmt.Sprintf("Quantity for invoice line %d must be positive", line.InvoiceItemID)}
+			}
+			requested[line.InvoiceItemID] += line.Quantity
+		}
+	} else {
+		for _, item := range ordered {
+			if remaining := item.quantity - item.creditedQuantity; remaining > 0 {
+				requested[item.id] = remaining
+			}
+		}
+	}
+
+	rounding := newMoneyRounding(currency, req.Rounding)
+
+	var drafts []creditLineDraft
+	var subtotal Decimal
+	fullyCredited := true
+	for _, item := range ordered {
+		qty := requested[item.id]
+		remaining := item.quantity - item.creditedQuantity
+		if qty > remaining {
+			return 0, "", &requestError{http.StatusConflict,
+				fmt.Sprintf("Invoice line %d has only %d uncredited units, cannot credit %d", item.id, remaining, qty)}
+		}
+		if qty < remaining {
+			fullyCredited = false
+		}
+		if qty == 0 {
+			continue
+		}
+
+		amount := creditedValue(item.lineTotal, item.creditedQuantity+qty, item.quantity, rounding.places).
+			Sub(creditedValue(item.lineTotal, item.creditedQuantity, item.quantity, rounding.places))
+		drafts = append(drafts, creditLineDraft{
+			invoiceItemID: item.id,
+			productID:     item.productID,
+			quantity:      qty,
+			unitPrice:     item.unitPrice,
+			amount:        amount,
+		})
+		subtotal = subtotal.Add(amount)
+	}
+
+	if len(drafts) == 0 {
+		return 0, "", &requestError{http.StatusConflict, "Nothing left to credit on this invoice"}
+	}
+
+	// Reverse the invoice's tax and document discount in proportion to the value being
+	// credited, rounded under the tenant's policy. The note that credits the last units
+	// takes whatever tax and discount have not been credited yet, and the shipping.
+	amounts := make([]Decimal, len(drafts))
+	for i, draft := range drafts {
+		amounts[i] = draft.amount
+	}
+	var lineTax []Decimal
+	var discountAmount, shippingAmount Decimal
+	if fullyCredited {
+		var creditedDiscount, creditedShipping, creditedTax Decimal
+		err = tx.QueryRow(`
+			SELECT COALESCE(SUM(document_discount_amount), 0), COALESCE(SUM(shipping_amount), 0),
+			       COALESCE(SUM(tax_amount), 0)
+			FROM sales_credit_notes
+			WHERE invoice_id = $1 AND tenant_id = $2
+		`, invoiceID, tenantID).Scan(&creditedDiscount, &creditedShipping, &creditedTax)
+		if err != nil {
+			return 0, "", err
+		}
+		discountAmount = invoiceDiscount.Sub(creditedDiscount)
+		shippingAmount = invoiceShipping.Sub(creditedShipping)
+		lineTax = rounding.allocate(invoiceTax.Sub(creditedTax), amounts)
+	} else if invoiceSubtotal.Sign() > 0 {
+		discountAmount = invoiceDiscount.MulDiv(subtotal, invoiceSubtotal, rounding.places)
+		shares := make([]Decimal, len(drafts))
+		for i, amount := range amounts {
+			shares[i] = invoiceTax.MulDiv(amount, invoiceSubtotal, decimalPlaces)
+		}
+		lineTax, _ = rounding.lines(shares)
+	}
+
+	in := totalsInput{
+		Currency:               currency,
+		Rounding:               req.Rounding,
+		Lines:                  make([]totalsLine, len(drafts)),
+		DocumentDiscountAmount: discountAmount,
+		ShippingAmount:         shippingAmount,
+		PricesIncludeTax:       pricesIncludeTax,
+	}
+	for i, draft := range drafts {
+		in.Lines[i] = totalsLine{
+			Quantity:       draft.quantity,
+			UnitPrice:      draft.unitPrice,
+			DiscountAmount: draft.unitPrice.MulInt(draft.quantity).Sub(draft.amount),
+		}
+		if lineTax != nil {
+			in.Lines[i].TaxAmount = &lineTax[i]
+		}
+	}
+	totals := calculateTotals(in)
+	for i, line := range totals.Lines {
+		drafts[i].taxAmount = line.TaxAmount
+	}
+
+	restockingFee := rounding.round(req.RestockingFee)
+	if restockingFee.Sign() < 0 || restockingFee.GreaterThan(totals.TotalAmount) {
+		return 0, "", &requestError{http.StatusBadRequest,
+			fmt.Sprintf("Restocking fee of %.2f exceeds the %.2f being credited", restockingFee, totals.TotalAmount)}
+	}
+
+	total := totals.TotalAmount.Sub(restockingFee)
+	applied := minDecimal(total, balanceDue)
+	unapplied := total.Sub(applied)
+
+	creditNoteNumber, err := nextDocumentNumber(tx, tenantID, numberCreditNote, req.NumberPattern, req.CreditDate)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var creditNoteID int
+	err = tx.QueryRow(`
+		INSERT INTO sales_credit_notes (tenant_id, credit_note_number, invoice_id, return_id, customer_id,
+		                                credit_date, reason, subtotal, document_discount_amount, shipping_amount,
+		                                tax_amount, prices_include_tax, seller_vat_id, buyer_vat_id, vat_treatment,
+		                                restocking_fee, total_amount, applied_amount, unapplied_amount, currency,
+		                                notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+		RETURNING id
+	`, tenantID, creditNoteNumber, invoiceID, req.ReturnID, customerID, req.CreditDate, req.Reason,
+		totals.Subtotal, totals.DocumentDiscountAmount, totals.ShippingAmount, totals.TaxAmount, pricesIncludeTax,
+		sellerVATID, buyerVATID, vatTreatment, restockingFee, total, applied, unapplied, currency, req.Notes,
+		userID).Scan(&creditNoteID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	for _, draft := range drafts {
+		_, err = tx.Exec(`
+			INSERT INTO sales_credit_note_items (tenant_id, credit_note_id, invoice_item_id, product_id, quantity,
+			                                     unit_price, discount_amount, tax_amount)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, tenantID, creditNoteID, draft.invoiceItemID, draft.productID, draft.quantity, draft.unitPrice,
+			draft.unitPrice.MulInt(draft.quantity).Sub(draft.amount), draft.taxAmount)
+		if err != nil {
+			return 0, "", err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE sales_invoice_items SET credited_quantity = credited_quantity + $1
+			WHERE id = $2 AND tenant_id = $3
+		`, draft.quantity, draft.invoiceItemID, tenantID)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if applied.Sign() > 0 {
+		if err = settleInvoice(tx, tenantID, invoiceID, Decimal{}, applied); err != nil {
+			return 0, "", err
+		}
+	}
+
+	if unapplied.Sign() > 0 {
+		err = recordCreditEntry(tx, tenantID, CustomerCreditEntry{
+			CustomerID:   customerID,
+			EntryType:    "credit_note",
+			Amount:       unapplied,
+			Currency:     currency,
+			InvoiceID:    &invoiceID,
+			CreditNoteID: &creditNoteID,
+			CreatedBy:    userID,
+		})
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	return creditNoteID, creditNoteNumber, nil
+}
+
+// creditReturn issues the credit note for a processed return. Returned products are
+// matched to the uncredited lines of the return's invoice, the return's restocking fee
+// is withheld, and the credit note total is recorded as the return's refund amount.
+func creditReturn(tx *sqlx.Tx, tenantID string, returnID int, settings SalesSettings, userID int) (int, string, error) {
+	var invoiceID *int
+	var reason *string
+	var restockingFee Decimal
+	err := tx.QueryRow("SELECT invoice_id, reason, restocking_fee FROM sales_returns WHERE id = $1 AND tenant_id = $2",
+		returnID, tenantID).Scan(&invoiceID, &reason, &restockingFee)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", &requestError{http.StatusNotFound, "Sales return not found"}
+		}
+		return 0, "", err
+	}
+	if invoiceID == nil {
+		return 0, "", &requestError{http.StatusConflict, "Sales return is not linked to an invoice"}
+	}
+
+	rows, err := tx.Query(`
+		SELECT product_id, SUM(quantity)
+		FROM sales_return_items
+		WHERE return_id = $1 AND tenant_id = $2
+		GROUP BY product_id
+	`, returnID, tenantID)
+	if err != nil {
+		return 0, "", err
+	}
+	returned := map[int]int{}
+	for rows.Next() {
+		var productID, quantity int
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		returned[productID] = quantity
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, "", err
+	}
+
+	rows, err = tx.Query(`
+		SELECT id, product_id, quantity - credited_quantity
+		FROM sales_invoice_items
+		WHERE invoice_id = $1 AND tenant_id = $2 AND quantity > credited_quantity
+		ORDER BY id
+	`, *invoiceID, tenantID)
+	if err != nil {
+		return 0, "", err
+	}
+	var lines []creditNoteLine
+	for rows.Next() {
+		var itemID, productID, remaining int
+		if err := rows.Scan(&itemID, &productID, &remaining); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		qty := returned[productID]
+		if qty > remaining {
+			qty = remaining
+		}
+		if qty > 0 {
+			lines = append(lines, creditNoteLine{InvoiceItemID: itemID, Quantity: qty})
+			returned[productID] -= qty
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, "", err
+	}
+
+	for productID, qty := range returned {
+		if qty > 0 {
+			return 0, "", &requestError{http.StatusConflict,
+				fmt.Sprintf("Returned quantity of product %d exceeds the uncredited quantity invoiced", productID)}
+		}
+	}
+	if len(lines) == 0 {
+		return 0, "", &requestError{http.StatusConflict, "Sales return has no items to credit"}
+	}
+
+	creditNoteID, creditNoteNumber, err := issueCreditNote(tx, tenantID, *invoiceID, creditNoteRequest{
+		CreditDate:    time.Now(),
+		ReturnID:      &returnID,
+		Reason:        reason,
+		Lines:         lines,
+		RestockingFee: restockingFee,
+		Rounding:      settings.Rounding,
+		NumberPattern: settings.NumberPatterns[numberCreditNote],
+	}, userID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sales_returns
+		SET credit_note_id = $1,
+		    refund_amount = (SELECT total_amount FROM sales_credit_notes WHERE id = $1)
+		WHERE id = $2 AND tenant_id = $3
+	`, creditNoteID, returnID, tenantID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return creditNoteID, creditNoteNumber, nil
+}
+
+// Credit Note Handlers
+
+// GetCreditNotes retrieves credit notes with optional filtering
+func (h *SalesHandler) GetCreditNotes(w http.ResponseWriter, r *http.Request) {
+	customerID := r.URL.Query().Get("customer_id")
+	invoiceID := r.URL.Query().Get("invoice_id")
+	limit := r.URL.Query().Get("limit")
+
+	if limit == "" {
+		limit = "50"
+	}
+
+	query := `
+		SELECT ` + creditNoteColumns + `, c.first_name, c.last_name, c.company_name, c.email
+		FROM sales_credit_notes cn
+		LEFT JOIN customers c ON cn.customer_id = c.id
+		WHERE cn.tenant_id = $1
+	`
+
+	args := []interface{}{requestTenant(r)}
+	argIndex := 2
+
+	if customerID != "" {
+		query += fmt.Sprintf(" AND cn.customer_id = $%d", argIndex)
+		args = append(args, customerID)
+		argIndex++
+	}
+
+	if invoiceID != "" {
+		query += fmt.Sprintf(" AND cn.invoice_id = $%d", argIndex)
+		args = append(args, invoiceID)
+		argIndex++
+	}
+
+	query += fmt.Sprintf(" ORDER BY cn.credit_date DESC, cn.id DESC LIMIT $%d", argIndex)
+	args = append(args, limit)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch credit notes")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch credit notes", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch credit notes")
+		return
+	}
+	defer rows.Close()
+
+	var notes []CreditNote
+	for rows.Next() {
+		var note CreditNote
+		var firstName, lastName, companyName, email sql.NullString
+
+		err := scanCreditNote(rows, &note, &firstName, &lastName, &companyName, &email)
+		if err != nil {
+			h.logger.Error("Failed to scan credit note", zap.Error(err))
+			continue
+		}
+
+		note.Customer = &Customer{
+			ID:          note.CustomerID,
+			CompanyName: &companyName.String,
+			FirstName:   &firstName.String,
+			LastName:    &lastName.String,
+			Email:       &email.String,
+		}
+
+		notes = append(notes, note)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"credit_notes": notes,
+		"count":        len(notes),
+	})
+}
+
+// GetCreditNote retrieves a single credit note with its items
+func (h *SalesHandler) GetCreditNote(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid credit note ID")
+		return
+	}
+
+	query := `
+		SELECT ` + creditNoteColumns + `, c.first_name, c.last_name, c.company_name, c.email
+		FROM sales_credit_notes cn
+		LEFT JOIN customers c ON cn.customer_id = c.id
+		WHERE cn.id = $1 AND cn.tenant_id = $2
+	`
+
+	tenantID := requestTenant(r)
+
+	var note CreditNote
+	var firstName, lastName, companyName, email sql.NullString
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch credit note")
+		return
+	}
+	defer tx.Rollback()
+
+	err = scanCreditNote(tx.QueryRow(query, id, tenantID), &note, &firstName, &lastName, &companyName, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Credit note not found")
+			return
+		}
+		h.logger.Error("Failed to fetch credit note", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch credit note")
+		return
+	}
+
+	note.Customer = &Customer{
+		ID:          note.CustomerID,
+		CompanyName: &companyName.String,
+		FirstName:   &firstName.String,
+		LastName:    &lastName.String,
+		Email:       &email.String,
+	}
+
+	rows, err := tx.Query(`
+		SELECT cni.id, cni.credit_note_id, cni.invoice_item_id, cni.product_id, cni.quantity,
+		       cni.unit_price, cni.discount_amount, cni.tax_amount, cni.line_total, cni.notes,
+		       cni.created_at, p.name as product_name, p.sku
+		FROM sales_credit_note_items cni
+		LEFT JOIN products p ON cni.product_id = p.id
+		WHERE cni.credit_note_id = $1 AND cni.tenant_id = $2
+		ORDER BY cni.id
+	`, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to fetch credit note items", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch credit note")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item CreditNoteItem
+		var productName, sku sql.NullString
+
+		err := rows.Scan(&item.ID, &item.CreditNoteID, &item.InvoiceItemID, &item.ProductID,
+			&item.Quantity, &item.UnitPrice, &item.DiscountAmount, &item.TaxAmount, &item.LineTotal,
+			&item.Notes, &item.CreatedAt, &productName, &sku)
+		if err != nil {
+			h.logger.Error("Failed to scan credit note item", zap.Error(err))
+			continue
+		}
+
+		item.Product = &Product{
+			ID:   item.ProductID,
+			Name: productName.String,
+			SKU:  sku.String,
+		}
+
+		note.Items = append(note.Items, item)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, note)
+}
+
+// createCreditNoteRequest is the body of CreateCreditNote
+type createCreditNoteRequest struct {
+	CreditDate *string          `json:"credit_date" validate:"date"`
+	Reason     *string          `json:"reason"`
+	Notes      *string          `json:"notes"`
+	Items      []creditNoteLine `json:"items"`
+}
+
+// CreateCreditNote issues a credit note against an invoice, in full or for selected lines
+func (h *SalesHandler) CreateCreditNote(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	invoiceID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	var req createCreditNoteRequest
+
+	// An empty body credits everything that remains on the invoice
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	if !checkRequest(w, &req) {
+		return
+	}
+
+	noteReq := creditNoteRequest{
+		CreditDate: time.Now(),
+		Reason:     req.Reason,
+		Notes:      req.Notes,
+		Lines:      req.Items,
+	}
+
+	if req.CreditDate != nil {
+		creditDate, err := time.Parse("2006-01-02", *req.CreditDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid credit date format")
+			return
+		}
+		noteReq.CreditDate = creditDate
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to issue credit note")
+		return
+	}
+	defer tx.Rollback()
+
+	settings := h.loadSettings(tx, requestTenant(r))
+	noteReq.Rounding = settings.Rounding
+	noteReq.NumberPattern = settings.NumberPatterns[numberCreditNote]
+	creditNoteID, creditNoteNumber, err := issueCreditNote(tx, requestTenant(r), invoiceID, noteReq, requestUser(r))
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to issue credit note")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to issue credit note")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"credit_note_id":     creditNoteID,
+		"credit_note_number": creditNoteNumber,
+		"invoice_id":         invoiceID,
+		"message":            "Credit note issued successfully",
+	})
+}
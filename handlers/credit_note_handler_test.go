@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestCreditNoteLimitedToInvoice(t *testing.T) {
+	tests := []struct {
+		name                      string
+		balanceDue                string
+		credited                  int64
+		creditedTax               string
+		body                      string
+		want                      int
+		total, applied, unapplied string
+	}{
+		{"whole invoice", "33", 0, "0", "", http.StatusCreated, "33", "33", "0"},
+		{"every unit", "33", 0, "0", `{"items":[{"invoice_item_id":41,"quantity":3}]}`,
+			http.StatusCreated, "33", "33", "0"},
+		{"one unit over", "33", 0, "0", `{"items":[{"invoice_item_id":41,"quantity":4}]}`,
+			http.StatusConflict, "", "", ""},
+		{"one unit", "33", 0, "0", `{"items":[{"invoice_item_id":41,"quantity":1}]}`,
+			http.StatusCreated, "11", "11", "0"},
+		{"the last unit", "11", 2, "2", `{"items":[{"invoice_item_id":41,"quantity":1}]}`,
+			http.StatusCreated, "11", "11", "0"},
+		{"the last unit and one more", "11", 2, "2", `{"items":[{"invoice_item_id":41,"quantity":2}]}`,
+			http.StatusConflict, "", "", ""},
+		{"already credited", "0", 3, "3", "", http.StatusConflict, "", "", ""},
+		{"more than is due", "5", 0, "0", `{"items":[{"invoice_item_id":41,"quantity":1}]}`,
+			http.StatusCreated, "11", "5", "6"},
+		{"nothing due", "0", 0, "0", "", http.StatusCreated, "33", "0", "33"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptSales(db, salesFixture{balances: map[int64]string{21: tt.balanceDue}, credited: tt.credited,
+				creditedTax: tt.creditedTax})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/invoices/21/credit-notes", tt.body))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			notes := db.ran("INSERT INTO sales_credit_notes")
+			if tt.want != http.StatusCreated {
+				if len(notes) != 0 || db.commits != 0 {
+					t.Error("refused credit note was still issued")
+				}
+				return
+			}
+			if len(notes) != 1 {
+				t.Fatalf("issued %d credit notes, want 1", len(notes))
+			}
+			if got := notes[0].args[16:19]; fmt.Sprint(got) != fmt.Sprint([]driver.Value{tt.total, tt.applied, tt.unapplied}) {
+				t.Errorf("total, applied and unapplied = %v, want %s, %s and %s", got, tt.total, tt.applied,
+					tt.unapplied)
+			}
+
+			settled := db.ran("SET paid_amount = paid_amount + $1")
+			if tt.applied != "0" && (len(settled) != 1 || settled[0].args[1] != tt.applied) {
+				t.Errorf("invoice settled by %v, want a credit of %s", settled, tt.applied)
+			}
+			credit := ledgerAmounts(db)
+			if tt.unapplied != "0" && fmt.Sprint(credit) != fmt.Sprint([]driver.Value{tt.unapplied}) {
+				t.Errorf("customer credited %v, want %s", credit, tt.unapplied)
+			}
+			if tt.unapplied == "0" && len(credit) != 0 {
+				t.Errorf("customer credited %v, want nothing", credit)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// hostRolesKey is the request context key under which the host ERP passes the roles of
+// the authenticated user.
+const hostRolesKey = "roles"
+
+// Data scopes, from widest to narrowest. A caller's scope decides which sales reps'
+// orders and quotes they can see.
+const (
+	dataScopeAll       = "all"       // every document of the tenant
+	dataScopeTerritory = "territory" // the caller's own and those of reps in territories they manage
+	dataScopeOwn       = "own"       // documents where the caller is the sales rep
+)
+
+var dataScopeRank = map[string]int{
+	dataScopeOwn:       1,
+	dataScopeTerritory: 2,
+	dataScopeAll:       3,
+}
+
+// dataScope is the record-level visibility of the caller
+type dataScope struct {
+	level    string
+	tenantID string
+	userID   int
+}
+
+// requestRoles returns the roles the host passed for the caller
+func requestRoles(r *http.Request) []string {
+	roles, _ := r.Context().Value(hostRolesKey).([]string)
+	return roles
+}
+
+// splitRoles parses a comma-separated role list setting
+func splitRoles(value string) []string {
+	var roles []string
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// scopeForRoles picks the widest scope any of roles is configured for, falling back to
+// the default scope for callers whose roles are not configured at all
+func (s SalesSettings) scopeForRoles(roles []string) string {
+	configured := map[string]string{}
+	for _, role := range s.DataScopeOwnRoles {
+		configured[role] = dataScopeOwn
+	}
+	for _, role := range s.DataScopeTerritoryRoles {
+		configured[role] = dataScopeTerritory
+	}
+	for _, role := range s.DataScopeAllRoles {
+		configured[role] = dataScopeAll
+	}
+
+	level := ""
+	for _, role := range roles {
+		if scope, ok := configured[role]; ok && dataScopeRank[scope] > dataScopeRank[level] {
+			level = scope
+		}
+	}
+	if level == "" {
+		level = s.DataScopeDefault
+	}
+	if dataScopeRank[level] == 0 {
+		level = dataScopeOwn
+	}
+	return level
+}
+
+// requestDataScope resolves the caller's data scope from their roles and the tenant's
+// data scope settings
+func (h *SalesHandler) requestDataScope(q sqlx.Queryer, r *http.Request) dataScope {
+	settings := h.loadSettings(q, requestTenant(r))
+	return dataScope{
+		level:    settings.scopeForRoles(requestRoles(r)),
+		tenantID: requestTenant(r),
+		userID:   requestUser(r),
+	}
+}
+
+// condition returns an SQL condition, starting with AND, that limits the sales rep in
+// repColumn to those the scope may see, along with its arguments bound from $argIndex on.
+// The condition is empty for the all scope. repColumn must be a trusted column expression.
+func (s dataScope) condition(repColumn string, argIndex int) (string, []interface{}) {
+	switch s.level {
+	case dataScopeAll:
+		return "", nil
+	case dataScopeTerritory:
+		return fmt.Sprintf(` AND %s IN (
+			SELECT scope_sr.id FROM sales_representatives scope_sr
+			LEFT JOIN sales_territories scope_st ON scope_st.id = scope_sr.territory_id
+			WHERE scope_sr.tenant_id = $%[2]d AND (scope_sr.user_id = $%[3]d OR scope_st.manager_id = $%[3]d)
+		)`, repColumn, argIndex, argIndex+1), []interface{}{s.tenantID, s.userID}
+	default:
+		return fmt.Sprintf(` AND %s IN (
+			SELECT scope_sr.id FROM sales_representatives scope_sr
+			WHERE scope_sr.tenant_id = $%d AND scope_sr.user_id = $%d
+		)`, repColumn, argIndex, argIndex+1), []interface{}{s.tenantID, s.userID}
+	}
+}
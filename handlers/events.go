@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// DomainEvent is a change to a sales document that other modules may react to. Events
+// are recorded in the outbox in the transaction that makes the change (migration 000013).
+type DomainEvent interface {
+	EventType() string
+	AggregateType() string
+	AggregateID() int
+}
+
+// Event types, as stored in sales_outbox.event_type
+const (
+	EventOrderCreated       = "sales.order.created"
+	EventOrderStatusChanged = "sales.order.status_changed"
+	EventQuoteConverted     = "sales.quote.converted"
+	EventInvoiceIssued      = "sales.invoice.issued"
+	EventPaymentReceived    = "sales.payment.received"
+	EventReturnProcessed    = "sales.return.processed"
+)
+
+// OrderCreated is recorded when an order is created, directly or from a quote
+type OrderCreated struct {
+	OrderID     int     `json:"order_id"`
+	OrderNumber string  `json:"order_number"`
+	CustomerID  int     `json:"customer_id"`
+	QuoteID     *int    `json:"quote_id"`
+	SalesRepID  *int    `json:"sales_rep_id"`
+	TotalAmount Decimal `json:"total_amount"`
+	Currency    string  `json:"currency"`
+}
+
+func (e OrderCreated) EventType() string     { return EventOrderCreated }
+func (e OrderCreated) AggregateType() string { return "order" }
+func (e OrderCreated) AggregateID() int      { return e.OrderID }
+
+// OrderStatusChanged is recorded when an order moves through its lifecycle
+type OrderStatusChanged struct {
+	OrderID    int    `json:"order_id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+}
+
+func (e OrderStatusChanged) EventType() string     { return EventOrderStatusChanged }
+func (e OrderStatusChanged) AggregateType() string { return "order" }
+func (e OrderStatusChanged) AggregateID() int      { return e.OrderID }
+
+// QuoteConverted is recorded when a quote is accepted and converted to an order
+type QuoteConverted struct {
+	QuoteID     int    `json:"quote_id"`
+	OrderID     int    `json:"order_id"`
+	OrderNumber string `json:"order_number"`
+}
+
+func (e QuoteConverted) EventType() string     { return EventQuoteConverted }
+func (e QuoteConverted) AggregateType() string { return "quote" }
+func (e QuoteConverted) AggregateID() int      { return e.QuoteID }
+
+// InvoiceIssued is recorded when a draft invoice is sent to the customer
+type InvoiceIssued struct {
+	InvoiceID     int        `json:"invoice_id"`
+	InvoiceNumber string     `json:"invoice_number"`
+	OrderID       *int       `json:"order_id"`
+	CustomerID    int        `json:"customer_id"`
+	InvoiceDate   time.Time  `json:"invoice_date"`
+	DueDate       *time.Time `json:"due_date"`
+	TotalAmount   Decimal    `json:"total_amount"`
+	Currency      string     `json:"currency"`
+}
+
+func (e InvoiceIssued) EventType() string     { return EventInvoiceIssued }
+func (e InvoiceIssued) AggregateType() string { return "invoice" }
+func (e InvoiceIssued) AggregateID() int      { return e.InvoiceID }
+
+// PaymentReceived is recorded when a payment is recorded, with the invoices it settled
+type PaymentReceived struct {
+	PaymentID       int                        `json:"payment_id"`
+	PaymentNumber   string                     `json:"payment_number"`
+	CustomerID      int                        `json:"customer_id"`
+	PaymentDate     time.Time                  `json:"payment_date"`
+	Amount          Decimal                    `json:"amount"`
+	Currency        string                     `json:"currency"`
+	PaymentMethod   string                     `json:"payment_method"`
+	Allocations     []paymentAllocationRequest `json:"allocations"`
+	UnappliedAmount Decimal                    `json:"unapplied_amount"`
+}
+
+func (e PaymentReceived) EventType() string     { return EventPaymentReceived }
+func (e PaymentReceived) AggregateType() string { return "payment" }
+func (e PaymentReceived) AggregateID() int      { return e.PaymentID }
+
+// ReturnProcessed is recorded when a return is credited, with the goods to restock
+type ReturnProcessed struct {
+	ReturnID     int                  `json:"return_id"`
+	OrderID      *int                 `json:"order_id"`
+	CustomerID   int                  `json:"customer_id"`
+	CreditNoteID *int                 `json:"credit_note_id"`
+	Restock      []RestockInstruction `json:"restock_instructions"`
+}
+
+func (e ReturnProcessed) EventType() string     { return EventReturnProcessed }
+func (e ReturnProcessed) AggregateType() string { return "return" }
+func (e ReturnProcessed) AggregateID() int      { return e.ReturnID }
+
+// recordEvent appends event to the outbox inside tx, so it is only dispatched if the
+// change it describes commits
+func recordEvent(tx *sqlx.Tx, tenantID string, event DomainEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO sales_outbox (tenant_id, event_type, aggregate_type, aggregate_id, payload)
+		VALUES ($1, $2, $3, $4, $5)
+	`, tenantID, event.EventType(), event.AggregateType(), event.AggregateID(), string(payload))
+	return err
+}
+
+// OutboxEvent is a recorded event as delivered to subscribers. Decode its payload into
+// the typed event named by Type.
+type OutboxEvent struct {
+	ID            int64           `json:"id"`
+	TenantID      string          `json:"tenant_id"`
+	Type          string          `json:"event_type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int             `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+// Decode unmarshals the event's payload into v
+func (e OutboxEvent) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// EventSubscriber handles a delivered event. Delivery is at-least-once: an event is
+// redelivered to a subscriber until it returns nil, so subscribers must be idempotent and
+// may use the event ID to recognise repeats. Subscribers that have accepted an event are
+// recorded (migration 000022) and are not handed it again when another one fails.
+type EventSubscriber func(ctx context.Context, event OutboxEvent) error
+
+// subscription is a subscriber under the name its deliveries are recorded by
+type subscription struct {
+	name       string
+	subscriber EventSubscriber
+}
+
+// AllEvents subscribes to every event type
+const AllEvents = "*"
+
+const (
+	outboxPollInterval = 2 * time.Second
+	outboxBatchSize    = 100
+	outboxMaxRetry     = time.Hour
+)
+
+// outboxRetryDelay is the wait before the next delivery of an event that has failed
+// attempts times: 5s, 10s, 20s, ... up to an hour
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := 5 * time.Second
+	for i := 1; i < attempts && delay < outboxMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxRetry {
+		delay = outboxMaxRetry
+	}
+	return delay
+}
+
+// EventDispatcher delivers outbox events to the subscribers registered in-process
+type EventDispatcher struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+
+	mu          sync.RWMutex
+	subscribers map[string][]subscription
+	names       map[string]bool
+}
+
+// NewEventDispatcher creates a dispatcher reading the outbox from db
+func NewEventDispatcher(db *sqlx.DB, logger *zap.Logger) *EventDispatcher {
+	return &EventDispatcher{
+		db:          db,
+		logger:      logger,
+		subscribers: map[string][]subscription{},
+		names:       map[string]bool{},
+	}
+}
+
+// Subscribe registers subscriber for events of eventType, or for every event with AllEvents.
+// Its deliveries are recorded under name, which must be unique and stay the same across
+// restarts; registering a name twice panics.
+func (d *EventDispatcher) Subscribe(eventType, name string, subscriber EventSubscriber) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.names[name] {
+		panic(fmt.Sprintf("sales events: subscriber %q registered twice", name))
+	}
+	d.names[name] = true
+	d.subscribers[eventType] = append(d.subscribers[eventType], subscription{name, subscriber})
+}
+
+// Run dispatches pending events until ctx is cancelled
+func (d *EventDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.DispatchPending(ctx)
+			if err != nil {
+				d.logger.Error("Failed to dispatch sales events", zap.Error(err))
+			}
+			// A full batch suggests more are waiting
+			if err != nil || n < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchPending delivers one batch of due events and returns how many it attempted.
+// Rows are locked while they are delivered, so several instances never deliver the same
+// event at once.
+func (d *EventDispatcher) DispatchPending(ctx context.Context) (int, error) {
+	tx, err := d.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, tenant_id, event_type, aggregate_type, aggregate_id, payload, occurred_at, attempts
+		FROM sales_outbox
+		WHERE dispatched_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	type pendingEvent struct {
+		event    OutboxEvent
+		attempts int
+	}
+	var pending []pendingEvent
+	for rows.Next() {
+		var p pendingEvent
+		var payload []byte
+		err := rows.Scan(&p.event.ID, &p.event.TenantID, &p.event.Type, &p.event.AggregateType,
+			&p.event.AggregateID, &payload, &p.event.OccurredAt, &p.attempts)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		p.event.Payload = payload
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range pending {
+		delivered, err := deliveredSubscribers(ctx, tx, p.event.ID)
+		if err != nil {
+			return 0, err
+		}
+		accepted, deliveryErr := d.deliver(ctx, p.event, delivered)
+		for _, name := range accepted {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO sales_outbox_deliveries (event_id, subscriber)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`, p.event.ID, name)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if deliveryErr != nil {
+			d.logger.Warn("Sales event delivery failed",
+				zap.Int64("event_id", p.event.ID), zap.String("event_type", p.event.Type), zap.Error(deliveryErr))
+			_, err = tx.ExecContext(ctx, `
+				UPDATE sales_outbox
+				SET attempts = attempts + 1, last_error = $1,
+				    next_attempt_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+				WHERE id = $3
+			`, deliveryErr.Error(), int(outboxRetryDelay(p.attempts+1).Seconds()), p.event.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE sales_outbox
+				SET attempts = attempts + 1, last_error = NULL, dispatched_at = CURRENT_TIMESTAMP
+				WHERE id = $1
+			`, p.event.ID)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(pending), tx.Commit()
+}
+
+// deliveredSubscribers returns the names of the subscribers that have accepted the event
+func deliveredSubscribers(ctx context.Context, tx *sqlx.Tx, eventID int64) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT subscriber FROM sales_outbox_deliveries WHERE event_id = $1
+	`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivered := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		delivered[name] = true
+	}
+	return delivered, rows.Err()
+}
+
+// deliver hands event to every subscriber of its type and of all events that is not in
+// delivered, and returns the names of those that accepted it along with the first error of
+// those that did not. A panicking subscriber counts as failed.
+func (d *EventDispatcher) deliver(ctx context.Context, event OutboxEvent, delivered map[string]bool) ([]string, error) {
+	d.mu.RLock()
+	subscriptions := append(append([]subscription{}, d.subscribers[event.Type]...), d.subscribers[AllEvents]...)
+	d.mu.RUnlock()
+
+	var accepted []string
+	var firstErr error
+	for _, s := range subscriptions {
+		if delivered[s.name] {
+			continue
+		}
+		if err := callSubscriber(ctx, s.subscriber, event); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("subscriber %s: %w", s.name, err)
+			}
+			continue
+		}
+		accepted = append(accepted, s.name)
+	}
+	return accepted, firstErr
+}
+
+func callSubscriber(ctx context.Context, subscriber EventSubscriber, event OutboxEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+	}()
+	return subscriber(ctx, event)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+func TestDispatcherDeliversToSubscribers(t *testing.T) {
+	d := NewEventDispatcher(nil, zap.NewNop())
+
+	var got []string
+	d.Subscribe(EventOrderCreated, "orders", func(ctx context.Context, event OutboxEvent) error {
+		var created OrderCreated
+		if err := event.Decode(&created); err != nil {
+			return err
+		}
+		got = append(got, "order:"+created.OrderNumber)
+		return nil
+	})
+	d.Subscribe(AllEvents, "all", func(ctx context.Context, event OutboxEvent) error {
+		got = append(got, "all:"+event.Type)
+		return nil
+	})
+	d.Subscribe(EventPaymentReceived, "payments", func(ctx context.Context, event OutboxEvent) error {
+		t.Errorf("payment subscriber received %s", event.Type)
+		return nil
+	})
+
+	event := OutboxEvent{ID: 1, Type: EventOrderCreated, Payload: []byte(`{"order_number":"SO-1"}`)}
+	accepted, err := d.deliver(context.Background(), event, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(accepted, ",") != "orders,all" {
+		t.Errorf("accepted by %v, want orders and all", accepted)
+	}
+
+	want := []string{"order:SO-1", "all:" + EventOrderCreated}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("deliveries = %v, want %v", got, want)
+	}
+}
+
+func TestDispatcherReportsFailingSubscriber(t *testing.T) {
+	d := NewEventDispatcher(nil, zap.NewNop())
+	d.Subscribe(EventInvoiceIssued, "invoices", func(ctx context.Context, event OutboxEvent) error {
+		panic("boom")
+	})
+
+	event := OutboxEvent{ID: 1, Type: EventInvoiceIssued, Payload: []byte(`{}`)}
+	if _, err := d.deliver(context.Background(), event, nil); err == nil {
+		t.Error("panicking subscriber: delivery succeeded, want error")
+	}
+}
+
+func TestOutboxRetryDelayBacksOff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{30, time.Hour},
+	} {
+		if got := outboxRetryDelay(tc.attempts); got != tc.want {
+			t.Errorf("attempt %d: delay = %v, want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestDispatcherRetriesOnlyFailedSubscribers(t *testing.T) {
+	db := &scriptDB{}
+	db.on("FROM sales_outbox_deliveries", func(scriptStatement) scriptResult {
+		res := scriptResult{columns: []string{"subscriber"}}
+		for _, st := range db.ran("INSERT INTO sales_outbox_deliveries") {
+			res.rows = append(res.rows, []driver.Value{st.args[1]})
+		}
+		return res
+	})
+	db.returns("WHERE dispatched_at IS NULL",
+		"id tenant_id event_type aggregate_type aggregate_id payload occurred_at attempts",
+		[]driver.Value{int64(1), hostTenant, EventOrderCreated, "order", int64(7), []byte(`{}`),
+			time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), int64(0)})
+
+	d := NewEventDispatcher(sqlx.NewDb(sql.OpenDB(db), "postgres"), zap.NewNop())
+	calls := map[string]int{}
+	d.Subscribe(EventOrderCreated, "steady", func(ctx context.Context, event OutboxEvent) error {
+		calls["steady"]++
+		return nil
+	})
+	d.Subscribe(AllEvents, "flaky", func(ctx context.Context, event OutboxEvent) error {
+		calls["flaky"]++
+		if calls["flaky"] == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := d.DispatchPending(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls["steady"] != 1 || calls["flaky"] != 2 {
+		t.Errorf("calls = %v, want steady once and flaky twice", calls)
+	}
+	if retried := db.ran("last_error = $1"); len(retried) != 1 || !strings.Contains(retried[0].args[0].(string), "flaky") {
+		t.Errorf("retries scheduled: %v, want one naming flaky", retried)
+	}
+	if len(db.ran("dispatched_at = CURRENT_TIMESTAMP")) != 1 {
+		t.Error("event not marked dispatched once every subscriber accepted it")
+	}
+}
+
+func TestSubscriberNamesAreUnique(t *testing.T) {
+	d := NewEventDispatcher(nil, zap.NewNop())
+	subscriber := func(ctx context.Context, event OutboxEvent) error { return nil }
+	d.Subscribe(EventOrderCreated, "inventory", subscriber)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("second subscriber named inventory was registered")
+		}
+	}()
+	d.Subscribe(AllEvents, "inventory", subscriber)
+}
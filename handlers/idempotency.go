@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// idempotencyKeyHeader carries the client's key for a create request it may retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyReplayedHeader marks a response replayed from an earlier request with the key
+const idempotencyReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength fits the idempotency_key column
+const maxIdempotencyKeyLength = 255
+
+// codeIdempotencyKeyReused is the problem code of a key sent again with a different request
+const codeIdempotencyKeyReused = "idempotency_key_reused"
+
+// storedResponseHeaders are the headers kept with a response, besides its Content-Type, and
+// sent again when it is replayed
+var storedResponseHeaders = []string{"Location", "ETag"}
+
+// storeResponseAttempts is how many times a successful response is offered to the database
+// before its key is given up
+const storeResponseAttempts = 3
+
+// idempotentRoutes are the routes that honour an Idempotency-Key header, keyed by
+// "METHOD /pattern"
+var idempotentRoutes = map[string]bool{
+	"POST /orders":              true,
+	"POST /quotes":              true,
+	"POST /quotes/{id}/convert": true,
+}
+
+// idempotent makes next safe to retry. The first successful response to a request with an
+// Idempotency-Key is stored for the idempotency window, and a retry with the same key and
+// request gets it back instead of running next again; the same key with a different
+// method, path or body is rejected with 422. Keys belong to the caller, so users of a
+// tenant cannot collide.
+//
+// The key is reserved in a transaction of its own, committed before next runs, so the
+// request never holds a second connection while next uses one. A retry arriving while the
+// key is reserved is refused with 409. A request that fails releases the key, and may be
+// retried with it. Storing the response of a successful request is tried again when it
+// fails; should it never succeed, the key is released too, so the client is not locked out
+// of retries for the whole window.
+func (h *SalesHandler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r.Method, r.URL.Path, body)
+
+		reserved, err := h.reserveIdempotencyKey(r, key, hash)
+		if err != nil {
+			h.logger.Error("Failed to reserve idempotency key", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to process request")
+			return
+		}
+		if !reserved {
+			h.replayResponse(w, r, key, hash)
+			return
+		}
+
+		rec := newResponseRecorder()
+		next(rec, r)
+
+		if rec.status >= 200 && rec.status < 300 {
+			if err = h.storeResponse(r, key, rec); err != nil {
+				// The request itself succeeded; a retry of it runs again
+				h.logger.Error("Failed to store idempotent response", zap.String("key", key), zap.Error(err))
+				h.releaseIdempotencyKey(r, key)
+			}
+		} else {
+			h.releaseIdempotencyKey(r, key)
+		}
+
+		rec.writeTo(w)
+	}
+}
+
+// storeResponse keeps the response rec recorded for key, trying again when it fails
+func (h *SalesHandler) storeResponse(r *http.Request, key string, rec *responseRecorder) error {
+	headers := map[string]string{}
+	for _, name := range storedResponseHeaders {
+		if value := rec.header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = h.inRequestTx(r, func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(`
+				UPDATE sales_idempotency_keys
+				SET status_code = $1, content_type = $2, response_body = $3, response_headers = $4
+				WHERE tenant_id = $5 AND user_id = $6 AND idempotency_key = $7
+			`, rec.status, rec.header.Get("Content-Type"), rec.body.Bytes(), encoded, requestTenant(r),
+				requestUser(r), key)
+			return err
+		})
+		if err == nil || attempt == storeResponseAttempts {
+			return err
+		}
+		h.logger.Warn("Failed to store idempotent response, trying again", zap.String("key", key),
+			zap.Int("attempt", attempt), zap.Error(err))
+	}
+}
+
+// releaseIdempotencyKey frees key, reserved by a request whose response is not kept. A key
+// that cannot be released stays reserved until it expires.
+func (h *SalesHandler) releaseIdempotencyKey(r *http.Request, key string) {
+	err := h.inRequestTx(r, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM sales_idempotency_keys
+			WHERE tenant_id = $1 AND user_id = $2 AND idempotency_key = $3 AND status_code IS NULL
+		`, requestTenant(r), requestUser(r), key)
+		return err
+	})
+	if err != nil {
+		h.logger.Error("Failed to release idempotency key", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// reserveIdempotencyKey records key for the request hashed to hash, and reports whether it
+// was free. Expired keys are purged first, so they can be reserved again.
+func (h *SalesHandler) reserveIdempotencyKey(r *http.Request, key, hash string) (bool, error) {
+	tenantID := requestTenant(r)
+
+	var reserved int64
+	err := h.inRequestTx(r, func(tx *sqlx.Tx) error {
+		window := h.loadSettings(tx, tenantID).IdempotencyWindowHours
+
+		_, err := tx.Exec(`
+			DELETE FROM sales_idempotency_keys
+			WHERE tenant_id = $1 AND expires_at <= CURRENT_TIMESTAMP
+		`, tenantID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(`
+			INSERT INTO sales_idempotency_keys (tenant_id, user_id, idempotency_key, request_hash, expires_at)
+			VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(hours => $5))
+			ON CONFLICT (tenant_id, user_id, idempotency_key) DO NOTHING
+		`, tenantID, requestUser(r), key, hash, window)
+		if err != nil {
+			return err
+		}
+		reserved, err = result.RowsAffected()
+		return err
+	})
+	return reserved > 0, err
+}
+
+// inRequestTx runs fn in a request transaction of its own, committed when fn succeeds
+func (h *SalesHandler) inRequestTx(r *http.Request, fn func(tx *sqlx.Tx) error) error {
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replayResponse answers a request whose key was used before with the stored response
+func (h *SalesHandler) replayResponse(w http.ResponseWriter, r *http.Request, key, hash string) {
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+	defer tx.Rollback()
+
+	var storedHash string
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body, headers []byte
+	err = tx.QueryRow(`
+		SELECT request_hash, status_code, content_type, response_body, response_headers
+		FROM sales_idempotency_keys
+		WHERE tenant_id = $1 AND user_id = $2 AND idempotency_key = $3
+	`, requestTenant(r), requestUser(r), key).Scan(&storedHash, &status, &contentType, &body, &headers)
+	if err == sql.ErrNoRows {
+		// The request holding the key failed and released it meanwhile
+		writeError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to fetch idempotent response", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	if storedHash != hash {
+		writeProblem(w, Problem{
+			Status: http.StatusUnprocessableEntity,
+			Detail: "Idempotency-Key was already used with a different request",
+			Code:   codeIdempotencyKeyReused,
+		})
+		return
+	}
+	if !status.Valid {
+		writeError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		return
+	}
+
+	if contentType.Valid && contentType.String != "" {
+		w.Header().Set("Content-Type", contentType.String)
+	}
+	if len(headers) > 0 {
+		var stored map[string]string
+		if err := json.Unmarshal(headers, &stored); err != nil {
+			h.logger.Error("Failed to decode idempotent response headers", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to process request")
+			return
+		}
+		for name, value := range stored {
+			w.Header().Set(name, value)
+		}
+	}
+	w.Header().Set(idempotencyReplayedHeader, "true")
+	w.WriteHeader(int(status.Int64))
+	w.Write(body)
+}
+
+// requestHash fingerprints a request, so a key sent again can be told to carry the same one
+func requestHash(method, path string, body []byte) string {
+	sum := sha256.New()
+	io.WriteString(sum, method+" "+path+"\n")
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// responseRecorder buffers a handler's response so it can be stored before it is sent
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{header: http.Header{}}
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// writeTo sends the recorded response to w
+func (rec *responseRecorder) writeTo(w http.ResponseWriter) {
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	w.WriteHeader(rec.status)
+	w.Write(rec.body.Bytes())
+}
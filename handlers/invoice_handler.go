@@ -0,0 +1,1258 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+const invoiceColumns = `
+	si.id, si.invoice_number, si.order_id, si.customer_id, si.invoice_date, si.due_date,
+	si.status, si.subtotal, si.tax_rate, si.tax_amount, si.discount_amount, si.shipping_amount,
+	si.total_amount, si.paid_amount, si.credited_amount, si.balance_due, si.currency,
+	si.document_discount_percent, si.document_discount_amount, si.prices_include_tax, si.ship_to_country,
+	si.ship_to_region, si.ship_to_city, si.ship_to_postal_code, si.seller_vat_id, si.buyer_vat_id,
+	si.vat_treatment, si.payment_terms, si.notes,
+	si.sent_at, si.voided_at, si.void_reason, si.created_by, si.created_at, si.updated_at,
+	si.updated_by, si.version
+`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanInvoice(row rowScanner, invoice *SalesInvoice, extra ...interface{}) error {
+	dest := []interface{}{
+		&invoice.ID, &invoice.InvoiceNumber, &invoice.OrderID, &invoice.CustomerID,
+		&invoice.InvoiceDate, &invoice.DueDate, &invoice.Status, &invoice.Subtotal,
+		&invoice.TaxRate, &invoice.TaxAmount, &invoice.DiscountAmount, &invoice.ShippingAmount,
+		&invoice.TotalAmount, &invoice.PaidAmount, &invoice.CreditedAmount, &invoice.BalanceDue,
+		&invoice.Currency, &invoice.DocumentDiscountPercent, &invoice.DocumentDiscountAmount,
+		&invoice.PricesIncludeTax, &invoice.ShipToCountry, &invoice.ShipToRegion, &invoice.ShipToCity,
+		&invoice.ShipToPostalCode, &invoice.SellerVATID, &invoice.BuyerVATID, &invoice.VATTreatment,
+		&invoice.PaymentTerms, &invoice.Notes, &invoice.SentAt, &invoice.VoidedAt, &invoice.VoidReason,
+		&invoice.CreatedBy, &invoice.CreatedAt, &invoice.UpdatedAt, &invoice.UpdatedBy, &invoice.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	invoice.VATLegend = vatLegend(invoice.VATTreatment)
+	return nil
+}
+
+// dueDateForTerms derives an invoice due date from the payment terms codes offered
+// by the default_payment_terms setting. Unknown terms leave the due date unset.
+func dueDateForTerms(invoiceDate time.Time, terms *string) *time.Time {
+	if terms == nil {
+		return nil
+	}
+
+	var days int
+	switch *terms {
+	case "due_on_receipt":
+		days = 0
+	case "net_15":
+		days = 15
+	case "net_30":
+		days = 30
+	case "net_60":
+		days = 60
+	default:
+		return nil
+	}
+
+	dueDate := invoiceDate.AddDate(0, 0, days)
+	return &dueDate
+}
+
+// lockInvoiceStatus locks the tenant's invoice row for the rest of the transaction and returns its status.
+func lockInvoiceStatus(tx *sqlx.Tx, tenantID string, invoiceID int) (string, error) {
+	var status string
+	err := tx.QueryRow("SELECT status FROM sales_invoices WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+		invoiceID, tenantID).Scan(&status)
+	return status, err
+}
+
+// recordInvoiceIssued records the InvoiceIssued event for an invoice that was just sent
+func recordInvoiceIssued(tx *sqlx.Tx, tenantID string, invoiceID int) error {
+	var invoice SalesInvoice
+	err := scanInvoice(tx.QueryRow("SELECT "+invoiceColumns+" FROM sales_invoices si WHERE si.id = $1 AND si.tenant_id = $2",
+		invoiceID, tenantID), &invoice)
+	if err != nil {
+		return err
+	}
+	return recordEvent(tx, tenantID, InvoiceIssued{
+		InvoiceID:     invoice.ID,
+		InvoiceNumber: invoice.InvoiceNumber,
+		OrderID:       invoice.OrderID,
+		CustomerID:    invoice.CustomerID,
+		InvoiceDate:   invoice.InvoiceDate,
+		DueDate:       invoice.DueDate,
+		TotalAmount:   invoice.TotalAmount,
+		Currency:      invoice.Currency,
+	})
+}
+
+// Sales Invoice Handlers
+
+// GetSalesInvoices retrieves all sales invoices with optional filtering
+func (h *SalesHandler) GetSalesInvoices(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	customerID := r.URL.Query().Get("customer_id")
+	orderID := r.URL.Query().Get("order_id")
+	limit := r.URL.Query().Get("limit")
+
+	if limit == "" {
+		limit = "50"
+	}
+
+	query := `
+		SELECT ` + invoiceColumns + `, c.first_name, c.last_name, c.company_name, c.email
+		FROM sales_invoices si
+		LEFT JOIN customers c ON si.customer_id = c.id
+		WHERE si.tenant_id = $1
+	`
+
+	args := []interface{}{requestTenant(r)}
+	argIndex := 2
+
+	if status != "" {
+		query += fmt.Sprintf(" AND si.status = $%d", argIndex)
+		args = append(args, status)
+		argIndex++
+	}
+
+	if customerID != "" {
+		query += fmt.Sprintf(" AND si.customer_id = $%d", argIndex)
+		args = append(args, customerID)
+		argIndex++
+	}
+
+	if orderID != "" {
+		query += fmt.Sprintf(" AND si.order_id = $%d", argIndex)
+		args = append(args, orderID)
+		argIndex++
+	}
+
+	query += fmt.Sprintf(" ORDER BY si.invoice_date DESC, si.id DESC LIMIT $%d", argIndex)
+	args = append(args, limit)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales invoices")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales invoices", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales invoices")
+		return
+	}
+	defer rows.Close()
+
+	var invoices []SalesInvoice
+	for rows.Next() {
+		var invoice SalesInvoice
+		var firstName, lastName, companyName, email sql.NullString
+
+		err := scanInvoice(rows, &invoice, &firstName, &lastName, &companyName, &email)
+		if err != nil {
+			h.logger.Error("Failed to scan sales invoice", zap.Error(err))
+			continue
+		}
+
+		invoice.Customer = &Customer{
+			ID:          invoice.CustomerID,
+			CompanyName: &companyName.String,
+			FirstName:   &firstName.String,
+			LastName:    &lastName.String,
+			Email:       &email.String,
+		}
+
+		invoices = append(invoices, invoice)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"invoices": invoices,
+		"count":    len(invoices),
+	})
+}
+
+// GetSalesInvoice retrieves a single sales invoice with its items
+func (h *SalesHandler) GetSalesInvoice(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales invoice")
+		return
+	}
+	defer tx.Rollback()
+
+	invoice, err := fetchSalesInvoice(tx, tenantID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales invoice not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales invoice")
+		return
+	}
+
+	setETag(w, invoice.Version)
+	sdk.WriteJSON(w, http.StatusOK, invoice)
+}
+
+// fetchSalesInvoice loads an invoice with its customer and items
+func fetchSalesInvoice(q sqlx.Queryer, tenantID string, id int) (SalesInvoice, error) {
+	query := `
+		SELECT ` + invoiceColumns + `, c.first_name, c.last_name, c.company_name, c.email, c.phone
+		FROM sales_invoices si
+		LEFT JOIN customers c ON si.customer_id = c.id
+		WHERE si.id = $1 AND si.tenant_id = $2
+	`
+
+	var invoice SalesInvoice
+	var firstName, lastName, companyName, email, phone sql.NullString
+
+	err := scanInvoice(q.QueryRowx(query, id, tenantID), &invoice, &firstName, &lastName, &companyName, &email, &phone)
+	if err != nil {
+		return invoice, err
+	}
+
+	invoice.Customer = &Customer{
+		ID:          invoice.CustomerID,
+		CompanyName: &companyName.String,
+		FirstName:   &firstName.String,
+		LastName:    &lastName.String,
+		Email:       &email.String,
+		Phone:       &phone.String,
+	}
+
+	invoice.Items, err = fetchInvoiceItems(q, tenantID, id)
+	return invoice, err
+}
+
+func fetchInvoiceItems(q sqlx.Queryer, tenantID string, invoiceID int) ([]SalesInvoiceItem, error) {
+	query := `
+		SELECT sii.id, sii.invoice_id, sii.order_item_id, sii.product_id, sii.quantity, sii.unit_price,
+		       sii.discount_percent, sii.discount_amount, sii.line_total, sii.tax_category, sii.tax_amount,
+		       sii.taxes, sii.credited_quantity,
+		       sii.notes, sii.created_at,
+		       p.name as product_name, p.sku, p.description
+		FROM sales_invoice_items sii
+		LEFT JOIN products p ON sii.product_id = p.id
+		WHERE sii.invoice_id = $1 AND sii.tenant_id = $2
+		ORDER BY sii.id
+	`
+
+	rows, err := q.Query(query, invoiceID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []SalesInvoiceItem
+	for rows.Next() {
+		var item SalesInvoiceItem
+		var taxes []byte
+		var productName, sku, description sql.NullString
+
+		err := rows.Scan(
+			&item.ID, &item.InvoiceID, &item.OrderItemID, &item.ProductID, &item.Quantity, &item.UnitPrice,
+			&item.DiscountPercent, &item.DiscountAmount, &item.LineTotal, &item.TaxCategory, &item.TaxAmount,
+			&taxes, &item.CreditedQuantity, &item.Notes, &item.CreatedAt, &productName, &sku, &description,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(taxes, &item.Taxes); err != nil {
+			return nil, err
+		}
+
+		item.Product = &Product{
+			ID:          item.ProductID,
+			Name:        productName.String,
+			SKU:         sku.String,
+			Description: &description.String,
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// createSalesInvoiceRequest is the body of CreateSalesInvoice
+type createSalesInvoiceRequest struct {
+	CustomerID   int                `json:"customer_id" validate:"required"`
+	OrderID      *int               `json:"order_id"`
+	InvoiceDate  string             `json:"invoice_date" validate:"required,date"`
+	DueDate      *string            `json:"due_date" validate:"date"`
+	PaymentTerms *string            `json:"payment_terms"`
+	Currency     *string            `json:"currency" validate:"currency"`
+	TaxRate      *Decimal           `json:"tax_rate" validate:"gte=0,lte=100"`
+	TaxAmount    *Decimal           `json:"tax_amount" validate:"gte=0"`
+	Notes        *string            `json:"notes"`
+	Items        []SalesInvoiceItem `json:"items" validate:"required"`
+
+	// Document-level amounts. Tax is worked out from tax_rate, which defaults to the
+	// default_tax_rate setting, unless a fixed tax_amount is given instead.
+	DocumentDiscountPercent Decimal `json:"document_discount_percent" validate:"gte=0,lte=100"`
+	DocumentDiscountAmount  Decimal `json:"document_discount_amount" validate:"gte=0"`
+	ShippingAmount          Decimal `json:"shipping_amount" validate:"gte=0"`
+
+	// A ship-to address within a tax jurisdiction is taxed at the jurisdiction's rates
+	// rather than tax_rate
+	PricesIncludeTax *bool   `json:"prices_include_tax"`
+	ShipToCountry    *string `json:"ship_to_country" validate:"country"`
+	ShipToRegion     *string `json:"ship_to_region"`
+	ShipToCity       *string `json:"ship_to_city"`
+	ShipToPostalCode *string `json:"ship_to_postal_code"`
+	BuyerVATID       *string `json:"buyer_vat_id" validate:"vat_id"`
+}
+
+func (req createSalesInvoiceRequest) validate() []FieldError {
+	return taxErrors(req.TaxRate, req.TaxAmount)
+}
+
+// taxErrors rejects a document that sets both a tax rate and a fixed tax amount
+func taxErrors(rate, amount *Decimal) []FieldError {
+	if rate != nil && amount != nil {
+		return []FieldError{{"tax_amount", fieldInvalid, "cannot be combined with tax_rate"}}
+	}
+	return nil
+}
+
+// CreateSalesInvoice creates a new draft sales invoice
+func (h *SalesHandler) CreateSalesInvoice(w http.ResponseWriter, r *http.Request) {
+	var req createSalesInvoiceRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	invoiceDate, err := time.Parse("2006-01-02", req.InvoiceDate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice date format")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create invoice")
+		return
+	}
+	defer tx.Rollback()
+
+	productIDs := make([]int, len(req.Items))
+	for i, item := range req.Items {
+		productIDs[i] = item.ProductID
+	}
+	if err := checkReferences(tx, tenantID, documentReferences(req.CustomerID, productIDs)...); err != nil {
+		h.writeRequestError(w, err, "Failed to create sales invoice")
+		return
+	}
+
+	if req.PaymentTerms == nil {
+		defaultTerms := h.loadSettings(tx, tenantID).DefaultPaymentTerms
+		req.PaymentTerms = &defaultTerms
+	}
+
+	dueDate := dueDateForTerms(invoiceDate, req.PaymentTerms)
+	if req.DueDate != nil {
+		dd, err := time.Parse("2006-01-02", *req.DueDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid due date format")
+			return
+		}
+		if dd.Before(invoiceDate) {
+			writeError(w, http.StatusBadRequest, "Due date cannot be before invoice date")
+			return
+		}
+		dueDate = &dd
+	}
+
+	if req.OrderID != nil {
+		if err := checkTenantRef(tx, "sales_orders", *req.OrderID, tenantID, "Sales order not found"); err != nil {
+			h.writeRequestError(w, err, "Failed to create sales invoice")
+			return
+		}
+	}
+
+	settings := h.loadSettings(tx, tenantID)
+	currency := requestCurrency(req.Currency, settings.DefaultCurrency)
+	var taxAmount Decimal
+	if req.TaxAmount != nil {
+		taxAmount = *req.TaxAmount
+	} else if req.TaxRate == nil {
+		req.TaxRate = &settings.DefaultTaxRate
+	}
+	if req.PricesIncludeTax == nil {
+		req.PricesIncludeTax = &settings.PricesIncludeTax
+	}
+	buyerVATID := normalizedVATID(req.BuyerVATID)
+	sellerVATID, vatTreatment := settings.vatTreatment(buyerVATID, req.ShipToCountry)
+
+	invoiceNumber, err := settings.nextDocumentNumber(tx, tenantID, numberInvoice, invoiceDate)
+	if err != nil {
+		h.logger.Error("Failed to allocate invoice number", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create invoice")
+		return
+	}
+
+	invoiceQuery := `
+		INSERT INTO sales_invoices (tenant_id, invoice_number, order_id, customer_id, invoice_date, due_date,
+		                            document_discount_percent, document_discount_amount, shipping_amount,
+		                            tax_rate, tax_amount, prices_include_tax, ship_to_country, ship_to_region,
+		                            ship_to_city, ship_to_postal_code, seller_vat_id, buyer_vat_id, vat_treatment,
+		                            currency, payment_terms, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21,
+		        $22, $23)
+		RETURNING id, created_at, updated_at
+	`
+
+	var invoiceID int
+	var createdAt, updatedAt time.Time
+
+	err = tx.QueryRow(invoiceQuery, tenantID, invoiceNumber, req.OrderID, req.CustomerID, invoiceDate, dueDate,
+		req.DocumentDiscountPercent, req.DocumentDiscountAmount, req.ShippingAmount, req.TaxRate, taxAmount,
+		*req.PricesIncludeTax, req.ShipToCountry, req.ShipToRegion, req.ShipToCity, req.ShipToPostalCode,
+		sellerVATID, buyerVATID, vatTreatment, currency, req.PaymentTerms, req.Notes, requestUser(r)).
+		Scan(&invoiceID, &createdAt, &updatedAt)
+	if err != nil {
+		h.logger.Error("Failed to create sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales invoice")
+		return
+	}
+
+	for _, item := range req.Items {
+		_, err = tx.Exec(`
+			INSERT INTO sales_invoice_items (tenant_id, invoice_id, product_id, quantity, unit_price,
+			                                 discount_percent, discount_amount, tax_category, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, tenantID, invoiceID, item.ProductID, item.Quantity, item.UnitPrice,
+			item.DiscountPercent, item.DiscountAmount, item.TaxCategory, item.Notes)
+		if err != nil {
+			h.logger.Error("Failed to create invoice item", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to create invoice item")
+			return
+		}
+	}
+
+	if _, err = h.recalculateTotals(tx, tenantID, invoiceDocument, invoiceID); err != nil {
+		h.logger.Error("Failed to calculate invoice totals", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create invoice")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create invoice")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"invoice_id":     invoiceID,
+		"invoice_number": invoiceNumber,
+		"created_at":     createdAt,
+		"updated_at":     updatedAt,
+		"message":        "Sales invoice created successfully",
+	})
+}
+
+// updateSalesInvoiceRequest is the body of UpdateSalesInvoice
+type updateSalesInvoiceRequest struct {
+	Status       *string  `json:"status"`
+	InvoiceDate  *string  `json:"invoice_date" validate:"date"`
+	DueDate      *string  `json:"due_date" validate:"date"`
+	PaymentTerms *string  `json:"payment_terms"`
+	TaxRate      *Decimal `json:"tax_rate" validate:"gte=0,lte=100"`
+	TaxAmount    *Decimal `json:"tax_amount" validate:"gte=0"`
+	Notes        *string  `json:"notes"`
+
+	DocumentDiscountPercent *Decimal `json:"document_discount_percent" validate:"gte=0,lte=100"`
+	DocumentDiscountAmount  *Decimal `json:"document_discount_amount" validate:"gte=0"`
+	ShippingAmount          *Decimal `json:"shipping_amount" validate:"gte=0"`
+
+	PricesIncludeTax *bool   `json:"prices_include_tax"`
+	ShipToCountry    *string `json:"ship_to_country" validate:"country"`
+	ShipToRegion     *string `json:"ship_to_region"`
+	ShipToCity       *string `json:"ship_to_city"`
+	ShipToPostalCode *string `json:"ship_to_postal_code"`
+	BuyerVATID       *string `json:"buyer_vat_id" validate:"vat_id"`
+}
+
+func (req updateSalesInvoiceRequest) validate() []FieldError {
+	return taxErrors(req.TaxRate, req.TaxAmount)
+}
+
+// changesTotals reports whether the request changes an input of the invoice totals. The
+// invoice date, ship-to address and buyer's VAT ID decide which tax rates apply.
+func (req updateSalesInvoiceRequest) changesTotals() bool {
+	return req.TaxRate != nil || req.TaxAmount != nil || req.DocumentDiscountPercent != nil ||
+		req.DocumentDiscountAmount != nil || req.ShippingAmount != nil || req.PricesIncludeTax != nil ||
+		req.InvoiceDate != nil || req.ShipToCountry != nil || req.ShipToRegion != nil ||
+		req.ShipToCity != nil || req.ShipToPostalCode != nil || req.BuyerVATID != nil
+}
+
+// UpdateSalesInvoice updates invoice header fields and moves the invoice through its status lifecycle
+func (h *SalesHandler) UpdateSalesInvoice(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	var req updateSalesInvoiceRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+		return
+	}
+	defer tx.Rollback()
+
+	currentStatus, err := lockInvoiceStatus(tx, tenantID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales invoice not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+		return
+	}
+
+	if !h.matchInvoiceVersion(w, r, tx, id, "Failed to update sales invoice") {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	// Amount and date fields are only editable while the invoice is a draft
+	if currentStatus != "draft" && (req.InvoiceDate != nil || req.PaymentTerms != nil || req.changesTotals()) {
+		writeError(w, http.StatusConflict, "Only draft invoices can be edited")
+		return
+	}
+
+	if req.Status != nil && *req.Status != currentStatus {
+		if *req.Status == "cancelled" {
+			writeError(w, http.StatusBadRequest, "Use the void endpoint to cancel an invoice")
+			return
+		}
+		if err := checkInvoiceTransition(tx, tenantID, id, currentStatus, *req.Status); err != nil {
+			h.writeRequestError(w, err, "Failed to update sales invoice")
+			return
+		}
+		setParts = append(setParts, fmt.Sprintf("status = $%d", argIndex))
+		args = append(args, *req.Status)
+		argIndex++
+
+		if *req.Status == "sent" {
+			setParts = append(setParts, "sent_at = CURRENT_TIMESTAMP")
+		}
+	}
+	if req.InvoiceDate != nil {
+		invoiceDate, err := time.Parse("2006-01-02", *req.InvoiceDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid invoice date format")
+			return
+		}
+		setParts = append(setParts, fmt.Sprintf("invoice_date = $%d", argIndex))
+		args = append(args, invoiceDate)
+		argIndex++
+	}
+	if req.DueDate != nil {
+		dd, err := time.Parse("2006-01-02", *req.DueDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid due date format")
+			return
+		}
+		setParts = append(setParts, fmt.Sprintf("due_date = $%d", argIndex))
+		args = append(args, dd)
+		argIndex++
+	}
+	if req.PaymentTerms != nil {
+		setParts = append(setParts, fmt.Sprintf("payment_terms = $%d", argIndex))
+		args = append(args, *req.PaymentTerms)
+		argIndex++
+	}
+	if req.TaxRate != nil {
+		setParts = append(setParts, fmt.Sprintf("tax_rate = $%d", argIndex))
+		args = append(args, *req.TaxRate)
+		argIndex++
+	}
+	if req.TaxAmount != nil {
+		// A fixed tax amount replaces the rate
+		setParts = append(setParts, "tax_rate = NULL", fmt.Sprintf("tax_amount = $%d", argIndex))
+		args = append(args, *req.TaxAmount)
+		argIndex++
+	}
+	if req.DocumentDiscountPercent != nil {
+		setParts = append(setParts, fmt.Sprintf("document_discount_percent = $%d", argIndex))
+		args = append(args, *req.DocumentDiscountPercent)
+		argIndex++
+	}
+	if req.DocumentDiscountAmount != nil {
+		setParts = append(setParts, fmt.Sprintf("document_discount_amount = $%d", argIndex))
+		args = append(args, *req.DocumentDiscountAmount)
+		argIndex++
+	}
+	if req.ShippingAmount != nil {
+		setParts = append(setParts, fmt.Sprintf("shipping_amount = $%d", argIndex))
+		args = append(args, *req.ShippingAmount)
+		argIndex++
+	}
+	if req.PricesIncludeTax != nil {
+		setParts = append(setParts, fmt.Sprintf("prices_include_tax = $%d", argIndex))
+		args = append(args, *req.PricesIncludeTax)
+		argIndex++
+	}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"ship_to_country", req.ShipToCountry},
+		{"ship_to_region", req.ShipToRegion},
+		{"ship_to_city", req.ShipToCity},
+		{"ship_to_postal_code", req.ShipToPostalCode},
+	} {
+		if field.value != nil {
+			setParts = append(setParts, fmt.Sprintf("%s = $%d", field.column, argIndex))
+			args = append(args, *field.value)
+			argIndex++
+		}
+	}
+	if req.BuyerVATID != nil {
+		setParts = append(setParts, fmt.Sprintf("buyer_vat_id = $%d", argIndex))
+		args = append(args, normalizeVATID(*req.BuyerVATID))
+		argIndex++
+	}
+	if req.Notes != nil {
+		setParts = append(setParts, fmt.Sprintf("notes = $%d", argIndex))
+		args = append(args, *req.Notes)
+		argIndex++
+	}
+
+	if len(setParts) == 0 {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE sales_invoices SET %s WHERE id = $%d AND tenant_id = $%d",
+		strings.Join(setParts, ", "), argIndex, argIndex+1)
+	args = append(args, id, tenantID)
+
+	if _, err = tx.Exec(query, args...); err != nil {
+		h.logger.Error("Failed to update sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+		return
+	}
+
+	// A draft shipping elsewhere or to another buyer may change VAT treatment
+	if req.ShipToCountry != nil || req.BuyerVATID != nil {
+		var sellerVATID, buyerVATID, shipToCountry *string
+		err = tx.QueryRow("SELECT seller_vat_id, buyer_vat_id, ship_to_country FROM sales_invoices WHERE id = $1 AND tenant_id = $2",
+			id, tenantID).Scan(&sellerVATID, &buyerVATID, &shipToCountry)
+		if err == nil {
+			treatment := determineVATTreatment(sellerVATID, buyerVATID, shipToCountry,
+				h.loadSettings(tx, tenantID).VATOSSRegistered)
+			_, err = tx.Exec("UPDATE sales_invoices SET vat_treatment = $1 WHERE id = $2 AND tenant_id = $3",
+				treatment, id, tenantID)
+		}
+		if err != nil {
+			h.logger.Error("Failed to determine VAT treatment", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+			return
+		}
+	}
+
+	if req.changesTotals() {
+		if _, err = h.recalculateTotals(tx, tenantID, invoiceDocument, id); err != nil {
+			h.logger.Error("Failed to calculate invoice totals", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+			return
+		}
+	}
+
+	if req.Status != nil && *req.Status == "sent" && currentStatus != "sent" {
+		if err = recordInvoiceIssued(tx, tenantID, id); err != nil {
+			h.logger.Error("Failed to record invoice event", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+			return
+		}
+	}
+
+	version, err := lockVersion(tx, "sales_invoices", tenantID, id)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales invoice version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales invoice")
+		return
+	}
+
+	setETag(w, version)
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Sales invoice updated successfully",
+		"version": version,
+	})
+}
+
+// SendSalesInvoice issues a draft invoice to the customer
+func (h *SalesHandler) SendSalesInvoice(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to send sales invoice")
+		return
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, tenantID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales invoice not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to send sales invoice")
+		return
+	}
+
+	if err := checkInvoiceTransition(tx, tenantID, id, status, "sent"); err != nil {
+		h.writeRequestError(w, err, "Failed to send sales invoice")
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sales_invoices SET status = 'sent', sent_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND tenant_id = $2
+	`, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to send sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to send sales invoice")
+		return
+	}
+
+	if err = recordInvoiceIssued(tx, tenantID, id); err != nil {
+		h.logger.Error("Failed to record invoice event", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to send sales invoice")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to send sales invoice")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"invoice_id": id,
+		"status":     "sent",
+		"message":    "Sales invoice sent successfully",
+	})
+}
+
+// voidSalesInvoiceRequest is the body of VoidSalesInvoice
+type voidSalesInvoiceRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// VoidSalesInvoice cancels an invoice that has not received any payment
+func (h *SalesHandler) VoidSalesInvoice(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	var req voidSalesInvoiceRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to void sales invoice")
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	var paidAmount, creditedAmount Decimal
+	err = tx.QueryRow(`
+		SELECT status, paid_amount, credited_amount FROM sales_invoices
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE
+	`, id, tenantID).Scan(&status, &paidAmount, &creditedAmount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales invoice not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to void sales invoice")
+		return
+	}
+
+	if !invoiceLifecycle.allows(status, "cancelled") {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Cannot void an invoice with status %s", status))
+		return
+	}
+
+	if paidAmount.Sign() > 0 {
+		writeError(w, http.StatusConflict, "Cannot void an invoice with recorded payments")
+		return
+	}
+
+	if creditedAmount.Sign() > 0 {
+		writeError(w, http.StatusConflict, "Cannot void an invoice with issued credit notes")
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sales_invoices
+		SET status = 'cancelled', voided_at = CURRENT_TIMESTAMP, void_reason = $1
+		WHERE id = $2 AND tenant_id = $3
+	`, req.Reason, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to void sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to void sales invoice")
+		return
+	}
+
+	if err = releaseInvoicedOrderLines(tx, tenantID, id); err != nil {
+		h.logger.Error("Failed to release invoiced order lines", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to void sales invoice")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to void sales invoice")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Sales invoice voided successfully",
+	})
+}
+
+// Sales Invoice Item Handlers
+
+// GetSalesInvoiceItems retrieves the item lines of an invoice
+func (h *SalesHandler) GetSalesInvoiceItems(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales invoice items")
+		return
+	}
+	defer tx.Rollback()
+
+	items, err := fetchInvoiceItems(tx, requestTenant(r), id)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales invoice items", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales invoice items")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"items": items,
+		"count": len(items),
+	})
+}
+
+// AddSalesInvoiceItem adds an item line to a draft invoice
+func (h *SalesHandler) AddSalesInvoiceItem(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	invoiceID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+
+	var item SalesInvoiceItem
+	if !decodeRequest(w, r, &item) {
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to add invoice item")
+		return
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, tenantID, invoiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales invoice not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to add invoice item")
+		return
+	}
+	if status != "draft" {
+		writeError(w, http.StatusConflict, "Only draft invoices can be edited")
+		return
+	}
+	if !h.matchInvoiceVersion(w, r, tx, invoiceID, "Failed to add invoice item") {
+		return
+	}
+
+	if err := checkReferences(tx, tenantID, reference{"product_id", "products", item.ProductID}); err != nil {
+		h.writeRequestError(w, err, "Failed to add invoice item")
+		return
+	}
+
+	var itemID int
+	err = tx.QueryRow(`
+		INSERT INTO sales_invoice_items (tenant_id, invoice_id, product_id, quantity, unit_price,
+		                                 discount_percent, discount_amount, tax_category, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`, tenantID, invoiceID, item.ProductID, item.Quantity, item.UnitPrice,
+		item.DiscountPercent, item.DiscountAmount, item.TaxCategory, item.Notes).Scan(&itemID)
+	if err != nil {
+		h.logger.Error("Failed to create invoice item", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to add invoice item")
+		return
+	}
+
+	if _, err = h.recalculateTotals(tx, tenantID, invoiceDocument, invoiceID); err != nil {
+		h.logger.Error("Failed to calculate invoice totals", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to add invoice item")
+		return
+	}
+
+	version, err := lockVersion(tx, "sales_invoices", tenantID, invoiceID)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales invoice version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to add invoice item")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to add invoice item")
+		return
+	}
+
+	setETag(w, version)
+	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"item_id": itemID,
+		"message": "Invoice item added successfully",
+		"version": version,
+	})
+}
+
+// updateSalesInvoiceItemRequest is the body of UpdateSalesInvoiceItem
+type updateSalesInvoiceItemRequest struct {
+	Quantity        *int     `json:"quantity" validate:"gt=0"`
+	UnitPrice       *Decimal `json:"unit_price" validate:"gte=0"`
+	DiscountPercent *Decimal `json:"discount_percent" validate:"gte=0,lte=100"`
+	DiscountAmount  *Decimal `json:"discount_amount" validate:"gte=0"`
+	TaxCategory     *string  `json:"tax_category"`
+	Notes           *string  `json:"notes"`
+}
+
+// validate checks the discount against the line value when the request sets all three
+func (req updateSalesInvoiceItemRequest) validate() []FieldError {
+	if req.Quantity == nil || req.UnitPrice == nil || req.DiscountAmount == nil {
+		return nil
+	}
+	return discountErrors(*req.Quantity, *req.UnitPrice, *req.DiscountAmount)
+}
+
+// UpdateSalesInvoiceItem updates an item line on a draft invoice
+func (h *SalesHandler) UpdateSalesInvoiceItem(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+	itemID, err := strconv.Atoi(chi.URLParam(r, "itemId"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid item ID")
+		return
+	}
+
+	var req updateSalesInvoiceItemRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.Quantity != nil {
+		setParts = append(setParts, fmt.Sprintf("quantity = $%d", argIndex))
+		args = append(args, *req.Quantity)
+		argIndex++
+	}
+	if req.UnitPrice != nil {
+		setParts = append(setParts, fmt.Sprintf("unit_price = $%d", argIndex))
+		args = append(args, *req.UnitPrice)
+		argIndex++
+	}
+	if req.DiscountPercent != nil {
+		setParts = append(setParts, fmt.Sprintf("discount_percent = $%d", argIndex))
+		args = append(args, *req.DiscountPercent)
+		argIndex++
+	}
+	if req.DiscountAmount != nil {
+		setParts = append(setParts, fmt.Sprintf("discount_amount = $%d", argIndex))
+		args = append(args, *req.DiscountAmount)
+		argIndex++
+	}
+	if req.TaxCategory != nil {
+		setParts = append(setParts, fmt.Sprintf("tax_category = NULLIF($%d, '')", argIndex))
+		args = append(args, *req.TaxCategory)
+		argIndex++
+	}
+	if req.Notes != nil {
+		setParts = append(setParts, fmt.Sprintf("notes = $%d", argIndex))
+		args = append(args, *req.Notes)
+		argIndex++
+	}
+
+	if len(setParts) == 0 {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update invoice item")
+		return
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, tenantID, invoiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales invoice not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update invoice item")
+		return
+	}
+	if status != "draft" {
+		writeError(w, http.StatusConflict, "Only draft invoices can be edited")
+		return
+	}
+	if !h.matchInvoiceVersion(w, r, tx, invoiceID, "Failed to update invoice item") {
+		return
+	}
+
+	// Lines generated from an order are fixed; void and re-invoice the order instead
+	query := fmt.Sprintf(`
+		UPDATE sales_invoice_items SET %s
+		WHERE id = $%d AND invoice_id = $%d AND tenant_id = $%d AND order_item_id IS NULL
+	`, strings.Join(setParts, ", "), argIndex, argIndex+1, argIndex+2)
+	args = append(args, itemID, invoiceID, tenantID)
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to update invoice item", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update invoice item")
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "Invoice item not found or generated from an order line")
+		return
+	}
+
+	if _, err = h.recalculateTotals(tx, tenantID, invoiceDocument, invoiceID); err != nil {
+		h.logger.Error("Failed to calculate invoice totals", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update invoice item")
+		return
+	}
+
+	version, err := lockVersion(tx, "sales_invoices", tenantID, invoiceID)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales invoice version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update invoice item")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update invoice item")
+		return
+	}
+
+	setETag(w, version)
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Invoice item updated successfully",
+		"version": version,
+	})
+}
+
+// DeleteSalesInvoiceItem removes an item line from a draft invoice
+func (h *SalesHandler) DeleteSalesInvoiceItem(w http.ResponseWriter, r *http.Request) {
+	invoiceID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid invoice ID")
+		return
+	}
+	itemID, err := strconv.Atoi(chi.URLParam(r, "itemId"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid item ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete invoice item")
+		return
+	}
+	defer tx.Rollback()
+
+	status, err := lockInvoiceStatus(tx, tenantID, invoiceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales invoice not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete invoice item")
+		return
+	}
+	if status != "draft" {
+		writeError(w, http.StatusConflict, "Only draft invoices can be edited")
+		return
+	}
+	if !h.matchInvoiceVersion(w, r, tx, invoiceID, "Failed to delete invoice item") {
+		return
+	}
+
+	var remaining int
+	err = tx.QueryRow("SELECT COUNT(*) FROM sales_invoice_items WHERE invoice_id = $1 AND tenant_id = $2",
+		invoiceID, tenantID).Scan(&remaining)
+	if err != nil {
+		h.logger.Error("Failed to count invoice items", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete invoice item")
+		return
+	}
+	if remaining <= 1 {
+		writeError(w, http.StatusConflict, "An invoice must keep at least one item")
+		return
+	}
+
+	result, err := tx.Exec(`
+		DELETE FROM sales_invoice_items
+		WHERE id = $1 AND invoice_id = $2 AND tenant_id = $3 AND order_item_id IS NULL
+	`, itemID, invoiceID, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to delete invoice item", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete invoice item")
+		return
+	}
+
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "Invoice item not found or generated from an order line")
+		return
+	}
+
+	if _, err = h.recalculateTotals(tx, tenantID, invoiceDocument, invoiceID); err != nil {
+		h.logger.Error("Failed to calculate invoice totals", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete invoice item")
+		return
+	}
+
+	version, err := lockVersion(tx, "sales_invoices", tenantID, invoiceID)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales invoice version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete invoice item")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete invoice item")
+		return
+	}
+
+	setETag(w, version)
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Invoice item deleted successfully",
+		"version": version,
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const invoiceBody = `{"customer_id":1,"invoice_date":"2026-03-02","tax_rate":10,
+	"items":[{"product_id":5,"quantity":2,"unit_price":10},{"product_id":6,"quantity":1,"unit_price":5.5}]}`
+
+func TestCreateSalesInvoiceNumbersDraftsInSequence(t *testing.T) {
+	db := &scriptDB{}
+	scriptSales(db, salesFixture{})
+	p := db.plugin()
+
+	for _, want := range []string{"INV-2026-000001", "INV-2026-000002"} {
+		rec := serve(t, p, callerRequest("POST", "/invoices", invoiceBody))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body.String())
+		}
+		var resp struct {
+			InvoiceNumber string `json:"invoice_number"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.InvoiceNumber != want {
+			t.Errorf("invoice_number = %q, want %q", resp.InvoiceNumber, want)
+		}
+	}
+
+	for _, st := range db.ran("INSERT INTO sales_number_sequences") {
+		if st.args[0] != hostTenant || st.args[1] != numberInvoice || st.args[2] != "2026" {
+			t.Errorf("sequence allocated for %v, want the tenant's 2026 invoices", st.args)
+		}
+	}
+	inserts := db.ran("INSERT INTO sales_invoices")
+	if len(inserts) != 2 || inserts[1].args[1] != "INV-2026-000002" {
+		t.Fatalf("invoices inserted: %v", inserts)
+	}
+	if strings.Contains(inserts[0].query, "status") {
+		t.Error("invoice inserted with a status, want the draft default")
+	}
+	if items := db.ran("INSERT INTO sales_invoice_items"); len(items) != 4 || items[0].args[1] != int64(31) {
+		t.Errorf("items inserted: %v", items)
+	}
+	if db.commits != 2 {
+		t.Errorf("commits = %d, want 2", db.commits)
+	}
+}
+
+func TestCreateSalesInvoiceStoresTotals(t *testing.T) {
+	db := &scriptDB{}
+	scriptSales(db, salesFixture{invoiceLines: [][]driver.Value{
+		{int64(1), int64(2), "10", "0", "0", nil},
+		{int64(2), int64(1), "5.50", "0", "0", nil},
+	}})
+
+	rec := serve(t, db.plugin(), callerRequest("POST", "/invoices", invoiceBody))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	lines := db.ran("SET discount_amount = $1, tax_amount = $2")
+	if len(lines) != 2 || lines[0].args[1] != "2" || lines[1].args[1] != "0.55" {
+		t.Errorf("line taxes stored: %v, want 2 and 0.55", lines)
+	}
+	header := db.ran("SET subtotal = $1")
+	if len(header) != 1 {
+		t.Fatalf("header totals stored %d times, want once", len(header))
+	}
+	// subtotal, document discount, discount, shipping, tax, total
+	want := []driver.Value{"25.5", "0", "0", "0", "2.55", "28.05", int64(31), hostTenant}
+	for i, arg := range want {
+		if header[0].args[i] != arg {
+			t.Errorf("totals arg %d = %v, want %v", i+1, header[0].args[i], arg)
+		}
+	}
+}
+
+func TestSendSalesInvoice(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		lines  int64
+		want   int
+	}{
+		{"draft with lines", "draft", 2, http.StatusOK},
+		{"draft without lines", "draft", 0, http.StatusConflict},
+		{"already sent", "sent", 2, http.StatusConflict},
+		{"cancelled", "cancelled", 2, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			db.returns("SELECT status FROM sales_invoices", "status", []driver.Value{tt.status})
+			db.returns("SELECT COUNT(*) FROM sales_invoice_items", "count", []driver.Value{tt.lines})
+			db.returns("FROM sales_invoices si WHERE si.id", invoiceColumns,
+				documentRow(invoiceColumns, map[string]driver.Value{"id": int64(7), "status": "sent"}))
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/invoices/7/send", ""))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			sent := db.ran("SET status = 'sent'")
+			events := db.ran("INSERT INTO sales_outbox")
+			if tt.want == http.StatusOK {
+				if len(sent) != 1 || len(events) != 1 || events[0].args[1] != "sales.invoice.issued" || db.commits != 1 {
+					t.Errorf("sent %d times with events %v and %d commits, want once with sales.invoice.issued",
+						len(sent), events, db.commits)
+				}
+			} else if len(sent) != 0 || len(events) != 0 || db.commits != 0 {
+				t.Errorf("refused send still updated the invoice")
+			}
+		})
+	}
+}
+
+func TestVoidSalesInvoice(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         string
+		paid, credited string
+		want           int
+		message        string
+	}{
+		{"unpaid draft", "draft", "0", "0", http.StatusOK, "voided"},
+		{"unpaid sent", "sent", "0", "0", http.StatusOK, "voided"},
+		{"part paid", "sent", "0.01", "0", http.StatusConflict, "payments"},
+		{"credited", "sent", "0", "0.01", http.StatusConflict, "credit notes"},
+		{"paid", "paid", "0", "0", http.StatusConflict, "status paid"},
+		{"already void", "cancelled", "0", "0", http.StatusConflict, "status cancelled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			db.returns("SELECT status, paid_amount, credited_amount FROM sales_invoices",
+				"status paid_amount credited_amount", []driver.Value{tt.status, tt.paid, tt.credited})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/invoices/7/void", `{"reason":"duplicate"}`))
+			if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.message) {
+				t.Fatalf("status = %d %s, want %d mentioning %q", rec.Code, rec.Body.String(), tt.want,
+					tt.message)
+			}
+
+			voided := db.ran("SET status = 'cancelled', voided_at")
+			released := db.ran("UPDATE sales_order_items soi")
+			if tt.want != http.StatusOK {
+				if len(voided) != 0 || len(released) != 0 || db.commits != 0 {
+					t.Error("refused void still changed the invoice")
+				}
+				return
+			}
+			if len(voided) != 1 || voided[0].args[0] != "duplicate" {
+				t.Errorf("void recorded as %v", voided)
+			}
+			if len(released) != 1 || released[0].args[0] != int64(7) || released[0].args[1] != hostTenant {
+				t.Errorf("order lines released by %v, want the invoice's", released)
+			}
+			if db.commits != 1 {
+				t.Errorf("commits = %d, want 1", db.commits)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+// lifecycle declares the statuses a sales document moves through and the transitions
+// allowed from each one. Statuses with no outgoing transitions are final.
+type lifecycle struct {
+	document    string
+	transitions map[string][]string
+}
+
+var orderLifecycle = lifecycle{
+	document: "order",
+	transitions: map[string][]string{
+		"pending":   {"confirmed", "cancelled"},
+		"confirmed": {"shipped", "cancelled"},
+		"shipped":   {"delivered"},
+		"delivered": {},
+		"cancelled": {},
+	},
+}
+
+var quoteLifecycle = lifecycle{
+	document: "quote",
+	transitions: map[string][]string{
+		"draft":    {"sent", "accepted", "rejected", "expired"},
+		"sent":     {"accepted", "rejected", "expired"},
+		"accepted": {},
+		"rejected": {},
+		"expired":  {},
+	},
+}
+
+var invoiceLifecycle = lifecycle{
+	document: "invoice",
+	transitions: map[string][]string{
+		"draft":     {"sent", "cancelled"},
+		"sent":      {"paid", "overdue", "cancelled"},
+		"overdue":   {"paid", "cancelled"},
+		"paid":      {},
+		"cancelled": {},
+	},
+}
+
+var returnLifecycle = lifecycle{
+	document: "return",
+	transitions: map[string][]string{
+		"pending":   {"approved", "rejected"},
+		"approved":  {"received"},
+		"received":  {"processed"},
+		"rejected":  {},
+		"processed": {},
+	},
+}
+
+func (l lifecycle) allows(from, to string) bool {
+	for _, status := range l.transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports an unknown target status as a bad request and an illegal move as a conflict
+func (l lifecycle) check(from, to string) error {
+	if _, ok := l.transitions[to]; !ok {
+		return &requestError{http.StatusBadRequest, fmt.Sprintf("Unknown %s status %s", l.document, to)}
+	}
+	if !l.allows(from, to) {
+		return &requestError{http.StatusConflict,
+			fmt.Sprintf("Cannot change %s status from %s to %s", l.document, from, to)}
+	}
+	return nil
+}
+
+// transitionOrder moves an order to status inside tx after checking the lifecycle and its
+// guards. Shipping records shipped quantities; reaching the configured status may generate
+// an invoice, whose ID is returned (0 when none was generated). Orders outside scope are
+// not found.
+func (h *SalesHandler) transitionOrder(tx *sqlx.Tx, scope dataScope, orderID int, status string) (int, error) {
+	tenantID := scope.tenantID
+
+	scopeCondition, scopeArgs := scope.condition("sales_rep_id", 3)
+	var current string
+	err := tx.QueryRow("SELECT status FROM sales_orders WHERE id = $1 AND tenant_id = $2"+scopeCondition+" FOR UPDATE",
+		append([]interface{}{orderID, tenantID}, scopeArgs...)...).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, &requestError{http.StatusNotFound, "Sales order not found"}
+		}
+		return 0, err
+	}
+
+	if err := orderLifecycle.check(current, status); err != nil {
+		return 0, err
+	}
+
+	var lineCount int
+	var invoiced bool
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(BOOL_OR(invoiced_amount > 0), false)
+		FROM sales_order_items
+		WHERE order_id = $1 AND tenant_id = $2
+	`, orderID, tenantID).Scan(&lineCount, &invoiced)
+	if err != nil {
+		return 0, err
+	}
+
+	switch status {
+	case "confirmed", "shipped":
+		if lineCount == 0 {
+			return 0, &requestError{http.StatusConflict,
+				fmt.Sprintf("Cannot mark an order without lines as %s", status)}
+		}
+	case "cancelled":
+		if invoiced {
+			return 0, &requestError{http.StatusConflict, "Cannot cancel an order that has been invoiced"}
+		}
+	}
+
+	if status == "shipped" {
+		_, err = tx.Exec(`
+			UPDATE sales_orders
+			SET status = $1, shipped_date = COALESCE(shipped_date, CURRENT_DATE)
+			WHERE id = $2 AND tenant_id = $3
+		`, status, orderID, tenantID)
+		if err == nil {
+			err = markOrderShipped(tx, tenantID, orderID)
+		}
+	} else {
+		_, err = tx.Exec("UPDATE sales_orders SET status = $1 WHERE id = $2 AND tenant_id = $3",
+			status, orderID, tenantID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = recordEvent(tx, tenantID, OrderStatusChanged{OrderID: orderID, FromStatus: current, ToStatus: status})
+	if err != nil {
+		return 0, err
+	}
+
+	return h.autoInvoiceOrder(tx, scope, orderID, status)
+}
+
+// checkInvoiceTransition checks the invoice lifecycle and its guards for a status change.
+// Invoices are only paid by settling their balance, never by editing the status.
+func checkInvoiceTransition(tx *sqlx.Tx, tenantID string, invoiceID int, from, to string) error {
+	if err := invoiceLifecycle.check(from, to); err != nil {
+		return err
+	}
+
+	switch to {
+	case "sent":
+		var lineCount int
+		err := tx.QueryRow("SELECT COUNT(*) FROM sales_invoice_items WHERE invoice_id = $1 AND tenant_id = $2",
+			invoiceID, tenantID).Scan(&lineCount)
+		if err != nil {
+			return err
+		}
+		if lineCount == 0 {
+			return &requestError{http.StatusConflict, "Cannot send an invoice without lines"}
+		}
+	case "paid":
+		var balanceDue Decimal
+		err := tx.QueryRow("SELECT balance_due FROM sales_invoices WHERE id = $1 AND tenant_id = $2",
+			invoiceID, tenantID).Scan(&balanceDue)
+		if err != nil {
+			return err
+		}
+		if balanceDue.Sign() > 0 {
+			return &requestError{http.StatusConflict,
+				fmt.Sprintf("Invoice still has %.2f due; record a payment to settle it", balanceDue)}
+		}
+	}
+
+	return nil
+}
+
+// changeOrderStatus serves the transition-specific order endpoints
+func (h *SalesHandler) changeOrderStatus(w http.ResponseWriter, r *http.Request, status, message string) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid order ID")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+	defer tx.Rollback()
+
+	invoiceID, err := h.transitionOrder(tx, h.requestDataScope(tx, r), id, status)
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to update sales order")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+
+	response := map[string]interface{}{
+		"order_id": id,
+		"status":   status,
+		"message":  message,
+	}
+	if invoiceID != 0 {
+		response["invoice_id"] = invoiceID
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, response)
+}
+
+// ConfirmSalesOrder confirms a pending order
+func (h *SalesHandler) ConfirmSalesOrder(w http.ResponseWriter, r *http.Request) {
+	h.changeOrderStatus(w, r, "confirmed", "Sales order confirmed successfully")
+}
+
+// ShipSalesOrder marks a confirmed order as shipped in full
+func (h *SalesHandler) ShipSalesOrder(w http.ResponseWriter, r *http.Request) {
+	h.changeOrderStatus(w, r, "shipped", "Sales order shipped successfully")
+}
+
+// DeliverSalesOrder marks a shipped order as delivered
+func (h *SalesHandler) DeliverSalesOrder(w http.ResponseWriter, r *http.Request) {
+	h.changeOrderStatus(w, r, "delivered", "Sales order delivered successfully")
+}
+
+// CancelSalesOrder cancels an order that has not been shipped or invoiced
+func (h *SalesHandler) CancelSalesOrder(w http.ResponseWriter, r *http.Request) {
+	h.changeOrderStatus(w, r, "cancelled", "Sales order cancelled successfully")
+}
+
+// lockQuoteStatus locks a quote and checks that it may move to status. Quotes outside scope
+// are not found.
+func lockQuoteStatus(tx *sqlx.Tx, scope dataScope, quoteID int, status string) error {
+	scopeCondition, scopeArgs := scope.condition("sales_rep_id", 3)
+	var current string
+	err := tx.QueryRow("SELECT status FROM sales_quotes WHERE id = $1 AND tenant_id = $2"+scopeCondition+" FOR UPDATE",
+		append([]interface{}{quoteID, scope.tenantID}, scopeArgs...)...).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &requestError{http.StatusNotFound, "Sales quote not found"}
+		}
+		return err
+	}
+	return quoteLifecycle.check(current, status)
+}
+
+// changeQuoteStatus serves the transition-specific quote endpoints
+func (h *SalesHandler) changeQuoteStatus(w http.ResponseWriter, r *http.Request, status, message string) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid quote ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockQuoteStatus(tx, h.requestDataScope(tx, r), id, status); err != nil {
+		h.writeRequestError(w, err, "Failed to update sales quote")
+		return
+	}
+
+	if status == "sent" {
+		var lineCount int
+		err = tx.QueryRow("SELECT COUNT(*) FROM sales_quote_items WHERE quote_id = $1 AND tenant_id = $2",
+			id, tenantID).Scan(&lineCount)
+		if err != nil {
+			h.logger.Error("Failed to count sales quote items", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+			return
+		}
+		if lineCount == 0 {
+			writeError(w, http.StatusConflict, "Cannot send a quote without lines")
+			return
+		}
+	}
+
+	_, err = tx.Exec("UPDATE sales_quotes SET status = $1 WHERE id = $2 AND tenant_id = $3", status, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to update sales quote status", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"quote_id": id,
+		"status":   status,
+		"message":  message,
+	})
+}
+
+// SendSalesQuote marks a draft quote as sent to the customer
+func (h *SalesHandler) SendSalesQuote(w http.ResponseWriter, r *http.Request) {
+	h.changeQuoteStatus(w, r, "sent", "Sales quote sent successfully")
+}
+
+// RejectSalesQuote records that the customer declined a quote
+func (h *SalesHandler) RejectSalesQuote(w http.ResponseWriter, r *http.Request) {
+	h.changeQuoteStatus(w, r, "rejected", "Sales quote rejected successfully")
+}
+
+// ExpireSalesQuote closes a quote that lapsed without an answer
+func (h *SalesHandler) ExpireSalesQuote(w http.ResponseWriter, r *http.Request) {
+	h.changeQuoteStatus(w, r, "expired", "Sales quote expired successfully")
+}
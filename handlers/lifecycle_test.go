@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+)
+
+func TestLifecycleTransitions(t *testing.T) {
+	tests := []struct {
+		lifecycle lifecycle
+		from, to  string
+		want      int
+	}{
+		{orderLifecycle, "pending", "confirmed", 0},
+		{orderLifecycle, "pending", "cancelled", 0},
+		{orderLifecycle, "pending", "shipped", http.StatusConflict},
+		{orderLifecycle, "confirmed", "shipped", 0},
+		{orderLifecycle, "confirmed", "cancelled", 0},
+		{orderLifecycle, "shipped", "delivered", 0},
+		{orderLifecycle, "shipped", "cancelled", http.StatusConflict},
+		{orderLifecycle, "delivered", "pending", http.StatusConflict},
+		{orderLifecycle, "cancelled", "pending", http.StatusConflict},
+		{orderLifecycle, "pending", "completed", http.StatusBadRequest},
+		{quoteLifecycle, "draft", "sent", 0},
+		{quoteLifecycle, "sent", "accepted", 0},
+		{quoteLifecycle, "sent", "draft", http.StatusConflict},
+		{quoteLifecycle, "accepted", "rejected", http.StatusConflict},
+		{quoteLifecycle, "expired", "sent", http.StatusConflict},
+		{invoiceLifecycle, "draft", "sent", 0},
+		{invoiceLifecycle, "draft", "paid", http.StatusConflict},
+		{invoiceLifecycle, "sent", "overdue", 0},
+		{invoiceLifecycle, "overdue", "paid", 0},
+		{invoiceLifecycle, "paid", "cancelled", http.StatusConflict},
+		{invoiceLifecycle, "cancelled", "draft", http.StatusConflict},
+		{returnLifecycle, "pending", "approved", 0},
+		{returnLifecycle, "pending", "received", http.StatusConflict},
+		{returnLifecycle, "approved", "received", 0},
+		{returnLifecycle, "received", "processed", 0},
+		{returnLifecycle, "rejected", "approved", http.StatusConflict},
+		{returnLifecycle, "processed", "refunded", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		err := tt.lifecycle.check(tt.from, tt.to)
+		got := 0
+		if rerr, ok := err.(*requestError); ok {
+			got = rerr.status
+		} else if err != nil {
+			t.Fatalf("%s %s -> %s: unexpected error %v", tt.lifecycle.document, tt.from, tt.to, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s %s -> %s: status %d, want %d", tt.lifecycle.document, tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	// Final statuses have no way out, and every target is itself a status
+	for _, l := range []lifecycle{orderLifecycle, quoteLifecycle, invoiceLifecycle, returnLifecycle} {
+		for from, targets := range l.transitions {
+			for _, to := range targets {
+				if _, ok := l.transitions[to]; !ok {
+					t.Errorf("%s %s -> %s: target is not a status", l.document, from, to)
+				}
+			}
+		}
+	}
+}
+
+func TestOrderTransitionGuards(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   string
+		current  string
+		lines    int64
+		invoiced bool
+		want     int
+	}{
+		{"confirm with lines", "confirm", "pending", 2, false, http.StatusOK},
+		{"confirm without lines", "confirm", "pending", 0, false, http.StatusConflict},
+		{"ship with lines", "ship", "confirmed", 2, false, http.StatusOK},
+		{"ship without lines", "ship", "confirmed", 0, false, http.StatusConflict},
+		{"ship before confirming", "ship", "pending", 2, false, http.StatusConflict},
+		{"cancel before invoicing", "cancel", "confirmed", 2, false, http.StatusOK},
+		{"cancel after invoicing", "cancel", "confirmed", 2, true, http.StatusConflict},
+		{"cancel after shipping", "cancel", "shipped", 2, false, http.StatusConflict},
+		{"deliver", "deliver", "shipped", 2, true, http.StatusOK},
+		{"deliver before shipping", "deliver", "confirmed", 2, false, http.StatusConflict},
+		{"confirm again", "confirm", "cancelled", 2, false, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			db.returns("SELECT status FROM sales_orders", "status", []driver.Value{tt.current})
+			db.returns("BOOL_OR(invoiced_amount > 0)", "count invoiced", []driver.Value{tt.lines, tt.invoiced})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/orders/7/"+tt.action, ""))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			updates := db.ran("UPDATE sales_orders")
+			events := db.ran("INSERT INTO sales_outbox")
+			if tt.want != http.StatusOK {
+				if len(updates) != 0 || len(events) != 0 || db.commits != 0 {
+					t.Error("refused transition still changed the order")
+				}
+				return
+			}
+			if len(updates) != 1 || len(events) != 1 || db.commits != 1 {
+				t.Errorf("%d updates, %d events and %d commits, want one of each", len(updates), len(events),
+					db.commits)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact decimal number with up to six fractional digits. Every amount,
+// price, rate and percentage the module stores or computes is a Decimal, so sums and
+// prorations reconcile to the cent with the DECIMAL columns they come from. It reads and
+// writes JSON numbers (strings are accepted too) and SQL numerics as decimal text, never
+// passing through a float.
+type Decimal struct {
+	micros int64
+}
+
+// decimalPlaces is the number of fractional digits a Decimal holds
+const decimalPlaces = 6
+
+var (
+	microsPerUnit = big.NewInt(1_000_000)
+	decimalType   = reflect.TypeOf(Decimal{})
+)
+
+// decimalFromInt returns n as a Decimal
+func decimalFromInt(n int) Decimal {
+	return Decimal{int64(n) * microsPerUnit.Int64()}
+}
+
+// parseDecimal parses a decimal literal such as "12.50", "-3" or "1e2". Digits beyond the
+// sixth decimal place are rounded half away from zero.
+func parseDecimal(s string) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	micros := roundQuo(new(big.Int).Mul(r.Num(), microsPerUnit), r.Denom())
+	if !micros.IsInt64() {
+		return Decimal{}, fmt.Errorf("decimal %q out of range", s)
+	}
+	return Decimal{micros.Int64()}, nil
+}
+
+// mustDecimal parses a decimal literal known to be valid
+func mustDecimal(s string) Decimal {
+	d, err := parseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// roundQuo returns n/d rounded half away from zero; d is positive
+func roundQuo(n, d *big.Int) *big.Int {
+	q, m := new(big.Int).QuoRem(n, d, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(m), big.NewInt(2)).Cmp(d) >= 0 {
+		q.Add(q, big.NewInt(int64(n.Sign())))
+	}
+	return q
+}
+
+func (d Decimal) Add(o Decimal) Decimal { return Decimal{d.micros + o.micros} }
+func (d Decimal) Sub(o Decimal) Decimal { return Decimal{d.micros - o.micros} }
+func (d Decimal) Neg() Decimal          { return Decimal{-d.micros} }
+
+// MulInt returns d × n
+func (d Decimal) MulInt(n int) Decimal { return Decimal{d.micros * int64(n)} }
+
+// Mul returns d × o, rounded to six places
+func (d Decimal) Mul(o Decimal) Decimal {
+	return d.MulDiv(o, decimalFromInt(1), decimalPlaces)
+}
+
+// MulDiv returns d × m / div, computed exactly and rounded once to places. It is how
+// amounts are prorated: a share of a total, or a percentage of an amount with div 100.
+func (d Decimal) MulDiv(m, div Decimal, places int) Decimal {
+	if div.micros == 0 {
+		panic("decimal: division by zero")
+	}
+	n := new(big.Int).Mul(big.NewInt(d.micros), big.NewInt(m.micros))
+	q := new(big.Int).Mul(big.NewInt(div.micros), pow10(decimalPlaces-places))
+	if q.Sign() < 0 {
+		n.Neg(n)
+		q.Neg(q)
+	}
+	rounded := roundQuo(n, q)
+	return Decimal{rounded.Mul(rounded, pow10(decimalPlaces-places)).Int64()}
+}
+
+// Percent returns pct percent of d, rounded to places
+func (d Decimal) Percent(pct Decimal, places int) Decimal {
+	return d.MulDiv(pct, decimalFromInt(100), places)
+}
+
+// Round rounds d half away from zero to places fractional digits
+func (d Decimal) Round(places int) Decimal {
+	unit := pow10(decimalPlaces - places).Int64()
+	return Decimal{roundQuo(big.NewInt(d.micros), big.NewInt(unit)).Int64() * unit}
+}
+
+// floor rounds d toward negative infinity to places fractional digits
+func (d Decimal) floor(places int) Decimal {
+	unit := pow10(decimalPlaces - places).Int64()
+	q := d.micros / unit
+	if d.micros%unit < 0 {
+		q--
+	}
+	return Decimal{q * unit}
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// Cmp returns -1, 0 or 1 as d is less than, equal to or greater than o
+func (d Decimal) Cmp(o Decimal) int {
+	switch {
+	case d.micros < o.micros:
+		return -1
+	case d.micros > o.micros:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) Sign() int                  { return d.Cmp(Decimal{}) }
+func (d Decimal) IsZero() bool               { return d.micros == 0 }
+func (d Decimal) LessThan(o Decimal) bool    { return d.micros < o.micros }
+func (d Decimal) GreaterThan(o Decimal) bool { return d.micros > o.micros }
+
+// minDecimal returns the smaller of a and b
+func minDecimal(a, b Decimal) Decimal {
+	if b.LessThan(a) {
+		return b
+	}
+	return a
+}
+
+// String formats d with as few fractional digits as it needs, such as "12.5" or "-3"
+func (d Decimal) String() string {
+	s := d.StringFixed(decimalPlaces)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// StringFixed formats d rounded to exactly places fractional digits, such as "12.50"
+func (d Decimal) StringFixed(places int) string {
+	micros := d.Round(places).micros
+	sign := ""
+	if micros < 0 {
+		sign = "-"
+		micros = -micros
+	}
+	unit := microsPerUnit.Int64()
+	s := sign + strconv.FormatInt(micros/unit, 10)
+	if places > 0 {
+		frac := fmt.Sprintf("%06d", micros%unit)
+		s += "." + frac[:places]
+	}
+	return s
+}
+
+// Format lets fmt print d exactly: %f and %v with a precision, such as %.2f, round to it,
+// and without one print all the digits d needs
+func (d Decimal) Format(f fmt.State, verb rune) {
+	s := d.String()
+	if places, ok := f.Precision(); ok && (verb == 'f' || verb == 'v') {
+		s = d.StringFixed(places)
+	}
+	fmt.Fprint(f, s)
+}
+
+// MarshalJSON writes d as a JSON number
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or a string holding one. A malformed value is
+// reported as a type error, so the request error names the field.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	v, err := parseDecimal(s)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: "string " + string(data), Type: reflect.TypeOf(float64(0))}
+	}
+	*d = v
+	return nil
+}
+
+// Scan reads a SQL numeric
+func (d *Decimal) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+		return nil
+	case int64:
+		*d = Decimal{v * microsPerUnit.Int64()}
+		return nil
+	case float64:
+		src = strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		src = string(v)
+	}
+	s, ok := src.(string)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into Decimal", src)
+	}
+	v, err := parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// Value writes d as decimal text, which PostgreSQL casts to numeric exactly
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// isoCurrencies are the active ISO 4217 currency codes
+var isoCurrencies = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true,
+	"AUD": true, "AWG": true, "AZN": true, "BAM": true, "BBD": true, "BDT": true, "BGN": true,
+	"BHD": true, "BIF": true, "BMD": true, "BND": true, "BOB": true, "BRL": true, "BSD": true,
+	"BTN": true, "BWP": true, "BYN": true, "BZD": true, "CAD": true, "CDF": true, "CHF": true,
+	"CLP": true, "CNY": true, "COP": true, "CRC": true, "CUP": true, "CVE": true, "CZK": true,
+	"DJF": true, "DKK": true, "DOP": true, "DZD": true, "EGP": true, "ERN": true, "ETB": true,
+	"EUR": true, "FJD": true, "FKP": true, "GBP": true, "GEL": true, "GHS": true, "GIP": true,
+	"GMD": true, "GNF": true, "GTQ": true, "GYD": true, "HKD": true, "HNL": true, "HTG": true,
+	"HUF": true, "IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true,
+	"JMD": true, "JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true,
+	"KPW": true, "KRW": true, "KWD": true, "KYD": true, "KZT": true, "LAK": true, "LBP": true,
+	"LKR": true, "LRD": true, "LSL": true, "LYD": true, "MAD": true, "MDL": true, "MGA": true,
+	"MKD": true, "MMK": true, "MNT": true, "MOP": true, "MRU": true, "MUR": true, "MVR": true,
+	"MWK": true, "MXN": true, "MYR": true, "MZN": true, "NAD": true, "NGN": true, "NIO": true,
+	"NOK": true, "NPR": true, "NZD": true, "OMR": true, "PAB": true, "PEN": true, "PGK": true,
+	"PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true, "RON": true, "RSD": true,
+	"RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true,
+	"SGD": true, "SHP": true, "SLE": true, "SOS": true, "SRD": true, "SSP": true, "STN": true,
+	"SVC": true, "SYP": true, "SZL": true, "THB": true, "TJS": true, "TMT": true, "TND": true,
+	"TOP": true, "TRY": true, "TTD": true, "TWD": true, "TZS": true, "UAH": true, "UGX": true,
+	"USD": true, "UYU": true, "UZS": true, "VES": true, "VND": true, "VUV": true, "WST": true,
+	"XAF": true, "XCD": true, "XOF": true, "XPF": true, "YER": true, "ZAR": true, "ZMW": true,
+	"ZWG": true,
+}
+
+// validCurrency reports whether code is an active ISO 4217 currency code, in any case
+func validCurrency(code string) bool {
+	return isoCurrencies[strings.ToUpper(code)]
+}
+
+// requestCurrency is the currency a request asks for, upper-cased, or fallback when it
+// names none
+func requestCurrency(currency *string, fallback string) string {
+	if currency == nil || *currency == "" {
+		return fallback
+	}
+	return strings.ToUpper(*currency)
+}
+
+// zeroDecimalCurrencies are the ISO 4217 currencies without a minor unit
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "ISK": true, "JPY": true,
+	"KMF": true, "KRW": true, "PYG": true, "RWF": true, "UGX": true, "VND": true,
+	"VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// moneyPlaces returns the fractional digits amounts in currency are rounded to: its ISO
+// 4217 minor unit, capped at the two places the sales tables store
+func moneyPlaces(currency string) int {
+	if zeroDecimalCurrencies[strings.ToUpper(currency)] {
+		return 0
+	}
+	return 2
+}
+
+// roundMoney rounds an amount to the minor unit of its currency
+func roundMoney(d Decimal, currency string) Decimal {
+	return d.Round(moneyPlaces(currency))
+}
+
+// Rounding policies for amounts derived line by line, such as prorated tax or fees
+const (
+	roundingPerLine     = "line"     // round each line, the document total is their sum
+	roundingPerDocument = "document" // round the exact document total once
+)
+
+// moneyRounding rounds the amounts of one document in its currency under a rounding policy
+type moneyRounding struct {
+	places      int
+	perDocument bool
+}
+
+// newMoneyRounding returns the rounding for documents in currency under policy
+func newMoneyRounding(currency, policy string) moneyRounding {
+	return moneyRounding{places: moneyPlaces(currency), perDocument: policy == roundingPerDocument}
+}
+
+// round rounds a single amount to the currency's minor unit
+func (m moneyRounding) round(d Decimal) Decimal {
+	return d.Round(m.places)
+}
+
+// lines rounds the exact amounts derived for the lines of a document and returns them with
+// their total. Per line, each amount is rounded and the total is their sum. Per document,
+// the exact sum is rounded once and the minor units lost to rounding go to the lines with
+// the largest remainders, so the stored lines still add up to the total.
+func (m moneyRounding) lines(exact []Decimal) ([]Decimal, Decimal) {
+	rounded := make([]Decimal, len(exact))
+	var total Decimal
+
+	if !m.perDocument {
+		for i, d := range exact {
+			rounded[i] = m.round(d)
+			total = total.Add(rounded[i])
+		}
+		return rounded, total
+	}
+
+	var exactTotal, floorTotal Decimal
+	order := make([]int, len(exact))
+	for i, d := range exact {
+		rounded[i] = d.floor(m.places)
+		exactTotal = exactTotal.Add(d)
+		floorTotal = floorTotal.Add(rounded[i])
+		order[i] = i
+	}
+	total = m.round(exactTotal)
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return exact[order[a]].Sub(rounded[order[a]]).GreaterThan(exact[order[b]].Sub(rounded[order[b]]))
+	})
+	unit := Decimal{pow10(decimalPlaces - m.places).Int64()}
+	for i := 0; floorTotal.LessThan(total) && i < len(order); i++ {
+		rounded[order[i]] = rounded[order[i]].Add(unit)
+		floorTotal = floorTotal.Add(unit)
+	}
+	return rounded, total
+}
+
+// allocate splits total, already rounded, across lines in proportion to weights. Every
+// share is rounded to the currency and the shares always add up to total.
+func (m moneyRounding) allocate(total Decimal, weights []Decimal) []Decimal {
+	var sum Decimal
+	for _, w := range weights {
+		sum = sum.Add(w)
+	}
+	shares := make([]Decimal, len(weights))
+	if len(shares) == 0 {
+		return shares
+	}
+	if sum.IsZero() {
+		shares[len(shares)-1] = total
+		return shares
+	}
+
+	for i, w := range weights {
+		shares[i] = total.MulDiv(w, sum, decimalPlaces)
+	}
+	shares, _ = moneyRounding{places: m.places, perDocument: true}.lines(shares)
+
+	// Exact shares can miss total by a millionth; the last line absorbs the difference
+	var allocated Decimal
+	for _, share := range shares {
+		allocated = allocated.Add(share)
+	}
+	shares[len(shares)-1] = shares[len(shares)-1].Add(total.Sub(allocated))
+	return shares
+}
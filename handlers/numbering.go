@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Document types numbered from their own sequence
+const (
+	numberQuote      = "quote"
+	numberOrder      = "order"
+	numberInvoice    = "invoice"
+	numberCreditNote = "credit_note"
+	numberPayment    = "payment"
+	numberReturn     = "return"
+)
+
+// numberPatternToken matches the placeholders of a numbering pattern: {YYYY}, {YY} and
+// {MM} from the document date, and {seq} or {seq:N}, the sequence zero-padded to N digits
+var numberPatternToken = regexp.MustCompile(`\{(YYYY|YY|MM|seq(?::(\d+))?)\}`)
+
+// maxSequenceWidth bounds the padding of {seq:N}, which fits the document number columns
+const maxSequenceWidth = 12
+
+// validNumberPattern reports whether pattern numbers documents uniquely: it has exactly one
+// {seq}, and a month only together with a year, as a sequence restarting every month would
+// otherwise repeat the next year.
+func validNumberPattern(pattern string) bool {
+	var seqs int
+	var year, month bool
+	for _, m := range numberPatternToken.FindAllStringSubmatch(pattern, -1) {
+		switch m[1] {
+		case "YYYY", "YY":
+			year = true
+		case "MM":
+			month = true
+		default:
+			seqs++
+			if m[2] != "" {
+				if width, err := strconv.Atoi(m[2]); err != nil || width < 1 || width > maxSequenceWidth {
+					return false
+				}
+			}
+		}
+	}
+	return seqs == 1 && (year || !month) && len(pattern) <= 30
+}
+
+// numberingPeriod is the period whose documents share a sequence under pattern: the month
+// of date when the pattern has one, else its year, else "" when the sequence never restarts
+func numberingPeriod(pattern string, date time.Time) string {
+	switch {
+	case strings.Contains(pattern, "{MM}"):
+		return date.Format("2006-01")
+	case strings.Contains(pattern, "{YYYY}") || strings.Contains(pattern, "{YY}"):
+		return date.Format("2006")
+	}
+	return ""
+}
+
+// formatDocumentNumber fills the placeholders of pattern with the document date and seq
+func formatDocumentNumber(pattern string, date time.Time, seq int64) string {
+	return numberPatternToken.ReplaceAllStringFunc(pattern, func(token string) string {
+		m := numberPatternToken.FindStringSubmatch(token)
+		switch m[1] {
+		case "YYYY":
+			return date.Format("2006")
+		case "YY":
+			return date.Format("06")
+		case "MM":
+			return date.Format("01")
+		}
+		width, _ := strconv.Atoi(m[2])
+		return fmt.Sprintf("%0*d", width, seq)
+	})
+}
+
+// nextDocumentNumber allocates the next number of a document type dated date. The counter
+// is advanced in tx and stays locked until it ends, so concurrent documents of the type wait
+// their turn, and a rolled-back document gives its number back: numbers are gap-free as
+// long as the documents are never deleted. Allocate it just before inserting the document
+// to hold the lock as briefly as possible.
+func nextDocumentNumber(tx *sqlx.Tx, tenantID, documentType, pattern string, date time.Time) (string, error) {
+	if !validNumberPattern(pattern) {
+		return "", fmt.Errorf("invalid %s number pattern %q", documentType, pattern)
+	}
+
+	var seq int64
+	err := tx.QueryRow(`
+		INSERT INTO sales_number_sequences (tenant_id, document_type, period, last_number)
+		VALUES ($1, $2, $3, 1)
+		ON CONFLICT (tenant_id, document_type, period)
+		DO UPDATE SET last_number = sales_number_sequences.last_number + 1, updated_at = CURRENT_TIMESTAMP
+		RETURNING last_number
+	`, tenantID, documentType, numberingPeriod(pattern, date)).Scan(&seq)
+	if err != nil {
+		return "", err
+	}
+	return formatDocumentNumber(pattern, date, seq), nil
+}
+
+// nextDocumentNumber allocates the next number of a document type under the tenant's pattern
+func (s SalesSettings) nextDocumentNumber(tx *sqlx.Tx, tenantID, documentType string, date time.Time) (string, error) {
+	return nextDocumentNumber(tx, tenantID, documentType, s.NumberPatterns[documentType], date)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+)
+
+// apiPrefix is where the host serves the module's routes (module.yml api.prefix)
+const apiPrefix = "/api/v1/sales"
+
+// fields describes an ad-hoc JSON object by example: each value stands for the type of
+// the property of the same name
+type fields map[string]interface{}
+
+// routeDoc describes the bodies of a route for the API documentation. request is the type
+// the handler decodes, or nil when it reads no body; response is the type or fields of the
+// success response, written with status (200 when zero).
+type routeDoc struct {
+	request  interface{}
+	response interface{}
+	status   int
+}
+
+// routeDocs documents the bodies of each route, keyed by "METHOD /pattern"
+var routeDocs = map[string]routeDoc{
+	"GET /orders":               {nil, fields{"orders": []SalesOrder{}, "count": 0}, 0},
+	"POST /orders":              {createSalesOrderRequest{}, fields{"order_id": 0, "order_number": "", "message": "", "created_at": time.Time{}, "updated_at": time.Time{}}, http.StatusCreated},
+	"GET /orders/{id}":          {nil, SalesOrder{}, 0},
+	"PUT /orders/{id}":          {updateSalesOrderRequest{}, fields{"message": "", "invoice_id": 0, "version": 0}, 0},
+	"POST /orders/{id}/invoice": {createInvoiceFromOrderRequest{}, fields{"invoice_id": 0, "invoice_number": "", "order_id": 0, "message": ""}, http.StatusCreated},
+	"POST /orders/{id}/confirm": {nil, orderStatusResponse, 0},
+	"POST /orders/{id}/ship":    {nil, orderStatusResponse, 0},
+	"POST /orders/{id}/deliver": {nil, orderStatusResponse, 0},
+	"POST /orders/{id}/cancel":  {nil, orderStatusResponse, 0},
+	"GET /orders/{id}/history":  {nil, historyResponse, 0},
+	"GET /quotes":               {nil, fields{"quotes": []SalesQuote{}, "count": 0}, 0},
+	"POST /quotes":              {createSalesQuoteRequest{}, fields{"quote_id": 0, "quote_number": "", "message": "", "created_at": time.Time{}, "updated_at": time.Time{}}, http.StatusCreated},
+	"GET /quotes/{id}":          {nil, SalesQuote{}, 0},
+	"PUT /quotes/{id}":          {updateSalesQuoteRequest{}, versionedMessage, 0},
+	"POST /quotes/{id}/convert": {nil, fields{"order_id": 0, "order_number": "", "message": "", "created_at": time.Time{}, "updated_at": time.Time{}}, http.StatusCreated},
+	"POST /quotes/{id}/send":    {nil, quoteStatusResponse, 0},
+	"POST /quotes/{id}/reject":  {nil, quoteStatusResponse, 0},
+	"POST /quotes/{id}/expire":  {nil, quoteStatusResponse, 0},
+	"GET /quotes/{id}/history":  {nil, historyResponse, 0},
+	"GET /reports/sales":        {nil, SalesReport{}, 0},
+	"GET /reports/vat-summary":  {nil, fields{"start_date": "", "end_date": "", "lines": []VATSummaryLine{}, "count": 0}, 0},
+	"GET /pipeline":             {nil, fields{"pipeline": []PipelineStage{}, "period": ""}, 0},
+
+	"GET /invoices":                        {nil, fields{"invoices": []SalesInvoice{}, "count": 0}, 0},
+	"POST /invoices":                       {createSalesInvoiceRequest{}, fields{"invoice_id": 0, "invoice_number": "", "message": "", "created_at": time.Time{}, "updated_at": time.Time{}}, http.StatusCreated},
+	"GET /invoices/{id}":                   {nil, SalesInvoice{}, 0},
+	"PUT /invoices/{id}":                   {updateSalesInvoiceRequest{}, versionedMessage, 0},
+	"POST /invoices/{id}/send":             {nil, fields{"invoice_id": 0, "status": "", "message": ""}, 0},
+	"POST /invoices/{id}/void":             {voidSalesInvoiceRequest{}, messageOnly, 0},
+	"GET /invoices/{id}/items":             {nil, fields{"items": []SalesInvoiceItem{}, "count": 0}, 0},
+	"POST /invoices/{id}/items":            {SalesInvoiceItem{}, fields{"item_id": 0, "message": "", "version": 0}, http.StatusCreated},
+	"PUT /invoices/{id}/items/{itemId}":    {updateSalesInvoiceItemRequest{}, versionedMessage, 0},
+	"DELETE /invoices/{id}/items/{itemId}": {nil, versionedMessage, 0},
+	"POST /invoices/{id}/credit-notes":     {createCreditNoteRequest{}, fields{"credit_note_id": 0, "credit_note_number": "", "invoice_id": 0, "message": ""}, http.StatusCreated},
+	"GET /invoices/{id}/history":           {nil, historyResponse, 0},
+
+	"GET /credit-notes":              {nil, fields{"credit_notes": []CreditNote{}, "count": 0}, 0},
+	"GET /credit-notes/{id}":         {nil, CreditNote{}, 0},
+	"GET /credit-notes/{id}/history": {nil, historyResponse, 0},
+
+	"GET /payments":                   {nil, fields{"payments": []SalesPayment{}, "count": 0}, 0},
+	"POST /payments":                  {createSalesPaymentRequest{}, fields{"payment_id": 0, "payment_number": "", "allocations": []paymentAllocationRequest{}, "unapplied_amount": 0.0, "created_at": time.Time{}, "message": ""}, http.StatusCreated},
+	"GET /payments/{id}":              {nil, SalesPayment{}, 0},
+	"POST /payments/{id}/allocations": {allocateSalesPaymentRequest{}, fields{"payment_id": 0, "unapplied_amount": 0.0, "message": ""}, 0},
+	"GET /payments/{id}/history":      {nil, historyResponse, 0},
+
+	"GET /returns":               {nil, fields{"returns": []SalesReturn{}, "count": 0}, 0},
+	"POST /returns":              {createSalesReturnRequest{}, fields{"return_id": 0, "return_number": "", "message": ""}, http.StatusCreated},
+	"GET /returns/{id}":          {nil, SalesReturn{}, 0},
+	"POST /returns/{id}/approve": {nil, messageOnly, 0},
+	"POST /returns/{id}/reject":  {rejectSalesReturnRequest{}, messageOnly, 0},
+	"POST /returns/{id}/receive": {receiveSalesReturnRequest{}, fields{"restocking_fee": 0.0, "message": ""}, 0},
+	"POST /returns/{id}/process": {nil, fields{"message": "", "credit_note_id": 0, "credit_note_number": "", "restock_instructions": []RestockInstruction{}}, 0},
+	"GET /returns/{id}/history":  {nil, historyResponse, 0},
+
+	"GET /customers/{id}/credit":        {nil, fields{"customer_id": 0, "balances": []CustomerCreditBalance{}, "entries": []CustomerCreditEntry{}}, 0},
+	"POST /customers/{id}/credit/apply": {applyCustomerCreditRequest{}, fields{"customer_id": 0, "allocations": []paymentAllocationRequest{}, "applied_amount": 0.0, "remaining_balance": 0.0, "message": ""}, 0},
+	"POST /customers/{id}/refunds":      {createCustomerRefundRequest{}, fields{"customer_id": 0, "refunded_amount": 0.0, "currency": "", "remaining_balance": 0.0, "message": ""}, http.StatusCreated},
+
+	"GET /webhooks":                 {nil, fields{"webhooks": []Webhook{}, "count": 0}, 0},
+	"POST /webhooks":                {createWebhookRequest{}, Webhook{}, http.StatusCreated},
+	"GET /webhooks/{id}":            {nil, Webhook{}, 0},
+	"PUT /webhooks/{id}":            {updateWebhookRequest{}, Webhook{}, 0},
+	"DELETE /webhooks/{id}":         {nil, messageOnly, 0},
+	"GET /webhooks/{id}/deliveries": {nil, fields{"deliveries": []WebhookDelivery{}, "count": 0}, 0},
+	"POST /webhooks/{id}/deliveries/{deliveryId}/replay": {nil, WebhookDelivery{}, http.StatusCreated},
+
+	"GET /tax/jurisdictions":                     {nil, fields{"jurisdictions": []TaxJurisdiction{}, "count": 0}, 0},
+	"POST /tax/jurisdictions":                    {createTaxJurisdictionRequest{}, TaxJurisdiction{}, http.StatusCreated},
+	"GET /tax/jurisdictions/{id}":                {nil, TaxJurisdiction{}, 0},
+	"PUT /tax/jurisdictions/{id}":                {updateTaxJurisdictionRequest{}, TaxJurisdiction{}, 0},
+	"DELETE /tax/jurisdictions/{id}":             {nil, messageOnly, 0},
+	"POST /tax/jurisdictions/{id}/rates":         {createTaxRateRequest{}, TaxRate{}, http.StatusCreated},
+	"PUT /tax/rates/{id}":                        {updateTaxRateRequest{}, TaxRate{}, 0},
+	"DELETE /tax/rates/{id}":                     {nil, messageOnly, 0},
+	"GET /tax/exemptions":                        {nil, fields{"exemptions": []TaxExemption{}, "count": 0}, 0},
+	"POST /tax/exemptions":                       {createTaxExemptionRequest{}, TaxExemption{}, http.StatusCreated},
+	"PUT /tax/exemptions/{id}":                   {updateTaxExemptionRequest{}, TaxExemption{}, 0},
+	"DELETE /tax/exemptions/{id}":                {nil, messageOnly, 0},
+	"GET /tax/product-categories":                {nil, fields{"product_tax_categories": []ProductTaxCategory{}, "count": 0}, 0},
+	"PUT /tax/product-categories/{productId}":    {setProductTaxCategoryRequest{}, ProductTaxCategory{}, 0},
+	"DELETE /tax/product-categories/{productId}": {nil, messageOnly, 0},
+
+	"GET /price-lists":         {nil, fields{"price_lists": []PriceList{}, "count": 0}, 0},
+	"POST /price-lists":        {createPriceListRequest{}, PriceList{}, http.StatusCreated},
+	"GET /price-lists/{id}":    {nil, PriceList{}, 0},
+	"PUT /price-lists/{id}":    {updatePriceListRequest{}, PriceList{}, 0},
+	"DELETE /price-lists/{id}": {nil, messageOnly, 0},
+
+	"GET /openapi.json": {nil, nil, 0},
+}
+
+var (
+	messageOnly         = fields{"message": ""}
+	versionedMessage    = fields{"message": "", "version": 0}
+	orderStatusResponse = fields{"order_id": 0, "status": "", "message": "", "invoice_id": 0}
+	quoteStatusResponse = fields{"quote_id": 0, "status": "", "message": ""}
+	historyResponse     = fields{"history": []AuditEntry{}, "count": 0}
+)
+
+// openAPIDocument builds the OpenAPI 3 description of routes. Schemas are derived from
+// the Go types in routeDocs, so the document follows the code it describes.
+func openAPIDocument(routes []route, version string) map[string]interface{} {
+	g := &schemaGenerator{schemas: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+
+	for _, rt := range routes {
+		doc := routeDocs[rt.key()]
+
+		status := doc.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		op := map[string]interface{}{
+			"operationId": handlerName(rt.handler),
+			"summary":     rt.description,
+			"tags":        []string{strings.Split(strings.TrimPrefix(rt.pattern, "/"), "/")[0]},
+			"responses": map[string]interface{}{
+				strconv.Itoa(status): map[string]interface{}{
+					"description": http.StatusText(status),
+					"content":     jsonContent(g.schemaOf(doc.response)),
+				},
+				"default": map[string]interface{}{"$ref": "#/components/responses/Error"},
+			},
+		}
+		if rt.permission != "" {
+			op["x-permission"] = rt.permission
+		}
+		params := pathParameters(rt.pattern)
+		if idempotentRoutes[rt.key()] {
+			params = append(params, map[string]interface{}{
+				"name":        idempotencyKeyHeader,
+				"in":          "header",
+				"description": "Makes the request safe to retry: a retry with the same key gets the original response",
+				"schema":      map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKeyLength},
+			})
+		}
+		if _, ok := versionedRoutes[rt.key()]; ok {
+			params = append(params, map[string]interface{}{
+				"name":        "If-Match",
+				"in":          "header",
+				"required":    true,
+				"description": "The ETag of the document being updated; an update of a changed document fails with 412",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if doc.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(g.schemaOf(doc.request)),
+			}
+		}
+
+		if paths[rt.pattern] == nil {
+			paths[rt.pattern] = map[string]interface{}{}
+		}
+		paths[rt.pattern][strings.ToLower(rt.method)] = op
+	}
+
+	problem := map[string]interface{}{
+		problemContentType: map[string]interface{}{"schema": g.schemaOf(Problem{})},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Sales Module API",
+			"version": version,
+		},
+		"servers": []map[string]interface{}{{"url": apiPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error, as RFC 7807 problem details",
+					"content":     problem,
+				},
+			},
+		},
+	}
+}
+
+// GetOpenAPIDocument serves the OpenAPI description of the module's routes
+func (p *SalesPlugin) GetOpenAPIDocument(w http.ResponseWriter, r *http.Request) {
+	sdk.WriteJSON(w, http.StatusOK, json.RawMessage(p.openAPI))
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+// pathParameters declares the {params} of pattern; every one is a numeric ID
+func pathParameters(pattern string) []map[string]interface{} {
+	var params []map[string]interface{}
+	for _, segment := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "integer"},
+			})
+		}
+	}
+	return params
+}
+
+// handlerName is the method name of a handler method value, used as the operation ID
+func handlerName(handler http.HandlerFunc) string {
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	name = strings.TrimSuffix(name, "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// schemaGenerator derives JSON schemas from Go types, collecting named structs as
+// reusable component schemas
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the schema of v, which is a value of the type to describe or fields
+func (g *schemaGenerator) schemaOf(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return map[string]interface{}{"type": "object"}
+	case fields:
+		properties := map[string]interface{}{}
+		for name, example := range v {
+			properties[name] = g.schema(reflect.TypeOf(example))
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == decimalType:
+		return map[string]interface{}{"type": "number", "format": "decimal"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil // placeholder for self-referencing types
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema describes the JSON encoding of a struct. Fields tagged validate:"required"
+// are required.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = g.schema(f.Type)
+		if strings.Contains(f.Tag.Get("validate"), "required") {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// schemaName is the component name of a named type, capitalised so request types read
+// like the documents they create
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+// invoiceableOrderStatuses are the order statuses from which invoices may be generated
+var invoiceableOrderStatuses = map[string]bool{
+	"confirmed": true,
+	"shipped":   true,
+	"delivered": true,
+}
+
+// orderInvoiceLine selects a quantity of one order line to bill
+type orderInvoiceLine struct {
+	OrderItemID int `json:"order_item_id" validate:"required"`
+	Quantity    int `json:"quantity" validate:"gt=0"`
+}
+
+// orderInvoiceRequest describes an invoice to generate from an order. When neither
+// Lines nor ProgressPercent is set, every uninvoiced quantity on the order is billed.
+// Rounding is the tenant's rounding policy for progress amounts and the carried-over tax,
+// and NumberPattern the pattern the invoice is numbered by.
+type orderInvoiceRequest struct {
+	InvoiceDate     time.Time
+	DueDate         *time.Time
+	Notes           *string
+	Lines           []orderInvoiceLine
+	ProgressPercent Decimal
+	Rounding        string
+	NumberPattern   string
+}
+
+type billableOrderItem struct {
+	id               int
+	productID        int
+	quantity         int
+	unitPrice        Decimal
+	discountPercent  Decimal
+	discountAmount   Decimal
+	taxCategory      *string
+	lineTotal        Decimal
+	invoicedQuantity int
+	invoicedAmount   Decimal
+}
+
+type invoiceLineDraft struct {
+	orderItemID     int
+	productID       int
+	quantity        int
+	unitPrice       Decimal
+	discountPercent Decimal
+	discountAmount  Decimal
+	taxCategory     *string
+	billedQuantity  int
+	billedAmount    Decimal
+	notes           *string
+}
+
+// invoiceOrder bills all or part of an order inside tx and advances the invoiced
+// quantity and amount of every order line it touches. Orders outside scope are not found.
+func (h *SalesHandler) invoiceOrder(tx *sqlx.Tx, scope dataScope, orderID int, req orderInvoiceRequest) (int, string, error) {
+	tenantID, userID := scope.tenantID, scope.userID
+
+	var customerID int
+	var status, currency string
+	var paymentTerms *string
+	var orderSubtotal, orderTax, orderDiscount, orderShipping Decimal
+	var orderTaxRate *Decimal
+	var pricesIncludeTax bool
+	var shipTo taxAddress
+	var sellerVATID, buyerVATID, vatTreatment *string
+
+	scopeCondition, scopeArgs := scope.condition("sales_rep_id", 3)
+	err := tx.QueryRow(`
+		SELECT customer_id, status, currency, payment_terms, subtotal, tax_rate, tax_amount,
+		       document_discount_amount, shipping_amount, prices_include_tax, ship_to_country,
+		       ship_to_region, ship_to_city, ship_to_postal_code, seller_vat_id, buyer_vat_id, vat_treatment
+		FROM sales_orders
+		WHERE id = $1 AND tenant_id = $2`+scopeCondition+`
+		FOR UPDATE
+	`, append([]interface{}{orderID, tenantID}, scopeArgs...)...).Scan(&customerID, &status, &currency, &paymentTerms, &orderSubtotal, &orderTaxRate,
+		&orderTax, &orderDiscount, &orderShipping, &pricesIncludeTax, &shipTo.Country, &shipTo.Region,
+		&shipTo.City, &shipTo.PostalCode, &sellerVATID, &buyerVATID, &vatTreatment)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", &requestError{http.StatusNotFound, "Sales order not found"}
+		}
+		return 0, "", err
+	}
+
+	if !invoiceableOrderStatuses[status] {
+		return 0, "", &requestError{http.StatusConflict,
+			fmt.Sprintf("Cannot invoice an order with status %s", status)}
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, product_id, quantity, unit_price, discount_percent, discount_amount,
+		       tax_category, line_total, invoiced_quantity, invoiced_amount
+		FROM sales_order_items
+		WHERE order_id = $1 AND tenant_id = $2
+		ORDER BY id
+		FOR UPDATE
+	`, orderID, tenantID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	items := map[int]*billableOrderItem{}
+	var ordered []*billableOrderItem
+	for rows.Next() {
+		item := &billableOrderItem{}
+		err := rows.Scan(&item.id, &item.productID, &item.quantity, &item.unitPrice,
+			&item.discountPercent, &item.discountAmount, &item.taxCategory, &item.lineTotal,
+			&item.invoicedQuantity, &item.invoicedAmount)
+		if err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		items[item.id] = item
+		ordered = append(ordered, item)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, "", err
+	}
+
+	rounding := newMoneyRounding(currency, req.Rounding)
+
+	var drafts []invoiceLineDraft
+	switch {
+	case !req.ProgressPercent.IsZero():
+		if len(req.Lines) > 0 {
+			return 0, "", &requestError{http.StatusBadRequest, "Specify either lines or progress_percent, not both"}
+		}
+		if req.ProgressPercent.Sign() < 0 || req.ProgressPercent.GreaterThan(decimalFromInt(100)) {
+			return 0, "", &requestError{http.StatusBadRequest, "Progress percent must be between 0 and 100"}
+		}
+
+		progress := make([]Decimal, len(ordered))
+		for i, item := range ordered {
+			progress[i] = item.lineTotal.Percent(req.ProgressPercent, decimalPlaces)
+		}
+		progress, _ = rounding.lines(progress)
+
+		for i, item := range ordered {
+			amount := minDecimal(progress[i], item.lineTotal.Sub(item.invoicedAmount))
+			if amount.Sign() <= 0 {
+				continue
+			}
+			note := fmt.Sprintf("Progress billing %.2f%% of order line %d", req.ProgressPercent, item.id)
+			drafts = append(drafts, invoiceLineDraft{
+				orderItemID:  item.id,
+				productID:    item.productID,
+				quantity:     1,
+				unitPrice:    amount,
+				taxCategory:  item.taxCategory,
+				billedAmount: amount,
+				notes:        &note,
+			})
+		}
+
+	case len(req.Lines) > 0:
+		requested := map[int]int{}
+		for _, line := range req.Lines {
+			item, ok := items[line.OrderItemID]
+			if !ok {
+				return 0, "", &requestError{http.StatusBadRequest,
+					fmt.Sprintf("Order line %d does not belong to this order", line.OrderItemID)}
+			}
+			if line.Quantity <= 0 {
+				return 0, "", &requestError{http.StatusBadRequest,
+					fmt.Sprintf("Quantity for order line %d must be positive", line.OrderItemID)}
+			}
+			requested[item.id] += line.Quantity
+		}
+
+		for _, item := range ordered {
+			if qty, ok := requested[item.id]; ok {
+				draft, err := billQuantity(item, qty, rounding)
+				if err != nil {
+					return 0, "", err
+				}
+				drafts = append(drafts, draft)
+			}
+		}
+
+	default:
+		for _, item := range ordered {
+			if remaining := item.quantity - item.invoicedQuantity; remaining > 0 {
+				draft, err := billQuantity(item, remaining, rounding)
+				if err != nil {
+					return 0, "", err
+				}
+				drafts = append(drafts, draft)
+			}
+		}
+	}
+
+	if len(drafts) == 0 {
+		return 0, "", &requestError{http.StatusConflict, "Nothing left to invoice on this order"}
+	}
+
+	// Carry the order's document discount over in proportion to the value being billed,
+	// and its tax too when the order has a fixed tax amount; otherwise the invoice is taxed
+	// at the order's rate or the rates in force on the invoice date. Shipping is billed in
+	// full by the first invoice that is not voided.
+	var invoiceSubtotal Decimal
+	for _, draft := range drafts {
+		invoiceSubtotal = invoiceSubtotal.Add(draft.billedAmount)
+	}
+	var taxAmount, discountAmount Decimal
+	if orderSubtotal.Sign() > 0 {
+		if orderTaxRate == nil {
+			taxAmount = orderTax.MulDiv(invoiceSubtotal, orderSubtotal, rounding.places)
+		}
+		discountAmount = orderDiscount.MulDiv(invoiceSubtotal, orderSubtotal, rounding.places)
+	}
+
+	var billedShipping Decimal
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(shipping_amount), 0)
+		FROM sales_invoices
+		WHERE order_id = $1 AND tenant_id = $2 AND voided_at IS NULL
+	`, orderID, tenantID).Scan(&billedShipping)
+	if err != nil {
+		return 0, "", err
+	}
+	shippingAmount := orderShipping.Sub(billedShipping)
+	if shippingAmount.Sign() < 0 {
+		shippingAmount = Decimal{}
+	}
+
+	dueDate := req.DueDate
+	if dueDate == nil {
+		dueDate = dueDateForTerms(req.InvoiceDate, paymentTerms)
+	}
+
+	invoiceNumber, err := nextDocumentNumber(tx, tenantID, numberInvoice, req.NumberPattern, req.InvoiceDate)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var invoiceID int
+	err = tx.QueryRow(`
+		INSERT INTO sales_invoices (tenant_id, invoice_number, order_id, customer_id, invoice_date, due_date,
+		                            document_discount_amount, shipping_amount, tax_rate, tax_amount,
+		                            prices_include_tax, ship_to_country, ship_to_region, ship_to_city,
+		                            ship_to_postal_code, seller_vat_id, buyer_vat_id, vat_treatment, currency,
+		                            payment_terms, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+		RETURNING id
+	`, tenantID, invoiceNumber, orderID, customerID, req.InvoiceDate, dueDate, discountAmount, shippingAmount,
+		orderTaxRate, taxAmount, pricesIncludeTax, shipTo.Country, shipTo.Region, shipTo.City, shipTo.PostalCode,
+		sellerVATID, buyerVATID, vatTreatment, currency, paymentTerms, req.Notes, userID).Scan(&invoiceID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	for _, draft := range drafts {
+		_, err = tx.Exec(`
+			INSERT INTO sales_invoice_items (tenant_id, invoice_id, order_item_id, billed_order_quantity, product_id,
+			                                 quantity, unit_price, discount_percent, discount_amount, tax_category,
+			                                 notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`, tenantID, invoiceID, draft.orderItemID, draft.billedQuantity, draft.productID, draft.quantity,
+			draft.unitPrice, draft.discountPercent, draft.discountAmount, draft.taxCategory, draft.notes)
+		if err != nil {
+			return 0, "", err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE sales_order_items
+			SET invoiced_quantity = invoiced_quantity + $1,
+			    invoiced_amount = invoiced_amount + $2
+			WHERE id = $3 AND tenant_id = $4
+		`, draft.billedQuantity, draft.billedAmount, draft.orderItemID, tenantID)
+		if err != nil {
+			return 0, "", err
+		}
+	}
+
+	if _, err = h.recalculateTotals(tx, tenantID, invoiceDocument, invoiceID); err != nil {
+		return 0, "", err
+	}
+
+	return invoiceID, invoiceNumber, nil
+}
+
+// releaseInvoicedOrderLines hands the quantities and amounts billed by an invoice back
+// to the order lines they came from, so that a voided invoice can be re-issued.
+func releaseInvoicedOrderLines(tx *sqlx.Tx, tenantID string, invoiceID int) error {
+	_, err := tx.Exec(`
+		UPDATE sales_order_items soi
+		SET invoiced_quantity = soi.invoiced_quantity - billed.quantity,
+		    invoiced_amount = soi.invoiced_amount - billed.amount
+		FROM (
+			SELECT order_item_id, SUM(billed_order_quantity) as quantity, SUM(line_total) as amount
+			FROM sales_invoice_items
+			WHERE invoice_id = $1 AND tenant_id = $2 AND order_item_id IS NOT NULL
+			GROUP BY order_item_id
+		) billed
+		WHERE soi.id = billed.order_item_id AND soi.tenant_id = $2
+	`, invoiceID, tenantID)
+	return err
+}
+
+// billQuantity prepares an invoice line for qty units of an order line, prorating
+// the line discount. The final units of a line bill whatever value remains so that
+// rounding never leaves cents behind or bills more than the line total.
+func billQuantity(item *billableOrderItem, qty int, rounding moneyRounding) (invoiceLineDraft, error) {
+	remainingQty := item.quantity - item.invoicedQuantity
+	if qty > remainingQty {
+		return invoiceLineDraft{}, &requestError{http.StatusConflict,
+			fmt.Sprintf("Order line %d has only %d uninvoiced units, cannot invoice %d", item.id, remainingQty, qty)}
+	}
+
+	remainingAmount := item.lineTotal.Sub(item.invoicedAmount)
+	discount := item.discountAmount.MulDiv(decimalFromInt(qty), decimalFromInt(item.quantity), rounding.places)
+	amount := item.unitPrice.MulInt(qty).Sub(discount)
+	if qty == remainingQty {
+		amount = remainingAmount
+	}
+	if amount.GreaterThan(remainingAmount) {
+		return invoiceLineDraft{}, &requestError{http.StatusConflict,
+			fmt.Sprintf("Order line %d has only %.2f left to invoice", item.id, remainingAmount)}
+	}
+
+	return invoiceLineDraft{
+		orderItemID:     item.id,
+		productID:       item.productID,
+		quantity:        qty,
+		unitPrice:       item.unitPrice,
+		discountPercent: item.discountPercent,
+		discountAmount:  item.unitPrice.MulInt(qty).Sub(amount),
+		taxCategory:     item.taxCategory,
+		billedQuantity:  qty,
+		billedAmount:    amount,
+	}, nil
+}
+
+// autoInvoiceOrder bills the remainder of an order when it reaches the status configured
+// by the auto_generate_invoice and auto_invoice_on_status settings. It returns 0 when no
+// invoice was generated.
+func (h *SalesHandler) autoInvoiceOrder(tx *sqlx.Tx, scope dataScope, orderID int, newStatus string) (int, error) {
+	settings := h.loadSettings(tx, scope.tenantID)
+	if !settings.AutoGenerateInvoice || newStatus != settings.AutoInvoiceOnStatus {
+		return 0, nil
+	}
+
+	invoiceReq := orderInvoiceRequest{
+		InvoiceDate:   time.Now(),
+		Rounding:      settings.Rounding,
+		NumberPattern: settings.NumberPatterns[numberInvoice],
+	}
+	invoiceID, _, err := h.invoiceOrder(tx, scope, orderID, invoiceReq)
+	if ierr, ok := err.(*requestError); ok && ierr.status == http.StatusConflict {
+		// Already fully invoiced or not invoiceable - nothing to generate
+		return 0, nil
+	}
+	return invoiceID, err
+}
+
+// createInvoiceFromOrderRequest is the body of CreateInvoiceFromOrder
+type createInvoiceFromOrderRequest struct {
+	InvoiceDate     *string            `json:"invoice_date" validate:"date"`
+	DueDate         *string            `json:"due_date" validate:"date"`
+	Notes           *string            `json:"notes"`
+	Items           []orderInvoiceLine `json:"items"`
+	ProgressPercent Decimal            `json:"progress_percent" validate:"gte=0,lte=100"`
+}
+
+// CreateInvoiceFromOrder generates an invoice for all or part of a sales order
+func (h *SalesHandler) CreateInvoiceFromOrder(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	orderID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid order ID")
+		return
+	}
+
+	var req createInvoiceFromOrderRequest
+
+	// An empty body invoices everything that remains on the order
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	if !checkRequest(w, &req) {
+		return
+	}
+
+	invoiceReq := orderInvoiceRequest{
+		InvoiceDate:     time.Now(),
+		Notes:           req.Notes,
+		Lines:           req.Items,
+		ProgressPercent: req.ProgressPercent,
+	}
+
+	if req.InvoiceDate != nil {
+		invoiceDate, err := time.Parse("2006-01-02", *req.InvoiceDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid invoice date format")
+			return
+		}
+		invoiceReq.InvoiceDate = invoiceDate
+	}
+
+	if req.DueDate != nil {
+		dueDate, err := time.Parse("2006-01-02", *req.DueDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid due date format")
+			return
+		}
+		invoiceReq.DueDate = &dueDate
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to invoice order")
+		return
+	}
+	defer tx.Rollback()
+
+	settings := h.loadSettings(tx, requestTenant(r))
+	invoiceReq.Rounding = settings.Rounding
+	invoiceReq.NumberPattern = settings.NumberPatterns[numberInvoice]
+	invoiceID, invoiceNumber, err := h.invoiceOrder(tx, h.requestDataScope(tx, r), orderID, invoiceReq)
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to invoice order")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to invoice order")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"invoice_id":     invoiceID,
+		"invoice_number": invoiceNumber,
+		"order_id":       orderID,
+		"message":        "Invoice generated from sales order successfully",
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestInvoiceOrderBillsEachLineOnce(t *testing.T) {
+	tests := []struct {
+		name           string
+		invoicedQty    int64
+		invoicedAmount string
+		body           string
+		want           int
+		billedQty      int64
+		billedAmount   string
+	}{
+		{"whole order", 0, "0", "", http.StatusCreated, 3, "30"},
+		{"every unit of the line", 0, "0", `{"items":[{"order_item_id":11,"quantity":3}]}`, http.StatusCreated, 3, "30"},
+		{"one unit over", 0, "0", `{"items":[{"order_item_id":11,"quantity":4}]}`, http.StatusConflict, 0, ""},
+		{"remainder", 2, "20", "", http.StatusCreated, 1, "10"},
+		{"remainder exactly", 2, "20", `{"items":[{"order_item_id":11,"quantity":1}]}`, http.StatusCreated, 1, "10"},
+		{"remainder and one more", 2, "20", `{"items":[{"order_item_id":11,"quantity":2}]}`, http.StatusConflict, 0, ""},
+		{"already invoiced", 3, "30", "", http.StatusConflict, 0, ""},
+		{"already invoiced line", 3, "30", `{"items":[{"order_item_id":11,"quantity":1}]}`, http.StatusConflict, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptSales(db, salesFixture{invoicedQuantity: tt.invoicedQty, invoicedAmount: tt.invoicedAmount})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/orders/7/invoice", tt.body))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			billed := db.ran("SET invoiced_quantity = invoiced_quantity + $1")
+			if tt.want != http.StatusCreated {
+				if len(billed) != 0 || len(db.ran("INSERT INTO sales_invoices")) != 0 || db.commits != 0 {
+					t.Error("refused invoice still billed the order")
+				}
+				return
+			}
+			if len(billed) != 1 || billed[0].args[0] != tt.billedQty || billed[0].args[1] != tt.billedAmount ||
+				billed[0].args[2] != int64(11) {
+				t.Errorf("order line billed with %v, want %d units worth %s", billed, tt.billedQty, tt.billedAmount)
+			}
+		})
+	}
+}
+
+func TestProgressBillingStaysWithinLineTotal(t *testing.T) {
+	tests := []struct {
+		name           string
+		invoicedAmount string
+		percent        string
+		want           int
+		billed         string
+	}{
+		{"half", "0", "50", http.StatusCreated, "15"},
+		{"all of it", "0", "100", http.StatusCreated, "30"},
+		{"just over all of it", "0", "100.01", http.StatusUnprocessableEntity, ""},
+		{"half of what remains", "20", "50", http.StatusCreated, "10"},
+		{"exactly what remains", "15", "50", http.StatusCreated, "15"},
+		{"nothing remains", "30", "1", http.StatusConflict, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptSales(db, salesFixture{invoicedAmount: tt.invoicedAmount})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/orders/7/invoice",
+				`{"progress_percent":`+tt.percent+`}`))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			billed := db.ran("SET invoiced_quantity = invoiced_quantity + $1")
+			if tt.want != http.StatusCreated {
+				if len(billed) != 0 || db.commits != 0 {
+					t.Error("refused invoice still billed the order")
+				}
+				return
+			}
+			if len(billed) != 1 || billed[0].args[0] != int64(0) || billed[0].args[1] != tt.billed {
+				t.Fatalf("order line billed with %v, want %s and no units", billed, tt.billed)
+			}
+			lines := db.ran("INSERT INTO sales_invoice_items")
+			if len(lines) != 1 || lines[0].args[6] != tt.billed {
+				t.Errorf("invoice line priced %v, want %s", lines, tt.billed)
+			}
+		})
+	}
+}
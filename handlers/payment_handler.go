@@ -0,0 +1,588 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+type SalesPayment struct {
+	ID              int                 `json:"id"`
+	PaymentNumber   string              `json:"payment_number"`
+	InvoiceID       *int                `json:"invoice_id"`
+	CustomerID      int                 `json:"customer_id"`
+	PaymentDate     time.Time           `json:"payment_date"`
+	Amount          Decimal             `json:"amount"`
+	UnappliedAmount Decimal             `json:"unapplied_amount"`
+	Currency        string              `json:"currency"`
+	PaymentMethod   string              `json:"payment_method"`
+	ReferenceNumber *string             `json:"reference_number"`
+	Notes           *string             `json:"notes"`
+	CreatedBy       int                 `json:"created_by"`
+	CreatedAt       time.Time           `json:"created_at"`
+	UpdatedBy       *int                `json:"updated_by"`
+	Allocations     []PaymentAllocation `json:"allocations,omitempty"`
+}
+
+type PaymentAllocation struct {
+	ID            int       `json:"id"`
+	PaymentID     int       `json:"payment_id"`
+	InvoiceID     int       `json:"invoice_id"`
+	InvoiceNumber string    `json:"invoice_number,omitempty"`
+	Amount        Decimal   `json:"amount"`
+	CreatedBy     int       `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// paymentAllocationRequest asks for part of a payment to be applied to one invoice
+type paymentAllocationRequest struct {
+	InvoiceID int     `json:"invoice_id" validate:"required"`
+	Amount    Decimal `json:"amount" validate:"gt=0"`
+}
+
+var paymentMethods = map[string]bool{
+	"cash":          true,
+	"check":         true,
+	"credit_card":   true,
+	"bank_transfer": true,
+	"other":         true,
+}
+
+// payableInvoiceStatuses are the invoice statuses that accept payments
+var payableInvoiceStatuses = map[string]bool{
+	"sent":    true,
+	"overdue": true,
+}
+
+const paymentColumns = `
+	sp.id, sp.payment_number, sp.invoice_id, sp.customer_id, sp.payment_date, sp.amount,
+	sp.unapplied_amount, sp.currency, sp.payment_method, sp.reference_number, sp.notes,
+	sp.created_by, sp.created_at, sp.updated_by
+`
+
+func scanPayment(row rowScanner, payment *SalesPayment) error {
+	return row.Scan(
+		&payment.ID, &payment.PaymentNumber, &payment.InvoiceID, &payment.CustomerID,
+		&payment.PaymentDate, &payment.Amount, &payment.UnappliedAmount, &payment.Currency,
+		&payment.PaymentMethod, &payment.ReferenceNumber, &payment.Notes,
+		&payment.CreatedBy, &payment.CreatedAt, &payment.UpdatedBy,
+	)
+}
+
+// lockInvoiceForSettlement locks an invoice and checks that amount may be settled against
+// it by a payment or credit of the given customer and currency.
+func lockInvoiceForSettlement(tx *sqlx.Tx, tenantID string, invoiceID, customerID int, currency string, amount Decimal) error {
+	if amount.Sign() <= 0 {
+		return &requestError{http.StatusBadRequest,
+			fmt.Sprintf("Allocation to invoice %d must be positive", invoiceID)}
+	}
+
+	var invoiceCustomerID int
+	var status, invoiceCurrency string
+	var balanceDue Decimal
+	err := tx.QueryRow(`
+		SELECT customer_id, status, currency, balance_due
+		FROM sales_invoices
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE
+	`, invoiceID, tenantID).Scan(&invoiceCustomerID, &status, &invoiceCurrency, &balanceDue)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &requestError{http.StatusNotFound, fmt.Sprintf("Sales invoice %d not found", invoiceID)}
+		}
+		return err
+	}
+
+	if invoiceCustomerID != customerID {
+		return &requestError{http.StatusBadRequest,
+			fmt.Sprintf("Sales invoice %d belongs to a different customer", invoiceID)}
+	}
+	if invoiceCurrency != currency {
+		return &requestError{http.StatusBadRequest,
+			fmt.Sprintf("Sales invoice %d is in %s, payment is in %s", invoiceID, invoiceCurrency, currency)}
+	}
+	if !payableInvoiceStatuses[status] {
+		return &requestError{http.StatusConflict,
+			fmt.Sprintf("Cannot apply payment to invoice %d with status %s", invoiceID, status)}
+	}
+	if amount.GreaterThan(balanceDue) {
+		return &requestError{http.StatusConflict,
+			fmt.Sprintf("Allocation of %.2f exceeds the %.2f due on invoice %d", amount, balanceDue, invoiceID)}
+	}
+
+	return nil
+}
+
+// settleInvoice adds paid and credited amounts to an invoice. Once nothing remains due the
+// invoice is marked paid, or cancelled when it was settled entirely by credit notes.
+func settleInvoice(tx *sqlx.Tx, tenantID string, invoiceID int, paid, credited Decimal) error {
+	_, err := tx.Exec(`
+		UPDATE sales_invoices
+		SET paid_amount = paid_amount + $1,
+		    credited_amount = credited_amount + $2,
+		    status = CASE
+		        WHEN paid_amount + credited_amount + $1 + $2 < total_amount THEN status
+		        WHEN paid_amount + $1 > 0 THEN 'paid'
+		        ELSE 'cancelled'
+		    END
+		WHERE id = $3 AND tenant_id = $4
+	`, paid, credited, invoiceID, tenantID)
+	return err
+}
+
+// allocatePayment applies amount from a payment to an invoice inside tx
+func allocatePayment(tx *sqlx.Tx, tenantID string, paymentID, customerID int, currency string, alloc paymentAllocationRequest, userID int) error {
+	if err := lockInvoiceForSettlement(tx, tenantID, alloc.InvoiceID, customerID, currency, alloc.Amount); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO sales_payment_allocations (tenant_id, payment_id, invoice_id, amount, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+	`, tenantID, paymentID, alloc.InvoiceID, alloc.Amount, userID)
+	if err != nil {
+		return err
+	}
+
+	return settleInvoice(tx, tenantID, alloc.InvoiceID, alloc.Amount, Decimal{})
+}
+
+// openInvoiceAllocations spreads amount over the customer's open invoices, oldest due first
+func openInvoiceAllocations(tx *sqlx.Tx, tenantID string, customerID int, currency string, amount Decimal) ([]paymentAllocationRequest, error) {
+	rows, err := tx.Query(`
+		SELECT id, balance_due
+		FROM sales_invoices
+		WHERE tenant_id = $1 AND customer_id = $2 AND currency = $3
+		  AND status IN ('sent', 'overdue') AND balance_due > 0
+		ORDER BY due_date NULLS LAST, invoice_date, id
+	`, tenantID, customerID, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var allocations []paymentAllocationRequest
+	remaining := amount
+	for rows.Next() && remaining.Sign() > 0 {
+		var invoiceID int
+		var balanceDue Decimal
+		if err := rows.Scan(&invoiceID, &balanceDue); err != nil {
+			return nil, err
+		}
+
+		applied := minDecimal(balanceDue, remaining)
+		allocations = append(allocations, paymentAllocationRequest{InvoiceID: invoiceID, Amount: applied})
+		remaining = remaining.Sub(applied)
+	}
+
+	return allocations, rows.Err()
+}
+
+// applyPaymentAllocations allocates a payment to invoices and reduces its unapplied amount.
+func applyPaymentAllocations(tx *sqlx.Tx, tenantID string, paymentID int, allocations []paymentAllocationRequest, userID int) (Decimal, error) {
+	var customerID int
+	var currency string
+	var unapplied Decimal
+	err := tx.QueryRow(`
+		SELECT customer_id, currency, unapplied_amount
+		FROM sales_payments
+		WHERE id = $1 AND tenant_id = $2
+		FOR UPDATE
+	`, paymentID, tenantID).Scan(&customerID, &currency, &unapplied)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Decimal{}, &requestError{http.StatusNotFound, "Sales payment not found"}
+		}
+		return Decimal{}, err
+	}
+
+	var total Decimal
+	for i := range allocations {
+		allocations[i].Amount = roundMoney(allocations[i].Amount, currency)
+		total = total.Add(allocations[i].Amount)
+	}
+	if total.GreaterThan(unapplied) {
+		return Decimal{}, &requestError{http.StatusConflict,
+			fmt.Sprintf("Allocations of %.2f exceed the %.2f unapplied on this payment", total, unapplied)}
+	}
+
+	for _, alloc := range allocations {
+		if err := allocatePayment(tx, tenantID, paymentID, customerID, currency, alloc, userID); err != nil {
+			return Decimal{}, err
+		}
+	}
+
+	remaining := unapplied.Sub(total)
+	_, err = tx.Exec("UPDATE sales_payments SET unapplied_amount = $1 WHERE id = $2 AND tenant_id = $3",
+		remaining, paymentID, tenantID)
+	return remaining, err
+}
+
+// Sales Payment Handlers
+
+// GetSalesPayments retrieves recorded payments with optional filtering
+func (h *SalesHandler) GetSalesPayments(w http.ResponseWriter, r *http.Request) {
+	customerID := r.URL.Query().Get("customer_id")
+	invoiceID := r.URL.Query().Get("invoice_id")
+	unapplied := r.URL.Query().Get("unapplied")
+	limit := r.URL.Query().Get("limit")
+
+	if limit == "" {
+		limit = "50"
+	}
+
+	query := `SELECT ` + paymentColumns + ` FROM sales_payments sp WHERE sp.tenant_id = $1`
+
+	args := []interface{}{requestTenant(r)}
+	argIndex := 2
+
+	if customerID != "" {
+		query += fmt.Sprintf(" AND sp.customer_id = $%d", argIndex)
+		args = append(args, customerID)
+		argIndex++
+	}
+
+	if invoiceID != "" {
+		query += fmt.Sprintf(` AND EXISTS (
+			SELECT 1 FROM sales_payment_allocations spa
+			WHERE spa.payment_id = sp.id AND spa.invoice_id = $%d
+		)`, argIndex)
+		args = append(args, invoiceID)
+		argIndex++
+	}
+
+	if unapplied == "true" {
+		query += " AND sp.unapplied_amount > 0"
+	}
+
+	query += fmt.Sprintf(" ORDER BY sp.payment_date DESC, sp.id DESC LIMIT $%d", argIndex)
+	args = append(args, limit)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales payments")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales payments", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales payments")
+		return
+	}
+	defer rows.Close()
+
+	var payments []SalesPayment
+	for rows.Next() {
+		var payment SalesPayment
+		if err := scanPayment(rows, &payment); err != nil {
+			h.logger.Error("Failed to scan sales payment", zap.Error(err))
+			continue
+		}
+		payments = append(payments, payment)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"payments": payments,
+		"count":    len(payments),
+	})
+}
+
+// GetSalesPayment retrieves a payment with its invoice allocations
+func (h *SalesHandler) GetSalesPayment(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid payment ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	var payment SalesPayment
+	query := `SELECT ` + paymentColumns + ` FROM sales_payments sp WHERE sp.id = $1 AND sp.tenant_id = $2`
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales payment")
+		return
+	}
+	defer tx.Rollback()
+
+	err = scanPayment(tx.QueryRow(query, id, tenantID), &payment)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales payment not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales payment", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales payment")
+		return
+	}
+
+	rows, err := tx.Query(`
+		SELECT spa.id, spa.payment_id, spa.invoice_id, si.invoice_number, spa.amount,
+		       spa.created_by, spa.created_at
+		FROM sales_payment_allocations spa
+		JOIN sales_invoices si ON spa.invoice_id = si.id
+		WHERE spa.payment_id = $1 AND spa.tenant_id = $2
+		ORDER BY spa.id
+	`, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to fetch payment allocations", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales payment")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alloc PaymentAllocation
+		err := rows.Scan(&alloc.ID, &alloc.PaymentID, &alloc.InvoiceID, &alloc.InvoiceNumber,
+			&alloc.Amount, &alloc.CreatedBy, &alloc.CreatedAt)
+		if err != nil {
+			h.logger.Error("Failed to scan payment allocation", zap.Error(err))
+			continue
+		}
+		payment.Allocations = append(payment.Allocations, alloc)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, payment)
+}
+
+// createSalesPaymentRequest is the body of CreateSalesPayment
+type createSalesPaymentRequest struct {
+	CustomerID      int                        `json:"customer_id" validate:"required"`
+	PaymentDate     string                     `json:"payment_date" validate:"required,date"`
+	Amount          Decimal                    `json:"amount" validate:"gt=0"`
+	Currency        *string                    `json:"currency" validate:"currency"`
+	PaymentMethod   string                     `json:"payment_method" validate:"required"`
+	ReferenceNumber *string                    `json:"reference_number"`
+	Notes           *string                    `json:"notes"`
+	Allocations     []paymentAllocationRequest `json:"allocations"`
+	AutoAllocate    bool                       `json:"auto_allocate"`
+}
+
+// CreateSalesPayment records a customer receipt and allocates it across one or more invoices.
+// Any amount left over is kept on the payment as unapplied credit.
+func (h *SalesHandler) CreateSalesPayment(w http.ResponseWriter, r *http.Request) {
+	var req createSalesPaymentRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if !paymentMethods[req.PaymentMethod] {
+		writeError(w, http.StatusBadRequest, "Invalid payment method")
+		return
+	}
+
+	if req.AutoAllocate && len(req.Allocations) > 0 {
+		writeError(w, http.StatusBadRequest, "Specify either allocations or auto_allocate, not both")
+		return
+	}
+
+	paymentDate, err := time.Parse("2006-01-02", req.PaymentDate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid payment date format")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record payment")
+		return
+	}
+	defer tx.Rollback()
+
+	settings := h.loadSettings(tx, tenantID)
+	currency := requestCurrency(req.Currency, settings.DefaultCurrency)
+	amount := roundMoney(req.Amount, currency)
+
+	if err := checkReferences(tx, tenantID, reference{"customer_id", "customers", req.CustomerID}); err != nil {
+		h.writeRequestError(w, err, "Failed to record payment")
+		return
+	}
+
+	allocations := req.Allocations
+	if req.AutoAllocate {
+		allocations, err = openInvoiceAllocations(tx, tenantID, req.CustomerID, currency, amount)
+		if err != nil {
+			h.logger.Error("Failed to find open invoices", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to record payment")
+			return
+		}
+	}
+
+	// Keep the legacy invoice reference when the receipt settles a single invoice
+	var invoiceID *int
+	if len(allocations) == 1 {
+		invoiceID = &allocations[0].InvoiceID
+	}
+
+	paymentNumber, err := settings.nextDocumentNumber(tx, tenantID, numberPayment, paymentDate)
+	if err != nil {
+		h.logger.Error("Failed to allocate payment number", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record payment")
+		return
+	}
+
+	var paymentID int
+	var createdAt time.Time
+	err = tx.QueryRow(`
+		INSERT INTO sales_payments (tenant_id, payment_number, invoice_id, customer_id, payment_date, amount,
+		                            unapplied_amount, currency, payment_method, reference_number,
+		                            notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at
+	`, tenantID, paymentNumber, invoiceID, req.CustomerID, paymentDate, amount, currency,
+		req.PaymentMethod, req.ReferenceNumber, req.Notes, requestUser(r)).Scan(&paymentID, &createdAt)
+	if err != nil {
+		h.logger.Error("Failed to create sales payment", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record payment")
+		return
+	}
+
+	unapplied, err := applyPaymentAllocations(tx, tenantID, paymentID, allocations, requestUser(r))
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to record payment")
+		return
+	}
+
+	if unapplied.Sign() > 0 {
+		err = recordCreditEntry(tx, tenantID, CustomerCreditEntry{
+			CustomerID: req.CustomerID,
+			EntryType:  "overpayment",
+			Amount:     unapplied,
+			Currency:   currency,
+			PaymentID:  &paymentID,
+			CreatedBy:  requestUser(r),
+		})
+		if err != nil {
+			h.logger.Error("Failed to record customer credit", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to record payment")
+			return
+		}
+	}
+
+	err = recordEvent(tx, tenantID, PaymentReceived{
+		PaymentID:       paymentID,
+		PaymentNumber:   paymentNumber,
+		CustomerID:      req.CustomerID,
+		PaymentDate:     paymentDate,
+		Amount:          amount,
+		Currency:        currency,
+		PaymentMethod:   req.PaymentMethod,
+		Allocations:     allocations,
+		UnappliedAmount: unapplied,
+	})
+	if err != nil {
+		h.logger.Error("Failed to record payment event", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record payment")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to record payment")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"payment_id":       paymentID,
+		"payment_number":   paymentNumber,
+		"allocations":      allocations,
+		"unapplied_amount": unapplied,
+		"created_at":       createdAt,
+		"message":          "Payment recorded successfully",
+	})
+}
+
+// allocateSalesPaymentRequest is the body of AllocateSalesPayment
+type allocateSalesPaymentRequest struct {
+	Allocations []paymentAllocationRequest `json:"allocations" validate:"required"`
+}
+
+// AllocateSalesPayment applies unapplied credit remaining on a payment to invoices
+func (h *SalesHandler) AllocateSalesPayment(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	paymentID, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid payment ID")
+		return
+	}
+
+	var req allocateSalesPaymentRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to allocate payment")
+		return
+	}
+	defer tx.Rollback()
+
+	unapplied, err := applyPaymentAllocations(tx, tenantID, paymentID, req.Allocations, requestUser(r))
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to allocate payment")
+		return
+	}
+
+	// Unapplied payment amounts are customer credit, so record what was used
+	var customerID int
+	var currency string
+	err = tx.QueryRow("SELECT customer_id, currency FROM sales_payments WHERE id = $1 AND tenant_id = $2",
+		paymentID, tenantID).Scan(&customerID, &currency)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales payment", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to allocate payment")
+		return
+	}
+
+	for _, alloc := range req.Allocations {
+		invoiceID := alloc.InvoiceID
+		err = recordCreditEntry(tx, tenantID, CustomerCreditEntry{
+			CustomerID: customerID,
+			EntryType:  "application",
+			Amount:     alloc.Amount.Neg(),
+			Currency:   currency,
+			PaymentID:  &paymentID,
+			InvoiceID:  &invoiceID,
+			CreatedBy:  requestUser(r),
+		})
+		if err != nil {
+			h.logger.Error("Failed to record credit application", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to allocate payment")
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to allocate payment")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"payment_id":       paymentID,
+		"unapplied_amount": unapplied,
+		"message":          "Payment allocated successfully",
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPaymentAllocationLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		want      int
+		settled   []string
+		unapplied string
+	}{
+		{"exactly the balance", `"amount":100,"allocations":[{"invoice_id":21,"amount":100}]`,
+			http.StatusCreated, []string{"100"}, "0"},
+		{"one cent over the balance", `"amount":100.01,"allocations":[{"invoice_id":21,"amount":100.01}]`,
+			http.StatusConflict, nil, ""},
+		{"one cent more than was paid", `"amount":100,"allocations":[{"invoice_id":21,"amount":100.01}]`,
+			http.StatusConflict, nil, ""},
+		{"more than was paid across invoices",
+			`"amount":120,"allocations":[{"invoice_id":21,"amount":100},{"invoice_id":22,"amount":20.01}]`,
+			http.StatusConflict, nil, ""},
+		{"part of the balance", `"amount":40,"allocations":[{"invoice_id":21,"amount":40}]`,
+			http.StatusCreated, []string{"40"}, "0"},
+		{"overpayment", `"amount":150,"allocations":[{"invoice_id":21,"amount":100}]`,
+			http.StatusCreated, []string{"100"}, "50"},
+		{"split across invoices",
+			`"amount":130,"allocations":[{"invoice_id":21,"amount":100},{"invoice_id":22,"amount":30}]`,
+			http.StatusCreated, []string{"100", "30"}, "0"},
+		{"auto allocated oldest first", `"amount":110,"auto_allocate":true`,
+			http.StatusCreated, []string{"100", "10"}, "0"},
+		{"unallocated", `"amount":25`, http.StatusCreated, nil, "25"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptSales(db, salesFixture{})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", "/payments",
+				`{"customer_id":1,"payment_date":"2026-03-02","payment_method":"bank_transfer",`+tt.body+`}`))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			settled := db.ran("SET paid_amount = paid_amount + $1")
+			if tt.want != http.StatusCreated {
+				if db.commits != 0 {
+					t.Error("refused payment was committed")
+				}
+				return
+			}
+			if len(settled) != len(tt.settled) {
+				t.Fatalf("settled %d invoices, want %d", len(settled), len(tt.settled))
+			}
+			for i, amount := range tt.settled {
+				if settled[i].args[0] != amount {
+					t.Errorf("invoice %d settled with %v, want %s", i+1, settled[i].args[0], amount)
+				}
+			}
+			remaining := db.ran("UPDATE sales_payments SET unapplied_amount")
+			if len(remaining) != 1 || remaining[0].args[0] != tt.unapplied {
+				t.Errorf("unapplied amount stored as %v, want %s", remaining, tt.unapplied)
+			}
+			credit := db.ran("INSERT INTO sales_customer_credit_ledger")
+			if tt.unapplied == "0" && len(credit) != 0 {
+				t.Errorf("fully applied payment recorded credit %v", credit)
+			}
+			if tt.unapplied != "0" && (len(credit) != 1 || credit[0].args[2] != "overpayment" || credit[0].args[3] != tt.unapplied) {
+				t.Errorf("overpayment recorded as %v, want %s", credit, tt.unapplied)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// hostPermissionsKey is the request context key under which the host ERP passes the
+// permissions granted to the authenticated user.
+const hostPermissionsKey = "permissions"
+
+// hasPermission reports whether the host granted permission to the caller. The host may
+// pass the grants as a list or as a set.
+func hasPermission(r *http.Request, permission string) bool {
+	switch grants := r.Context().Value(hostPermissionsKey).(type) {
+	case []string:
+		for _, grant := range grants {
+			if grant == permission {
+				return true
+			}
+		}
+	case map[string]bool:
+		return grants[permission]
+	}
+	return false
+}
+
+// requirePermission rejects callers that were not granted permission, naming the missing
+// permission so the client can tell the user what to ask for. permission is one of those
+// declared in module.yml.
+func requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasPermission(r, permission) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("Missing permission: %s", permission))
+			return
+		}
+		next(w, r)
+	}
+}
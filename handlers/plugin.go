@@ -1,9 +1,10 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/jmoiron/sqlx"
 	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
@@ -12,9 +13,14 @@ import (
 
 // SalesPlugin implements the ModulePlugin interface
 type SalesPlugin struct {
-	db      *sqlx.DB
-	logger  *zap.Logger
-	handler *SalesHandler
+	db         *sqlx.DB
+	logger     *zap.Logger
+	handler    *SalesHandler
+	events     *EventDispatcher
+	webhooks   *WebhookSender
+	router     *routeTable
+	openAPI    []byte
+	stopEvents context.CancelFunc
 }
 
 // NewSalesPlugin creates a new plugin instance
@@ -27,6 +33,23 @@ func (p *SalesPlugin) Initialize(db *sqlx.DB, logger *zap.Logger) error {
 	p.db = db
 	p.logger = logger
 	p.handler = NewSalesHandler(db, logger)
+	p.router = newRouteTable(p.routes(), p.handler.idempotent)
+
+	openAPI, err := json.Marshal(openAPIDocument(p.router.routes, p.GetModuleVersion()))
+	if err != nil {
+		return fmt.Errorf("failed to build OpenAPI document: %w", err)
+	}
+	p.openAPI = openAPI
+
+	p.events = NewEventDispatcher(db, logger)
+	p.webhooks = NewWebhookSender(db, logger)
+	p.events.Subscribe(AllEvents, "sales.webhooks", p.webhooks.Enqueue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopEvents = cancel
+	go p.events.Run(ctx)
+	go p.webhooks.Run(ctx)
+
 	p.logger.Info("Sales module initialized")
 	return nil
 }
@@ -44,63 +67,127 @@ func (p *SalesPlugin) GetModuleVersion() string {
 // Cleanup performs cleanup
 func (p *SalesPlugin) Cleanup() error {
 	p.logger.Info("Cleaning up sales module")
+	if p.stopEvents != nil {
+		p.stopEvents()
+	}
 	return nil
 }
 
-// GetHandler returns a handler function for a given route and method
-func (p *SalesPlugin) GetHandler(route string, method string) (http.HandlerFunc, error) {
-	route = strings.TrimPrefix(route, "/")
-	method = strings.ToUpper(method)
-
-	handlers := map[string]http.HandlerFunc{
-		"GET /orders":               p.handler.GetSalesOrders,
-		"POST /orders":              p.handler.CreateSalesOrder,
-		"GET /orders/{id}":          p.handler.GetSalesOrder,
-		"PUT /orders/{id}":          p.handler.UpdateSalesOrder,
-		"GET /quotes":               p.handler.GetSalesQuotes,
-		"POST /quotes":              p.handler.CreateSalesQuote,
-		"POST /quotes/{id}/convert": p.handler.ConvertQuoteToOrder,
-		"GET /reports/sales":        p.handler.GetSalesReport,
-		"GET /pipeline":             p.handler.GetSalesPipeline,
-	}
-
-	key := method + " " + route
-	if handler, ok := handlers[key]; ok {
-		return handler, nil
-	}
-
-	// Try pattern matching
-	for pattern, handler := range handlers {
-		if matchRoute(pattern, key) {
-			return handler, nil
-		}
-	}
-
-	return nil, fmt.Errorf("handler not found for route: %s %s", method, route)
+// Subscribe registers an in-process subscriber for the sales domain events of eventType,
+// or for all of them with AllEvents. Other modules use it to react to sales changes, under
+// a name unique to the subscriber such as "inventory.restock".
+func (p *SalesPlugin) Subscribe(eventType, name string, subscriber EventSubscriber) {
+	p.events.Subscribe(eventType, name, subscriber)
 }
 
-func matchRoute(pattern, actual string) bool {
-	pp := strings.Split(pattern, " ")
-	ap := strings.Split(actual, " ")
-
-	if len(pp) != 2 || len(ap) != 2 || pp[0] != ap[0] {
-		return false
+// routes lists every route the module serves, in the order the API documentation shows them
+func (p *SalesPlugin) routes() []route {
+	return []route{
+		{"GET", "/orders", p.handler.GetSalesOrders, "sales.orders.view", "List sales orders"},
+		{"POST", "/orders", p.handler.CreateSalesOrder, "sales.orders.create", "Create a sales order"},
+		{"GET", "/orders/{id}", p.handler.GetSalesOrder, "sales.orders.view", "Get a sales order with its items"},
+		{"PUT", "/orders/{id}", p.handler.UpdateSalesOrder, "sales.orders.edit", "Update a sales order"},
+		{"POST", "/orders/{id}/invoice", p.handler.CreateInvoiceFromOrder, "sales.invoices.create", "Invoice a sales order"},
+		{"POST", "/orders/{id}/confirm", p.handler.ConfirmSalesOrder, "sales.orders.edit", "Confirm a sales order"},
+		{"POST", "/orders/{id}/ship", p.handler.ShipSalesOrder, "sales.orders.edit", "Mark a sales order shipped"},
+		{"POST", "/orders/{id}/deliver", p.handler.DeliverSalesOrder, "sales.orders.edit", "Mark a sales order delivered"},
+		{"POST", "/orders/{id}/cancel", p.handler.CancelSalesOrder, "sales.orders.delete", "Cancel a sales order"},
+		{"GET", "/orders/{id}/history", p.handler.GetSalesOrderHistory, "sales.orders.view", "Audit trail of a sales order"},
+		{"GET", "/quotes", p.handler.GetSalesQuotes, "sales.quotes.view", "List sales quotes"},
+		{"POST", "/quotes", p.handler.CreateSalesQuote, "sales.quotes.create", "Create a sales quote"},
+		{"GET", "/quotes/{id}", p.handler.GetSalesQuote, "sales.quotes.view", "Get a sales quote with its items"},
+		{"PUT", "/quotes/{id}", p.handler.UpdateSalesQuote, "sales.quotes.edit", "Update a sales quote"},
+		{"POST", "/quotes/{id}/convert", p.handler.ConvertQuoteToOrder, "sales.orders.create", "Convert a quote to a sales order"},
+		{"POST", "/quotes/{id}/send", p.handler.SendSalesQuote, "sales.quotes.edit", "Send a quote to the customer"},
+		{"POST", "/quotes/{id}/reject", p.handler.RejectSalesQuote, "sales.quotes.edit", "Mark a quote rejected"},
+		{"POST", "/quotes/{id}/expire", p.handler.ExpireSalesQuote, "sales.quotes.edit", "Mark a quote expired"},
+		{"GET", "/quotes/{id}/history", p.handler.GetSalesQuoteHistory, "sales.quotes.view", "Audit trail of a sales quote"},
+		{"GET", "/reports/sales", p.handler.GetSalesReport, "sales.orders.view", "Sales analytics report"},
+		{"GET", "/reports/vat-summary", p.handler.GetVATSummaryReport, "sales.invoices.view", "Tax on issued invoices by VAT treatment, country and rate"},
+		{"GET", "/pipeline", p.handler.GetSalesPipeline, "sales.orders.view", "Order pipeline by status"},
+
+		{"GET", "/invoices", p.handler.GetSalesInvoices, "sales.invoices.view", "List sales invoices"},
+		{"POST", "/invoices", p.handler.CreateSalesInvoice, "sales.invoices.create", "Create a sales invoice"},
+		{"GET", "/invoices/{id}", p.handler.GetSalesInvoice, "sales.invoices.view", "Get a sales invoice with its items"},
+		{"PUT", "/invoices/{id}", p.handler.UpdateSalesInvoice, "sales.invoices.edit", "Update a sales invoice"},
+		{"POST", "/invoices/{id}/send", p.handler.SendSalesInvoice, "sales.invoices.edit", "Issue a draft invoice"},
+		{"POST", "/invoices/{id}/void", p.handler.VoidSalesInvoice, "sales.invoices.delete", "Void an invoice"},
+		{"GET", "/invoices/{id}/items", p.handler.GetSalesInvoiceItems, "sales.invoices.view", "List the items of an invoice"},
+		{"POST", "/invoices/{id}/items", p.handler.AddSalesInvoiceItem, "sales.invoices.edit", "Add an item to a draft invoice"},
+		{"PUT", "/invoices/{id}/items/{itemId}", p.handler.UpdateSalesInvoiceItem, "sales.invoices.edit", "Update an item of a draft invoice"},
+		{"DELETE", "/invoices/{id}/items/{itemId}", p.handler.DeleteSalesInvoiceItem, "sales.invoices.edit", "Remove an item from a draft invoice"},
+		{"POST", "/invoices/{id}/credit-notes", p.handler.CreateCreditNote, "sales.invoices.create", "Credit an invoice"},
+		{"GET", "/invoices/{id}/history", p.handler.GetSalesInvoiceHistory, "sales.invoices.view", "Audit trail of a sales invoice"},
+
+		{"GET", "/credit-notes", p.handler.GetCreditNotes, "sales.invoices.view", "List credit notes"},
+		{"GET", "/credit-notes/{id}", p.handler.GetCreditNote, "sales.invoices.view", "Get a credit note with its items"},
+		{"GET", "/credit-notes/{id}/history", p.handler.GetCreditNoteHistory, "sales.invoices.view", "Audit trail of a credit note"},
+
+		{"GET", "/payments", p.handler.GetSalesPayments, "sales.payments.view", "List payments"},
+		{"POST", "/payments", p.handler.CreateSalesPayment, "sales.payments.create", "Record a payment"},
+		{"GET", "/payments/{id}", p.handler.GetSalesPayment, "sales.payments.view", "Get a payment with its allocations"},
+		{"POST", "/payments/{id}/allocations", p.handler.AllocateSalesPayment, "sales.payments.edit", "Allocate a payment to invoices"},
+		{"GET", "/payments/{id}/history", p.handler.GetSalesPaymentHistory, "sales.payments.view", "Audit trail of a payment"},
+
+		{"GET", "/returns", p.handler.GetSalesReturns, "sales.returns.view", "List sales returns"},
+		{"POST", "/returns", p.handler.CreateSalesReturn, "sales.returns.create", "Open a sales return"},
+		{"GET", "/returns/{id}", p.handler.GetSalesReturn, "sales.returns.view", "Get a sales return with its items"},
+		{"POST", "/returns/{id}/approve", p.handler.ApproveSalesReturn, "sales.returns.edit", "Approve a return"},
+		{"POST", "/returns/{id}/reject", p.handler.RejectSalesReturn, "sales.returns.edit", "Reject a return"},
+		{"POST", "/returns/{id}/receive", p.handler.ReceiveSalesReturn, "sales.returns.edit", "Record returned goods as received"},
+		{"POST", "/returns/{id}/process", p.handler.ProcessSalesReturn, "sales.returns.edit", "Credit a received return"},
+		{"GET", "/returns/{id}/history", p.handler.GetSalesReturnHistory, "sales.returns.view", "Audit trail of a sales return"},
+
+		{"GET", "/customers/{id}/credit", p.handler.GetCustomerCredit, "sales.payments.view", "Customer credit balances and ledger history"},
+		{"POST", "/customers/{id}/credit/apply", p.handler.ApplyCustomerCredit, "sales.payments.edit", "Apply customer credit to open invoices"},
+		{"POST", "/customers/{id}/refunds", p.handler.CreateCustomerRefund, "sales.payments.create", "Refund customer credit"},
+
+		{"GET", "/webhooks", p.handler.GetWebhooks, "sales.webhooks.view", "List webhooks"},
+		{"POST", "/webhooks", p.handler.CreateWebhook, "sales.webhooks.create", "Subscribe a URL to sales events"},
+		{"GET", "/webhooks/{id}", p.handler.GetWebhook, "sales.webhooks.view", "Get a webhook"},
+		{"PUT", "/webhooks/{id}", p.handler.UpdateWebhook, "sales.webhooks.edit", "Update a webhook or rotate its secret"},
+		{"DELETE", "/webhooks/{id}", p.handler.DeleteWebhook, "sales.webhooks.delete", "Delete a webhook"},
+		{"GET", "/webhooks/{id}/deliveries", p.handler.GetWebhookDeliveries, "sales.webhooks.view", "Delivery log of a webhook"},
+		{"POST", "/webhooks/{id}/deliveries/{deliveryId}/replay", p.handler.ReplayWebhookDelivery, "sales.webhooks.edit", "Send a logged delivery again"},
+
+		{"GET", "/tax/jurisdictions", p.handler.GetTaxJurisdictions, "sales.tax.view", "List tax jurisdictions with their rates"},
+		{"POST", "/tax/jurisdictions", p.handler.CreateTaxJurisdiction, "sales.tax.create", "Create a tax jurisdiction"},
+		{"GET", "/tax/jurisdictions/{id}", p.handler.GetTaxJurisdiction, "sales.tax.view", "Get a tax jurisdiction with its rates"},
+		{"PUT", "/tax/jurisdictions/{id}", p.handler.UpdateTaxJurisdiction, "sales.tax.edit", "Update a tax jurisdiction"},
+		{"DELETE", "/tax/jurisdictions/{id}", p.handler.DeleteTaxJurisdiction, "sales.tax.delete", "Delete a tax jurisdiction and its rates"},
+		{"POST", "/tax/jurisdictions/{id}/rates", p.handler.CreateTaxRate, "sales.tax.create", "Add a rate to a tax jurisdiction"},
+		{"PUT", "/tax/rates/{id}", p.handler.UpdateTaxRate, "sales.tax.edit", "Update a tax rate"},
+		{"DELETE", "/tax/rates/{id}", p.handler.DeleteTaxRate, "sales.tax.delete", "Delete a tax rate"},
+		{"GET", "/tax/exemptions", p.handler.GetTaxExemptions, "sales.tax.view", "List customer tax exemption certificates"},
+		{"POST", "/tax/exemptions", p.handler.CreateTaxExemption, "sales.tax.create", "Record a customer tax exemption certificate"},
+		{"PUT", "/tax/exemptions/{id}", p.handler.UpdateTaxExemption, "sales.tax.edit", "Update a tax exemption certificate"},
+		{"DELETE", "/tax/exemptions/{id}", p.handler.DeleteTaxExemption, "sales.tax.delete", "Delete a tax exemption certificate"},
+		{"GET", "/tax/product-categories", p.handler.GetProductTaxCategories, "sales.tax.view", "List product tax categories"},
+		{"PUT", "/tax/product-categories/{productId}", p.handler.SetProductTaxCategory, "sales.tax.edit", "Assign a product a tax category"},
+		{"DELETE", "/tax/product-categories/{productId}", p.handler.DeleteProductTaxCategory, "sales.tax.delete", "Return a product to the general tax rates"},
+
+		{"GET", "/price-lists", p.handler.GetPriceLists, "sales.price_lists.view", "List price lists"},
+		{"POST", "/price-lists", p.handler.CreatePriceList, "sales.price_lists.create", "Create a price list"},
+		{"GET", "/price-lists/{id}", p.handler.GetPriceList, "sales.price_lists.view", "Get a price list with its items"},
+		{"PUT", "/price-lists/{id}", p.handler.UpdatePriceList, "sales.price_lists.edit", "Update a price list or replace its items"},
+		{"DELETE", "/price-lists/{id}", p.handler.DeletePriceList, "sales.price_lists.delete", "Delete a price list"},
+
+		{"GET", "/openapi.json", p.GetOpenAPIDocument, "", "OpenAPI description of this API"},
 	}
+}
 
-	pPath := strings.Split(pp[1], "/")
-	aPath := strings.Split(ap[1], "/")
-
-	if len(pPath) != len(aPath) {
-		return false
-	}
+// Router returns the module's routes as a single handler, for hosts that mount the module
+// under its prefix instead of registering routes one by one
+func (p *SalesPlugin) Router() http.Handler {
+	return p.router
+}
 
-	for i := range pPath {
-		if pPath[i] != aPath[i] && !(strings.HasPrefix(pPath[i], "{") && strings.HasSuffix(pPath[i], "}")) {
-			return false
-		}
+// GetHandler returns a handler function for a given route and method
+func (p *SalesPlugin) GetHandler(route string, method string) (http.HandlerFunc, error) {
+	if p.router == nil {
+		return nil, fmt.Errorf("sales module is not initialized")
 	}
-
-	return true
+	return p.router.handler(route, method)
 }
 
 // Handler is the exported symbol
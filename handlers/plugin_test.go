@@ -0,0 +1,1446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// routePermissions lists every route with the permission module.yml requires for it
+var routePermissions = []struct {
+	method     string
+	path       string
+	pattern    string
+	permission string
+}{
+	{"GET", "/orders", "GET /orders", "sales.orders.view"},
+	{"POST", "/orders", "POST /orders", "sales.orders.create"},
+	{"GET", "/orders/7", "GET /orders/{id}", "sales.orders.view"},
+	{"PUT", "/orders/7", "PUT /orders/{id}", "sales.orders.edit"},
+	{"POST", "/orders/7/invoice", "POST /orders/{id}/invoice", "sales.invoices.create"},
+	{"POST", "/orders/7/confirm", "POST /orders/{id}/confirm", "sales.orders.edit"},
+	{"POST", "/orders/7/ship", "POST /orders/{id}/ship", "sales.orders.edit"},
+	{"POST", "/orders/7/deliver", "POST /orders/{id}/deliver", "sales.orders.edit"},
+	{"POST", "/orders/7/cancel", "POST /orders/{id}/cancel", "sales.orders.delete"},
+	{"GET", "/orders/7/history", "GET /orders/{id}/history", "sales.orders.view"},
+	{"GET", "/quotes", "GET /quotes", "sales.quotes.view"},
+	{"POST", "/quotes", "POST /quotes", "sales.quotes.create"},
+	{"GET", "/quotes/7", "GET /quotes/{id}", "sales.quotes.view"},
+	{"PUT", "/quotes/7", "PUT /quotes/{id}", "sales.quotes.edit"},
+	{"POST", "/quotes/7/convert", "POST /quotes/{id}/convert", "sales.orders.create"},
+	{"POST", "/quotes/7/send", "POST /quotes/{id}/send", "sales.quotes.edit"},
+	{"POST", "/quotes/7/reject", "POST /quotes/{id}/reject", "sales.quotes.edit"},
+	{"POST", "/quotes/7/expire", "POST /quotes/{id}/expire", "sales.quotes.edit"},
+	{"GET", "/quotes/7/history", "GET /quotes/{id}/history", "sales.quotes.view"},
+	{"GET", "/reports/sales", "GET /reports/sales", "sales.orders.view"},
+	{"GET", "/reports/vat-summary", "GET /reports/vat-summary", "sales.invoices.view"},
+	{"GET", "/pipeline", "GET /pipeline", "sales.orders.view"},
+	{"GET", "/invoices", "GET /invoices", "sales.invoices.view"},
+	{"POST", "/invoices", "POST /invoices", "sales.invoices.create"},
+	{"GET", "/invoices/7", "GET /invoices/{id}", "sales.invoices.view"},
+	{"PUT", "/invoices/7", "PUT /invoices/{id}", "sales.invoices.edit"},
+	{"POST", "/invoices/7/send", "POST /invoices/{id}/send", "sales.invoices.edit"},
+	{"POST", "/invoices/7/void", "POST /invoices/{id}/void", "sales.invoices.delete"},
+	{"GET", "/invoices/7/items", "GET /invoices/{id}/items", "sales.invoices.view"},
+	{"POST", "/invoices/7/items", "POST /invoices/{id}/items", "sales.invoices.edit"},
+	{"PUT", "/invoices/7/items/3", "PUT /invoices/{id}/items/{itemId}", "sales.invoices.edit"},
+	{"DELETE", "/invoices/7/items/3", "DELETE /invoices/{id}/items/{itemId}", "sales.invoices.edit"},
+	{"POST", "/invoices/7/credit-notes", "POST /invoices/{id}/credit-notes", "sales.invoices.create"},
+	{"GET", "/invoices/7/history", "GET /invoices/{id}/history", "sales.invoices.view"},
+	{"GET", "/credit-notes", "GET /credit-notes", "sales.invoices.view"},
+	{"GET", "/credit-notes/7", "GET /credit-notes/{id}", "sales.invoices.view"},
+	{"GET", "/credit-notes/7/history", "GET /credit-notes/{id}/history", "sales.invoices.view"},
+	{"GET", "/payments", "GET /payments", "sales.payments.view"},
+	{"POST", "/payments", "POST /payments", "sales.payments.create"},
+	{"GET", "/payments/7", "GET /payments/{id}", "sales.payments.view"},
+	{"POST", "/payments/7/allocations", "POST /payments/{id}/allocations", "sales.payments.edit"},
+	{"GET", "/payments/7/history", "GET /payments/{id}/history", "sales.payments.view"},
+	{"GET", "/returns", "GET /returns", "sales.returns.view"},
+	{"POST", "/returns", "POST /returns", "sales.returns.create"},
+	{"GET", "/returns/7", "GET /returns/{id}", "sales.returns.view"},
+	{"POST", "/returns/7/approve", "POST /returns/{id}/approve", "sales.returns.edit"},
+	{"POST", "/returns/7/reject", "POST /returns/{id}/reject", "sales.returns.edit"},
+	{"POST", "/returns/7/receive", "POST /returns/{id}/receive", "sales.returns.edit"},
+	{"POST", "/returns/7/process", "POST /returns/{id}/process", "sales.returns.edit"},
+	{"GET", "/returns/7/history", "GET /returns/{id}/history", "sales.returns.view"},
+	{"GET", "/customers/7/credit", "GET /customers/{id}/credit", "sales.payments.view"},
+	{"POST", "/customers/7/credit/apply", "POST /customers/{id}/credit/apply", "sales.payments.edit"},
+	{"POST", "/customers/7/refunds", "POST /customers/{id}/refunds", "sales.payments.create"},
+	{"GET", "/webhooks", "GET /webhooks", "sales.webhooks.view"},
+	{"POST", "/webhooks", "POST /webhooks", "sales.webhooks.create"},
+	{"GET", "/webhooks/7", "GET /webhooks/{id}", "sales.webhooks.view"},
+	{"PUT", "/webhooks/7", "PUT /webhooks/{id}", "sales.webhooks.edit"},
+	{"DELETE", "/webhooks/7", "DELETE /webhooks/{id}", "sales.webhooks.delete"},
+	{"GET", "/webhooks/7/deliveries", "GET /webhooks/{id}/deliveries", "sales.webhooks.view"},
+	{"POST", "/webhooks/7/deliveries/3/replay", "POST /webhooks/{id}/deliveries/{deliveryId}/replay", "sales.webhooks.edit"},
+	{"GET", "/tax/jurisdictions", "GET /tax/jurisdictions", "sales.tax.view"},
+	{"POST", "/tax/jurisdictions", "POST /tax/jurisdictions", "sales.tax.create"},
+	{"GET", "/tax/jurisdictions/7", "GET /tax/jurisdictions/{id}", "sales.tax.view"},
+	{"PUT", "/tax/jurisdictions/7", "PUT /tax/jurisdictions/{id}", "sales.tax.edit"},
+	{"DELETE", "/tax/jurisdictions/7", "DELETE /tax/jurisdictions/{id}", "sales.tax.delete"},
+	{"POST", "/tax/jurisdictions/7/rates", "POST /tax/jurisdictions/{id}/rates", "sales.tax.create"},
+	{"PUT", "/tax/rates/7", "PUT /tax/rates/{id}", "sales.tax.edit"},
+	{"DELETE", "/tax/rates/7", "DELETE /tax/rates/{id}", "sales.tax.delete"},
+	{"GET", "/tax/exemptions", "GET /tax/exemptions", "sales.tax.view"},
+	{"POST", "/tax/exemptions", "POST /tax/exemptions", "sales.tax.create"},
+	{"PUT", "/tax/exemptions/7", "PUT /tax/exemptions/{id}", "sales.tax.edit"},
+	{"DELETE", "/tax/exemptions/7", "DELETE /tax/exemptions/{id}", "sales.tax.delete"},
+	{"GET", "/tax/product-categories", "GET /tax/product-categories", "sales.tax.view"},
+	{"PUT", "/tax/product-categories/7", "PUT /tax/product-categories/{productId}", "sales.tax.edit"},
+	{"DELETE", "/tax/product-categories/7", "DELETE /tax/product-categories/{productId}", "sales.tax.delete"},
+	{"GET", "/price-lists", "GET /price-lists", "sales.price_lists.view"},
+	{"POST", "/price-lists", "POST /price-lists", "sales.price_lists.create"},
+	{"GET", "/price-lists/7", "GET /price-lists/{id}", "sales.price_lists.view"},
+	{"PUT", "/price-lists/7", "PUT /price-lists/{id}", "sales.price_lists.edit"},
+	{"DELETE", "/price-lists/7", "DELETE /price-lists/{id}", "sales.price_lists.delete"},
+	{"GET", "/openapi.json", "GET /openapi.json", ""},
+}
+
+func testPlugin() *SalesPlugin {
+	p := &SalesPlugin{logger: zap.NewNop(), handler: NewSalesHandler(nil, zap.NewNop())}
+	p.router = newRouteTable(p.routes(), p.handler.idempotent)
+	return p
+}
+
+// hostTenant is the tenant of the callers in hostContext
+const hostTenant = "6f1c2b3a-4d5e-4f60-8a7b-9c0d1e2f3a4b"
+
+// hostContext carries what the host passes for an authenticated caller granted grants
+func hostContext(grants []string) context.Context {
+	ctx := context.WithValue(context.Background(), hostTenantKey, hostTenant)
+	ctx = context.WithValue(ctx, hostUserKey, 42)
+	return context.WithValue(ctx, hostPermissionsKey, grants)
+}
+
+func TestEveryRouteDeclaresItsPermission(t *testing.T) {
+	routes := testPlugin().router.byKey
+	if len(routes) != len(routePermissions) {
+		t.Errorf("plugin serves %d routes, table covers %d", len(routes), len(routePermissions))
+	}
+
+	manifest, err := os.ReadFile("../module.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range routePermissions {
+		rt, ok := routes[tc.pattern]
+		if !ok {
+			t.Errorf("%s: route not registered", tc.pattern)
+			continue
+		}
+		if rt.permission != tc.permission {
+			t.Errorf("%s: permission = %q, want %q", tc.pattern, rt.permission, tc.permission)
+		}
+		if rt.permission != "" && !strings.Contains(string(manifest), "- "+rt.permission+"\n") {
+			t.Errorf("%s: permission %q is not declared in module.yml", tc.pattern, rt.permission)
+		}
+	}
+}
+
+func TestRoutesRejectCallersWithoutPermission(t *testing.T) {
+	p := testPlugin()
+
+	for _, tc := range routePermissions {
+		if tc.permission == "" {
+			continue
+		}
+
+		handler, err := p.GetHandler(tc.path, tc.method)
+		if err != nil {
+			t.Errorf("%s %s: %v", tc.method, tc.path, err)
+			continue
+		}
+
+		// Grant everything except the permission the route needs
+		var grants []string
+		for _, other := range routePermissions {
+			if other.permission != tc.permission {
+				grants = append(grants, other.permission)
+			}
+		}
+
+		req := httptest.NewRequest(tc.method, tc.path, nil).WithContext(hostContext(grants))
+		rec := httptest.NewRecorder()
+
+		handler(rec, req)
+
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want %d", tc.method, tc.path, rec.Code, http.StatusForbidden)
+		}
+		if !strings.Contains(rec.Body.String(), tc.permission) {
+			t.Errorf("%s %s: response %q does not name %s", tc.method, tc.path, rec.Body.String(), tc.permission)
+		}
+	}
+}
+
+func TestRouterPassesURLParams(t *testing.T) {
+	table := newRouteTable([]route{{
+		method:  "GET",
+		pattern: "/invoices/{id}/items/{itemId}",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, chi.URLParam(r, "id")+","+chi.URLParam(r, "itemId"))
+		},
+		permission: "sales.invoices.view",
+	}}, nil)
+	ctx := hostContext([]string{"sales.invoices.view"})
+
+	// Hosts register either the pattern or the concrete path, and see the full request path
+	for _, registered := range []string{"/invoices/{id}/items/{itemId}", "/invoices/7/items/3"} {
+		handler, err := table.handler(registered, "GET")
+		if err != nil {
+			t.Fatalf("%s: %v", registered, err)
+		}
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest("GET", "/api/v1/sales/invoices/7/items/3", nil).WithContext(ctx))
+
+		if rec.Body.String() != "7,3" {
+			t.Errorf("registered %s: params = %q, want %q", registered, rec.Body.String(), "7,3")
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	table.ServeHTTP(rec, httptest.NewRequest("GET", "/invoices/7/items/3", nil).WithContext(ctx))
+	if rec.Body.String() != "7,3" {
+		t.Errorf("mounted: params = %q, want %q", rec.Body.String(), "7,3")
+	}
+}
+
+func TestRouterRejectsUnservedMethods(t *testing.T) {
+	p := testPlugin()
+
+	rec := httptest.NewRecorder()
+	p.Router().ServeHTTP(rec, httptest.NewRequest("PATCH", "/orders/7", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PATCH /orders/7: status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, PUT" {
+		t.Errorf("PATCH /orders/7: Allow = %q, want %q", allow, "GET, PUT")
+	}
+
+	if _, err := p.GetHandler("/shipments", "GET"); err == nil {
+		t.Error("GET /shipments: handler found, want error")
+	}
+}
+
+// manifestRoutes reads the "METHOD /path" routes declared under api.routes in module.yml,
+// each mapped to whether it is served: false for routes marked "status: planned"
+func manifestRoutes(t *testing.T) map[string]bool {
+	manifest, err := os.ReadFile("../module.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]bool{}
+	inAPI := false
+	path := ""
+	var methods []string
+	for _, line := range strings.Split(string(manifest), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "  api:"):
+			inAPI = true
+		case inAPI && strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "   ") && trimmed != "":
+			inAPI = false
+		case inAPI && strings.HasPrefix(trimmed, "- path: "):
+			path = strings.TrimPrefix(trimmed, "- path: ")
+		case inAPI && strings.HasPrefix(trimmed, "methods: "):
+			methods = strings.Split(strings.Trim(strings.TrimPrefix(trimmed, "methods: "), "[]"), ",")
+			for _, method := range methods {
+				routes[strings.TrimSpace(method)+" "+path] = true
+			}
+		case inAPI && trimmed == "status: planned":
+			for _, method := range methods {
+				routes[strings.TrimSpace(method)+" "+path] = false
+			}
+		}
+	}
+	return routes
+}
+
+func TestOpenAPIMatchesManifest(t *testing.T) {
+	p := testPlugin()
+
+	encoded, err := json.Marshal(openAPIDocument(p.router.routes, p.GetModuleVersion()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	served := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			served[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	declared := manifestRoutes(t)
+	if len(declared) == 0 {
+		t.Fatal("no api.routes found in module.yml")
+	}
+	for route := range served {
+		switch live, ok := declared[route]; {
+		case !ok:
+			t.Errorf("%s is served but not declared in module.yml", route)
+		case !live:
+			t.Errorf("%s is served but marked planned in module.yml", route)
+		}
+	}
+	for route, live := range declared {
+		if live && !served[route] {
+			t.Errorf("%s is declared in module.yml but not served", route)
+		}
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	p := testPlugin()
+	for _, rt := range p.router.routes {
+		if _, ok := routeDocs[rt.key()]; !ok {
+			t.Errorf("%s: no entry in routeDocs", rt.key())
+		}
+	}
+	for key := range routeDocs {
+		if _, ok := p.router.byKey[key]; !ok {
+			t.Errorf("routeDocs documents %s, which is not a route", key)
+		}
+	}
+
+	doc := openAPIDocument(p.router.routes, p.GetModuleVersion())
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range []string{"SalesOrder", "SalesQuote", "PipelineStage", "CreateSalesOrderRequest"} {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("schema %s missing", name)
+		}
+	}
+
+	order := schemas["CreateSalesOrderRequest"].(map[string]interface{})
+	if required, _ := order["required"].([]string); strings.Join(required, ",") != "customer_id,order_date,items" {
+		t.Errorf("CreateSalesOrderRequest required = %v", order["required"])
+	}
+
+	get := doc["paths"].(map[string]map[string]interface{})["/orders/{id}"]["get"].(map[string]interface{})
+	if get["operationId"] != "GetSalesOrder" || get["x-permission"] != "sales.orders.view" {
+		t.Errorf("GET /orders/{id}: operationId %v, permission %v", get["operationId"], get["x-permission"])
+	}
+}
+
+func TestRequirePermissionAcceptsGrantedCaller(t *testing.T) {
+	called := false
+	handler := requirePermission("sales.orders.view", func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	for _, grants := range []interface{}{
+		[]string{"sales.quotes.view", "sales.orders.view"},
+		map[string]bool{"sales.orders.view": true},
+	} {
+		called = false
+		ctx := context.WithValue(context.Background(), hostPermissionsKey, grants)
+		req := httptest.NewRequest("GET", "/orders", nil).WithContext(ctx)
+
+		handler(httptest.NewRecorder(), req)
+
+		if !called {
+			t.Errorf("grants %v: handler was not called", grants)
+		}
+	}
+}
+
+func TestScopeForRolesPicksWidestScope(t *testing.T) {
+	settings := defaultSalesSettings()
+
+	for _, tc := range []struct {
+		roles []string
+		want  string
+	}{
+		{[]string{"sales_rep"}, dataScopeOwn},
+		{[]string{"sales_manager"}, dataScopeTerritory},
+		{[]string{"sales_rep", "admin"}, dataScopeAll},
+		{[]string{"sales_manager", "sales_rep"}, dataScopeTerritory},
+		{[]string{"warehouse"}, dataScopeOwn},
+		{nil, dataScopeOwn},
+	} {
+		if got := settings.scopeForRoles(tc.roles); got != tc.want {
+			t.Errorf("roles %v: scope = %q, want %q", tc.roles, got, tc.want)
+		}
+	}
+
+	settings.DataScopeDefault = dataScopeAll
+	if got := settings.scopeForRoles([]string{"warehouse"}); got != dataScopeAll {
+		t.Errorf("unlisted role: scope = %q, want configured default %q", got, dataScopeAll)
+	}
+
+	settings.DataScopeDefault = "everything"
+	if got := settings.scopeForRoles(nil); got != dataScopeOwn {
+		t.Errorf("unknown default: scope = %q, want %q", got, dataScopeOwn)
+	}
+}
+
+func TestRequestIDPrefersHostValue(t *testing.T) {
+	req := httptest.NewRequest("GET", "/orders/7/history", nil)
+	req.Header.Set("X-Request-ID", "client-id")
+
+	if got := requestID(req); got != "client-id" {
+		t.Errorf("without host value: request ID = %q, want %q", got, "client-id")
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), hostRequestIDKey, "host-id"))
+	if got := requestID(req); got != "host-id" {
+		t.Errorf("with host value: request ID = %q, want %q", got, "host-id")
+	}
+}
+
+func TestValidateRequestReportsFieldPaths(t *testing.T) {
+	badDate := "2024-02-30"
+	req := createSalesOrderRequest{
+		OrderDate:    "2024-13-01",
+		RequiredDate: &badDate,
+		Items: []SalesOrderItem{
+			{ProductID: 1, Quantity: 2, UnitPrice: decimalFromInt(10)},
+			{ProductID: 0, Quantity: 0, UnitPrice: decimalFromInt(-1), DiscountPercent: decimalFromInt(120)},
+			{ProductID: 3, Quantity: 2, UnitPrice: decimalFromInt(5), DiscountAmount: mustDecimal("10.01")},
+		},
+	}
+
+	want := []FieldError{
+		{"customer_id", fieldRequired, "is required"},
+		{"order_date", fieldInvalid, "must be a date in YYYY-MM-DD form"},
+		{"required_date", fieldInvalid, "must be a date in YYYY-MM-DD form"},
+		{"items[1].product_id", fieldRequired, "is required"},
+		{"items[1].quantity", fieldTooSmall, "must be greater than 0"},
+		{"items[1].unit_price", fieldTooSmall, "must be at least 0"},
+		{"items[1].discount_percent", fieldTooLarge, "must be at most 100"},
+		{"items[2].discount_amount", fieldTooLarge, "must not exceed the line value of 10.00"},
+	}
+	got := validateRequest(&req)
+	if len(got) != len(want) {
+		t.Fatalf("errors = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("error %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	if errs := validateRequest(&createSalesOrderRequest{}); len(errs) != 3 {
+		t.Errorf("empty order: errors = %v, want customer_id, order_date and items", errs)
+	}
+}
+
+func TestValidateRequestSkipsAbsentOptionalFields(t *testing.T) {
+	if errs := validateRequest(&updateSalesInvoiceItemRequest{}); len(errs) != 0 {
+		t.Errorf("empty update: errors = %v", errs)
+	}
+
+	zero, price, discount := 0, decimalFromInt(4), decimalFromInt(9)
+	errs := validateRequest(&updateSalesInvoiceItemRequest{Quantity: &zero})
+	if len(errs) != 1 || errs[0].Field != "quantity" {
+		t.Errorf("zero quantity: errors = %v", errs)
+	}
+
+	two := 2
+	errs = validateRequest(&updateSalesInvoiceItemRequest{Quantity: &two, UnitPrice: &price, DiscountAmount: &discount})
+	if len(errs) != 1 || errs[0].Field != "discount_amount" {
+		t.Errorf("discount over line value: errors = %v", errs)
+	}
+
+	var ret createSalesReturnRequest
+	json.Unmarshal([]byte(`{"order_id": 1, "items": [{"order_item_id": 4, "quantity": 1, "condition": "lost"}]}`), &ret)
+	errs = validateRequest(&ret)
+	if len(errs) != 1 || errs[0].Field != "items[0].condition" || errs[0].Code != fieldInvalid {
+		t.Errorf("unknown condition: errors = %v", errs)
+	}
+}
+
+func TestDecodeRequestWritesProblem(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{`{"customer_id": 1,`, http.StatusBadRequest, codeMalformedBody, ""},
+		{``, http.StatusBadRequest, codeMalformedBody, ""},
+		{`{"customer_id": "abc"}`, http.StatusUnprocessableEntity, codeValidationFailed, "customer_id"},
+		{`{"customer_id": 1, "payment_date": "2024-05-01", "amount": 0, "payment_method": "cash"}`,
+			http.StatusUnprocessableEntity, codeValidationFailed, "amount"},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		var req createSalesPaymentRequest
+		if decodeRequest(rec, httptest.NewRequest("POST", "/payments", strings.NewReader(tc.body)), &req) {
+			t.Errorf("%s: decoded, want problem", tc.body)
+			continue
+		}
+
+		if rec.Code != tc.status {
+			t.Errorf("%s: status = %d, want %d", tc.body, rec.Code, tc.status)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != problemContentType {
+			t.Errorf("%s: Content-Type = %q", tc.body, ct)
+		}
+
+		var problem Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("%s: %v", tc.body, err)
+		}
+		if problem.Status != tc.status || problem.Code != tc.code || problem.Type != "about:blank" {
+			t.Errorf("%s: problem = %+v", tc.body, problem)
+		}
+		if tc.field != "" && (len(problem.Errors) != 1 || problem.Errors[0].Field != tc.field) {
+			t.Errorf("%s: field errors = %v, want one for %s", tc.body, problem.Errors, tc.field)
+		}
+	}
+}
+
+func TestWriteRequestErrorReportsFields(t *testing.T) {
+	h := NewSalesHandler(nil, zap.NewNop())
+
+	rec := httptest.NewRecorder()
+	h.writeRequestError(rec, &validationError{[]FieldError{{"customer_id", fieldNotFound, "does not exist"}}}, "Failed")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"field":"customer_id"`) {
+		t.Errorf("validation error: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.writeRequestError(rec, &requestError{http.StatusConflict, "Only draft invoices can be edited"}, "Failed")
+	var problem Problem
+	json.Unmarshal(rec.Body.Bytes(), &problem)
+	if problem.Status != http.StatusConflict || problem.Code != codeConflict || problem.Detail != "Only draft invoices can be edited" {
+		t.Errorf("request error: problem = %+v", problem)
+	}
+}
+
+func TestDecimalIsExact(t *testing.T) {
+	var sum Decimal
+	for i := 0; i < 10; i++ {
+		sum = sum.Add(mustDecimal("0.1"))
+	}
+	if sum.Cmp(decimalFromInt(1)) != 0 {
+		t.Errorf("ten times 0.1 = %s, want 1", sum)
+	}
+
+	var item SalesOrderItem
+	if err := json.Unmarshal([]byte(`{"unit_price": "19.99", "discount_amount": 0.015}`), &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.UnitPrice.String() != "19.99" || item.DiscountAmount.String() != "0.015" {
+		t.Errorf("decoded %s and %s", item.UnitPrice, item.DiscountAmount)
+	}
+	out, _ := json.Marshal(struct{ Amount Decimal }{mustDecimal("1234.50")})
+	if string(out) != `{"Amount":1234.5}` {
+		t.Errorf("encoded %s", out)
+	}
+	if err := json.Unmarshal([]byte(`{"unit_price": "abc"}`), &item); err == nil {
+		t.Error("malformed amount decoded")
+	}
+
+	var scanned Decimal
+	if err := scanned.Scan([]byte("-42.105000")); err != nil || scanned.StringFixed(2) != "-42.11" {
+		t.Errorf("scanned %s, %v; rounded %s", scanned, err, scanned.StringFixed(2))
+	}
+}
+
+func TestDecimalMulDivRoundsOnce(t *testing.T) {
+	tests := []struct {
+		d, m, div string
+		places    int
+		want      string
+	}{
+		{"10", "1", "3", 2, "3.33"},
+		{"20", "1", "3", 2, "6.67"},
+		{"2.5", "1", "1", 0, "3"},
+		{"-2.5", "1", "1", 0, "-3"},
+		{"100.01", "19", "100", 2, "19"},
+		{"0.005", "1", "1", 2, "0.01"},
+	}
+	for _, tc := range tests {
+		got := mustDecimal(tc.d).MulDiv(mustDecimal(tc.m), mustDecimal(tc.div), tc.places)
+		if got.Cmp(mustDecimal(tc.want)) != 0 {
+			t.Errorf("%s × %s / %s to %d places = %s, want %s", tc.d, tc.m, tc.div, tc.places, got, tc.want)
+		}
+	}
+}
+
+func TestMoneyRoundingPolicies(t *testing.T) {
+	// Three lines of 1/3 cent over a whole cent each
+	exact := []Decimal{mustDecimal("1.003333"), mustDecimal("1.003333"), mustDecimal("1.003334")}
+
+	lines, total := newMoneyRounding("USD", roundingPerLine).lines(exact)
+	if total.String() != "3" || lines[0].String() != "1" {
+		t.Errorf("per line: lines %v, total %s", lines, total)
+	}
+
+	lines, total = newMoneyRounding("USD", roundingPerDocument).lines(exact)
+	if total.String() != "3.01" {
+		t.Errorf("per document: total %s, want 3.01", total)
+	}
+	var sum Decimal
+	for _, line := range lines {
+		sum = sum.Add(line)
+	}
+	if sum.Cmp(total) != 0 {
+		t.Errorf("per document: lines %v add up to %s, want %s", lines, sum, total)
+	}
+
+	if lines, total := newMoneyRounding("JPY", roundingPerLine).lines([]Decimal{mustDecimal("99.5")}); total.String() != "100" || lines[0].String() != "100" {
+		t.Errorf("JPY: lines %v, total %s", lines, total)
+	}
+}
+
+func TestMoneyRoundingAllocateAddsUp(t *testing.T) {
+	rounding := newMoneyRounding("EUR", roundingPerLine)
+	weights := []Decimal{decimalFromInt(1), decimalFromInt(1), decimalFromInt(1)}
+
+	shares := rounding.allocate(mustDecimal("10.00"), weights)
+	var sum Decimal
+	for _, share := range shares {
+		if share.Cmp(share.Round(2)) != 0 {
+			t.Errorf("share %s is not whole cents", share)
+		}
+		sum = sum.Add(share)
+	}
+	if sum.Cmp(mustDecimal("10")) != 0 {
+		t.Errorf("shares %v add up to %s, want 10", shares, sum)
+	}
+
+	shares = rounding.allocate(mustDecimal("5"), []Decimal{{}, {}})
+	if shares[1].String() != "5" {
+		t.Errorf("zero weights: shares %v", shares)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestCalculateTotalsGolden checks calculateTotals against testdata/totals: each input
+// document in a .json file has its expected totals in the .golden file next to it. Run
+// with -update after a deliberate change to rewrite the golden files.
+func TestCalculateTotalsGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "totals", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no totals test cases found")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var in totalsInput
+			if err := json.Unmarshal(data, &in); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := json.MarshalIndent(calculateTotals(in), "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(input, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("totals differ from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestCalculateTotalsAddsUp(t *testing.T) {
+	rate := mustDecimal("19")
+	totals := calculateTotals(totalsInput{
+		Currency: "EUR",
+		Rounding: roundingPerDocument,
+		Lines: []totalsLine{
+			{Quantity: 7, UnitPrice: mustDecimal("1.13"), DiscountPercent: mustDecimal("3")},
+			{Quantity: 1, UnitPrice: mustDecimal("0.99")},
+			{Quantity: 13, UnitPrice: mustDecimal("2.71"), DiscountAmount: mustDecimal("1.01")},
+		},
+		DocumentDiscountPercent: mustDecimal("7.5"),
+		ShippingAmount:          mustDecimal("3.90"),
+		TaxRate:                 &rate,
+	})
+
+	var subtotal, lineDiscounts, documentDiscount, tax Decimal
+	for _, line := range totals.Lines {
+		subtotal = subtotal.Add(line.LineTotal)
+		lineDiscounts = lineDiscounts.Add(line.DiscountAmount)
+		documentDiscount = documentDiscount.Add(line.DocumentDiscount)
+		tax = tax.Add(line.TaxAmount)
+	}
+	if subtotal.Cmp(totals.Subtotal) != 0 {
+		t.Errorf("line totals add up to %s, subtotal is %s", subtotal, totals.Subtotal)
+	}
+	if documentDiscount.Cmp(totals.DocumentDiscountAmount) != 0 {
+		t.Errorf("document discount shares add up to %s, want %s", documentDiscount, totals.DocumentDiscountAmount)
+	}
+	if lineDiscounts.Add(documentDiscount).Cmp(totals.DiscountAmount) != 0 {
+		t.Errorf("discounts add up to %s, want %s", lineDiscounts.Add(documentDiscount), totals.DiscountAmount)
+	}
+	if tax.Cmp(totals.TaxAmount) != 0 {
+		t.Errorf("line taxes add up to %s, want %s", tax, totals.TaxAmount)
+	}
+	want := totals.Subtotal.Sub(totals.DocumentDiscountAmount).Add(totals.ShippingAmount).Add(totals.TaxAmount)
+	if totals.TotalAmount.Cmp(want) != 0 {
+		t.Errorf("total %s, want %s", totals.TotalAmount, want)
+	}
+}
+
+func TestTaxJurisdictionCovers(t *testing.T) {
+	str := func(s string) *string { return &s }
+	california := TaxJurisdiction{Country: "US", Region: str("CA")}
+	sanFrancisco := TaxJurisdiction{Country: "US", Region: str("CA"), PostalCodePrefix: str("941")}
+	london := TaxJurisdiction{Country: "GB", City: str("London")}
+
+	tests := []struct {
+		name         string
+		jurisdiction TaxJurisdiction
+		addr         taxAddress
+		want         bool
+	}{
+		{"region", california, taxAddress{Country: str("us"), Region: str(" ca ")}, true},
+		{"other region", california, taxAddress{Country: str("US"), Region: str("NV")}, false},
+		{"no region", california, taxAddress{Country: str("US")}, false},
+		{"postal prefix", sanFrancisco, taxAddress{Country: str("US"), Region: str("CA"), PostalCode: str("94107")}, true},
+		{"other postal code", sanFrancisco, taxAddress{Country: str("US"), Region: str("CA"), PostalCode: str("90001")}, false},
+		{"city", london, taxAddress{Country: str("GB"), City: str("LONDON"), PostalCode: str("SW1A 1AA")}, true},
+		{"other country", london, taxAddress{Country: str("IE"), City: str("London")}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.jurisdiction.covers(tt.addr); got != tt.want {
+			t.Errorf("%s: covers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTaxRulesRatesFor(t *testing.T) {
+	str := func(s string) *string { return &s }
+	stateID := 2
+	rules := &taxRules{
+		jurisdictions: []TaxJurisdiction{
+			{ID: 1, Code: "CA", Rates: []TaxRate{
+				{Name: "GST", Rate: mustDecimal("5")},
+			}},
+			{ID: 2, Code: "CA-QC", Rates: []TaxRate{
+				{Name: "QST", Rate: mustDecimal("9.975"), IsCompound: true, Priority: 1},
+				{Name: "QST on books", TaxCategory: str("books"), Rate: decimalFromInt(0), Priority: 1},
+			}},
+		},
+	}
+
+	rates := rules.ratesFor(nil)
+	if len(rates) != 2 || rates[0].Name != "GST" || rates[1].Name != "QST" || !rates[1].Compound {
+		t.Fatalf("general rates: %+v", rates)
+	}
+
+	rates = rules.ratesFor(str("BOOKS"))
+	if len(rates) != 2 || rates[0].Name != "GST" || rates[1].Name != "QST on books" {
+		t.Fatalf("category rates: %+v", rates)
+	}
+
+	rules.exemptions = []TaxExemption{{CertificateNumber: "QC-123", JurisdictionID: &stateID}}
+	rates = rules.ratesFor(nil)
+	if rates[0].Exemption != nil || rates[1].Exemption == nil || *rates[1].Exemption != "QC-123" {
+		t.Fatalf("exemption: %+v", rates)
+	}
+
+	if rates := (&taxRules{}).ratesFor(nil); rates == nil {
+		t.Error("no rates: want an empty list, not nil")
+	}
+}
+
+func TestVATIDCountry(t *testing.T) {
+	tests := []struct {
+		id      string
+		country string
+		ok      bool
+	}{
+		{"DE123456789", "DE", true},
+		{"de 123.456.789", "DE", true},
+		{"ATU12345678", "AT", true},
+		{"NL123456789B01", "NL", true},
+		{"FR-XX123456789", "FR", true},
+		{"EL123456789", "GR", true},
+		{"GR123456789", "", false},
+		{"DE12345678", "", false},
+		{"GB123456789", "", false},
+		{"DE", "", false},
+	}
+	for _, tt := range tests {
+		country, ok := vatIDCountry(tt.id)
+		if country != tt.country || ok != tt.ok {
+			t.Errorf("vatIDCountry(%q) = %q, %v, want %q, %v", tt.id, country, ok, tt.country, tt.ok)
+		}
+	}
+}
+
+func TestDetermineVATTreatment(t *testing.T) {
+	str := func(s string) *string { return &s }
+	seller := str("DE123456789")
+
+	tests := []struct {
+		name   string
+		seller *string
+		buyer  *string
+		shipTo *string
+		oss    bool
+		want   *string
+	}{
+		{"no seller VAT ID", nil, str("FR12345678901"), str("FR"), false, nil},
+		{"malformed seller VAT ID", str("DE1"), nil, str("FR"), false, nil},
+		{"domestic", seller, str("DE987654321"), str("de"), false, str(vatDomestic)},
+		{"no ship-to country", seller, str("FR12345678901"), nil, false, str(vatDomestic)},
+		{"reverse charge", seller, str("FR12345678901"), str("FR"), false, str(vatReverseCharge)},
+		{"Greek buyer", seller, str("EL123456789"), str("GR"), true, str(vatReverseCharge)},
+		{"malformed buyer VAT ID", seller, str("FR1"), str("FR"), false, str(vatDistanceSale)},
+		{"consumer under OSS", seller, nil, str("FR"), true, str(vatOSS)},
+		{"consumer below OSS threshold", seller, nil, str("FR"), false, str(vatDistanceSale)},
+		{"export", seller, str("FR12345678901"), str("US"), true, str(vatExport)},
+	}
+	for _, tt := range tests {
+		got := determineVATTreatment(tt.seller, tt.buyer, tt.shipTo, tt.oss)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("%s: treatment = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if legend := vatLegend(str(vatReverseCharge)); legend == nil {
+		t.Error("reverse charge: want a legend")
+	}
+	if legend := vatLegend(str(vatDomestic)); legend != nil {
+		t.Errorf("domestic: legend = %q, want none", *legend)
+	}
+}
+
+func TestValidNumberPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"INV-{YYYY}-{seq:6}", true},
+		{"SO{YY}{MM}-{seq}", true},
+		{"CN-{seq:4}", true},
+		{"INV-{YYYY}", false},
+		{"INV-{seq}-{seq}", false},
+		{"INV-{MM}-{seq}", false},
+		{"INV-{seq:0}", false},
+		{"INV-{seq:13}", false},
+		{"INVOICE-NUMBER-FOR-{YYYY}-{seq:6}", false},
+	}
+	for _, tt := range tests {
+		if got := validNumberPattern(tt.pattern); got != tt.want {
+			t.Errorf("validNumberPattern(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDocumentNumber(t *testing.T) {
+	date := time.Date(2026, time.March, 5, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		pattern string
+		seq     int64
+		want    string
+		period  string
+	}{
+		{"INV-{YYYY}-{seq:6}", 42, "INV-2026-000042", "2026"},
+		{"SO{YY}{MM}-{seq}", 7, "SO2603-7", "2026-03"},
+		{"CN-{seq:2}", 1234, "CN-1234", ""},
+	}
+	for _, tt := range tests {
+		if got := formatDocumentNumber(tt.pattern, date, tt.seq); got != tt.want {
+			t.Errorf("formatDocumentNumber(%q, %d) = %q, want %q", tt.pattern, tt.seq, got, tt.want)
+		}
+		if got := numberingPeriod(tt.pattern, date); got != tt.period {
+			t.Errorf("numberingPeriod(%q) = %q, want %q", tt.pattern, got, tt.period)
+		}
+	}
+
+	for documentType, pattern := range defaultSalesSettings().NumberPatterns {
+		if !validNumberPattern(pattern) {
+			t.Errorf("default %s number pattern %q is invalid", documentType, pattern)
+		}
+	}
+}
+
+func TestIdempotentPassesRequestsWithoutKey(t *testing.T) {
+	h := NewSalesHandler(nil, zap.NewNop())
+	var body string
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", "/orders", strings.NewReader(`{"customer_id":1}`)))
+	if rec.Code != http.StatusCreated || body != `{"customer_id":1}` {
+		t.Errorf("status %d, body %q: want the handler to run with the body", rec.Code, body)
+	}
+
+	req := httptest.NewRequest("POST", "/orders", strings.NewReader(`{}`))
+	req.Header.Set(idempotencyKeyHeader, strings.Repeat("k", maxIdempotencyKeyLength+1))
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("key too long: status %d, want 400", rec.Code)
+	}
+}
+
+func TestRequestHash(t *testing.T) {
+	base := requestHash("POST", "/quotes/7/convert", []byte(`{}`))
+	if requestHash("POST", "/quotes/7/convert", []byte(`{}`)) != base {
+		t.Error("same request: want the same hash")
+	}
+	if requestHash("POST", "/quotes/8/convert", []byte(`{}`)) == base {
+		t.Error("other path: want a different hash")
+	}
+	if requestHash("POST", "/quotes/7/convert", []byte(`{"x":1}`)) == base {
+		t.Error("other body: want a different hash")
+	}
+}
+
+func TestResponseRecorderReplaysResponse(t *testing.T) {
+	rec := newResponseRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	rec.WriteHeader(http.StatusCreated)
+	rec.WriteHeader(http.StatusOK)
+	io.WriteString(rec, `{"order_id":1}`)
+
+	w := httptest.NewRecorder()
+	rec.writeTo(w)
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/json" || w.Body.String() != `{"order_id":1}` {
+		t.Errorf("replayed %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+// otherTenant owns the documents the caller of hostContext must never reach
+const otherTenant = "0b7d3c2e-9a8f-4e6d-b5c4-a3b2c1d0e9f8"
+
+// ownedRows answers statements containing fragment with rows only when they may see the
+// rows of owner: the transaction is bound to owner, as row-level security requires, and
+// the statement filters on it. Other statements find nothing.
+func ownedRows(db *scriptDB, owner, fragment string, columns []string, rows ...[]driver.Value) {
+	db.on(fragment, func(st scriptStatement) scriptResult {
+		bound := st.settings[tenantSetting] == owner
+		filtered := false
+		for _, arg := range st.args {
+			filtered = filtered || arg == owner
+		}
+		if !bound || !filtered {
+			return scriptResult{columns: columns}
+		}
+		return scriptResult{columns: columns, rows: rows, affected: int64(len(rows))}
+	})
+}
+
+// columnNames names the columns of a column list such as orderColumns, followed by extra
+func columnNames(columns string, extra ...string) []string {
+	var names []string
+	for _, column := range strings.Split(columns, ",") {
+		names = append(names, strings.TrimSpace(column))
+	}
+	return append(names, extra...)
+}
+
+// scriptOtherTenant gives otherTenant order 7, quote 8, invoice 9 and price list 10
+func scriptOtherTenant(db *scriptDB) {
+	customer := []string{"first_name", "last_name", "company_name", "email", "phone"}
+	ownedRows(db, otherTenant, "FROM sales_orders so", columnNames(orderColumns, append(customer, "rep_first_name", "rep_last_name")...),
+		append(documentRow(orderColumns, map[string]driver.Value{"id": int64(7), "status": "pending"}),
+			nil, nil, nil, nil, nil, nil, nil))
+	ownedRows(db, otherTenant, "SELECT status FROM sales_orders", []string{"status"}, []driver.Value{"pending"})
+	ownedRows(db, otherTenant, "SELECT version FROM sales_orders", []string{"version"}, []driver.Value{int64(1)})
+	ownedRows(db, otherTenant, "SELECT status FROM sales_quotes", []string{"status"}, []driver.Value{"sent"})
+	ownedRows(db, otherTenant, "FROM sales_quotes sq", columnNames(quoteColumns, quoteExtra...),
+		append(documentRow(quoteColumns, map[string]driver.Value{"id": int64(8)}), nil, nil, nil, nil, nil, nil))
+	ownedRows(db, otherTenant, "SELECT version FROM sales_quotes", []string{"version"}, []driver.Value{int64(1)})
+	ownedRows(db, otherTenant, "FROM price_lists pl", priceListColumnNames, priceListRow(10, 1))
+	ownedRows(db, otherTenant, "SELECT version FROM price_lists", []string{"version"}, []driver.Value{int64(1)})
+	ownedRows(db, otherTenant, "FROM sales_invoices si", columnNames(invoiceColumns, customer...),
+		append(documentRow(invoiceColumns, map[string]driver.Value{"id": int64(9)}), nil, nil, nil, nil, nil))
+	ownedRows(db, otherTenant, "SELECT status FROM sales_invoices", []string{"status"}, []driver.Value{"draft"})
+	ownedRows(db, otherTenant, "SELECT version FROM sales_invoices", []string{"version"}, []driver.Value{int64(1)})
+	ownedRows(db, otherTenant, "SELECT status, paid_amount, credited_amount", []string{"status", "paid", "credited"},
+		[]driver.Value{"draft", "0", "0"})
+	ownedRows(db, otherTenant, "SELECT 1 FROM sales_", []string{"found"}, []driver.Value{int64(1)})
+}
+
+// asTenant moves req to tenantID
+func asTenant(req *http.Request, tenantID string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), hostTenantKey, tenantID))
+}
+
+func TestOtherTenantsDocumentsAreOutOfReach(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+	}{
+		{"GET", "/orders/7", ""},
+		{"PUT", "/orders/7", `{"notes":"mine now"}`},
+		{"POST", "/orders/7/confirm", ""},
+		{"POST", "/orders/7/cancel", ""},
+		{"POST", "/orders/7/invoice", ""},
+		{"GET", "/orders/7/history", ""},
+		{"POST", "/quotes/8/convert", ""},
+		{"POST", "/quotes/8/send", ""},
+		{"POST", "/quotes/8/reject", ""},
+		{"GET", "/quotes/8/history", ""},
+		{"GET", "/quotes/8", ""},
+		{"PUT", "/quotes/8", `{"notes":"mine now"}`},
+		{"GET", "/invoices/9", ""},
+		{"PUT", "/invoices/9", `{"notes":"mine now"}`},
+		{"POST", "/invoices/9/send", ""},
+		{"POST", "/invoices/9/void", `{"reason":"mine now"}`},
+		{"POST", "/invoices/9/items", `{"product_id":5,"quantity":1,"unit_price":10}`},
+		{"PUT", "/invoices/9/items/3", `{"quantity":2}`},
+		{"DELETE", "/invoices/9/items/3", ""},
+		{"POST", "/invoices/9/credit-notes", ""},
+		{"GET", "/invoices/9/history", ""},
+		{"GET", "/price-lists/10", ""},
+		{"PUT", "/price-lists/10", `{"name":"mine now"}`},
+		{"DELETE", "/price-lists/10", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptOtherTenant(db)
+
+			req := callerRequest(tt.method, tt.path, tt.body)
+			req.Header.Set("If-Match", `"1"`)
+			rec := serve(t, db.plugin(), req)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusNotFound, rec.Body.String())
+			}
+
+			db.mu.Lock()
+			defer db.mu.Unlock()
+			if len(db.log) == 0 || !strings.Contains(db.log[0].query, "set_config(") {
+				t.Fatal("request did not start by binding its transaction to the tenant")
+			}
+			for _, st := range db.log {
+				if st.settings[tenantSetting] != hostTenant {
+					t.Errorf("%q ran bound to tenant %q, want the caller's", st.query, st.settings[tenantSetting])
+				}
+				for _, arg := range st.args {
+					if arg == otherTenant {
+						t.Errorf("%q was given the other tenant's ID", st.query)
+					}
+				}
+				verb := strings.Fields(st.query)[0]
+				if verb == "INSERT" || verb == "UPDATE" || verb == "DELETE" {
+					t.Errorf("wrote to the database: %q", st.query)
+				}
+			}
+			if db.commits != 0 {
+				t.Errorf("commits = %d, want 0", db.commits)
+			}
+		})
+	}
+}
+
+func TestOwnerReachesItsDocuments(t *testing.T) {
+	// The script is the same as for the other tenant's caller, so only the tenant decides
+	for _, tt := range []struct{ method, path, body string }{
+		{"GET", "/orders/7", ""},
+		{"GET", "/quotes/8", ""},
+		{"GET", "/invoices/9", ""},
+		{"POST", "/invoices/9/void", `{"reason":"duplicate"}`},
+		{"GET", "/price-lists/10", ""},
+	} {
+		db := &scriptDB{}
+		scriptOtherTenant(db)
+
+		rec := serve(t, db.plugin(), asTenant(callerRequest(tt.method, tt.path, tt.body), otherTenant))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s %s as its owner: status = %d, want %d: %s", tt.method, tt.path, rec.Code,
+				http.StatusOK, rec.Body.String())
+		}
+	}
+}
+
+func TestOtherTenantsCustomersAndProductsAreRefused(t *testing.T) {
+	db := &scriptDB{}
+	// The host ERP's customers and products are outside the sales row-level security
+	// policies, so only the statement's own tenant filter keeps them apart
+	for _, table := range []string{"customers", "products"} {
+		db.on("SELECT 1 FROM "+table, func(st scriptStatement) scriptResult {
+			for _, arg := range st.args {
+				if arg == hostTenant {
+					return scriptResult{columns: []string{"found"}}
+				}
+			}
+			return scriptResult{columns: []string{"found"}, rows: [][]driver.Value{{int64(1)}}}
+		})
+	}
+
+	rec := serve(t, db.plugin(), callerRequest("POST", "/orders",
+		`{"customer_id":1,"order_date":"2026-03-02","items":[{"product_id":5,"quantity":1,"unit_price":10}]}`))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusUnprocessableEntity, rec.Body.String())
+	}
+	for _, field := range []string{"customer_id", "items[0].product_id"} {
+		if !strings.Contains(rec.Body.String(), `"field":"`+field+`"`) {
+			t.Errorf("response does not name %s: %s", field, rec.Body.String())
+		}
+	}
+	if len(db.ran("INSERT INTO sales_orders")) != 0 {
+		t.Error("created an order for another tenant's customer")
+	}
+}
+
+func TestRequireTenantRejectsUnattributedRequests(t *testing.T) {
+	for _, tenant := range []interface{}{nil, "", "not-a-tenant", 42} {
+		db := &scriptDB{}
+		scriptOtherTenant(db)
+
+		rec := serve(t, db.plugin(), asTenant(callerRequest("GET", "/orders/7", ""), "").
+			WithContext(context.WithValue(hostContext([]string{"sales.orders.view"}), hostTenantKey, tenant)))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("tenant %v: status = %d, want %d", tenant, rec.Code, http.StatusUnauthorized)
+		}
+		if len(db.log) != 0 {
+			t.Errorf("tenant %v: %d statements ran, want none", tenant, len(db.log))
+		}
+	}
+}
+
+// repUser is the user of the sales rep of the documents in scriptRepDocuments
+const repUser = 77
+
+// repRows answers statements containing fragment with rows only when they may see the
+// documents of repUser: either the statement is not limited to the caller's reps, or the
+// caller it is limited to is repUser
+func repRows(db *scriptDB, fragment string, columns []string, rows ...[]driver.Value) {
+	db.on(fragment, func(st scriptStatement) scriptResult {
+		if strings.Contains(st.query, "scope_sr.user_id") {
+			visible := false
+			for _, arg := range st.args {
+				visible = visible || arg == int64(repUser)
+			}
+			if !visible {
+				return scriptResult{columns: columns}
+			}
+		}
+		return scriptResult{columns: columns, rows: rows, affected: int64(len(rows))}
+	})
+}
+
+// scriptRepDocuments gives repUser's rep order 7 and quote 8
+func scriptRepDocuments(db *scriptDB) {
+	customer := []string{"first_name", "last_name", "company_name", "email", "phone"}
+	repRows(db, "FROM sales_orders so", columnNames(orderColumns, append(customer, "rep_first_name", "rep_last_name")...),
+		append(documentRow(orderColumns, map[string]driver.Value{"id": int64(7), "status": "pending"}),
+			nil, nil, nil, nil, nil, nil, nil))
+	repRows(db, "SELECT status FROM sales_orders", []string{"status"}, []driver.Value{"pending"})
+	repRows(db, "SELECT version FROM sales_orders", []string{"version"}, []driver.Value{int64(1)})
+	repRows(db, "SELECT status FROM sales_quotes", []string{"status"}, []driver.Value{"sent"})
+	repRows(db, "FROM sales_quotes sq", columnNames(quoteColumns, quoteExtra...),
+		append(documentRow(quoteColumns, map[string]driver.Value{"id": int64(8)}), nil, nil, nil, nil, nil, nil))
+	repRows(db, "SELECT version FROM sales_quotes", []string{"version"}, []driver.Value{int64(1)})
+	repRows(db, "SELECT 1 FROM sales_", []string{"found"}, []driver.Value{int64(1)})
+}
+
+// asCaller makes req come from user with roles
+func asCaller(req *http.Request, user int, roles ...string) *http.Request {
+	ctx := context.WithValue(req.Context(), hostUserKey, user)
+	return req.WithContext(context.WithValue(ctx, hostRolesKey, roles))
+}
+
+func TestOwnScopeRefusesOtherRepsDocuments(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+	}{
+		{"GET", "/orders/7", ""},
+		{"PUT", "/orders/7", `{"notes":"mine now"}`},
+		{"POST", "/orders/7/confirm", ""},
+		{"POST", "/orders/7/invoice", ""},
+		{"GET", "/orders/7/history", ""},
+		{"POST", "/quotes/8/convert", ""},
+		{"POST", "/quotes/8/send", ""},
+		{"GET", "/quotes/8/history", ""},
+		{"GET", "/quotes/8", ""},
+		{"PUT", "/quotes/8", `{"notes":"mine now"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptRepDocuments(db)
+
+			req := asCaller(callerRequest(tt.method, tt.path, tt.body), 42, "sales_rep")
+			req.Header.Set("If-Match", `"1"`)
+			rec := serve(t, db.plugin(), req)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusNotFound, rec.Body.String())
+			}
+			for _, st := range db.log {
+				verb := strings.Fields(st.query)[0]
+				if verb == "INSERT" || verb == "UPDATE" || verb == "DELETE" {
+					t.Errorf("wrote to the database: %q", st.query)
+				}
+			}
+			if db.commits != 0 {
+				t.Errorf("commits = %d, want 0", db.commits)
+			}
+		})
+	}
+
+	// The rep and an admin see the order
+	for _, caller := range []*http.Request{
+		asCaller(callerRequest("GET", "/orders/7", ""), repUser, "sales_rep"),
+		asCaller(callerRequest("GET", "/orders/7", ""), 42, "admin"),
+	} {
+		db := &scriptDB{}
+		scriptRepDocuments(db)
+		if rec := serve(t, db.plugin(), caller); rec.Code != http.StatusOK {
+			t.Errorf("user %v with roles %v: status = %d, want %d", caller.Context().Value(hostUserKey),
+				caller.Context().Value(hostRolesKey), rec.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestSalesReportIsExact(t *testing.T) {
+	db := &scriptDB{}
+	db.returns("COUNT(*) as total_orders", "total_orders total_sales average_order_value completed_orders completed_sales",
+		[]driver.Value{int64(3), "0.30", "0.100000000000000000", int64(1), "0.10"})
+	db.returns("FROM sales_credit_notes", "invoiced credited", []driver.Value{"0.30", "0.10"})
+
+	rec := serve(t, db.plugin(), callerRequest("GET", "/reports/sales?start_date=2026-01-01&end_date=2026-03-31", ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var report map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]string{
+		"total_sales":         "0.3",
+		"average_order_value": "0.1",
+		"invoiced_sales":      "0.3",
+		"credit_notes_total":  "0.1",
+		"net_sales":           "0.2",
+	} {
+		if got := string(report[field]); got != want {
+			t.Errorf("%s = %s, want %s", field, got, want)
+		}
+	}
+}
+
+func TestSalesForecastExtendsTrend(t *testing.T) {
+	h := &SalesHandler{}
+	history := []SalesDataPoint{
+		{ActualSales: mustDecimal("100.10")},
+		{ActualSales: mustDecimal("200.20")},
+		{ActualSales: mustDecimal("300.30")},
+	}
+
+	forecast := h.calculateSalesForecast(history, "month")
+	want := []string{"400.4", "500.5", "600.6"}
+	if len(forecast) != len(want) {
+		t.Fatalf("forecast has %d periods, want %d", len(forecast), len(want))
+	}
+	for i, point := range forecast {
+		if point.Predicted.String() != want[i] {
+			t.Errorf("%s: predicted %s, want %s", point.Period, point.Predicted, want[i])
+		}
+	}
+}
+
+// keyedRequest is a create request sent with an Idempotency-Key
+func keyedRequest(key string) *http.Request {
+	req := callerRequest("POST", "/orders", `{"customer_id":1}`)
+	req.Header.Set(idempotencyKeyHeader, key)
+	return req
+}
+
+func TestIdempotentReservesKeyBeforeHandlerRuns(t *testing.T) {
+	db := &scriptDB{}
+	h := db.plugin().handler
+
+	var inUse, commits int
+	handler := h.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		inUse = h.db.Stats().InUse
+		commits = db.commits
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":7}`))
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, keyedRequest("order-1"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if inUse != 0 {
+		t.Errorf("handler ran with %d connections held for the key, want none", inUse)
+	}
+	if commits != 1 || len(db.ran("INSERT INTO sales_idempotency_keys")) != 1 {
+		t.Errorf("handler ran after %d commits, want the reservation committed first", commits)
+	}
+	stored := db.ran("UPDATE sales_idempotency_keys")
+	if len(stored) != 1 || db.commits != 2 {
+		t.Fatalf("response stored %d times in %d commits, want once after the reservation", len(stored), db.commits)
+	}
+	if body, _ := stored[0].args[2].([]byte); string(body) != `{"id":7}` {
+		t.Errorf("stored body %q, want the response", body)
+	}
+}
+
+func TestIdempotentReleasesKeyOfFailedRequest(t *testing.T) {
+	db := &scriptDB{}
+	handler := db.plugin().handler.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusConflict, "Customer is on credit hold")
+	})
+
+	rec := httptest.NewRecorder()
+	handler(rec, keyedRequest("order-1"))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want the handler's %d", rec.Code, http.StatusConflict)
+	}
+	if len(db.ran("AND status_code IS NULL")) != 1 {
+		t.Error("key of the failed request was not released")
+	}
+	if len(db.ran("UPDATE sales_idempotency_keys")) != 0 {
+		t.Error("response of the failed request was stored")
+	}
+}
+
+func TestIdempotentRetryReplaysStoredResponse(t *testing.T) {
+	db := &scriptDB{}
+	var stored []driver.Value
+	db.on("UPDATE sales_idempotency_keys", func(st scriptStatement) scriptResult {
+		stored = st.args
+		return scriptResult{affected: 1}
+	})
+	reserved := false
+	db.on("INSERT INTO sales_idempotency_keys", func(scriptStatement) scriptResult {
+		if reserved {
+			return scriptResult{affected: 0}
+		}
+		reserved = true
+		return scriptResult{affected: 1}
+	})
+	db.on("SELECT request_hash, status_code", func(scriptStatement) scriptResult {
+		return scriptResult{columns: strings.Fields("request_hash status_code content_type response_body response_headers"),
+			rows: [][]driver.Value{{requestHash("POST", "/orders", []byte(`{"customer_id":1}`)),
+				stored[0], stored[1], stored[2], stored[3]}}}
+	})
+
+	var runs int
+	handler := db.plugin().handler.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		w.Header().Set("Location", "/api/v1/sales/orders/7")
+		setETag(w, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"order_id":7}`))
+	})
+
+	first := httptest.NewRecorder()
+	handler(first, keyedRequest("order-1"))
+	retry := httptest.NewRecorder()
+	handler(retry, keyedRequest("order-1"))
+
+	if runs != 1 {
+		t.Errorf("handler ran %d times, want once", runs)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, want the first response %d %s", retry.Code, retry.Body.String(), first.Code,
+			first.Body.String())
+	}
+	for _, name := range []string{"Content-Type", "Location", "ETag"} {
+		if got, want := retry.Header().Get(name), first.Header().Get(name); got != want || want == "" {
+			t.Errorf("replayed %s = %q, want %q", name, got, want)
+		}
+	}
+	if retry.Header().Get(idempotencyReplayedHeader) != "true" {
+		t.Errorf("replay is not marked with %s", idempotencyReplayedHeader)
+	}
+}
+
+func TestIdempotentStoreIsTriedAgain(t *testing.T) {
+	db := &scriptDB{}
+	failures := 1
+	db.on("UPDATE sales_idempotency_keys", func(scriptStatement) scriptResult {
+		if failures > 0 {
+			failures--
+			return scriptResult{err: errors.New("connection reset")}
+		}
+		return scriptResult{affected: 1}
+	})
+	handler := db.plugin().handler.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	handler(httptest.NewRecorder(), keyedRequest("order-1"))
+	if got := len(db.ran("UPDATE sales_idempotency_keys")); got != 2 {
+		t.Errorf("stored the response in %d attempts, want 2", got)
+	}
+	if len(db.ran("AND status_code IS NULL")) != 0 {
+		t.Error("key of the stored response was released")
+	}
+}
+
+func TestIdempotentUnstoredResponseReleasesKey(t *testing.T) {
+	db := &scriptDB{}
+	db.on("UPDATE sales_idempotency_keys", func(scriptStatement) scriptResult {
+		return scriptResult{err: errors.New("connection reset")}
+	})
+
+	var runs int
+	handler := db.plugin().handler.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	first := httptest.NewRecorder()
+	handler(first, keyedRequest("order-1"))
+	if first.Code != http.StatusCreated {
+		t.Errorf("first request: status = %d, want %d", first.Code, http.StatusCreated)
+	}
+	if got := len(db.ran("UPDATE sales_idempotency_keys")); got != storeResponseAttempts {
+		t.Errorf("tried to store the response %d times, want %d", got, storeResponseAttempts)
+	}
+	if len(db.ran("AND status_code IS NULL")) != 1 {
+		t.Error("key of the unstored response was not released")
+	}
+
+	// The released key is free, so a retry runs rather than waiting out the window
+	retry := httptest.NewRecorder()
+	handler(retry, keyedRequest("order-1"))
+	if retry.Code != http.StatusCreated || runs != 2 {
+		t.Errorf("retry: status = %d after %d runs, want %d after 2", retry.Code, runs, http.StatusCreated)
+	}
+}
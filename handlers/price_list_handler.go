@@ -0,0 +1,546 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+// PriceList is a named set of product prices in one currency, valid from ValidFrom until
+// ValidTo. Its version is raised on every change to the list or its items.
+type PriceList struct {
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Code        string          `json:"code"`
+	Description *string         `json:"description"`
+	Currency    string          `json:"currency"`
+	ValidFrom   *time.Time      `json:"valid_from"`
+	ValidTo     *time.Time      `json:"valid_to"`
+	IsActive    bool            `json:"is_active"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Version     int             `json:"version"`
+	Items       []PriceListItem `json:"items,omitempty"`
+}
+
+// PriceListItem prices a product for orders of MinQuantity up to MaxQuantity units
+type PriceListItem struct {
+	ID          int        `json:"id"`
+	PriceListID int        `json:"price_list_id"`
+	ProductID   int        `json:"product_id"`
+	Price       Decimal    `json:"price"`
+	MinQuantity int        `json:"min_quantity"`
+	MaxQuantity *int       `json:"max_quantity"`
+	ValidFrom   *time.Time `json:"valid_from"`
+	ValidTo     *time.Time `json:"valid_to"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+const priceListColumns = `
+	pl.id, pl.name, pl.code, pl.description, COALESCE(pl.currency, 'USD'), pl.valid_from, pl.valid_to,
+	COALESCE(pl.is_active, true), pl.created_at, pl.updated_at, pl.version
+`
+
+func scanPriceList(row rowScanner, list *PriceList) error {
+	return row.Scan(&list.ID, &list.Name, &list.Code, &list.Description, &list.Currency, &list.ValidFrom,
+		&list.ValidTo, &list.IsActive, &list.CreatedAt, &list.UpdatedAt, &list.Version)
+}
+
+const priceListItemColumns = `
+	pli.id, pli.price_list_id, pli.product_id, pli.price, COALESCE(pli.min_quantity, 1), pli.max_quantity,
+	pli.valid_from, pli.valid_to, pli.created_at, pli.updated_at
+`
+
+func scanPriceListItem(row rowScanner, item *PriceListItem) error {
+	return row.Scan(&item.ID, &item.PriceListID, &item.ProductID, &item.Price, &item.MinQuantity,
+		&item.MaxQuantity, &item.ValidFrom, &item.ValidTo, &item.CreatedAt, &item.UpdatedAt)
+}
+
+// GetPriceLists retrieves the tenant's price lists, without their items
+func (h *SalesHandler) GetPriceLists(w http.ResponseWriter, r *http.Request) {
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch price lists")
+		return
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + priceListColumns + ` FROM price_lists pl WHERE pl.tenant_id = $1`
+	args := []interface{}{requestTenant(r)}
+	if active := r.URL.Query().Get("active"); active != "" {
+		isActive, err := strconv.ParseBool(active)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid active filter")
+			return
+		}
+		query += " AND COALESCE(pl.is_active, true) = $2"
+		args = append(args, isActive)
+	}
+	query += " ORDER BY pl.code"
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch price lists", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch price lists")
+		return
+	}
+	defer rows.Close()
+
+	lists := []PriceList{}
+	for rows.Next() {
+		var list PriceList
+		if err := scanPriceList(rows, &list); err != nil {
+			h.logger.Error("Failed to scan price list", zap.Error(err))
+			continue
+		}
+		lists = append(lists, list)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"price_lists": lists,
+		"count":       len(lists),
+	})
+}
+
+// GetPriceList retrieves a single price list with its items
+func (h *SalesHandler) GetPriceList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid price list ID")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch price list")
+		return
+	}
+	defer tx.Rollback()
+
+	list, err := fetchPriceList(tx, requestTenant(r), id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Price list not found")
+			return
+		}
+		h.logger.Error("Failed to fetch price list", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch price list")
+		return
+	}
+
+	setETag(w, list.Version)
+	sdk.WriteJSON(w, http.StatusOK, list)
+}
+
+// fetchPriceList loads a price list with its items
+func fetchPriceList(q sqlx.Queryer, tenantID string, id int) (PriceList, error) {
+	var list PriceList
+	err := scanPriceList(q.QueryRowx(`SELECT `+priceListColumns+` FROM price_lists pl
+		WHERE pl.id = $1 AND pl.tenant_id = $2`, id, tenantID), &list)
+	if err != nil {
+		return list, err
+	}
+
+	rows, err := q.Query(`SELECT `+priceListItemColumns+` FROM price_list_items pli
+		WHERE pli.price_list_id = $1 AND pli.tenant_id = $2
+		ORDER BY pli.product_id, pli.min_quantity`, id, tenantID)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	list.Items = []PriceListItem{}
+	for rows.Next() {
+		var item PriceListItem
+		if err := scanPriceListItem(rows, &item); err != nil {
+			return list, err
+		}
+		list.Items = append(list.Items, item)
+	}
+	return list, rows.Err()
+}
+
+// priceListItemRequest is an item of a price list in a request; min_quantity defaults to 1
+type priceListItemRequest struct {
+	ProductID   int     `json:"product_id" validate:"required"`
+	Price       Decimal `json:"price" validate:"gte=0"`
+	MinQuantity *int    `json:"min_quantity" validate:"gt=0"`
+	MaxQuantity *int    `json:"max_quantity" validate:"gt=0"`
+	ValidFrom   *string `json:"valid_from" validate:"date"`
+	ValidTo     *string `json:"valid_to" validate:"date"`
+}
+
+func (item priceListItemRequest) validate() []FieldError {
+	if item.MaxQuantity != nil && *item.MaxQuantity < item.minQuantity() {
+		return []FieldError{{"max_quantity", fieldInvalid, fmt.Sprintf("must not be below min_quantity %d", item.minQuantity())}}
+	}
+	return optionalValidityErrors(item.ValidFrom, item.ValidTo)
+}
+
+// optionalValidityErrors is validityErrors for a period that may be open at its start
+func optionalValidityErrors(from, to *string) []FieldError {
+	if from == nil {
+		return nil
+	}
+	return validityErrors("valid_to", *from, to)
+}
+
+// createPriceListRequest is the body of CreatePriceList; currency defaults to the
+// default_currency setting
+type createPriceListRequest struct {
+	Name        string                 `json:"name" validate:"required"`
+	Code        string                 `json:"code" validate:"required"`
+	Description *string                `json:"description"`
+	Currency    *string                `json:"currency" validate:"currency"`
+	ValidFrom   *string                `json:"valid_from" validate:"date"`
+	ValidTo     *string                `json:"valid_to" validate:"date"`
+	IsActive    *bool                  `json:"is_active"`
+	Items       []priceListItemRequest `json:"items"`
+}
+
+func (req createPriceListRequest) validate() []FieldError {
+	return optionalValidityErrors(req.ValidFrom, req.ValidTo)
+}
+
+// CreatePriceList adds a price list with its items
+func (h *SalesHandler) CreatePriceList(w http.ResponseWriter, r *http.Request) {
+	var req createPriceListRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create price list")
+		return
+	}
+	defer tx.Rollback()
+
+	tenantID := requestTenant(r)
+	currency := requestCurrency(req.Currency, h.loadSettings(tx, tenantID).DefaultCurrency)
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM price_lists WHERE tenant_id = $1 AND code = $2)`,
+		tenantID, req.Code).Scan(&exists)
+	if err != nil {
+		h.logger.Error("Failed to check price list code", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create price list")
+		return
+	}
+	if exists {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Price list %s already exists", req.Code))
+		return
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO price_lists (tenant_id, name, code, description, currency, valid_from, valid_to, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, tenantID, req.Name, req.Code, req.Description, currency, parseOptionalDate(req.ValidFrom),
+		parseOptionalDate(req.ValidTo), isActive).Scan(&id)
+	if err != nil {
+		h.logger.Error("Failed to create price list", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create price list")
+		return
+	}
+
+	if err := insertPriceListItems(tx, tenantID, id, req.Items); err != nil {
+		h.writeRequestError(w, err, "Failed to create price list")
+		return
+	}
+
+	list, err := fetchPriceList(tx, tenantID, id)
+	if err != nil {
+		h.logger.Error("Failed to fetch price list", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create price list")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create price list")
+		return
+	}
+
+	setETag(w, list.Version)
+	sdk.WriteJSON(w, http.StatusCreated, list)
+}
+
+// minQuantity is the quantity from which the item's price applies
+func (item priceListItemRequest) minQuantity() int {
+	if item.MinQuantity == nil {
+		return 1
+	}
+	return *item.MinQuantity
+}
+
+// insertPriceListItems adds items to price list id, after checking their products exist.
+// A product may be priced only once from each quantity.
+func insertPriceListItems(tx *sqlx.Tx, tenantID string, id int, items []priceListItemRequest) error {
+	var errs []FieldError
+	type tier struct{ productID, minQuantity int }
+	seen := map[tier]bool{}
+	refs := make([]reference, len(items))
+	for i, item := range items {
+		refs[i] = reference{fmt.Sprintf("items[%d].product_id", i), "products", item.ProductID}
+		if t := (tier{item.ProductID, item.minQuantity()}); seen[t] {
+			errs = append(errs, FieldError{fmt.Sprintf("items[%d].min_quantity", i), fieldInvalid,
+				fmt.Sprintf("product %d is already priced from quantity %d", t.productID, t.minQuantity)})
+		} else {
+			seen[t] = true
+		}
+	}
+	if len(errs) > 0 {
+		return &validationError{errs}
+	}
+	if err := checkReferences(tx, tenantID, refs...); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		_, err := tx.Exec(`
+			INSERT INTO price_list_items (tenant_id, price_list_id, product_id, price, min_quantity,
+			                              max_quantity, valid_from, valid_to)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, tenantID, id, item.ProductID, item.Price, item.minQuantity(), item.MaxQuantity,
+			parseOptionalDate(item.ValidFrom), parseOptionalDate(item.ValidTo))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePriceListRequest is the body of UpdatePriceList. Items, when sent, replace all the
+// items of the list. An empty valid_from or valid_to leaves the period open at that end.
+type updatePriceListRequest struct {
+	Name        *string                 `json:"name"`
+	Description *string                 `json:"description"`
+	Currency    *string                 `json:"currency" validate:"currency"`
+	ValidFrom   *string                 `json:"valid_from"`
+	ValidTo     *string                 `json:"valid_to"`
+	IsActive    *bool                   `json:"is_active"`
+	Items       *[]priceListItemRequest `json:"items"`
+}
+
+func (req updatePriceListRequest) validate() []FieldError {
+	var errs []FieldError
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{
+		{"valid_from", req.ValidFrom},
+		{"valid_to", req.ValidTo},
+	} {
+		if field.value == nil || *field.value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", *field.value); err != nil {
+			errs = append(errs, FieldError{field.name, fieldInvalid, "must be a date in YYYY-MM-DD form"})
+		}
+	}
+	return errs
+}
+
+// UpdatePriceList changes a price list and optionally replaces its items
+func (h *SalesHandler) UpdatePriceList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid price list ID")
+		return
+	}
+
+	var req updatePriceListRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.Name != nil {
+		setParts = append(setParts, fmt.Sprintf("name = $%d", argIndex))
+		args = append(args, *req.Name)
+		argIndex++
+	}
+	if req.Description != nil {
+		setParts = append(setParts, fmt.Sprintf("description = $%d", argIndex))
+		args = append(args, *req.Description)
+		argIndex++
+	}
+	if req.Currency != nil {
+		setParts = append(setParts, fmt.Sprintf("currency = UPPER($%d)", argIndex))
+		args = append(args, *req.Currency)
+		argIndex++
+	}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"valid_from", req.ValidFrom},
+		{"valid_to", req.ValidTo},
+	} {
+		if field.value != nil {
+			setParts = append(setParts, fmt.Sprintf("%s = NULLIF($%d, '')::date", field.column, argIndex))
+			args = append(args, *field.value)
+			argIndex++
+		}
+	}
+	if req.IsActive != nil {
+		setParts = append(setParts, fmt.Sprintf("is_active = $%d", argIndex))
+		args = append(args, *req.IsActive)
+		argIndex++
+	}
+
+	if len(setParts) == 0 && req.Items == nil {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+	// Replacing the items alone still updates the list, raising its version
+	setParts = append(setParts, "updated_at = CURRENT_TIMESTAMP")
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update price list")
+		return
+	}
+	defer tx.Rollback()
+
+	if !h.matchPriceListVersion(w, r, tx, id, "Failed to update price list") {
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE price_lists SET %s WHERE id = $%d AND tenant_id = $%d",
+		strings.Join(setParts, ", "), argIndex, argIndex+1)
+	args = append(args, id, tenantID)
+
+	if _, err = tx.Exec(query, args...); err != nil {
+		h.logger.Error("Failed to update price list", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update price list")
+		return
+	}
+
+	if req.Items != nil {
+		_, err = tx.Exec("DELETE FROM price_list_items WHERE price_list_id = $1 AND tenant_id = $2", id, tenantID)
+		if err != nil {
+			h.logger.Error("Failed to delete price list items", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update price list")
+			return
+		}
+		if err := insertPriceListItems(tx, tenantID, id, *req.Items); err != nil {
+			h.writeRequestError(w, err, "Failed to update price list")
+			return
+		}
+	}
+
+	list, err := fetchPriceList(tx, tenantID, id)
+	if err != nil {
+		h.logger.Error("Failed to fetch price list", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update price list")
+		return
+	}
+	if list.ValidFrom != nil && list.ValidTo != nil && list.ValidTo.Before(*list.ValidFrom) {
+		writeValidationError(w, []FieldError{{"valid_to", fieldInvalid,
+			"must not be before " + list.ValidFrom.Format("2006-01-02")}})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update price list")
+		return
+	}
+
+	setETag(w, list.Version)
+	sdk.WriteJSON(w, http.StatusOK, list)
+}
+
+// DeletePriceList removes a price list with its items
+func (h *SalesHandler) DeletePriceList(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid price list ID")
+		return
+	}
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete price list")
+		return
+	}
+	defer tx.Rollback()
+
+	if !h.matchPriceListVersion(w, r, tx, id, "Failed to delete price list") {
+		return
+	}
+
+	_, err = tx.Exec("DELETE FROM price_list_items WHERE price_list_id = $1 AND tenant_id = $2", id, tenantID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM price_lists WHERE id = $1 AND tenant_id = $2", id, tenantID)
+	}
+	if err != nil {
+		h.logger.Error("Failed to delete price list", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete price list")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete price list")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]string{
+		"message": "Price list deleted successfully",
+	})
+}
+
+// matchPriceListVersion locks price list id and checks its version against the If-Match
+// header of r, like matchInvoiceVersion. A missing list is refused with 404.
+func (h *SalesHandler) matchPriceListVersion(w http.ResponseWriter, r *http.Request, tx *sqlx.Tx, id int, failure string) bool {
+	tenantID := requestTenant(r)
+
+	version, err := lockVersion(tx, "price_lists", tenantID, id)
+	if err == sql.ErrNoRows {
+		writeError(w, http.StatusNotFound, "Price list not found")
+		return false
+	}
+	if err == nil && !ifMatches(requestIfMatch(r), version) {
+		var list PriceList
+		if list, err = fetchPriceList(tx, tenantID, id); err == nil {
+			writePreconditionFailed(w, version, list)
+			return false
+		}
+	}
+	if err != nil {
+		h.logger.Error("Failed to fetch price list version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, failure)
+		return false
+	}
+	return true
+}
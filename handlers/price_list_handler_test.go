@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// priceListColumnNames names the columns of priceListColumns
+var priceListColumnNames = strings.Fields("id name code description currency valid_from valid_to is_active " +
+	"created_at updated_at version")
+
+// priceListRow is a row of priceListColumns for an active price list
+func priceListRow(id, version int64) []driver.Value {
+	created := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	return []driver.Value{id, "Wholesale", "WHOLESALE", nil, "USD", nil, nil, true, created, created, version}
+}
+
+func TestCreatePriceListRejectsRepeatedTiers(t *testing.T) {
+	db := &scriptDB{}
+	db.returns("SELECT EXISTS", "exists", []driver.Value{false})
+	db.returns("INSERT INTO price_lists", "id", []driver.Value{int64(10)})
+
+	rec := serve(t, db.plugin(), callerRequest("POST", "/price-lists", `{"name":"Wholesale","code":"WHOLESALE",
+		"items":[{"product_id":5,"price":9},{"product_id":5,"price":8,"min_quantity":1}]}`))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"field":"items[1].min_quantity"`) {
+		t.Fatalf("status = %d, want %d naming items[1].min_quantity: %s", rec.Code,
+			http.StatusUnprocessableEntity, rec.Body.String())
+	}
+	if len(db.ran("INSERT INTO price_list_items")) != 0 || db.commits != 0 {
+		t.Errorf("stored the items of a refused price list")
+	}
+}
+
+func TestCreatePriceListRefusesTakenCode(t *testing.T) {
+	db := &scriptDB{}
+	db.returns("SELECT EXISTS", "exists", []driver.Value{true})
+
+	rec := serve(t, db.plugin(), callerRequest("POST", "/price-lists", `{"name":"Wholesale","code":"WHOLESALE"}`))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body.String())
+	}
+	if len(db.ran("INSERT INTO price_lists")) != 0 {
+		t.Errorf("created a second price list with a taken code")
+	}
+}
+
+func TestDeletePriceListDeletesItsItemsFirst(t *testing.T) {
+	db := &scriptDB{}
+	scriptVersionedDocuments(db, 2)
+
+	req := callerRequest("DELETE", "/price-lists/10", "")
+	req.Header.Set("If-Match", `"2"`)
+	if rec := serve(t, db.plugin(), req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var deletes []string
+	for _, st := range db.ran("DELETE FROM") {
+		deletes = append(deletes, strings.Fields(st.query)[2])
+	}
+	if strings.Join(deletes, ",") != "price_list_items,price_lists" {
+		t.Errorf("deleted from %v, want price_list_items then price_lists", deletes)
+	}
+}
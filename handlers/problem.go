@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// problemContentType is the media type of error responses (RFC 7807)
+const problemContentType = "application/problem+json"
+
+// Problem is the body of every error response, an RFC 7807 problem details document.
+// Code is a stable identifier clients can branch on; Errors lists the request fields
+// that failed validation, so forms can show each message next to its field.
+type Problem struct {
+	Type   string       `json:"type"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Code   string       `json:"code"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a problem with one field of the request body. Field is its JSON path,
+// such as "items[2].quantity".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problem codes
+const (
+	codeBadRequest       = "bad_request"
+	codeMalformedBody    = "malformed_body"
+	codeValidationFailed = "validation_failed"
+	codeUnauthenticated  = "unauthenticated"
+	codeForbidden        = "forbidden"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeConflict         = "conflict"
+	codeInternal         = "internal_error"
+)
+
+// Field error codes
+const (
+	fieldRequired    = "required"
+	fieldInvalidType = "invalid_type"
+	fieldInvalid     = "invalid"
+	fieldTooSmall    = "too_small"
+	fieldTooLarge    = "too_large"
+	fieldNotFound    = "not_found"
+)
+
+// statusCodes is the problem code reported for each status when no more specific code applies
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          codeBadRequest,
+	http.StatusUnauthorized:        codeUnauthenticated,
+	http.StatusForbidden:           codeForbidden,
+	http.StatusNotFound:            codeNotFound,
+	http.StatusMethodNotAllowed:    codeMethodNotAllowed,
+	http.StatusConflict:            codeConflict,
+	http.StatusUnprocessableEntity: codeValidationFailed,
+}
+
+// writeProblem writes p as the response, filling in the defaults RFC 7807 allows to be implied
+func writeProblem(w http.ResponseWriter, p Problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Code == "" {
+		p.Code = statusCodes[p.Status]
+		if p.Code == "" {
+			p.Code = codeInternal
+		}
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError writes a problem with status and a human-readable detail
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeProblem(w, Problem{Status: status, Detail: detail})
+}
+
+// validationError is a request rejected for the fields it lists
+type validationError struct {
+	fields []FieldError
+}
+
+func (e *validationError) Error() string {
+	messages := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		messages[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// writeValidationError reports fields as a 422 validation problem
+func writeValidationError(w http.ResponseWriter, fields []FieldError) {
+	writeProblem(w, Problem{
+		Status: http.StatusUnprocessableEntity,
+		Detail: "The request has invalid fields",
+		Code:   codeValidationFailed,
+		Errors: fields,
+	})
+}
+
+// decodeRequest decodes the JSON body of r into v and validates it. When either fails it
+// writes the problem and returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeDecodeError(w, err)
+		return false
+	}
+	return checkRequest(w, v)
+}
+
+// checkRequest validates a decoded request body, writing the problem and returning false
+// when it is invalid
+func checkRequest(w http.ResponseWriter, v interface{}) bool {
+	if fields := validateRequest(v); len(fields) > 0 {
+		writeValidationError(w, fields)
+		return false
+	}
+	return true
+}
+
+// writeDecodeError reports a body that could not be decoded. A value of the wrong type is
+// reported against its field; anything else means the body is not JSON.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		writeValidationError(w, []FieldError{{
+			Field:   typeErr.Field,
+			Code:    fieldInvalidType,
+			Message: fmt.Sprintf("must be %s", jsonTypeName(typeErr.Type.Kind().String())),
+		}})
+		return
+	}
+
+	detail := "The request body is not valid JSON"
+	if err == io.EOF {
+		detail = "The request body is empty"
+	}
+	writeProblem(w, Problem{Status: http.StatusBadRequest, Detail: detail, Code: codeMalformedBody})
+}
+
+// jsonTypeName names a Go kind as the JSON type a client should send
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"):
+		return "an integer"
+	case strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "bool":
+		return "true or false"
+	case kind == "string":
+		return "a string"
+	case kind == "slice", kind == "array":
+		return "an array"
+	}
+	return "an object"
+}
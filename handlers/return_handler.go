@@ -0,0 +1,866 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+type SalesReturn struct {
+	ID              int                  `json:"id"`
+	ReturnNumber    string               `json:"return_number"`
+	OrderID         *int                 `json:"order_id"`
+	InvoiceID       *int                 `json:"invoice_id"`
+	CustomerID      int                  `json:"customer_id"`
+	ReturnDate      time.Time            `json:"return_date"`
+	Status          string               `json:"status"`
+	Reason          *string              `json:"reason"`
+	Notes           *string              `json:"notes"`
+	TotalAmount     Decimal              `json:"total_amount"`
+	RestockingFee   Decimal              `json:"restocking_fee"`
+	RefundAmount    Decimal              `json:"refund_amount"`
+	CreditNoteID    *int                 `json:"credit_note_id"`
+	ApprovedAt      *time.Time           `json:"approved_at"`
+	RejectedAt      *time.Time           `json:"rejected_at"`
+	RejectionReason *string              `json:"rejection_reason"`
+	ReceivedAt      *time.Time           `json:"received_at"`
+	ProcessedAt     *time.Time           `json:"processed_at"`
+	CreatedBy       int                  `json:"created_by"`
+	CreatedAt       time.Time            `json:"created_at"`
+	UpdatedAt       time.Time            `json:"updated_at"`
+	UpdatedBy       *int                 `json:"updated_by"`
+	Customer        *Customer            `json:"customer,omitempty"`
+	Items           []SalesReturnItem    `json:"items,omitempty"`
+	Restock         []RestockInstruction `json:"restock_instructions,omitempty"`
+}
+
+type SalesReturnItem struct {
+	ID            int       `json:"id"`
+	ReturnID      int       `json:"return_id"`
+	OrderItemID   *int      `json:"order_item_id"`
+	ProductID     int       `json:"product_id"`
+	Quantity      int       `json:"quantity"`
+	UnitPrice     Decimal   `json:"unit_price"`
+	LineTotal     Decimal   `json:"line_total"`
+	Reason        *string   `json:"reason"`
+	Condition     *string   `json:"condition"`
+	RestockingFee Decimal   `json:"restocking_fee"`
+	CreatedAt     time.Time `json:"created_at"`
+	Product       *Product  `json:"product,omitempty"`
+}
+
+type RestockInstruction struct {
+	ID           int       `json:"id"`
+	ReturnID     int       `json:"return_id"`
+	ReturnItemID int       `json:"return_item_id"`
+	ProductID    int       `json:"product_id"`
+	Quantity     int       `json:"quantity"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// returnConditions are the conditions a returned item may be inspected as
+var returnConditions = map[string]bool{
+	"good":      true,
+	"damaged":   true,
+	"defective": true,
+}
+
+const returnColumns = `
+	sr.id, sr.return_number, sr.order_id, sr.invoice_id, sr.customer_id, sr.return_date,
+	sr.status, sr.reason, sr.notes, sr.total_amount, sr.restocking_fee, sr.refund_amount,
+	sr.credit_note_id, sr.approved_at, sr.rejected_at, sr.rejection_reason, sr.received_at,
+	sr.processed_at, sr.created_by, sr.created_at, sr.updated_at, sr.updated_by
+`
+
+func scanReturn(row rowScanner, ret *SalesReturn, extra ...interface{}) error {
+	dest := []interface{}{
+		&ret.ID, &ret.ReturnNumber, &ret.OrderID, &ret.InvoiceID, &ret.CustomerID, &ret.ReturnDate,
+		&ret.Status, &ret.Reason, &ret.Notes, &ret.TotalAmount, &ret.RestockingFee, &ret.RefundAmount,
+		&ret.CreditNoteID, &ret.ApprovedAt, &ret.RejectedAt, &ret.RejectionReason, &ret.ReceivedAt,
+		&ret.ProcessedAt, &ret.CreatedBy, &ret.CreatedAt, &ret.UpdatedAt, &ret.UpdatedBy,
+	}
+	return row.Scan(append(dest, extra...)...)
+}
+
+// returnableOrderItem is an order line with the quantity still open for return
+type returnableOrderItem struct {
+	productID        int
+	quantity         int
+	lineTotal        Decimal
+	shippedQuantity  int
+	returnedQuantity int
+}
+
+// markOrderShipped records every line of an order as shipped in full. Shipped quantities
+// bound what a customer may later return.
+func markOrderShipped(tx *sqlx.Tx, tenantID string, orderID int) error {
+	_, err := tx.Exec(`
+		UPDATE sales_order_items
+		SET shipped_quantity = quantity
+		WHERE order_id = $1 AND tenant_id = $2 AND shipped_quantity < quantity
+	`, orderID, tenantID)
+	return err
+}
+
+// changeReturnStatus locks a return and moves it to status, rejecting illegal transitions
+func changeReturnStatus(tx *sqlx.Tx, tenantID string, returnID int, status string) error {
+	var current string
+	err := tx.QueryRow("SELECT status FROM sales_returns WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+		returnID, tenantID).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &requestError{http.StatusNotFound, "Sales return not found"}
+		}
+		return err
+	}
+
+	if err := returnLifecycle.check(current, status); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE sales_returns SET status = $1 WHERE id = $2 AND tenant_id = $3", status, returnID, tenantID)
+	return err
+}
+
+// Sales Return Handlers
+
+// GetSalesReturns retrieves sales returns with optional filtering
+func (h *SalesHandler) GetSalesReturns(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	customerID := r.URL.Query().Get("customer_id")
+	orderID := r.URL.Query().Get("order_id")
+	limit := r.URL.Query().Get("limit")
+
+	if limit == "" {
+		limit = "50"
+	}
+
+	query := `
+		SELECT ` + returnColumns + `, c.first_name, c.last_name, c.company_name, c.email
+		FROM sales_returns sr
+		LEFT JOIN customers c ON sr.customer_id = c.id
+		WHERE sr.tenant_id = $1
+	`
+
+	args := []interface{}{requestTenant(r)}
+	argIndex := 2
+
+	if status != "" {
+		query += fmt.Sprintf(" AND sr.status = $%d", argIndex)
+		args = append(args, status)
+		argIndex++
+	}
+
+	if customerID != "" {
+		query += fmt.Sprintf(" AND sr.customer_id = $%d", argIndex)
+		args = append(args, customerID)
+		argIndex++
+	}
+
+	if orderID != "" {
+		query += fmt.Sprintf(" AND sr.order_id = $%d", argIndex)
+		args = append(args, orderID)
+		argIndex++
+	}
+
+	query += fmt.Sprintf(" ORDER BY sr.return_date DESC, sr.id DESC LIMIT $%d", argIndex)
+	args = append(args, limit)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales returns")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales returns", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales returns")
+		return
+	}
+	defer rows.Close()
+
+	var returns []SalesReturn
+	for rows.Next() {
+		var ret SalesReturn
+		var firstName, lastName, companyName, email sql.NullString
+
+		err := scanReturn(rows, &ret, &firstName, &lastName, &companyName, &email)
+		if err != nil {
+			h.logger.Error("Failed to scan sales return", zap.Error(err))
+			continue
+		}
+
+		ret.Customer = &Customer{
+			ID:          ret.CustomerID,
+			CompanyName: &companyName.String,
+			FirstName:   &firstName.String,
+			LastName:    &lastName.String,
+			Email:       &email.String,
+		}
+
+		returns = append(returns, ret)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"returns": returns,
+		"count":   len(returns),
+	})
+}
+
+// GetSalesReturn retrieves a single sales return with its items and restock instructions
+func (h *SalesHandler) GetSalesReturn(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid return ID")
+		return
+	}
+
+	query := `
+		SELECT ` + returnColumns + `, c.first_name, c.last_name, c.company_name, c.email
+		FROM sales_returns sr
+		LEFT JOIN customers c ON sr.customer_id = c.id
+		WHERE sr.id = $1 AND sr.tenant_id = $2
+	`
+
+	tenantID := requestTenant(r)
+
+	var ret SalesReturn
+	var firstName, lastName, companyName, email sql.NullString
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales return")
+		return
+	}
+	defer tx.Rollback()
+
+	err = scanReturn(tx.QueryRow(query, id, tenantID), &ret, &firstName, &lastName, &companyName, &email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales return not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales return", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales return")
+		return
+	}
+
+	ret.Customer = &Customer{
+		ID:          ret.CustomerID,
+		CompanyName: &companyName.String,
+		FirstName:   &firstName.String,
+		LastName:    &lastName.String,
+		Email:       &email.String,
+	}
+
+	itemRows, err := tx.Query(`
+		SELECT sri.id, sri.return_id, sri.order_item_id, sri.product_id, sri.quantity, sri.unit_price,
+		       sri.line_total, sri.reason, sri.condition, sri.restocking_fee, sri.created_at,
+		       p.name as product_name, p.sku
+		FROM sales_return_items sri
+		LEFT JOIN products p ON sri.product_id = p.id
+		WHERE sri.return_id = $1 AND sri.tenant_id = $2
+		ORDER BY sri.id
+	`, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales return items", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales return")
+		return
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var item SalesReturnItem
+		var productName, sku sql.NullString
+
+		err := itemRows.Scan(&item.ID, &item.ReturnID, &item.OrderItemID, &item.ProductID, &item.Quantity,
+			&item.UnitPrice, &item.LineTotal, &item.Reason, &item.Condition, &item.RestockingFee,
+			&item.CreatedAt, &productName, &sku)
+		if err != nil {
+			h.logger.Error("Failed to scan sales return item", zap.Error(err))
+			continue
+		}
+
+		item.Product = &Product{
+			ID:   item.ProductID,
+			Name: productName.String,
+			SKU:  sku.String,
+		}
+
+		ret.Items = append(ret.Items, item)
+	}
+
+	restock, err := fetchRestockInstructions(h.db, tenantID, id)
+	if err != nil {
+		h.logger.Error("Failed to fetch restock instructions", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales return")
+		return
+	}
+	ret.Restock = restock
+
+	sdk.WriteJSON(w, http.StatusOK, ret)
+}
+
+func fetchRestockInstructions(q sqlx.Queryer, tenantID string, returnID int) ([]RestockInstruction, error) {
+	rows, err := q.Query(`
+		SELECT id, return_id, return_item_id, product_id, quantity, status, created_at
+		FROM sales_restock_instructions
+		WHERE return_id = $1 AND tenant_id = $2
+		ORDER BY id
+	`, returnID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instructions []RestockInstruction
+	for rows.Next() {
+		var instruction RestockInstruction
+		err := rows.Scan(&instruction.ID, &instruction.ReturnID, &instruction.ReturnItemID,
+			&instruction.ProductID, &instruction.Quantity, &instruction.Status, &instruction.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, rows.Err()
+}
+
+// createSalesReturnRequest is the body of CreateSalesReturn
+type createSalesReturnRequest struct {
+	OrderID    int     `json:"order_id" validate:"required"`
+	InvoiceID  *int    `json:"invoice_id"`
+	ReturnDate *string `json:"return_date" validate:"date"`
+	Reason     *string `json:"reason"`
+	Notes      *string `json:"notes"`
+	Items      []struct {
+		OrderItemID int     `json:"order_item_id" validate:"required"`
+		Quantity    int     `json:"quantity" validate:"gt=0"`
+		Reason      *string `json:"reason"`
+		Condition   *string `json:"condition" validate:"oneof=good damaged defective"`
+	} `json:"items" validate:"required"`
+}
+
+// CreateSalesReturn opens a return authorisation for shipped order lines
+func (h *SalesHandler) CreateSalesReturn(w http.ResponseWriter, r *http.Request) {
+	var req createSalesReturnRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	returnDate := time.Now()
+	if req.ReturnDate != nil {
+		rd, err := time.Parse("2006-01-02", *req.ReturnDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid return date format")
+			return
+		}
+		returnDate = rd
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the order serialises returns against it while open quantities are checked
+	var customerID int
+	var currency string
+	err = tx.QueryRow("SELECT customer_id, currency FROM sales_orders WHERE id = $1 AND tenant_id = $2 FOR UPDATE",
+		req.OrderID, tenantID).Scan(&customerID, &currency)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusBadRequest, "Sales order not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales order", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+		return
+	}
+
+	if req.InvoiceID != nil {
+		var invoiceOrderID *int
+		err = tx.QueryRow("SELECT order_id FROM sales_invoices WHERE id = $1 AND tenant_id = $2",
+			*req.InvoiceID, tenantID).Scan(&invoiceOrderID)
+		if err == sql.ErrNoRows || (err == nil && (invoiceOrderID == nil || *invoiceOrderID != req.OrderID)) {
+			writeError(w, http.StatusBadRequest, "Invoice does not belong to this order")
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to fetch sales invoice", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+			return
+		}
+	}
+
+	rows, err := tx.Query(`
+		SELECT soi.id, soi.product_id, soi.quantity, soi.line_total, soi.shipped_quantity,
+		       COALESCE(SUM(sri.quantity) FILTER (WHERE sr.status != 'rejected'), 0)
+		FROM sales_order_items soi
+		LEFT JOIN sales_return_items sri ON sri.order_item_id = soi.id AND sri.tenant_id = soi.tenant_id
+		LEFT JOIN sales_returns sr ON sri.return_id = sr.id
+		WHERE soi.order_id = $1 AND soi.tenant_id = $2
+		GROUP BY soi.id
+	`, req.OrderID, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales order items", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+		return
+	}
+
+	orderItems := map[int]*returnableOrderItem{}
+	for rows.Next() {
+		var id int
+		item := &returnableOrderItem{}
+		err := rows.Scan(&id, &item.productID, &item.quantity, &item.lineTotal,
+			&item.shippedQuantity, &item.returnedQuantity)
+		if err != nil {
+			rows.Close()
+			h.logger.Error("Failed to scan sales order item", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+			return
+		}
+		orderItems[id] = item
+	}
+	rows.Close()
+
+	for _, line := range req.Items {
+		item, ok := orderItems[line.OrderItemID]
+		if !ok {
+			writeError(w, http.StatusBadRequest,
+				fmt.Sprintf("Order line %d does not belong to this order", line.OrderItemID))
+			return
+		}
+
+		returnable := item.shippedQuantity - item.returnedQuantity
+		if line.Quantity > returnable {
+			writeError(w, http.StatusConflict,
+				fmt.Sprintf("Order line %d has only %d shipped units open for return, cannot return %d",
+					line.OrderItemID, returnable, line.Quantity))
+			return
+		}
+		item.returnedQuantity += line.Quantity
+	}
+
+	returnNumber, err := h.loadSettings(tx, tenantID).nextDocumentNumber(tx, tenantID, numberReturn, returnDate)
+	if err != nil {
+		h.logger.Error("Failed to allocate return number", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+		return
+	}
+
+	var returnID int
+	err = tx.QueryRow(`
+		INSERT INTO sales_returns (tenant_id, return_number, order_id, invoice_id, customer_id, return_date,
+		                           status, reason, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, 'pending', $7, $8, $9)
+		RETURNING id
+	`, tenantID, returnNumber, req.OrderID, req.InvoiceID, customerID, returnDate, req.Reason, req.Notes, requestUser(r)).Scan(&returnID)
+	if err != nil {
+		h.logger.Error("Failed to create sales return", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+		return
+	}
+
+	var totalAmount Decimal
+	for _, line := range req.Items {
+		item := orderItems[line.OrderItemID]
+		unitPrice := item.lineTotal.MulDiv(decimalFromInt(1), decimalFromInt(item.quantity), moneyPlaces(currency))
+
+		_, err = tx.Exec(`
+			INSERT INTO sales_return_items (tenant_id, return_id, order_item_id, product_id, quantity, unit_price,
+			                                reason, condition)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, tenantID, returnID, line.OrderItemID, item.productID, line.Quantity, unitPrice, line.Reason, line.Condition)
+		if err != nil {
+			h.logger.Error("Failed to create sales return item", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+			return
+		}
+		totalAmount = totalAmount.Add(unitPrice.MulInt(line.Quantity))
+	}
+
+	_, err = tx.Exec("UPDATE sales_returns SET total_amount = $1 WHERE id = $2 AND tenant_id = $3",
+		totalAmount, returnID, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to update sales return total", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create sales return")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
+		"return_id":     returnID,
+		"return_number": returnNumber,
+		"message":       "Sales return created successfully",
+	})
+}
+
+// ApproveSalesReturn authorises a pending return
+func (h *SalesHandler) ApproveSalesReturn(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid return ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to approve sales return")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := changeReturnStatus(tx, tenantID, id, "approved"); err != nil {
+		h.writeRequestError(w, err, "Failed to approve sales return")
+		return
+	}
+
+	_, err = tx.Exec("UPDATE sales_returns SET approved_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2",
+		id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to approve sales return", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to approve sales return")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to approve sales return")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Sales return approved successfully",
+	})
+}
+
+// rejectSalesReturnRequest is the body of RejectSalesReturn
+type rejectSalesReturnRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// RejectSalesReturn declines a pending return, releasing its quantities for other returns
+func (h *SalesHandler) RejectSalesReturn(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid return ID")
+		return
+	}
+
+	var req rejectSalesReturnRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to reject sales return")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := changeReturnStatus(tx, tenantID, id, "rejected"); err != nil {
+		h.writeRequestError(w, err, "Failed to reject sales return")
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sales_returns
+		SET rejected_at = CURRENT_TIMESTAMP, rejection_reason = $1
+		WHERE id = $2 AND tenant_id = $3
+	`, req.Reason, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to reject sales return", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to reject sales return")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to reject sales return")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Sales return rejected successfully",
+	})
+}
+
+// receiveSalesReturnRequest is the body of ReceiveSalesReturn
+type receiveSalesReturnRequest struct {
+	Items []struct {
+		ReturnItemID int    `json:"return_item_id" validate:"required"`
+		Condition    string `json:"condition" validate:"required,oneof=good damaged defective"`
+	} `json:"items"`
+}
+
+// ReceiveSalesReturn records the arrival of returned goods and their inspected condition,
+// and works out the restocking fee charged for each item
+func (h *SalesHandler) ReceiveSalesReturn(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid return ID")
+		return
+	}
+
+	var req receiveSalesReturnRequest
+
+	// An empty body keeps the conditions recorded when the return was opened
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeDecodeError(w, err)
+		return
+	}
+	if !checkRequest(w, &req) {
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+		return
+	}
+	defer tx.Rollback()
+
+	settings := h.loadSettings(tx, tenantID)
+
+	if err := changeReturnStatus(tx, tenantID, id, "received"); err != nil {
+		h.writeRequestError(w, err, "Failed to receive sales return")
+		return
+	}
+
+	for _, item := range req.Items {
+		result, err := tx.Exec(`
+			UPDATE sales_return_items SET condition = $1
+			WHERE id = $2 AND return_id = $3 AND tenant_id = $4
+		`, item.Condition, item.ReturnItemID, id, tenantID)
+		if err != nil {
+			h.logger.Error("Failed to record return item condition", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			writeError(w, http.StatusBadRequest,
+				fmt.Sprintf("Return item %d does not belong to this return", item.ReturnItemID))
+			return
+		}
+	}
+
+	var currency string
+	err = tx.QueryRow(`
+		SELECT so.currency
+		FROM sales_returns sr
+		JOIN sales_orders so ON so.id = sr.order_id AND so.tenant_id = sr.tenant_id
+		WHERE sr.id = $1 AND sr.tenant_id = $2
+	`, id, tenantID).Scan(&currency)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales return currency", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+		return
+	}
+
+	rows, err := tx.Query("SELECT id, line_total, condition FROM sales_return_items WHERE return_id = $1 AND tenant_id = $2 ORDER BY id",
+		id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales return items", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+		return
+	}
+
+	var itemIDs []int
+	var fees []Decimal
+	for rows.Next() {
+		var itemID int
+		var lineTotal Decimal
+		var condition *string
+		if err := rows.Scan(&itemID, &lineTotal, &condition); err != nil {
+			rows.Close()
+			h.logger.Error("Failed to scan sales return item", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+			return
+		}
+		if condition == nil || !returnConditions[*condition] {
+			rows.Close()
+			writeError(w, http.StatusBadRequest,
+				fmt.Sprintf("Return item %d needs an inspected condition", itemID))
+			return
+		}
+
+		itemIDs = append(itemIDs, itemID)
+		fees = append(fees, lineTotal.Percent(settings.restockingFeePercent(*condition), decimalPlaces))
+	}
+	rows.Close()
+
+	fees, totalFee := newMoneyRounding(currency, settings.Rounding).lines(fees)
+	for i, fee := range fees {
+		_, err = tx.Exec("UPDATE sales_return_items SET restocking_fee = $1 WHERE id = $2 AND tenant_id = $3",
+			fee, itemIDs[i], tenantID)
+		if err != nil {
+			h.logger.Error("Failed to record restocking fee", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE sales_returns
+		SET received_at = CURRENT_TIMESTAMP, restocking_fee = $1
+		WHERE id = $2 AND tenant_id = $3
+	`, totalFee, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to receive sales return", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to receive sales return")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"restocking_fee": totalFee,
+		"message":        "Sales return received successfully",
+	})
+}
+
+// ProcessSalesReturn completes a received return: it credits the invoiced value less
+// restocking fees and instructs inventory to restock items that arrived in good condition
+func (h *SalesHandler) ProcessSalesReturn(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid return ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process sales return")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := changeReturnStatus(tx, tenantID, id, "processed"); err != nil {
+		h.writeRequestError(w, err, "Failed to process sales return")
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Sales return processed successfully",
+	}
+
+	// Returns of goods that were never invoiced have nothing to credit
+	var invoiceID, orderID, creditNoteID *int
+	var customerID int
+	err = tx.QueryRow("SELECT invoice_id, order_id, customer_id FROM sales_returns WHERE id = $1 AND tenant_id = $2",
+		id, tenantID).Scan(&invoiceID, &orderID, &customerID)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales return", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process sales return")
+		return
+	}
+	if invoiceID != nil {
+		noteID, creditNoteNumber, err := creditReturn(tx, tenantID, id, h.loadSettings(tx, tenantID), requestUser(r))
+		if err != nil {
+			h.writeRequestError(w, err, "Failed to process sales return")
+			return
+		}
+		response["credit_note_id"] = noteID
+		response["credit_note_number"] = creditNoteNumber
+		creditNoteID = &noteID
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO sales_restock_instructions (tenant_id, return_id, return_item_id, product_id, quantity)
+		SELECT tenant_id, return_id, id, product_id, quantity
+		FROM sales_return_items
+		WHERE return_id = $1 AND tenant_id = $2 AND condition = 'good'
+	`, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to create restock instructions", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process sales return")
+		return
+	}
+
+	_, err = tx.Exec("UPDATE sales_returns SET processed_at = CURRENT_TIMESTAMP WHERE id = $1 AND tenant_id = $2",
+		id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to process sales return", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process sales return")
+		return
+	}
+
+	restock, err := fetchRestockInstructions(tx, tenantID, id)
+	if err != nil {
+		h.logger.Error("Failed to fetch restock instructions", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process sales return")
+		return
+	}
+	response["restock_instructions"] = restock
+
+	err = recordEvent(tx, tenantID, ReturnProcessed{
+		ReturnID:     id,
+		OrderID:      orderID,
+		CustomerID:   customerID,
+		CreditNoteID: creditNoteID,
+		Restock:      restock,
+	})
+	if err != nil {
+		h.logger.Error("Failed to record return event", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process sales return")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to process sales return")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReturnLimitedToShippedQuantity(t *testing.T) {
+	tests := []struct {
+		name              string
+		shipped, returned int64
+		quantities        []int
+		want              int
+		total             string
+	}{
+		{"everything shipped", 3, 0, []int{3}, http.StatusCreated, "30"},
+		{"one more than shipped", 3, 0, []int{4}, http.StatusConflict, ""},
+		{"part of a shipment", 2, 0, []int{1}, http.StatusCreated, "10"},
+		{"all of a part shipment", 2, 0, []int{2}, http.StatusCreated, "20"},
+		{"more than a part shipment", 2, 0, []int{3}, http.StatusConflict, ""},
+		{"what is left after a return", 3, 1, []int{2}, http.StatusCreated, "20"},
+		{"one more than is left", 3, 1, []int{3}, http.StatusConflict, ""},
+		{"split over two lines", 3, 1, []int{1, 1}, http.StatusCreated, "20"},
+		{"split over two lines and over", 3, 1, []int{1, 2}, http.StatusConflict, ""},
+		{"nothing shipped", 0, 0, []int{1}, http.StatusConflict, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &scriptDB{}
+			scriptSales(db, salesFixture{shipped: tt.shipped, returned: tt.returned})
+
+			var items []string
+			for _, qty := range tt.quantities {
+				items = append(items, fmt.Sprintf(`{"order_item_id":11,"quantity":%d,"condition":"good"}`, qty))
+			}
+			rec := serve(t, db.plugin(), callerRequest("POST", "/returns",
+				`{"order_id":7,"items":[`+strings.Join(items, ",")+`]}`))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+
+			if tt.want != http.StatusCreated {
+				if len(db.ran("INSERT INTO sales_returns")) != 0 || db.commits != 0 {
+					t.Error("refused return was still created")
+				}
+				return
+			}
+			lines := db.ran("INSERT INTO sales_return_items")
+			if len(lines) != len(tt.quantities) || lines[0].args[5] != "10" {
+				t.Errorf("return lines %v, want %d priced at 10", lines, len(tt.quantities))
+			}
+			total := db.ran("UPDATE sales_returns SET total_amount")
+			if len(total) != 1 || total[0].args[0] != tt.total {
+				t.Errorf("return total stored as %v, want %s", total, tt.total)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// route is an endpoint the module serves: its handler, the module.yml permission a caller
+// needs to reach it and a one-line description for the API documentation. Routes without
+// a permission are open to every authenticated user of the tenant.
+type route struct {
+	method      string
+	pattern     string
+	handler     http.HandlerFunc
+	permission  string
+	description string
+}
+
+// key identifies the route as "METHOD /pattern"
+func (rt route) key() string {
+	return rt.method + " " + rt.pattern
+}
+
+// wrap authenticates the caller, resolves their tenant and checks the route's permission
+// before handler, the route's handler with any middleware of its own, runs
+func (rt route) wrap(handler http.HandlerFunc) http.HandlerFunc {
+	if rt.permission == "" {
+		return requireUser(requireTenant(handler))
+	}
+	return requireUser(requireTenant(requirePermission(rt.permission, handler)))
+}
+
+// routeTable is the module's routing table, compiled once into a chi router. The router
+// extracts URL parameters, so handlers read them with chi.URLParam whatever router the
+// host uses, and answers 405 with the allowed methods for known paths.
+type routeTable struct {
+	routes []route
+	byKey  map[string]route
+	mux    *chi.Mux
+}
+
+// newRouteTable compiles routes, passing the handlers of idempotentRoutes through
+// idempotent when it is set, and those of versionedRoutes through requireIfMatch.
+// Registering the same method and pattern twice panics.
+func newRouteTable(routes []route, idempotent func(http.HandlerFunc) http.HandlerFunc) *routeTable {
+	t := &routeTable{
+		routes: routes,
+		byKey:  make(map[string]route, len(routes)),
+		mux:    chi.NewRouter(),
+	}
+
+	for _, rt := range routes {
+		if _, ok := t.byKey[rt.key()]; ok {
+			panic(fmt.Sprintf("sales: route %s registered twice", rt.key()))
+		}
+		t.byKey[rt.key()] = rt
+		handler := rt.handler
+		if idempotent != nil && idempotentRoutes[rt.key()] {
+			handler = idempotent(handler)
+		}
+		if document, ok := versionedRoutes[rt.key()]; ok {
+			handler = requireIfMatch(document, handler)
+		}
+		t.mux.Method(rt.method, rt.pattern, rt.wrap(handler))
+	}
+
+	t.mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Route not found")
+	})
+	t.mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+			path = rctx.RoutePath
+		}
+		w.Header().Set("Allow", strings.Join(t.allowedMethods(path), ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s not allowed", r.Method))
+	})
+
+	return t
+}
+
+// allowedMethods lists the methods served for path, in registration order
+func (t *routeTable) allowedMethods(path string) []string {
+	var methods []string
+	seen := map[string]bool{}
+	for _, rt := range t.routes {
+		if !seen[rt.method] && t.mux.Match(chi.NewRouteContext(), rt.method, path) {
+			seen[rt.method] = true
+			methods = append(methods, rt.method)
+		}
+	}
+	return methods
+}
+
+// ServeHTTP routes a request whose path is relative to the module, as when the host
+// mounts the table under the module's prefix
+func (t *routeTable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mux.ServeHTTP(w, r)
+}
+
+// handler returns the handler the host registers for path, which is either a concrete
+// module path such as "/orders/7" or a pattern such as "/orders/{id}". Requests are routed
+// through the table, so the parameters and 405 responses come from it.
+func (t *routeTable) handler(path, method string) (http.HandlerFunc, error) {
+	path = "/" + strings.TrimPrefix(path, "/")
+	method = strings.ToUpper(method)
+
+	if len(t.allowedMethods(path)) == 0 {
+		return nil, fmt.Errorf("handler not found for route: %s %s", method, path)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A fresh route context keeps the host's own URL parameters from shadowing ours
+		rctx := chi.NewRouteContext()
+		rctx.RoutePath = modulePath(path, r)
+		t.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx)))
+	}, nil
+}
+
+// modulePath is the module-relative path of r. A pattern registered by the host stands
+// for the trailing segments of the request path, as many as the pattern has.
+func modulePath(path string, r *http.Request) string {
+	if !strings.Contains(path, "{") {
+		return path
+	}
+
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	n := strings.Count(path, "/")
+	if len(segments) < n {
+		return path
+	}
+	return "/" + strings.Join(segments[len(segments)-n:], "/")
+}
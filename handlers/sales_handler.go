@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -16,19 +17,33 @@ import (
 
 // Local domain types
 type SalesOrder struct {
-	ID              int                  `json:"id"`
-	OrderNumber     string               `json:"order_number"`
-	CustomerID      int                  `json:"customer_id"`
-	QuoteID         *int                 `json:"quote_id"`
-	OrderDate       time.Time            `json:"order_date"`
-	RequiredDate    *time.Time           `json:"required_date"`
-	ShippedDate     *time.Time           `json:"shipped_date"`
-	Status          string               `json:"status"`
-	Subtotal        float64              `json:"subtotal"`
-	TaxAmount       float64              `json:"tax_amount"`
-	DiscountAmount  float64              `json:"discount_amount"`
-	ShippingAmount  float64              `json:"shipping_amount"`
-	TotalAmount     float64              `json:"total_amount"`
+	ID             int        `json:"id"`
+	OrderNumber    string     `json:"order_number"`
+	CustomerID     int        `json:"customer_id"`
+	QuoteID        *int       `json:"quote_id"`
+	OrderDate      time.Time  `json:"order_date"`
+	RequiredDate   *time.Time `json:"required_date"`
+	ShippedDate    *time.Time `json:"shipped_date"`
+	Status         string     `json:"status"`
+	Subtotal       Decimal    `json:"subtotal"`
+	TaxRate        *Decimal   `json:"tax_rate"`
+	TaxAmount      Decimal    `json:"tax_amount"`
+	DiscountAmount Decimal    `json:"discount_amount"`
+	ShippingAmount Decimal    `json:"shipping_amount"`
+	TotalAmount    Decimal    `json:"total_amount"`
+
+	DocumentDiscountPercent Decimal `json:"document_discount_percent"`
+	DocumentDiscountAmount  Decimal `json:"document_discount_amount"`
+	PricesIncludeTax        bool    `json:"prices_include_tax"`
+	ShipToCountry           *string `json:"ship_to_country"`
+	ShipToRegion            *string `json:"ship_to_region"`
+	ShipToCity              *string `json:"ship_to_city"`
+	ShipToPostalCode        *string `json:"ship_to_postal_code"`
+	SellerVATID             *string `json:"seller_vat_id"`
+	BuyerVATID              *string `json:"buyer_vat_id"`
+	VATTreatment            *string `json:"vat_treatment"`
+	VATLegend               *string `json:"vat_legend"`
+
 	Currency        string               `json:"currency"`
 	PaymentTerms    *string              `json:"payment_terms"`
 	ShippingAddress interface{}          `json:"shipping_address"`
@@ -38,6 +53,8 @@ type SalesOrder struct {
 	CreatedBy       int                  `json:"created_by"`
 	CreatedAt       time.Time            `json:"created_at"`
 	UpdatedAt       time.Time            `json:"updated_at"`
+	UpdatedBy       *int                 `json:"updated_by"`
+	Version         int                  `json:"version"`
 	Customer        *Customer            `json:"customer,omitempty"`
 	SalesRep        *SalesRepresentative `json:"sales_rep,omitempty"`
 	Items           []SalesOrderItem     `json:"items,omitempty"`
@@ -59,18 +76,23 @@ type SalesRepresentative struct {
 }
 
 type SalesOrderItem struct {
-	ID              int       `json:"id"`
-	OrderID         int       `json:"order_id"`
-	ProductID       int       `json:"product_id"`
-	Quantity        int       `json:"quantity"`
-	UnitPrice       float64   `json:"unit_price"`
-	DiscountPercent float64   `json:"discount_percent"`
-	DiscountAmount  float64   `json:"discount_amount"`
-	LineTotal       float64   `json:"line_total"`
-	ShippedQuantity int       `json:"shipped_quantity"`
-	Notes           *string   `json:"notes"`
-	CreatedAt       time.Time `json:"created_at"`
-	Product         *Product  `json:"product,omitempty"`
+	ID               int       `json:"id"`
+	OrderID          int       `json:"order_id"`
+	ProductID        int       `json:"product_id" validate:"required"`
+	Quantity         int       `json:"quantity" validate:"gt=0"`
+	UnitPrice        Decimal   `json:"unit_price" validate:"gte=0"`
+	DiscountPercent  Decimal   `json:"discount_percent" validate:"gte=0,lte=100"`
+	DiscountAmount   Decimal   `json:"discount_amount" validate:"gte=0"`
+	LineTotal        Decimal   `json:"line_total"`
+	TaxCategory      *string   `json:"tax_category"`
+	TaxAmount        Decimal   `json:"tax_amount"`
+	Taxes            []lineTax `json:"taxes"`
+	ShippedQuantity  int       `json:"shipped_quantity"`
+	InvoicedQuantity int       `json:"invoiced_quantity"`
+	InvoicedAmount   Decimal   `json:"invoiced_amount"`
+	Notes            *string   `json:"notes"`
+	CreatedAt        time.Time `json:"created_at"`
+	Product          *Product  `json:"product,omitempty"`
 }
 
 type Product struct {
@@ -81,41 +103,125 @@ type Product struct {
 }
 
 type SalesQuote struct {
-	ID             int                  `json:"id"`
-	QuoteNumber    string               `json:"quote_number"`
-	CustomerID     int                  `json:"customer_id"`
-	QuoteDate      time.Time            `json:"quote_date"`
-	ValidUntil     *time.Time           `json:"valid_until"`
-	Status         string               `json:"status"`
-	Subtotal       float64              `json:"subtotal"`
-	TaxAmount      float64              `json:"tax_amount"`
-	DiscountAmount float64              `json:"discount_amount"`
-	TotalAmount    float64              `json:"total_amount"`
-	Currency       string               `json:"currency"`
-	Notes          *string              `json:"notes"`
-	Terms          *string              `json:"terms"`
-	SalesRepID     *int                 `json:"sales_rep_id"`
-	CreatedBy      int                  `json:"created_by"`
-	CreatedAt      time.Time            `json:"created_at"`
-	UpdatedAt      time.Time            `json:"updated_at"`
-	Customer       *Customer            `json:"customer,omitempty"`
-	SalesRep       *SalesRepresentative `json:"sales_rep,omitempty"`
-	Items          []SalesQuoteItem     `json:"items,omitempty"`
+	ID             int        `json:"id"`
+	QuoteNumber    string     `json:"quote_number"`
+	CustomerID     int        `json:"customer_id"`
+	QuoteDate      time.Time  `json:"quote_date"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	Status         string     `json:"status"`
+	Subtotal       Decimal    `json:"subtotal"`
+	TaxRate        *Decimal   `json:"tax_rate"`
+	TaxAmount      Decimal    `json:"tax_amount"`
+	DiscountAmount Decimal    `json:"discount_amount"`
+	ShippingAmount Decimal    `json:"shipping_amount"`
+	TotalAmount    Decimal    `json:"total_amount"`
+
+	DocumentDiscountPercent Decimal `json:"document_discount_percent"`
+	DocumentDiscountAmount  Decimal `json:"document_discount_amount"`
+	PricesIncludeTax        bool    `json:"prices_include_tax"`
+	ShipToCountry           *string `json:"ship_to_country"`
+	ShipToRegion            *string `json:"ship_to_region"`
+	ShipToCity              *string `json:"ship_to_city"`
+	ShipToPostalCode        *string `json:"ship_to_postal_code"`
+	SellerVATID             *string `json:"seller_vat_id"`
+	BuyerVATID              *string `json:"buyer_vat_id"`
+	VATTreatment            *string `json:"vat_treatment"`
+	VATLegend               *string `json:"vat_legend"`
+
+	Currency   string               `json:"currency"`
+	Notes      *string              `json:"notes"`
+	Terms      *string              `json:"terms"`
+	SalesRepID *int                 `json:"sales_rep_id"`
+	CreatedBy  int                  `json:"created_by"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+	UpdatedBy  *int                 `json:"updated_by"`
+	Version    int                  `json:"version"`
+	Customer   *Customer            `json:"customer,omitempty"`
+	SalesRep   *SalesRepresentative `json:"sales_rep,omitempty"`
+	Items      []SalesQuoteItem     `json:"items,omitempty"`
 }
 
 type SalesQuoteItem struct {
 	ID              int       `json:"id"`
 	QuoteID         int       `json:"quote_id"`
-	ProductID       int       `json:"product_id"`
-	Quantity        int       `json:"quantity"`
-	UnitPrice       float64   `json:"unit_price"`
-	DiscountPercent float64   `json:"discount_percent"`
-	DiscountAmount  float64   `json:"discount_amount"`
-	LineTotal       float64   `json:"line_total"`
+	ProductID       int       `json:"product_id" validate:"required"`
+	Quantity        int       `json:"quantity" validate:"gt=0"`
+	UnitPrice       Decimal   `json:"unit_price" validate:"gte=0"`
+	DiscountPercent Decimal   `json:"discount_percent" validate:"gte=0,lte=100"`
+	DiscountAmount  Decimal   `json:"discount_amount" validate:"gte=0"`
+	LineTotal       Decimal   `json:"line_total"`
+	TaxCategory     *string   `json:"tax_category"`
+	TaxAmount       Decimal   `json:"tax_amount"`
+	Taxes           []lineTax `json:"taxes"`
 	Notes           *string   `json:"notes"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
+type SalesInvoice struct {
+	ID             int        `json:"id"`
+	InvoiceNumber  string     `json:"invoice_number"`
+	OrderID        *int       `json:"order_id"`
+	CustomerID     int        `json:"customer_id"`
+	InvoiceDate    time.Time  `json:"invoice_date"`
+	DueDate        *time.Time `json:"due_date"`
+	Status         string     `json:"status"`
+	Subtotal       Decimal    `json:"subtotal"`
+	TaxRate        *Decimal   `json:"tax_rate"`
+	TaxAmount      Decimal    `json:"tax_amount"`
+	DiscountAmount Decimal    `json:"discount_amount"`
+	ShippingAmount Decimal    `json:"shipping_amount"`
+	TotalAmount    Decimal    `json:"total_amount"`
+	PaidAmount     Decimal    `json:"paid_amount"`
+	CreditedAmount Decimal    `json:"credited_amount"`
+	BalanceDue     Decimal    `json:"balance_due"`
+	Currency       string     `json:"currency"`
+
+	DocumentDiscountPercent Decimal `json:"document_discount_percent"`
+	DocumentDiscountAmount  Decimal `json:"document_discount_amount"`
+	PricesIncludeTax        bool    `json:"prices_include_tax"`
+	ShipToCountry           *string `json:"ship_to_country"`
+	ShipToRegion            *string `json:"ship_to_region"`
+	ShipToCity              *string `json:"ship_to_city"`
+	ShipToPostalCode        *string `json:"ship_to_postal_code"`
+	SellerVATID             *string `json:"seller_vat_id"`
+	BuyerVATID              *string `json:"buyer_vat_id"`
+	VATTreatment            *string `json:"vat_treatment"`
+	VATLegend               *string `json:"vat_legend"`
+
+	PaymentTerms *string            `json:"payment_terms"`
+	Notes        *string            `json:"notes"`
+	SentAt       *time.Time         `json:"sent_at"`
+	VoidedAt     *time.Time         `json:"voided_at"`
+	VoidReason   *string            `json:"void_reason"`
+	CreatedBy    int                `json:"created_by"`
+	CreatedAt    time.Time          `json:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at"`
+	UpdatedBy    *int               `json:"updated_by"`
+	Version      int                `json:"version"`
+	Customer     *Customer          `json:"customer,omitempty"`
+	Items        []SalesInvoiceItem `json:"items,omitempty"`
+}
+
+type SalesInvoiceItem struct {
+	ID               int       `json:"id"`
+	InvoiceID        int       `json:"invoice_id"`
+	OrderItemID      *int      `json:"order_item_id"`
+	ProductID        int       `json:"product_id" validate:"required"`
+	Quantity         int       `json:"quantity" validate:"gt=0"`
+	UnitPrice        Decimal   `json:"unit_price" validate:"gte=0"`
+	DiscountPercent  Decimal   `json:"discount_percent" validate:"gte=0,lte=100"`
+	DiscountAmount   Decimal   `json:"discount_amount" validate:"gte=0"`
+	LineTotal        Decimal   `json:"line_total"`
+	TaxCategory      *string   `json:"tax_category"`
+	TaxAmount        Decimal   `json:"tax_amount"`
+	Taxes            []lineTax `json:"taxes"`
+	CreditedQuantity int       `json:"credited_quantity"`
+	Notes            *string   `json:"notes"`
+	CreatedAt        time.Time `json:"created_at"`
+	Product          *Product  `json:"product,omitempty"`
+}
+
 // SalesHandler handles all sales-related HTTP requests
 type SalesHandler struct {
 	db     *sqlx.DB
@@ -127,6 +233,62 @@ func NewSalesHandler(db *sqlx.DB, logger *zap.Logger) *SalesHandler {
 	return &SalesHandler{db: db, logger: logger}
 }
 
+const orderColumns = `
+	so.id, so.order_number, so.customer_id, so.quote_id, so.order_date, so.required_date,
+	so.shipped_date, so.status, so.subtotal, so.tax_rate, so.tax_amount, so.discount_amount,
+	so.shipping_amount, so.total_amount, so.document_discount_percent, so.document_discount_amount,
+	so.prices_include_tax, so.ship_to_country, so.ship_to_region, so.ship_to_city, so.ship_to_postal_code,
+	so.seller_vat_id, so.buyer_vat_id, so.vat_treatment,
+	so.currency, so.payment_terms, so.shipping_address, so.billing_address, so.notes, so.sales_rep_id,
+	so.created_by, so.created_at, so.updated_at, so.updated_by, so.version
+`
+
+func scanOrder(row rowScanner, order *SalesOrder, extra ...interface{}) error {
+	dest := []interface{}{
+		&order.ID, &order.OrderNumber, &order.CustomerID, &order.QuoteID,
+		&order.OrderDate, &order.RequiredDate, &order.ShippedDate, &order.Status,
+		&order.Subtotal, &order.TaxRate, &order.TaxAmount, &order.DiscountAmount, &order.ShippingAmount,
+		&order.TotalAmount, &order.DocumentDiscountPercent, &order.DocumentDiscountAmount,
+		&order.PricesIncludeTax, &order.ShipToCountry, &order.ShipToRegion, &order.ShipToCity,
+		&order.ShipToPostalCode, &order.SellerVATID, &order.BuyerVATID, &order.VATTreatment,
+		&order.Currency, &order.PaymentTerms, &order.ShippingAddress, &order.BillingAddress,
+		&order.Notes, &order.SalesRepID, &order.CreatedBy, &order.CreatedAt, &order.UpdatedAt,
+		&order.UpdatedBy, &order.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	order.VATLegend = vatLegend(order.VATTreatment)
+	return nil
+}
+
+const quoteColumns = `
+	sq.id, sq.quote_number, sq.customer_id, sq.quote_date, sq.valid_until, sq.status,
+	sq.subtotal, sq.tax_rate, sq.tax_amount, sq.discount_amount, sq.shipping_amount, sq.total_amount,
+	sq.document_discount_percent, sq.document_discount_amount, sq.prices_include_tax, sq.ship_to_country,
+	sq.ship_to_region, sq.ship_to_city, sq.ship_to_postal_code, sq.seller_vat_id, sq.buyer_vat_id,
+	sq.vat_treatment, sq.currency, sq.notes,
+	sq.terms, sq.sales_rep_id, sq.created_by, sq.created_at, sq.updated_at, sq.updated_by, sq.version
+`
+
+func scanQuote(row rowScanner, quote *SalesQuote, extra ...interface{}) error {
+	dest := []interface{}{
+		&quote.ID, &quote.QuoteNumber, &quote.CustomerID, &quote.QuoteDate,
+		&quote.ValidUntil, &quote.Status, &quote.Subtotal, &quote.TaxRate, &quote.TaxAmount,
+		&quote.DiscountAmount, &quote.ShippingAmount, &quote.TotalAmount,
+		&quote.DocumentDiscountPercent, &quote.DocumentDiscountAmount, &quote.PricesIncludeTax,
+		&quote.ShipToCountry, &quote.ShipToRegion, &quote.ShipToCity, &quote.ShipToPostalCode,
+		&quote.SellerVATID, &quote.BuyerVATID, &quote.VATTreatment, &quote.Currency, &quote.Notes,
+		&quote.Terms, &quote.SalesRepID, &quote.CreatedBy, &quote.CreatedAt, &quote.UpdatedAt,
+		&quote.UpdatedBy, &quote.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	quote.VATLegend = vatLegend(quote.VATTreatment)
+	return nil
+}
+
 // Sales Order Handlers
 
 // GetSalesOrders retrieves all sales orders with optional filtering
@@ -139,17 +301,30 @@ func (h *SalesHandler) GetSalesOrders(w http.ResponseWriter, r *http.Request) {
 		limit = "50"
 	}
 
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales orders")
+		return
+	}
+	defer tx.Rollback()
+
 	query := `
-		SELECT so.*, c.first_name, c.last_name, c.company_name, c.email,
+		SELECT ` + orderColumns + `, c.first_name, c.last_name, c.company_name, c.email,
 		       sr.first_name as rep_first_name, sr.last_name as rep_last_name
 		FROM sales_orders so
 		LEFT JOIN customers c ON so.customer_id = c.id
-		LEFT JOIN sales_representatives sr ON so.sales_rep_id = sr.id
-		WHERE 1=1
+		LEFT JOIN sales_representatives sr ON so.sales_rep_id = sr.id AND sr.tenant_id = so.tenant_id
+		WHERE so.tenant_id = $1
 	`
 
-	args := []interface{}{}
-	argIndex := 1
+	args := []interface{}{requestTenant(r)}
+	argIndex := 2
+
+	scopeCondition, scopeArgs := h.requestDataScope(tx, r).condition("so.sales_rep_id", argIndex)
+	query += scopeCondition
+	args = append(args, scopeArgs...)
+	argIndex += len(scopeArgs)
 
 	if status != "" {
 		query += fmt.Sprintf(" AND so.status = $%d", argIndex)
@@ -166,9 +341,9 @@ func (h *SalesHandler) GetSalesOrders(w http.ResponseWriter, r *http.Request) {
 	query += fmt.Sprintf(" ORDER BY so.order_date DESC LIMIT $%d", argIndex)
 	args = append(args, limit)
 
-	rows, err := h.db.Query(query, args...)
+	rows, err := tx.Query(query, args...)
 	if err != nil {
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch sales orders")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales orders")
 		return
 	}
 	defer rows.Close()
@@ -178,15 +353,8 @@ func (h *SalesHandler) GetSalesOrders(w http.ResponseWriter, r *http.Request) {
 		var order SalesOrder
 		var firstName, lastName, companyName, email, repFirstName, repLastName sql.NullString
 
-		err := rows.Scan(
-			&order.ID, &order.OrderNumber, &order.CustomerID, &order.QuoteID,
-			&order.OrderDate, &order.RequiredDate, &order.ShippedDate, &order.Status,
-			&order.Subtotal, &order.TaxAmount, &order.DiscountAmount, &order.ShippingAmount,
-			&order.TotalAmount, &order.Currency, &order.PaymentTerms, &order.ShippingAddress,
-			&order.BillingAddress, &order.Notes, &order.SalesRepID, &order.CreatedBy,
-			&order.CreatedAt, &order.UpdatedAt, &firstName, &lastName, &companyName, &email,
-			&repFirstName, &repLastName,
-		)
+		err := scanOrder(rows, &order, &firstName, &lastName, &companyName, &email,
+			&repFirstName, &repLastName)
 		if err != nil {
 			// Error scanning - skip this order
 			continue
@@ -222,41 +390,54 @@ func (h *SalesHandler) GetSalesOrder(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid order ID")
+		writeError(w, http.StatusBadRequest, "Invalid order ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales order")
 		return
 	}
+	defer tx.Rollback()
 
-	// Get order details
+	order, err := fetchSalesOrder(tx, tenantID, id, h.requestDataScope(tx, r))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales order not found")
+			return
+		}
+		// Error:"Failed to fetch sales order", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales order")
+		return
+	}
+
+	setETag(w, order.Version)
+	sdk.WriteJSON(w, http.StatusOK, order)
+}
+
+// fetchSalesOrder loads an order with its customer, sales rep and items. Orders outside
+// scope are not found.
+func fetchSalesOrder(q sqlx.Queryer, tenantID string, id int, scope dataScope) (SalesOrder, error) {
+	scopeCondition, scopeArgs := scope.condition("so.sales_rep_id", 3)
 	query := `
-		SELECT so.*, c.first_name, c.last_name, c.company_name, c.email, c.phone,
+		SELECT ` + orderColumns + `, c.first_name, c.last_name, c.company_name, c.email, c.phone,
 		       sr.first_name as rep_first_name, sr.last_name as rep_last_name
 		FROM sales_orders so
 		LEFT JOIN customers c ON so.customer_id = c.id
-		LEFT JOIN sales_representatives sr ON so.sales_rep_id = sr.id
-		WHERE so.id = $1
-	`
+		LEFT JOIN sales_representatives sr ON so.sales_rep_id = sr.id AND sr.tenant_id = so.tenant_id
+		WHERE so.id = $1 AND so.tenant_id = $2` + scopeCondition
 
 	var order SalesOrder
 	var firstName, lastName, companyName, email, phone, repFirstName, repLastName sql.NullString
 
-	err = h.db.QueryRow(query, id).Scan(
-		&order.ID, &order.OrderNumber, &order.CustomerID, &order.QuoteID,
-		&order.OrderDate, &order.RequiredDate, &order.ShippedDate, &order.Status,
-		&order.Subtotal, &order.TaxAmount, &order.DiscountAmount, &order.ShippingAmount,
-		&order.TotalAmount, &order.Currency, &order.PaymentTerms, &order.ShippingAddress,
-		&order.BillingAddress, &order.Notes, &order.SalesRepID, &order.CreatedBy,
-		&order.CreatedAt, &order.UpdatedAt, &firstName, &lastName, &companyName, &email, &phone,
-		&repFirstName, &repLastName,
-	)
-
+	err := scanOrder(q.QueryRowx(query, append([]interface{}{id, tenantID}, scopeArgs...)...), &order,
+		&firstName, &lastName, &companyName, &email, &phone, &repFirstName, &repLastName)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			sdk.WriteError(w, http.StatusNotFound, "Sales order not found")
-			return
-		}
-		// Error:"Failed to fetch sales order", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch sales order")
-		return
+		return order, err
 	}
 
 	order.Customer = &Customer{
@@ -278,30 +459,39 @@ func (h *SalesHandler) GetSalesOrder(w http.ResponseWriter, r *http.Request) {
 
 	// Get order items
 	itemsQuery := `
-		SELECT soi.*, p.name as product_name, p.sku, p.description
+		SELECT soi.id, soi.order_id, soi.product_id, soi.quantity, soi.unit_price,
+		       soi.discount_percent, soi.discount_amount, soi.line_total, soi.tax_category, soi.tax_amount,
+		       soi.taxes, soi.shipped_quantity,
+		       soi.invoiced_quantity, soi.invoiced_amount, soi.notes, soi.created_at,
+		       p.name as product_name, p.sku, p.description
 		FROM sales_order_items soi
 		JOIN products p ON soi.product_id = p.id
-		WHERE soi.order_id = $1
+		WHERE soi.order_id = $1 AND soi.tenant_id = $2
 		ORDER BY soi.id
 	`
 
-	itemRows, err := h.db.Query(itemsQuery, id)
+	itemRows, err := q.Query(itemsQuery, id, tenantID)
 	if err == nil {
 		defer itemRows.Close()
 
 		for itemRows.Next() {
 			var item SalesOrderItem
+			var taxes []byte
 			var productName, sku, description sql.NullString
 
 			err := itemRows.Scan(
 				&item.ID, &item.OrderID, &item.ProductID, &item.Quantity,
 				&item.UnitPrice, &item.DiscountPercent, &item.DiscountAmount,
-				&item.LineTotal, &item.ShippedQuantity, &item.Notes, &item.CreatedAt,
+				&item.LineTotal, &item.TaxCategory, &item.TaxAmount, &taxes, &item.ShippedQuantity,
+				&item.InvoicedQuantity, &item.InvoicedAmount, &item.Notes, &item.CreatedAt,
 				&productName, &sku, &description,
 			)
 			if err != nil {
 				continue
 			}
+			if err := json.Unmarshal(taxes, &item.Taxes); err != nil {
+				continue
+			}
 
 			item.Product = &Product{
 				ID:          item.ProductID,
@@ -314,38 +504,54 @@ func (h *SalesHandler) GetSalesOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	sdk.WriteJSON(w, http.StatusOK, order)
+	return order, nil
+}
+
+// createSalesOrderRequest is the body of CreateSalesOrder
+type createSalesOrderRequest struct {
+	CustomerID      int              `json:"customer_id" validate:"required"`
+	QuoteID         *int             `json:"quote_id"`
+	OrderDate       string           `json:"order_date" validate:"required,date"`
+	RequiredDate    *string          `json:"required_date" validate:"date"`
+	PaymentTerms    *string          `json:"payment_terms"`
+	ShippingAddress *string          `json:"shipping_address"`
+	BillingAddress  *string          `json:"billing_address"`
+	Notes           *string          `json:"notes"`
+	SalesRepID      *int             `json:"sales_rep_id"`
+	Items           []SalesOrderItem `json:"items" validate:"required"`
+
+	// Document-level amounts, in currency; currency defaults to the default_currency setting
+	// and tax_rate to the default_tax_rate setting
+	Currency                *string  `json:"currency" validate:"currency"`
+	DocumentDiscountPercent Decimal  `json:"document_discount_percent" validate:"gte=0,lte=100"`
+	DocumentDiscountAmount  Decimal  `json:"document_discount_amount" validate:"gte=0"`
+	ShippingAmount          Decimal  `json:"shipping_amount" validate:"gte=0"`
+	TaxRate                 *Decimal `json:"tax_rate" validate:"gte=0,lte=100"`
+
+	// Tax is determined from the ship-to address, falling back to tax_rate outside every
+	// tax jurisdiction; prices_include_tax defaults to the prices_include_tax setting
+	PricesIncludeTax *bool   `json:"prices_include_tax"`
+	ShipToCountry    *string `json:"ship_to_country" validate:"country"`
+	ShipToRegion     *string `json:"ship_to_region"`
+	ShipToCity       *string `json:"ship_to_city"`
+	ShipToPostalCode *string `json:"ship_to_postal_code"`
+
+	// The buyer's EU VAT ID; with the seller_vat_id setting it determines the VAT treatment
+	BuyerVATID *string `json:"buyer_vat_id" validate:"vat_id"`
 }
 
 // CreateSalesOrder creates a new sales order
 func (h *SalesHandler) CreateSalesOrder(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		CustomerID      int              `json:"customer_id" validate:"required"`
-		QuoteID         *int             `json:"quote_id"`
-		OrderDate       string           `json:"order_date" validate:"required"`
-		RequiredDate    *string          `json:"required_date"`
-		PaymentTerms    *string          `json:"payment_terms"`
-		ShippingAddress *string          `json:"shipping_address"`
-		BillingAddress  *string          `json:"billing_address"`
-		Notes           *string          `json:"notes"`
-		SalesRepID      *int             `json:"sales_rep_id"`
-		Items           []SalesOrderItem `json:"items" validate:"required"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
+	var req createSalesOrderRequest
 
-	if len(req.Items) == 0 {
-		sdk.WriteError(w, http.StatusBadRequest, "At least one item is required")
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
 	// Parse order date
 	orderDate, err := time.Parse("2006-01-02", req.OrderDate)
 	if err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid order date format")
+		writeError(w, http.StatusBadRequest, "Invalid order date format")
 		return
 	}
 
@@ -353,132 +559,182 @@ func (h *SalesHandler) CreateSalesOrder(w http.ResponseWriter, r *http.Request)
 	if req.RequiredDate != nil {
 		rd, err := time.Parse("2006-01-02", *req.RequiredDate)
 		if err != nil {
-			sdk.WriteError(w, http.StatusBadRequest, "Invalid required date format")
+			writeError(w, http.StatusBadRequest, "Invalid required date format")
 			return
 		}
 		requiredDate = &rd
 	}
 
-	// Generate order number
-	orderNumber := fmt.Sprintf("SO-%d", time.Now().Unix())
+	tenantID := requestTenant(r)
 
 	// Start transaction
-	tx, err := h.db.Beginx()
+	tx, err := h.beginRequestTx(r)
 	if err != nil {
 		// Error:"Failed to begin transaction", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to create order")
+		writeError(w, http.StatusInternalServerError, "Failed to create order")
 		return
 	}
 	defer tx.Rollback()
 
-	// Calculate totals
-	var subtotal, taxAmount, discountAmount float64
-	for _, item := range req.Items {
-		lineTotal := float64(item.Quantity) * item.UnitPrice
-		if item.DiscountAmount > 0 {
-			lineTotal -= item.DiscountAmount
+	productIDs := make([]int, len(req.Items))
+	for i, item := range req.Items {
+		productIDs[i] = item.ProductID
+	}
+	if err := checkReferences(tx, tenantID, documentReferences(req.CustomerID, productIDs)...); err != nil {
+		h.writeRequestError(w, err, "Failed to create sales order")
+		return
+	}
+
+	if req.QuoteID != nil {
+		if err := checkTenantRef(tx, "sales_quotes", *req.QuoteID, tenantID, "Sales quote not found"); err != nil {
+			h.writeRequestError(w, err, "Failed to create sales order")
+			return
+		}
+	}
+	if req.SalesRepID != nil {
+		if err := checkTenantRef(tx, "sales_representatives", *req.SalesRepID, tenantID, "Sales representative not found"); err != nil {
+			h.writeRequestError(w, err, "Failed to create sales order")
+			return
 		}
-		subtotal += lineTotal
-		discountAmount += item.DiscountAmount
 	}
 
-	totalAmount := subtotal + taxAmount + discountAmount
+	settings := h.loadSettings(tx, tenantID)
+	if req.TaxRate == nil {
+		req.TaxRate = &settings.DefaultTaxRate
+	}
+	if req.PricesIncludeTax == nil {
+		req.PricesIncludeTax = &settings.PricesIncludeTax
+	}
+	currency := requestCurrency(req.Currency, settings.DefaultCurrency)
+	buyerVATID := normalizedVATID(req.BuyerVATID)
+	sellerVATID, vatTreatment := settings.vatTreatment(buyerVATID, req.ShipToCountry)
+
+	orderNumber, err := settings.nextDocumentNumber(tx, tenantID, numberOrder, orderDate)
+	if err != nil {
+		h.logger.Error("Failed to allocate order number", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create order")
+		return
+	}
 
-	// Create sales order
+	// Create sales order; its totals are calculated once the items are in
 	orderQuery := `
-		INSERT INTO sales_orders (order_number, customer_id, quote_id, order_date, required_date,
-		                          subtotal, tax_amount, discount_amount, shipping_amount, total_amount,
-		                          currency, payment_terms, shipping_address, billing_address, notes,
-		                          sales_rep_id, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		INSERT INTO sales_orders (tenant_id, order_number, customer_id, quote_id, order_date, required_date,
+		                          document_discount_percent, document_discount_amount, shipping_amount, tax_rate,
+		                          prices_include_tax, ship_to_country, ship_to_region, ship_to_city,
+		                          ship_to_postal_code, seller_vat_id, buyer_vat_id, vat_treatment, currency,
+		                          payment_terms, shipping_address, billing_address, notes, sales_rep_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21,
+		        $22, $23, $24, $25)
 		RETURNING id, created_at, updated_at
 	`
 
 	var orderID int
 	var createdAt, updatedAt time.Time
 
-	err = tx.QueryRow(orderQuery, orderNumber, req.CustomerID, req.QuoteID, orderDate, requiredDate,
-		subtotal, taxAmount, discountAmount, 0, totalAmount, "USD", req.PaymentTerms,
-		req.ShippingAddress, req.BillingAddress, req.Notes, req.SalesRepID, 1).
+	err = tx.QueryRow(orderQuery, tenantID, orderNumber, req.CustomerID, req.QuoteID, orderDate, requiredDate,
+		req.DocumentDiscountPercent, req.DocumentDiscountAmount, req.ShippingAmount, req.TaxRate,
+		*req.PricesIncludeTax, req.ShipToCountry, req.ShipToRegion, req.ShipToCity, req.ShipToPostalCode,
+		sellerVATID, buyerVATID, vatTreatment, currency, req.PaymentTerms, req.ShippingAddress, req.BillingAddress,
+		req.Notes, req.SalesRepID, requestUser(r)).
 		Scan(&orderID, &createdAt, &updatedAt)
 
 	if err != nil {
 		// Error:"Failed to create sales order", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to create sales order")
+		writeError(w, http.StatusInternalServerError, "Failed to create sales order")
 		return
 	}
 
 	// Create order items
 	for _, item := range req.Items {
 		itemQuery := `
-			INSERT INTO sales_order_items (order_id, product_id, quantity, unit_price,
-			                               discount_percent, discount_amount, notes)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			INSERT INTO sales_order_items (tenant_id, order_id, product_id, quantity, unit_price,
+			                               discount_percent, discount_amount, tax_category, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		`
 
-		_, err = tx.Exec(itemQuery, orderID, item.ProductID, item.Quantity, item.UnitPrice,
-			item.DiscountPercent, item.DiscountAmount, item.Notes)
+		_, err = tx.Exec(itemQuery, tenantID, orderID, item.ProductID, item.Quantity, item.UnitPrice,
+			item.DiscountPercent, item.DiscountAmount, item.TaxCategory, item.Notes)
 		if err != nil {
 			// Error:"Failed to create order item", zap.Error(err))
-			sdk.WriteError(w, http.StatusInternalServerError, "Failed to create order item")
+			writeError(w, http.StatusInternalServerError, "Failed to create order item")
 			return
 		}
 	}
 
+	totals, err := h.recalculateTotals(tx, tenantID, orderDocument, orderID)
+	if err != nil {
+		h.logger.Error("Failed to calculate order totals", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create order")
+		return
+	}
+
+	err = recordEvent(tx, tenantID, OrderCreated{
+		OrderID:     orderID,
+		OrderNumber: orderNumber,
+		CustomerID:  req.CustomerID,
+		QuoteID:     req.QuoteID,
+		SalesRepID:  req.SalesRepID,
+		TotalAmount: totals.TotalAmount,
+		Currency:    currency,
+	})
+	if err != nil {
+		h.logger.Error("Failed to record order event", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create order")
+		return
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		// Error:"Failed to commit transaction", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to create order")
+		writeError(w, http.StatusInternalServerError, "Failed to create order")
 		return
 	}
 
 	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
 		"order_id":     orderID,
 		"order_number": orderNumber,
+		"total_amount": totals.TotalAmount,
 		"created_at":   createdAt,
 		"updated_at":   updatedAt,
 		"message":      "Sales order created successfully",
 	})
 }
 
+// updateSalesOrderRequest is the body of UpdateSalesOrder
+type updateSalesOrderRequest struct {
+	Status          *string `json:"status"`
+	RequiredDate    *string `json:"required_date" validate:"date"`
+	ShippedDate     *string `json:"shipped_date" validate:"date"`
+	PaymentTerms    *string `json:"payment_terms"`
+	ShippingAddress *string `json:"shipping_address"`
+	BillingAddress  *string `json:"billing_address"`
+	Notes           *string `json:"notes"`
+}
+
 // UpdateSalesOrder updates an existing sales order
 func (h *SalesHandler) UpdateSalesOrder(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid order ID")
+		writeError(w, http.StatusBadRequest, "Invalid order ID")
 		return
 	}
 
-	var req struct {
-		Status          *string `json:"status"`
-		RequiredDate    *string `json:"required_date"`
-		ShippedDate     *string `json:"shipped_date"`
-		PaymentTerms    *string `json:"payment_terms"`
-		ShippingAddress *string `json:"shipping_address"`
-		BillingAddress  *string `json:"billing_address"`
-		Notes           *string `json:"notes"`
-	}
+	var req updateSalesOrderRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
-	// Build dynamic update query
+	// Build dynamic update query. Status changes go through the order lifecycle instead.
 	setParts := []string{}
 	args := []interface{}{}
 	argIndex := 1
 
-	if req.Status != nil {
-		setParts = append(setParts, fmt.Sprintf("status = $%d", argIndex))
-		args = append(args, *req.Status)
-		argIndex++
-	}
 	if req.RequiredDate != nil {
 		rd, err := time.Parse("2006-01-02", *req.RequiredDate)
 		if err != nil {
-			sdk.WriteError(w, http.StatusBadRequest, "Invalid required date format")
+			writeError(w, http.StatusBadRequest, "Invalid required date format")
 			return
 		}
 		setParts = append(setParts, fmt.Sprintf("required_date = $%d", argIndex))
@@ -488,7 +744,7 @@ func (h *SalesHandler) UpdateSalesOrder(w http.ResponseWriter, r *http.Request)
 	if req.ShippedDate != nil {
 		sd, err := time.Parse("2006-01-02", *req.ShippedDate)
 		if err != nil {
-			sdk.WriteError(w, http.StatusBadRequest, "Invalid shipped date format")
+			writeError(w, http.StatusBadRequest, "Invalid shipped date format")
 			return
 		}
 		setParts = append(setParts, fmt.Sprintf("shipped_date = $%d", argIndex))
@@ -516,41 +772,99 @@ func (h *SalesHandler) UpdateSalesOrder(w http.ResponseWriter, r *http.Request)
 		argIndex++
 	}
 
-	if len(setParts) == 0 {
-		sdk.WriteError(w, http.StatusBadRequest, "No fields to update")
+	if len(setParts) == 0 && req.Status == nil {
+		writeError(w, http.StatusBadRequest, "No fields to update")
 		return
 	}
 
-	query := fmt.Sprintf("UPDATE sales_orders SET %s WHERE id = $%d",
-		fmt.Sprintf("%s", setParts[0]), argIndex)
-	for i := 1; i < len(setParts); i++ {
-		query = fmt.Sprintf("UPDATE sales_orders SET %s WHERE id = $%d",
-			fmt.Sprintf("%s, %s", query[19:], setParts[i]), argIndex)
-	}
-	args = append(args, id)
+	tenantID := requestTenant(r)
 
-	result, err := h.db.Exec(query, args...)
+	tx, err := h.beginRequestTx(r)
 	if err != nil {
-		// Error:"Failed to update sales order", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to update sales order")
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales order")
 		return
 	}
+	defer tx.Rollback()
+
+	scope := h.requestDataScope(tx, r)
 
-	rowsAffected, err := result.RowsAffected()
+	version, err := lockScopedVersion(tx, "sales_orders", scope, id)
 	if err != nil {
-		// Error:"Failed to get rows affected", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to update sales order")
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales order not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales order", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales order")
 		return
 	}
-
-	if rowsAffected == 0 {
-		sdk.WriteError(w, http.StatusNotFound, "Sales order not found")
+	if !ifMatches(requestIfMatch(r), version) {
+		order, err := fetchSalesOrder(tx, tenantID, id, scope)
+		if err != nil {
+			h.logger.Error("Failed to fetch sales order", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales order")
+			return
+		}
+		writePreconditionFailed(w, version, order)
 		return
 	}
 
-	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+	if len(setParts) > 0 {
+		query := fmt.Sprintf("UPDATE sales_orders SET %s WHERE id = $%d AND tenant_id = $%d",
+			strings.Join(setParts, ", "), argIndex, argIndex+1)
+		args = append(args, id, tenantID)
+
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			h.logger.Error("Failed to update sales order", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales order")
+			return
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			h.logger.Error("Failed to get rows affected", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales order")
+			return
+		}
+
+		if rowsAffected == 0 {
+			writeError(w, http.StatusNotFound, "Sales order not found")
+			return
+		}
+	}
+
+	response := map[string]interface{}{
 		"message": "Sales order updated successfully",
-	})
+	}
+
+	if req.Status != nil {
+		invoiceID, err := h.transitionOrder(tx, scope, id, *req.Status)
+		if err != nil {
+			h.writeRequestError(w, err, "Failed to update sales order")
+			return
+		}
+		if invoiceID != 0 {
+			response["invoice_id"] = invoiceID
+		}
+	}
+
+	if version, err = lockVersion(tx, "sales_orders", tenantID, id); err != nil {
+		h.logger.Error("Failed to fetch sales order version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+	response["version"] = version
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales order")
+		return
+	}
+
+	setETag(w, version)
+	sdk.WriteJSON(w, http.StatusOK, response)
 }
 
 // Sales Quote Handlers
@@ -565,17 +879,30 @@ func (h *SalesHandler) GetSalesQuotes(w http.ResponseWriter, r *http.Request) {
 		limit = "50"
 	}
 
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales quotes")
+		return
+	}
+	defer tx.Rollback()
+
 	query := `
-		SELECT sq.*, c.first_name, c.last_name, c.company_name, c.email,
+		SELECT ` + quoteColumns + `, c.first_name, c.last_name, c.company_name, c.email,
 		       sr.first_name as rep_first_name, sr.last_name as rep_last_name
 		FROM sales_quotes sq
 		LEFT JOIN customers c ON sq.customer_id = c.id
-		LEFT JOIN sales_representatives sr ON sq.sales_rep_id = sr.id
-		WHERE 1=1
+		LEFT JOIN sales_representatives sr ON sq.sales_rep_id = sr.id AND sr.tenant_id = sq.tenant_id
+		WHERE sq.tenant_id = $1
 	`
 
-	args := []interface{}{}
-	argIndex := 1
+	args := []interface{}{requestTenant(r)}
+	argIndex := 2
+
+	scopeCondition, scopeArgs := h.requestDataScope(tx, r).condition("sq.sales_rep_id", argIndex)
+	query += scopeCondition
+	args = append(args, scopeArgs...)
+	argIndex += len(scopeArgs)
 
 	if status != "" {
 		query += fmt.Sprintf(" AND sq.status = $%d", argIndex)
@@ -592,10 +919,10 @@ func (h *SalesHandler) GetSalesQuotes(w http.ResponseWriter, r *http.Request) {
 	query += fmt.Sprintf(" ORDER BY sq.quote_date DESC LIMIT $%d", argIndex)
 	args = append(args, limit)
 
-	rows, err := h.db.Query(query, args...)
+	rows, err := tx.Query(query, args...)
 	if err != nil {
 		// Error:"Failed to fetch sales quotes", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch sales quotes")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales quotes")
 		return
 	}
 	defer rows.Close()
@@ -605,14 +932,8 @@ func (h *SalesHandler) GetSalesQuotes(w http.ResponseWriter, r *http.Request) {
 		var quote SalesQuote
 		var firstName, lastName, companyName, email, repFirstName, repLastName sql.NullString
 
-		err := rows.Scan(
-			&quote.ID, &quote.QuoteNumber, &quote.CustomerID, &quote.QuoteDate,
-			&quote.ValidUntil, &quote.Status, &quote.Subtotal, &quote.TaxAmount,
-			&quote.DiscountAmount, &quote.TotalAmount, &quote.Currency, &quote.Notes,
-			&quote.Terms, &quote.SalesRepID, &quote.CreatedBy, &quote.CreatedAt,
-			&quote.UpdatedAt, &firstName, &lastName, &companyName, &email,
-			&repFirstName, &repLastName,
-		)
+		err := scanQuote(rows, &quote, &firstName, &lastName, &companyName, &email,
+			&repFirstName, &repLastName)
 		if err != nil {
 			// Error:"Failed to scan sales quote", zap.Error(err))
 			continue
@@ -643,32 +964,152 @@ func (h *SalesHandler) GetSalesQuotes(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// CreateSalesQuote creates a new sales quote
-func (h *SalesHandler) CreateSalesQuote(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		CustomerID int              `json:"customer_id" validate:"required"`
-		QuoteDate  string           `json:"quote_date" validate:"required"`
-		ValidUntil *string          `json:"valid_until"`
-		Notes      *string          `json:"notes"`
-		Terms      *string          `json:"terms"`
-		SalesRepID *int             `json:"sales_rep_id"`
-		Items      []SalesQuoteItem `json:"items" validate:"required"`
+// GetSalesQuote retrieves a single sales quote by ID
+func (h *SalesHandler) GetSalesQuote(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid quote ID")
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid request body")
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales quote")
 		return
 	}
+	defer tx.Rollback()
 
-	if len(req.Items) == 0 {
-		sdk.WriteError(w, http.StatusBadRequest, "At least one item is required")
+	quote, err := fetchSalesQuote(tx, tenantID, id, h.requestDataScope(tx, r))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales quote not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales quote", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales quote")
+		return
+	}
+
+	setETag(w, quote.Version)
+	sdk.WriteJSON(w, http.StatusOK, quote)
+}
+
+// fetchSalesQuote loads a quote with its customer, sales rep and items. Quotes outside
+// scope are not found.
+func fetchSalesQuote(q sqlx.Queryer, tenantID string, id int, scope dataScope) (SalesQuote, error) {
+	scopeCondition, scopeArgs := scope.condition("sq.sales_rep_id", 3)
+	query := `
+		SELECT ` + quoteColumns + `, c.first_name, c.last_name, c.company_name, c.email,
+		       sr.first_name as rep_first_name, sr.last_name as rep_last_name
+		FROM sales_quotes sq
+		LEFT JOIN customers c ON sq.customer_id = c.id
+		LEFT JOIN sales_representatives sr ON sq.sales_rep_id = sr.id AND sr.tenant_id = sq.tenant_id
+		WHERE sq.id = $1 AND sq.tenant_id = $2` + scopeCondition
+
+	var quote SalesQuote
+	var firstName, lastName, companyName, email, repFirstName, repLastName sql.NullString
+
+	err := scanQuote(q.QueryRowx(query, append([]interface{}{id, tenantID}, scopeArgs...)...), &quote,
+		&firstName, &lastName, &companyName, &email, &repFirstName, &repLastName)
+	if err != nil {
+		return quote, err
+	}
+
+	quote.Customer = &Customer{
+		ID:          quote.CustomerID,
+		CompanyName: &companyName.String,
+		FirstName:   &firstName.String,
+		LastName:    &lastName.String,
+		Email:       &email.String,
+	}
+
+	if repFirstName.Valid && quote.SalesRepID != nil {
+		quote.SalesRep = &SalesRepresentative{
+			ID:        *quote.SalesRepID,
+			FirstName: &repFirstName.String,
+			LastName:  &repLastName.String,
+		}
+	}
+
+	itemRows, err := q.Query(`
+		SELECT id, quote_id, product_id, quantity, unit_price, discount_percent, discount_amount,
+		       line_total, tax_category, tax_amount, taxes, notes, created_at
+		FROM sales_quote_items
+		WHERE quote_id = $1 AND tenant_id = $2
+		ORDER BY id
+	`, id, tenantID)
+	if err == nil {
+		defer itemRows.Close()
+
+		for itemRows.Next() {
+			var item SalesQuoteItem
+			var taxes []byte
+
+			err := itemRows.Scan(
+				&item.ID, &item.QuoteID, &item.ProductID, &item.Quantity,
+				&item.UnitPrice, &item.DiscountPercent, &item.DiscountAmount,
+				&item.LineTotal, &item.TaxCategory, &item.TaxAmount, &taxes, &item.Notes, &item.CreatedAt,
+			)
+			if err != nil {
+				continue
+			}
+			if err := json.Unmarshal(taxes, &item.Taxes); err != nil {
+				continue
+			}
+
+			quote.Items = append(quote.Items, item)
+		}
+	}
+
+	return quote, nil
+}
+
+// createSalesQuoteRequest is the body of CreateSalesQuote
+type createSalesQuoteRequest struct {
+	CustomerID int              `json:"customer_id" validate:"required"`
+	QuoteDate  string           `json:"quote_date" validate:"required,date"`
+	ValidUntil *string          `json:"valid_until" validate:"date"`
+	Notes      *string          `json:"notes"`
+	Terms      *string          `json:"terms"`
+	SalesRepID *int             `json:"sales_rep_id"`
+	Items      []SalesQuoteItem `json:"items" validate:"required"`
+
+	// Document-level amounts, in currency; currency defaults to the default_currency setting
+	// and tax_rate to the default_tax_rate setting
+	Currency                *string  `json:"currency" validate:"currency"`
+	DocumentDiscountPercent Decimal  `json:"document_discount_percent" validate:"gte=0,lte=100"`
+	DocumentDiscountAmount  Decimal  `json:"document_discount_amount" validate:"gte=0"`
+	ShippingAmount          Decimal  `json:"shipping_amount" validate:"gte=0"`
+	TaxRate                 *Decimal `json:"tax_rate" validate:"gte=0,lte=100"`
+
+	// Tax is determined from the ship-to address, falling back to tax_rate outside every
+	// tax jurisdiction; prices_include_tax defaults to the prices_include_tax setting
+	PricesIncludeTax *bool   `json:"prices_include_tax"`
+	ShipToCountry    *string `json:"ship_to_country" validate:"country"`
+	ShipToRegion     *string `json:"ship_to_region"`
+	ShipToCity       *string `json:"ship_to_city"`
+	ShipToPostalCode *string `json:"ship_to_postal_code"`
+
+	// The buyer's EU VAT ID; with the seller_vat_id setting it determines the VAT treatment
+	BuyerVATID *string `json:"buyer_vat_id" validate:"vat_id"`
+}
+
+// CreateSalesQuote creates a new sales quote
+func (h *SalesHandler) CreateSalesQuote(w http.ResponseWriter, r *http.Request) {
+	var req createSalesQuoteRequest
+
+	if !decodeRequest(w, r, &req) {
 		return
 	}
 
 	// Parse quote date
 	quoteDate, err := time.Parse("2006-01-02", req.QuoteDate)
 	if err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid quote date format")
+		writeError(w, http.StatusBadRequest, "Invalid quote date format")
 		return
 	}
 
@@ -676,148 +1117,364 @@ func (h *SalesHandler) CreateSalesQuote(w http.ResponseWriter, r *http.Request)
 	if req.ValidUntil != nil {
 		vu, err := time.Parse("2006-01-02", *req.ValidUntil)
 		if err != nil {
-			sdk.WriteError(w, http.StatusBadRequest, "Invalid valid until date format")
+			writeError(w, http.StatusBadRequest, "Invalid valid until date format")
 			return
 		}
 		validUntil = &vu
 	}
 
-	// Generate quote number
-	quoteNumber := fmt.Sprintf("SQ-%d", time.Now().Unix())
+	tenantID := requestTenant(r)
 
 	// Start transaction
-	tx, err := h.db.Beginx()
+	tx, err := h.beginRequestTx(r)
 	if err != nil {
 		// Error:"Failed to begin transaction", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to create quote")
+		writeError(w, http.StatusInternalServerError, "Failed to create quote")
 		return
 	}
 	defer tx.Rollback()
 
-	// Calculate totals
-	var subtotal, taxAmount, discountAmount float64
-	for _, item := range req.Items {
-		lineTotal := float64(item.Quantity) * item.UnitPrice
-		if item.DiscountAmount > 0 {
-			lineTotal -= item.DiscountAmount
+	productIDs := make([]int, len(req.Items))
+	for i, item := range req.Items {
+		productIDs[i] = item.ProductID
+	}
+	if err := checkReferences(tx, tenantID, documentReferences(req.CustomerID, productIDs)...); err != nil {
+		h.writeRequestError(w, err, "Failed to create sales quote")
+		return
+	}
+
+	if req.SalesRepID != nil {
+		if err := checkTenantRef(tx, "sales_representatives", *req.SalesRepID, tenantID, "Sales representative not found"); err != nil {
+			h.writeRequestError(w, err, "Failed to create sales quote")
+			return
 		}
-		subtotal += lineTotal
-		discountAmount += item.DiscountAmount
 	}
 
-	totalAmount := subtotal + taxAmount + discountAmount
+	settings := h.loadSettings(tx, tenantID)
+	if req.TaxRate == nil {
+		req.TaxRate = &settings.DefaultTaxRate
+	}
+	if req.PricesIncludeTax == nil {
+		req.PricesIncludeTax = &settings.PricesIncludeTax
+	}
+	currency := requestCurrency(req.Currency, settings.DefaultCurrency)
+	buyerVATID := normalizedVATID(req.BuyerVATID)
+	sellerVATID, vatTreatment := settings.vatTreatment(buyerVATID, req.ShipToCountry)
+
+	quoteNumber, err := settings.nextDocumentNumber(tx, tenantID, numberQuote, quoteDate)
+	if err != nil {
+		h.logger.Error("Failed to allocate quote number", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create quote")
+		return
+	}
 
-	// Create sales quote
+	// Create sales quote; its totals are calculated once the items are in
 	quoteQuery := `
-		INSERT INTO sales_quotes (quote_number, customer_id, quote_date, valid_until,
-		                          subtotal, tax_amount, discount_amount, total_amount,
-		                          currency, notes, terms, sales_rep_id, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		INSERT INTO sales_quotes (tenant_id, quote_number, customer_id, quote_date, valid_until,
+		                          document_discount_percent, document_discount_amount, shipping_amount, tax_rate,
+		                          prices_include_tax, ship_to_country, ship_to_region, ship_to_city,
+		                          ship_to_postal_code, seller_vat_id, buyer_vat_id, vat_treatment, currency, notes,
+		                          terms, sales_rep_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
 		RETURNING id, created_at, updated_at
 	`
 
 	var quoteID int
 	var createdAt, updatedAt time.Time
 
-	err = tx.QueryRow(quoteQuery, quoteNumber, req.CustomerID, quoteDate, validUntil,
-		subtotal, taxAmount, discountAmount, totalAmount, "USD", req.Notes,
-		req.Terms, req.SalesRepID, 1).Scan(&quoteID, &createdAt, &updatedAt)
+	err = tx.QueryRow(quoteQuery, tenantID, quoteNumber, req.CustomerID, quoteDate, validUntil,
+		req.DocumentDiscountPercent, req.DocumentDiscountAmount, req.ShippingAmount, req.TaxRate,
+		*req.PricesIncludeTax, req.ShipToCountry, req.ShipToRegion, req.ShipToCity, req.ShipToPostalCode,
+		sellerVATID, buyerVATID, vatTreatment, currency, req.Notes, req.Terms, req.SalesRepID, requestUser(r)).
+		Scan(&quoteID, &createdAt, &updatedAt)
 
 	if err != nil {
 		// Error:"Failed to create sales quote", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to create sales quote")
+		writeError(w, http.StatusInternalServerError, "Failed to create sales quote")
 		return
 	}
 
 	// Create quote items
 	for _, item := range req.Items {
 		itemQuery := `
-			INSERT INTO sales_quote_items (quote_id, product_id, quantity, unit_price,
-			                               discount_percent, discount_amount, notes)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			INSERT INTO sales_quote_items (tenant_id, quote_id, product_id, quantity, unit_price,
+			                               discount_percent, discount_amount, tax_category, notes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		`
 
-		_, err = tx.Exec(itemQuery, quoteID, item.ProductID, item.Quantity, item.UnitPrice,
-			item.DiscountPercent, item.DiscountAmount, item.Notes)
+		_, err = tx.Exec(itemQuery, tenantID, quoteID, item.ProductID, item.Quantity, item.UnitPrice,
+			item.DiscountPercent, item.DiscountAmount, item.TaxCategory, item.Notes)
 		if err != nil {
 			// Error:"Failed to create quote item", zap.Error(err))
-			sdk.WriteError(w, http.StatusInternalServerError, "Failed to create quote item")
+			writeError(w, http.StatusInternalServerError, "Failed to create quote item")
 			return
 		}
 	}
 
+	totals, err := h.recalculateTotals(tx, tenantID, quoteDocument, quoteID)
+	if err != nil {
+		h.logger.Error("Failed to calculate quote totals", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create quote")
+		return
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		// Error:"Failed to commit transaction", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to create quote")
+		writeError(w, http.StatusInternalServerError, "Failed to create quote")
 		return
 	}
 
 	sdk.WriteJSON(w, http.StatusCreated, map[string]interface{}{
 		"quote_id":     quoteID,
 		"quote_number": quoteNumber,
+		"total_amount": totals.TotalAmount,
 		"created_at":   createdAt,
 		"updated_at":   updatedAt,
 		"message":      "Sales quote created successfully",
 	})
 }
 
+// updateSalesQuoteRequest is the body of UpdateSalesQuote
+type updateSalesQuoteRequest struct {
+	ValidUntil *string `json:"valid_until" validate:"date"`
+	Notes      *string `json:"notes"`
+	Terms      *string `json:"terms"`
+
+	// Amounts are only editable while the quote is a draft
+	DocumentDiscountPercent *Decimal `json:"document_discount_percent" validate:"gte=0,lte=100"`
+	DocumentDiscountAmount  *Decimal `json:"document_discount_amount" validate:"gte=0"`
+	ShippingAmount          *Decimal `json:"shipping_amount" validate:"gte=0"`
+	TaxRate                 *Decimal `json:"tax_rate" validate:"gte=0,lte=100"`
+}
+
+// changesTotals reports whether the request changes an input of the quote totals
+func (req updateSalesQuoteRequest) changesTotals() bool {
+	return req.DocumentDiscountPercent != nil || req.DocumentDiscountAmount != nil ||
+		req.ShippingAmount != nil || req.TaxRate != nil
+}
+
+// UpdateSalesQuote updates a draft or sent quote. Status changes go through the quote
+// lifecycle endpoints instead.
+func (h *SalesHandler) UpdateSalesQuote(w http.ResponseWriter, r *http.Request) {
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid quote ID")
+		return
+	}
+
+	var req updateSalesQuoteRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.ValidUntil != nil {
+		vu, err := time.Parse("2006-01-02", *req.ValidUntil)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid valid until date format")
+			return
+		}
+		setParts = append(setParts, fmt.Sprintf("valid_until = $%d", argIndex))
+		args = append(args, vu)
+		argIndex++
+	}
+	if req.Notes != nil {
+		setParts = append(setParts, fmt.Sprintf("notes = $%d", argIndex))
+		args = append(args, *req.Notes)
+		argIndex++
+	}
+	if req.Terms != nil {
+		setParts = append(setParts, fmt.Sprintf("terms = $%d", argIndex))
+		args = append(args, *req.Terms)
+		argIndex++
+	}
+	if req.DocumentDiscountPercent != nil {
+		setParts = append(setParts, fmt.Sprintf("document_discount_percent = $%d", argIndex))
+		args = append(args, *req.DocumentDiscountPercent)
+		argIndex++
+	}
+	if req.DocumentDiscountAmount != nil {
+		setParts = append(setParts, fmt.Sprintf("document_discount_amount = $%d", argIndex))
+		args = append(args, *req.DocumentDiscountAmount)
+		argIndex++
+	}
+	if req.ShippingAmount != nil {
+		setParts = append(setParts, fmt.Sprintf("shipping_amount = $%d", argIndex))
+		args = append(args, *req.ShippingAmount)
+		argIndex++
+	}
+	if req.TaxRate != nil {
+		setParts = append(setParts, fmt.Sprintf("tax_rate = $%d", argIndex))
+		args = append(args, *req.TaxRate)
+		argIndex++
+	}
+
+	if len(setParts) == 0 {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+	defer tx.Rollback()
+
+	scope := h.requestDataScope(tx, r)
+
+	version, err := lockScopedVersion(tx, "sales_quotes", scope, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Sales quote not found")
+			return
+		}
+		h.logger.Error("Failed to fetch sales quote", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+	quote, err := fetchSalesQuote(tx, tenantID, id, scope)
+	if err != nil {
+		h.logger.Error("Failed to fetch sales quote", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+	if !ifMatches(requestIfMatch(r), version) {
+		writePreconditionFailed(w, version, quote)
+		return
+	}
+
+	switch {
+	case quote.Status != "draft" && quote.Status != "sent":
+		writeError(w, http.StatusConflict, "Only draft or sent quotes can be edited")
+		return
+	case quote.Status != "draft" && req.changesTotals():
+		writeError(w, http.StatusConflict, "Only draft quotes can change their amounts")
+		return
+	}
+
+	query := fmt.Sprintf("UPDATE sales_quotes SET %s WHERE id = $%d AND tenant_id = $%d",
+		strings.Join(setParts, ", "), argIndex, argIndex+1)
+	args = append(args, id, tenantID)
+
+	if _, err = tx.Exec(query, args...); err != nil {
+		h.logger.Error("Failed to update sales quote", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+
+	if req.changesTotals() {
+		if _, err = h.recalculateTotals(tx, tenantID, quoteDocument, id); err != nil {
+			h.logger.Error("Failed to calculate quote totals", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+			return
+		}
+	}
+
+	if version, err = lockVersion(tx, "sales_quotes", tenantID, id); err != nil {
+		h.logger.Error("Failed to fetch sales quote version", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update sales quote")
+		return
+	}
+
+	setETag(w, version)
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Sales quote updated successfully",
+		"version": version,
+	})
+}
+
 // ConvertQuoteToOrder converts a quote to a sales order
 func (h *SalesHandler) ConvertQuoteToOrder(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	quoteID, err := strconv.Atoi(idStr)
 	if err != nil {
-		sdk.WriteError(w, http.StatusBadRequest, "Invalid quote ID")
+		writeError(w, http.StatusBadRequest, "Invalid quote ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	// Start transaction
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		// Error:"Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to convert quote")
+		return
+	}
+	defer tx.Rollback()
+
+	// Converting accepts the quote, so it must still be open
+	if err := lockQuoteStatus(tx, h.requestDataScope(tx, r), quoteID, "accepted"); err != nil {
+		h.writeRequestError(w, err, "Failed to convert quote")
 		return
 	}
 
 	// Get quote details
 	quoteQuery := `
-		SELECT customer_id, quote_date, subtotal, tax_amount, discount_amount, total_amount,
-		       currency, notes, terms, sales_rep_id, created_by
+		SELECT customer_id, quote_date, document_discount_percent, document_discount_amount,
+		       shipping_amount, tax_rate, tax_amount, prices_include_tax, ship_to_country, ship_to_region,
+		       ship_to_city, ship_to_postal_code, buyer_vat_id, currency, notes, terms, sales_rep_id
 		FROM sales_quotes
-		WHERE id = $1 AND status = 'draft'
+		WHERE id = $1 AND tenant_id = $2
 	`
 
-	var customerID, createdBy int
+	var customerID int
 	var quoteDate time.Time
-	var subtotal, taxAmount, discountAmount, totalAmount float64
+	var discountPercent, discountAmount, shippingAmount, taxAmount Decimal
+	var taxRate *Decimal
+	var pricesIncludeTax bool
+	var shipTo taxAddress
+	var buyerVATID *string
 	var currency, notes, terms string
 	var salesRepID sql.NullInt64
 
-	err = h.db.QueryRow(quoteQuery, quoteID).Scan(
-		&customerID, &quoteDate, &subtotal, &taxAmount, &discountAmount, &totalAmount,
-		&currency, &notes, &terms, &salesRepID, &createdBy,
+	err = tx.QueryRow(quoteQuery, quoteID, tenantID).Scan(
+		&customerID, &quoteDate, &discountPercent, &discountAmount, &shippingAmount, &taxRate, &taxAmount,
+		&pricesIncludeTax, &shipTo.Country, &shipTo.Region, &shipTo.City, &shipTo.PostalCode, &buyerVATID,
+		&currency, &notes, &terms, &salesRepID,
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			sdk.WriteError(w, http.StatusNotFound, "Quote not found or not in draft status")
-			return
-		}
 		// Error:"Failed to fetch quote", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch quote")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch quote")
 		return
 	}
 
-	// Generate order number
-	orderNumber := fmt.Sprintf("SO-%d", time.Now().Unix())
+	// The order's VAT treatment is determined afresh, under the settings in force now
+	settings := h.loadSettings(tx, tenantID)
+	sellerVATID, vatTreatment := settings.vatTreatment(buyerVATID, shipTo.Country)
 
-	// Start transaction
-	tx, err := h.db.Beginx()
+	orderNumber, err := settings.nextDocumentNumber(tx, tenantID, numberOrder, quoteDate)
 	if err != nil {
-		// Error:"Failed to begin transaction", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to convert quote")
+		h.logger.Error("Failed to allocate order number", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to convert quote")
 		return
 	}
-	defer tx.Rollback()
 
-	// Create sales order
+	// Create sales order with the quote's document-level amounts
 	orderQuery := `
-		INSERT INTO sales_orders (order_number, customer_id, quote_id, order_date,
-		                          subtotal, tax_amount, discount_amount, shipping_amount, total_amount,
+		INSERT INTO sales_orders (tenant_id, order_number, customer_id, quote_id, order_date,
+		                          document_discount_percent, document_discount_amount, shipping_amount,
+		                          tax_rate, tax_amount, prices_include_tax, ship_to_country, ship_to_region,
+		                          ship_to_city, ship_to_postal_code, seller_vat_id, buyer_vat_id, vat_treatment,
 		                          currency, notes, sales_rep_id, created_by)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
 		RETURNING id, created_at, updated_at
 	`
 
@@ -830,44 +1487,74 @@ func (h *SalesHandler) ConvertQuoteToOrder(w http.ResponseWriter, r *http.Reques
 		salesRepIDVal = &srID
 	}
 
-	err = tx.QueryRow(orderQuery, orderNumber, customerID, quoteID, quoteDate,
-		subtotal, taxAmount, discountAmount, 0, totalAmount, currency, notes,
-		salesRepIDVal, createdBy).Scan(&orderID, &createdAt, &updatedAt)
+	err = tx.QueryRow(orderQuery, tenantID, orderNumber, customerID, quoteID, quoteDate,
+		discountPercent, discountAmount, shippingAmount, taxRate, taxAmount, pricesIncludeTax, shipTo.Country,
+		shipTo.Region, shipTo.City, shipTo.PostalCode, sellerVATID, buyerVATID, vatTreatment, currency, notes,
+		salesRepIDVal, requestUser(r)).Scan(&orderID, &createdAt, &updatedAt)
 
 	if err != nil {
 		// Error:"Failed to create sales order", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to create sales order")
+		writeError(w, http.StatusInternalServerError, "Failed to create sales order")
 		return
 	}
 
 	// Copy quote items to order items
 	copyItemsQuery := `
-		INSERT INTO sales_order_items (order_id, product_id, quantity, unit_price,
-		                               discount_percent, discount_amount, notes)
-		SELECT $1, product_id, quantity, unit_price, discount_percent, discount_amount, notes
+		INSERT INTO sales_order_items (tenant_id, order_id, product_id, quantity, unit_price,
+		                               discount_percent, discount_amount, tax_category, notes)
+		SELECT tenant_id, $1, product_id, quantity, unit_price, discount_percent, discount_amount, tax_category, notes
 		FROM sales_quote_items
-		WHERE quote_id = $2
+		WHERE quote_id = $2 AND tenant_id = $3
 	`
 
-	_, err = tx.Exec(copyItemsQuery, orderID, quoteID)
+	_, err = tx.Exec(copyItemsQuery, orderID, quoteID, tenantID)
 	if err != nil {
 		// Error:"Failed to copy quote items", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to copy quote items")
+		writeError(w, http.StatusInternalServerError, "Failed to copy quote items")
+		return
+	}
+
+	totals, err := h.recalculateTotals(tx, tenantID, orderDocument, orderID)
+	if err != nil {
+		h.logger.Error("Failed to calculate order totals", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to convert quote")
 		return
 	}
 
 	// Update quote status
-	_, err = tx.Exec("UPDATE sales_quotes SET status = 'accepted' WHERE id = $1", quoteID)
+	_, err = tx.Exec("UPDATE sales_quotes SET status = 'accepted' WHERE id = $1 AND tenant_id = $2", quoteID, tenantID)
 	if err != nil {
 		// Error:"Failed to update quote status", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to update quote status")
+		writeError(w, http.StatusInternalServerError, "Failed to update quote status")
+		return
+	}
+
+	err = recordEvent(tx, tenantID, OrderCreated{
+		OrderID:     orderID,
+		OrderNumber: orderNumber,
+		CustomerID:  customerID,
+		QuoteID:     &quoteID,
+		SalesRepID:  salesRepIDVal,
+		TotalAmount: totals.TotalAmount,
+		Currency:    currency,
+	})
+	if err == nil {
+		err = recordEvent(tx, tenantID, QuoteConverted{
+			QuoteID:     quoteID,
+			OrderID:     orderID,
+			OrderNumber: orderNumber,
+		})
+	}
+	if err != nil {
+		h.logger.Error("Failed to record quote conversion events", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to convert quote")
 		return
 	}
 
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
 		// Error:"Failed to commit transaction", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to convert quote")
+		writeError(w, http.StatusInternalServerError, "Failed to convert quote")
 		return
 	}
 
@@ -888,47 +1575,161 @@ func (h *SalesHandler) GetSalesReport(w http.ResponseWriter, r *http.Request) {
 	endDate := r.URL.Query().Get("end_date")
 
 	if startDate == "" || endDate == "" {
-		sdk.WriteError(w, http.StatusBadRequest, "Start date and end date are required")
+		writeError(w, http.StatusBadRequest, "Start date and end date are required")
 		return
 	}
 
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to generate sales report")
+		return
+	}
+	defer tx.Rollback()
+
+	// Invoices and credit notes count towards the rep of the order they bill
+	scope := h.requestDataScope(tx, r)
+	orderScope, scopeArgs := scope.condition("sales_rep_id", 4)
+	invoiceScope, _ := scope.condition(`(SELECT scope_so.sales_rep_id FROM sales_orders scope_so
+		WHERE scope_so.id = sales_invoices.order_id)`, 4)
+	creditNoteScope, _ := scope.condition(`(SELECT scope_so.sales_rep_id FROM sales_invoices scope_si
+		JOIN sales_orders scope_so ON scope_so.id = scope_si.order_id
+		WHERE scope_si.id = sales_credit_notes.invoice_id)`, 4)
+
 	query := `
 		SELECT 
 			COUNT(*) as total_orders,
 			SUM(total_amount) as total_sales,
 			AVG(total_amount) as average_order_value,
-			COUNT(CASE WHEN status = 'completed' THEN 1 END) as completed_orders,
-			SUM(CASE WHEN status = 'completed' THEN total_amount ELSE 0 END) as completed_sales
+			COUNT(CASE WHEN status = 'delivered' THEN 1 END) as completed_orders,
+			SUM(CASE WHEN status = 'delivered' THEN total_amount ELSE 0 END) as completed_sales
 		FROM sales_orders
-		WHERE order_date BETWEEN $1 AND $2
-	`
+		WHERE tenant_id = $1 AND order_date BETWEEN $2 AND $3` + orderScope
 
-	var report struct {
-		TotalOrders       int     `json:"total_orders"`
-		TotalSales        float64 `json:"total_sales"`
-		AverageOrderValue float64 `json:"average_order_value"`
-		CompletedOrders   int     `json:"completed_orders"`
-		CompletedSales    float64 `json:"completed_sales"`
-	}
+	var report SalesReport
 
-	err := h.db.QueryRow(query, startDate, endDate).Scan(
+	err = tx.QueryRow(query, append([]interface{}{tenantID, startDate, endDate}, scopeArgs...)...).Scan(
 		&report.TotalOrders, &report.TotalSales, &report.AverageOrderValue,
 		&report.CompletedOrders, &report.CompletedSales,
 	)
 
 	if err != nil {
 		// Error:"Failed to generate sales report", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to generate sales report")
+		writeError(w, http.StatusInternalServerError, "Failed to generate sales report")
 		return
 	}
 
+	// Net sales are invoiced revenue less the credit notes issued in the same period.
+	// Voided invoices never counted; invoices cancelled by credit notes are netted out.
+	var invoicedSales, creditNotesTotal Decimal
+	err = tx.QueryRow(`
+		SELECT
+			(SELECT COALESCE(SUM(total_amount), 0)
+			 FROM sales_invoices
+			 WHERE tenant_id = $1 AND invoice_date BETWEEN $2 AND $3
+			   AND status != 'draft' AND voided_at IS NULL`+invoiceScope+`),
+			(SELECT COALESCE(SUM(total_amount), 0)
+			 FROM sales_credit_notes
+			 WHERE tenant_id = $1 AND credit_date BETWEEN $2 AND $3`+creditNoteScope+`)
+	`, append([]interface{}{tenantID, startDate, endDate}, scopeArgs...)...).Scan(&invoicedSales, &creditNotesTotal)
+	if err != nil {
+		h.logger.Error("Failed to calculate net sales", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to generate sales report")
+		return
+	}
+	report.InvoicedSales = invoicedSales
+	report.CreditNotesTotal = creditNotesTotal
+	report.NetSales = invoicedSales.Sub(creditNotesTotal)
+
 	sdk.WriteJSON(w, http.StatusOK, report)
 }
 
+// GetVATSummaryReport totals the tax charged on issued invoices in a period by VAT
+// treatment, ship-to country and rate, from the tax breakdown stored on each line
+func (h *SalesHandler) GetVATSummaryReport(w http.ResponseWriter, r *http.Request) {
+	startDate := r.URL.Query().Get("start_date")
+	endDate := r.URL.Query().Get("end_date")
+
+	if startDate == "" || endDate == "" {
+		writeError(w, http.StatusBadRequest, "Start date and end date are required")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to generate VAT summary")
+		return
+	}
+	defer tx.Rollback()
+
+	invoiceScope, scopeArgs := h.requestDataScope(tx, r).condition(`(SELECT scope_so.sales_rep_id FROM sales_orders scope_so
+		WHERE scope_so.id = sales_invoices.order_id)`, 4)
+
+	rows, err := tx.Query(`
+		SELECT COALESCE(sales_invoices.vat_treatment, ''), COALESCE(UPPER(sales_invoices.ship_to_country), ''),
+		       sales_invoices.currency, t.rate, COUNT(DISTINCT sales_invoices.id),
+		       SUM(t.taxable_amount), SUM(t.tax_amount)
+		FROM sales_invoices
+		JOIN sales_invoice_items sii ON sii.invoice_id = sales_invoices.id AND sii.tenant_id = sales_invoices.tenant_id
+		CROSS JOIN LATERAL jsonb_to_recordset(sii.taxes) AS t(rate DECIMAL, taxable_amount DECIMAL, tax_amount DECIMAL)
+		WHERE sales_invoices.tenant_id = $1 AND sales_invoices.invoice_date BETWEEN $2 AND $3
+		  AND sales_invoices.status != 'draft' AND sales_invoices.voided_at IS NULL`+invoiceScope+`
+		GROUP BY 1, 2, 3, 4
+		ORDER BY 1, 2, 3, 4
+	`, append([]interface{}{tenantID, startDate, endDate}, scopeArgs...)...)
+	if err != nil {
+		h.logger.Error("Failed to generate VAT summary", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to generate VAT summary")
+		return
+	}
+	defer rows.Close()
+
+	lines := []VATSummaryLine{}
+	for rows.Next() {
+		var line VATSummaryLine
+		var treatment, country string
+		err := rows.Scan(&treatment, &country, &line.Currency, &line.Rate, &line.InvoiceCount,
+			&line.TaxableAmount, &line.TaxAmount)
+		if err != nil {
+			h.logger.Error("Failed to scan VAT summary line", zap.Error(err))
+			continue
+		}
+		if treatment != "" {
+			line.VATTreatment = &treatment
+		}
+		if country != "" {
+			line.Country = &country
+		}
+		lines = append(lines, line)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"start_date": startDate,
+		"end_date":   endDate,
+		"lines":      lines,
+		"count":      len(lines),
+	})
+}
+
 // Advanced Sales Features
 
 // GetSalesPipeline retrieves sales pipeline data
 func (h *SalesHandler) GetSalesPipeline(w http.ResponseWriter, r *http.Request) {
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales pipeline")
+		return
+	}
+	defer tx.Rollback()
+
+	scopeCondition, scopeArgs := h.requestDataScope(tx, r).condition("so.sales_rep_id", 2)
+
 	query := `
 		SELECT 
 			so.status,
@@ -936,7 +1737,7 @@ func (h *SalesHandler) GetSalesPipeline(w http.ResponseWriter, r *http.Request)
 			SUM(so.total_amount) as total_value,
 			AVG(so.total_amount) as average_value
 		FROM sales_orders so
-		WHERE so.order_date >= CURRENT_DATE - INTERVAL '30 days'
+		WHERE so.tenant_id = $1 AND so.order_date >= CURRENT_DATE - INTERVAL '30 days'` + scopeCondition + `
 		GROUP BY so.status
 		ORDER BY 
 			CASE so.status
@@ -949,10 +1750,10 @@ func (h *SalesHandler) GetSalesPipeline(w http.ResponseWriter, r *http.Request)
 			END
 	`
 
-	rows, err := h.db.Query(query)
+	rows, err := tx.Query(query, append([]interface{}{requestTenant(r)}, scopeArgs...)...)
 	if err != nil {
 		// Error:"Failed to fetch sales pipeline", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch sales pipeline")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales pipeline")
 		return
 	}
 	defer rows.Close()
@@ -980,6 +1781,16 @@ func (h *SalesHandler) GetSalesForecast(w http.ResponseWriter, r *http.Request)
 		period = "monthly"
 	}
 
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales forecast")
+		return
+	}
+	defer tx.Rollback()
+
+	scopeCondition, scopeArgs := h.requestDataScope(tx, r).condition("sales_rep_id", 2)
+
 	var forecastQuery string
 	var groupBy string
 
@@ -992,8 +1803,8 @@ func (h *SalesHandler) GetSalesForecast(w http.ResponseWriter, r *http.Request)
 				COUNT(*) as order_count,
 				AVG(total_amount) as average_order_value
 			FROM sales_orders
-			WHERE order_date >= CURRENT_DATE - INTERVAL '12 months'
-			  AND status IN ('delivered', 'shipped')
+			WHERE tenant_id = $1 AND order_date >= CURRENT_DATE - INTERVAL '12 months'
+			  AND status IN ('delivered', 'shipped')` + scopeCondition + `
 			GROUP BY DATE_TRUNC('month', order_date)
 			ORDER BY period
 		`
@@ -1006,8 +1817,8 @@ func (h *SalesHandler) GetSalesForecast(w http.ResponseWriter, r *http.Request)
 				COUNT(*) as order_count,
 				AVG(total_amount) as average_order_value
 			FROM sales_orders
-			WHERE order_date >= CURRENT_DATE - INTERVAL '4 quarters'
-			  AND status IN ('delivered', 'shipped')
+			WHERE tenant_id = $1 AND order_date >= CURRENT_DATE - INTERVAL '4 quarters'
+			  AND status IN ('delivered', 'shipped')` + scopeCondition + `
 			GROUP BY DATE_TRUNC('quarter', order_date)
 			ORDER BY period
 		`
@@ -1020,18 +1831,18 @@ func (h *SalesHandler) GetSalesForecast(w http.ResponseWriter, r *http.Request)
 				COUNT(*) as order_count,
 				AVG(total_amount) as average_order_value
 			FROM sales_orders
-			WHERE order_date >= CURRENT_DATE - INTERVAL '3 years'
-			  AND status IN ('delivered', 'shipped')
+			WHERE tenant_id = $1 AND order_date >= CURRENT_DATE - INTERVAL '3 years'
+			  AND status IN ('delivered', 'shipped')` + scopeCondition + `
 			GROUP BY DATE_TRUNC('year', order_date)
 			ORDER BY period
 		`
 		groupBy = "year"
 	}
 
-	rows, err := h.db.Query(forecastQuery)
+	rows, err := tx.Query(forecastQuery, append([]interface{}{requestTenant(r)}, scopeArgs...)...)
 	if err != nil {
 		// Error:"Failed to fetch sales forecast", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch sales forecast")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales forecast")
 		return
 	}
 	defer rows.Close()
@@ -1082,6 +1893,16 @@ func (h *SalesHandler) GetTopCustomers(w http.ResponseWriter, r *http.Request) {
 		periodCondition = ""
 	}
 
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch top customers")
+		return
+	}
+	defer tx.Rollback()
+
+	scopeCondition, scopeArgs := h.requestDataScope(tx, r).condition("so.sales_rep_id", 3)
+
 	query := fmt.Sprintf(`
 		SELECT 
 			c.id, c.customer_number, c.company_name, c.first_name, c.last_name, c.email,
@@ -1092,16 +1913,16 @@ func (h *SalesHandler) GetTopCustomers(w http.ResponseWriter, r *http.Request) {
 			MIN(so.order_date) as first_order_date
 		FROM customers c
 		JOIN sales_orders so ON c.id = so.customer_id
-		WHERE so.status IN ('delivered', 'shipped') %s
+		WHERE so.tenant_id = $1 AND so.status IN ('delivered', 'shipped') %s %s
 		GROUP BY c.id, c.customer_number, c.company_name, c.first_name, c.last_name, c.email
 		ORDER BY total_spent DESC
-		LIMIT $1
-	`, periodCondition)
+		LIMIT $2
+	`, periodCondition, scopeCondition)
 
-	rows, err := h.db.Query(query, limit)
+	rows, err := tx.Query(query, append([]interface{}{requestTenant(r), limit}, scopeArgs...)...)
 	if err != nil {
 		// Error:"Failed to fetch top customers", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch top customers")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch top customers")
 		return
 	}
 	defer rows.Close()
@@ -1135,10 +1956,18 @@ func (h *SalesHandler) GetSalesPerformance(w http.ResponseWriter, r *http.Reques
 	salesRepID := r.URL.Query().Get("sales_rep_id")
 
 	if startDate == "" || endDate == "" {
-		sdk.WriteError(w, http.StatusBadRequest, "Start date and end date are required")
+		writeError(w, http.StatusBadRequest, "Start date and end date are required")
 		return
 	}
 
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales performance")
+		return
+	}
+	defer tx.Rollback()
+
 	query := `
 		SELECT 
 			sr.id as rep_id,
@@ -1151,12 +1980,18 @@ func (h *SalesHandler) GetSalesPerformance(w http.ResponseWriter, r *http.Reques
 			SUM(CASE WHEN so.status = 'cancelled' THEN so.total_amount ELSE 0 END) as lost_sales
 		FROM sales_representatives sr
 		LEFT JOIN sales_orders so ON sr.id = so.sales_rep_id
-			AND so.order_date BETWEEN $1 AND $2
-		WHERE sr.is_active = true
+			AND so.tenant_id = sr.tenant_id
+			AND so.order_date BETWEEN $2 AND $3
+		WHERE sr.tenant_id = $1 AND sr.is_active = true
 	`
 
-	args := []interface{}{startDate, endDate}
-	argIndex := 3
+	args := []interface{}{requestTenant(r), startDate, endDate}
+	argIndex := 4
+
+	scopeCondition, scopeArgs := h.requestDataScope(tx, r).condition("sr.id", argIndex)
+	query += scopeCondition
+	args = append(args, scopeArgs...)
+	argIndex += len(scopeArgs)
 
 	if salesRepID != "" {
 		query += fmt.Sprintf(" AND sr.id = $%d", argIndex)
@@ -1166,10 +2001,10 @@ func (h *SalesHandler) GetSalesPerformance(w http.ResponseWriter, r *http.Reques
 
 	query += " GROUP BY sr.id, sr.first_name, sr.last_name ORDER BY total_sales DESC"
 
-	rows, err := h.db.Query(query, args...)
+	rows, err := tx.Query(query, args...)
 	if err != nil {
 		// Error:"Failed to fetch sales performance", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch sales performance")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch sales performance")
 		return
 	}
 	defer rows.Close()
@@ -1186,8 +2021,8 @@ func (h *SalesHandler) GetSalesPerformance(w http.ResponseWriter, r *http.Reques
 		}
 
 		// Calculate conversion rate
-		if perf.TotalSales > 0 {
-			perf.ConversionRate = (perf.ClosedSales / perf.TotalSales) * 100
+		if perf.TotalSales.Sign() > 0 {
+			perf.ConversionRate = perf.ClosedSales.MulDiv(decimalFromInt(100), perf.TotalSales, 2)
 		}
 
 		performance = append(performance, perf)
@@ -1211,10 +2046,20 @@ func (h *SalesHandler) GetProductSalesAnalysis(w http.ResponseWriter, r *http.Re
 	}
 
 	if startDate == "" || endDate == "" {
-		sdk.WriteError(w, http.StatusBadRequest, "Start date and end date are required")
+		writeError(w, http.StatusBadRequest, "Start date and end date are required")
 		return
 	}
 
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch product sales analysis")
+		return
+	}
+	defer tx.Rollback()
+
+	scopeCondition, scopeArgs := h.requestDataScope(tx, r).condition("so.sales_rep_id", 5)
+
 	query := `
 		SELECT 
 			p.id, p.name, p.sku, p.cost_price, p.selling_price,
@@ -1227,18 +2072,18 @@ func (h *SalesHandler) GetProductSalesAnalysis(w http.ResponseWriter, r *http.Re
 		FROM products p
 		JOIN sales_order_items soi ON p.id = soi.product_id
 		JOIN sales_orders so ON soi.order_id = so.id
-		WHERE so.order_date BETWEEN $1 AND $2
+		WHERE so.tenant_id = $1 AND so.order_date BETWEEN $2 AND $3
 		  AND so.status IN ('delivered', 'shipped')
-		  AND p.is_active = true
+		  AND p.is_active = true` + scopeCondition + `
 		GROUP BY p.id, p.name, p.sku, p.cost_price, p.selling_price
 		ORDER BY total_revenue DESC
-		LIMIT $3
+		LIMIT $4
 	`
 
-	rows, err := h.db.Query(query, startDate, endDate, limit)
+	rows, err := tx.Query(query, append([]interface{}{requestTenant(r), startDate, endDate, limit}, scopeArgs...)...)
 	if err != nil {
 		// Error:"Failed to fetch product sales analysis", zap.Error(err))
-		sdk.WriteError(w, http.StatusInternalServerError, "Failed to fetch product sales analysis")
+		writeError(w, http.StatusInternalServerError, "Failed to fetch product sales analysis")
 		return
 	}
 	defer rows.Close()
@@ -1256,8 +2101,8 @@ func (h *SalesHandler) GetProductSalesAnalysis(w http.ResponseWriter, r *http.Re
 		}
 
 		// Calculate profit margin
-		if item.TotalRevenue > 0 {
-			item.ProfitMargin = (item.TotalProfit / item.TotalRevenue) * 100
+		if item.TotalRevenue.Sign() > 0 {
+			item.ProfitMargin = item.TotalProfit.MulDiv(decimalFromInt(100), item.TotalRevenue, 2)
 		}
 
 		analysis = append(analysis, item)
@@ -1286,11 +2131,11 @@ type SalesOrderWithDetails struct {
 	ShippingAddress map[string]interface{}      `json:"shipping_address"`
 	BillingAddress  map[string]interface{}      `json:"billing_address"`
 	Notes           string                      `json:"notes"`
-	Subtotal        float64                     `json:"subtotal"`
-	TaxAmount       float64                     `json:"tax_amount"`
-	ShippingAmount  float64                     `json:"shipping_amount"`
-	DiscountAmount  float64                     `json:"discount_amount"`
-	TotalAmount     float64                     `json:"total_amount"`
+	Subtotal        Decimal                     `json:"subtotal"`
+	TaxAmount       Decimal                     `json:"tax_amount"`
+	ShippingAmount  Decimal                     `json:"shipping_amount"`
+	DiscountAmount  Decimal                     `json:"discount_amount"`
+	TotalAmount     Decimal                     `json:"total_amount"`
 	CreatedAt       time.Time                   `json:"created_at"`
 	UpdatedAt       time.Time                   `json:"updated_at"`
 	Customer        *CustomerInfo               `json:"customer"`
@@ -1303,9 +2148,9 @@ type SalesOrderItemWithDetails struct {
 	OrderID         int       `json:"order_id"`
 	ProductID       int       `json:"product_id"`
 	Quantity        int       `json:"quantity"`
-	UnitPrice       float64   `json:"unit_price"`
-	DiscountPercent float64   `json:"discount_percent"`
-	LineTotal       float64   `json:"line_total"`
+	UnitPrice       Decimal   `json:"unit_price"`
+	DiscountPercent Decimal   `json:"discount_percent"`
+	LineTotal       Decimal   `json:"line_total"`
 	Notes           string    `json:"notes"`
 	CreatedAt       time.Time `json:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at"`
@@ -1329,33 +2174,57 @@ type SalesRepInfo struct {
 
 // Helper functions
 
+// requestError is a request rejected by a business rule, carrying the HTTP status to report
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+// writeRequestError reports err to the client, logging anything that is not a requestError
+func (h *SalesHandler) writeRequestError(w http.ResponseWriter, err error, fallback string) {
+	switch rerr := err.(type) {
+	case *requestError:
+		writeError(w, rerr.status, rerr.message)
+		return
+	case *validationError:
+		writeValidationError(w, rerr.fields)
+		return
+	}
+	h.logger.Error(fallback, zap.Error(err))
+	writeError(w, http.StatusInternalServerError, fallback)
+}
+
 func (h *SalesHandler) calculateSalesForecast(historicalData []SalesDataPoint, groupBy string) []ForecastDataPoint {
 	if len(historicalData) < 2 {
 		return []ForecastDataPoint{}
 	}
 
-	// Simple linear regression
+	// Simple linear regression of sales over the period index x. The prediction at x is
+	// (slopeNum × (n·x − Σx) / denom + Σy) / n, computed exactly and rounded once.
 	n := len(historicalData)
-	var sumX, sumY, sumXY, sumXX float64
+	var sumX, sumXX int
+	var sumY, sumXY Decimal
 
-	for i, data := range historicalData {
-		x := float64(i)
-		y := data.ActualSales
+	for x, data := range historicalData {
 		sumX += x
-		sumY += y
-		sumXY += x * y
 		sumXX += x * x
+		sumY = sumY.Add(data.ActualSales)
+		sumXY = sumXY.Add(data.ActualSales.MulInt(x))
 	}
 
-	// Calculate slope and intercept
-	slope := (float64(n)*sumXY - sumX*sumY) / (float64(n)*sumXX - sumX*sumX)
-	intercept := (sumY - slope*sumX) / float64(n)
+	slopeNum := sumXY.MulInt(n).Sub(sumY.MulInt(sumX))
+	denom := decimalFromInt(n*sumXX - sumX*sumX)
 
 	// Generate forecast for next 3 periods
 	var forecast []ForecastDataPoint
 	for i := 0; i < 3; i++ {
-		x := float64(n + i)
-		predicted := slope*x + intercept
+		x := n + i
+		predicted := slopeNum.MulDiv(decimalFromInt(n*x-sumX), denom, decimalPlaces).Add(sumY).
+			MulDiv(decimalFromInt(1), decimalFromInt(n), 2)
 
 		forecast = append(forecast, ForecastDataPoint{
 			Period:    fmt.Sprintf("Forecast %d", i+1),
@@ -1368,23 +2237,47 @@ func (h *SalesHandler) calculateSalesForecast(historicalData []SalesDataPoint, g
 
 // Additional types for enhanced features
 
+// SalesReport summarises orders, invoices and credit notes over a period
+type SalesReport struct {
+	TotalOrders       int     `json:"total_orders"`
+	TotalSales        Decimal `json:"total_sales"`
+	AverageOrderValue Decimal `json:"average_order_value"`
+	CompletedOrders   int     `json:"completed_orders"`
+	CompletedSales    Decimal `json:"completed_sales"`
+	InvoicedSales     Decimal `json:"invoiced_sales"`
+	CreditNotesTotal  Decimal `json:"credit_notes_total"`
+	NetSales          Decimal `json:"net_sales"`
+}
+
+// VATSummaryLine is the tax charged at one rate on invoices of one VAT treatment shipping
+// to one country
+type VATSummaryLine struct {
+	VATTreatment  *string `json:"vat_treatment"`
+	Country       *string `json:"country"`
+	Currency      string  `json:"currency"`
+	Rate          Decimal `json:"rate"`
+	InvoiceCount  int     `json:"invoice_count"`
+	TaxableAmount Decimal `json:"taxable_amount"`
+	TaxAmount     Decimal `json:"tax_amount"`
+}
+
 type PipelineStage struct {
 	Status       string  `json:"status"`
 	Count        int     `json:"count"`
-	TotalValue   float64 `json:"total_value"`
-	AverageValue float64 `json:"average_value"`
+	TotalValue   Decimal `json:"total_value"`
+	AverageValue Decimal `json:"average_value"`
 }
 
 type SalesDataPoint struct {
 	Period            time.Time `json:"period"`
-	ActualSales       float64   `json:"actual_sales"`
+	ActualSales       Decimal   `json:"actual_sales"`
 	OrderCount        int       `json:"order_count"`
-	AverageOrderValue float64   `json:"average_order_value"`
+	AverageOrderValue Decimal   `json:"average_order_value"`
 }
 
 type ForecastDataPoint struct {
 	Period    string  `json:"period"`
-	Predicted float64 `json:"predicted"`
+	Predicted Decimal `json:"predicted"`
 }
 
 type TopCustomer struct {
@@ -1395,8 +2288,8 @@ type TopCustomer struct {
 	LastName          *string    `json:"last_name"`
 	Email             *string    `json:"email"`
 	TotalOrders       int        `json:"total_orders"`
-	TotalSpent        float64    `json:"total_spent"`
-	AverageOrderValue float64    `json:"average_order_value"`
+	TotalSpent        Decimal    `json:"total_spent"`
+	AverageOrderValue Decimal    `json:"average_order_value"`
 	LastOrderDate     *time.Time `json:"last_order_date"`
 	FirstOrderDate    *time.Time `json:"first_order_date"`
 }
@@ -1405,27 +2298,27 @@ type SalesPerformance struct {
 	RepID             int     `json:"rep_id"`
 	RepName           string  `json:"rep_name"`
 	TotalOrders       int     `json:"total_orders"`
-	TotalSales        float64 `json:"total_sales"`
-	AverageOrderValue float64 `json:"average_order_value"`
+	TotalSales        Decimal `json:"total_sales"`
+	AverageOrderValue Decimal `json:"average_order_value"`
 	UniqueCustomers   int     `json:"unique_customers"`
-	ClosedSales       float64 `json:"closed_sales"`
-	LostSales         float64 `json:"lost_sales"`
-	ConversionRate    float64 `json:"conversion_rate"`
+	ClosedSales       Decimal `json:"closed_sales"`
+	LostSales         Decimal `json:"lost_sales"`
+	ConversionRate    Decimal `json:"conversion_rate"`
 }
 
 type ProductSalesAnalysis struct {
 	ProductID               int     `json:"product_id"`
 	ProductName             string  `json:"product_name"`
 	SKU                     string  `json:"sku"`
-	CostPrice               float64 `json:"cost_price"`
-	SellingPrice            float64 `json:"selling_price"`
+	CostPrice               Decimal `json:"cost_price"`
+	SellingPrice            Decimal `json:"selling_price"`
 	TotalQuantitySold       int     `json:"total_quantity_sold"`
-	TotalRevenue            float64 `json:"total_revenue"`
+	TotalRevenue            Decimal `json:"total_revenue"`
 	OrderCount              int     `json:"order_count"`
 	AverageQuantityPerOrder float64 `json:"average_quantity_per_order"`
-	AverageSellingPrice     float64 `json:"average_selling_price"`
-	TotalProfit             float64 `json:"total_profit"`
-	ProfitMargin            float64 `json:"profit_margin"`
+	AverageSellingPrice     Decimal `json:"average_selling_price"`
+	TotalProfit             Decimal `json:"total_profit"`
+	ProfitMargin            Decimal `json:"profit_margin"`
 }
 
 // Helper functions for JSON responses
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quoteExtra names the customer and sales rep columns read with quoteColumns
+var quoteExtra = []string{"first_name", "last_name", "company_name", "email", "rep_first_name", "rep_last_name"}
+
+func TestUpdateQuoteRespectsLifecycle(t *testing.T) {
+	tests := []struct {
+		status, body string
+		want         int
+	}{
+		{"draft", `{"shipping_amount":5}`, http.StatusOK},
+		{"sent", `{"notes":"call first"}`, http.StatusOK},
+		{"sent", `{"shipping_amount":5}`, http.StatusConflict},
+		{"accepted", `{"notes":"call first"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		db := &scriptDB{}
+		db.returns("SELECT version FROM sales_quotes", "version", []driver.Value{int64(1)})
+		db.returns("FROM sales_quotes sq", strings.Join(columnNames(quoteColumns, quoteExtra...), " "),
+			append(documentRow(quoteColumns, map[string]driver.Value{"id": int64(8), "status": tt.status}),
+				nil, nil, nil, nil, nil, nil))
+		db.returns("SELECT currency, document_discount_percent",
+			"currency document_discount_percent document_discount_amount shipping_amount prices_include_tax "+
+				"tax_rate tax_amount customer_id quote_date ship_to_country ship_to_region ship_to_city "+
+				"ship_to_postal_code seller_vat_id vat_treatment",
+			[]driver.Value{"USD", "0", "0", "5", false, "10", "0", int64(1),
+				time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), nil, nil, nil, nil, nil, nil})
+
+		req := callerRequest("PUT", "/quotes/8", tt.body)
+		req.Header.Set("If-Match", `"1"`)
+		rec := serve(t, db.plugin(), req)
+		if rec.Code != tt.want {
+			t.Errorf("%s quote with %s: status = %d, want %d: %s", tt.status, tt.body, rec.Code, tt.want,
+				rec.Body.String())
+		}
+		if updated := len(db.ran("UPDATE sales_quotes SET")) > 0; updated != (tt.want == http.StatusOK) {
+			t.Errorf("%s quote with %s: updated = %v", tt.status, tt.body, updated)
+		}
+	}
+}
+
+func TestCreateDocumentCurrency(t *testing.T) {
+	tests := []struct {
+		currency, stored string
+		want             string
+	}{
+		{"", "", "USD"},
+		{"", "EUR", "EUR"},
+		{`,"currency":"gbp"`, "EUR", "GBP"},
+		{`,"currency":"XYZ"`, "EUR", ""},
+	}
+	// currencyArg is the position of the currency among the arguments of insert
+	for _, doc := range []struct {
+		path, body, insert string
+		currencyArg        int
+	}{
+		{"/orders", `{"customer_id":1,"order_date":"2026-03-02","items":[{"product_id":5,"quantity":1,"unit_price":10}]`,
+			"INSERT INTO sales_orders", 18},
+		{"/quotes", `{"customer_id":1,"quote_date":"2026-03-02","items":[{"product_id":5,"quantity":1,"unit_price":10}]`,
+			"INSERT INTO sales_quotes", 17},
+	} {
+		for _, tt := range tests {
+			db := &scriptDB{}
+			if tt.stored != "" {
+				db.returns("FROM sales_settings", "key value", []driver.Value{"default_currency", tt.stored})
+			}
+			scriptSales(db, salesFixture{})
+
+			rec := serve(t, db.plugin(), callerRequest("POST", doc.path, doc.body+tt.currency+"}"))
+			if tt.want == "" {
+				if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), `"field":"currency"`) {
+					t.Errorf("POST %s with %s: status = %d, want %d naming currency: %s", doc.path, tt.currency,
+						rec.Code, http.StatusUnprocessableEntity, rec.Body.String())
+				}
+				continue
+			}
+			if rec.Code != http.StatusCreated {
+				t.Errorf("POST %s with %q: status = %d, want %d: %s", doc.path, tt.currency, rec.Code,
+					http.StatusCreated, rec.Body.String())
+				continue
+			}
+			inserts := db.ran(doc.insert)
+			if len(inserts) != 1 {
+				t.Fatalf("POST %s: ran %q %d times, want once", doc.path, doc.insert, len(inserts))
+			}
+			if stored := inserts[0].args[doc.currencyArg]; stored != tt.want {
+				t.Errorf("POST %s with %q and default %q: stored currency %v, want %s", doc.path, tt.currency,
+					tt.stored, stored, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// scriptDB stands in for PostgreSQL so handlers can be run end to end. Each statement is
+// answered by the first rule whose fragment occurs in its SQL; a statement no rule matches
+// returns no rows and affects one. Every statement is logged along with the settings its
+// transaction was bound to by set_config.
+type scriptDB struct {
+	mu      sync.Mutex
+	rules   []scriptRule
+	log     []scriptStatement
+	commits int
+}
+
+type scriptRule struct {
+	fragment string
+	answer   func(st scriptStatement) scriptResult
+}
+
+// scriptStatement is a statement a handler ran
+type scriptStatement struct {
+	query    string
+	args     []driver.Value
+	settings map[string]string
+}
+
+// scriptResult answers a statement: rows for a query, the affected count for an exec
+type scriptResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// on answers statements containing fragment with answer
+func (s *scriptDB) on(fragment string, answer func(st scriptStatement) scriptResult) {
+	s.rules = append(s.rules, scriptRule{fragment, answer})
+}
+
+// returns answers statements containing fragment with rows of columns, which are named
+// with spaces between them
+func (s *scriptDB) returns(fragment, columns string, rows ...[]driver.Value) {
+	s.on(fragment, func(scriptStatement) scriptResult {
+		return scriptResult{columns: strings.Fields(columns), rows: rows, affected: int64(len(rows))}
+	})
+}
+
+// affects answers statements containing fragment as having changed n rows
+func (s *scriptDB) affects(fragment string, n int64) {
+	s.on(fragment, func(scriptStatement) scriptResult { return scriptResult{affected: n} })
+}
+
+// ran returns the statements run so far that contain fragment
+func (s *scriptDB) ran(fragment string) []scriptStatement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []scriptStatement
+	for _, st := range s.log {
+		if strings.Contains(st.query, fragment) {
+			found = append(found, st)
+		}
+	}
+	return found
+}
+
+// plugin returns the sales plugin running against the script
+func (s *scriptDB) plugin() *SalesPlugin {
+	db := sqlx.NewDb(sql.OpenDB(s), "postgres")
+	p := &SalesPlugin{logger: zap.NewNop(), handler: NewSalesHandler(db, zap.NewNop())}
+	p.router = newRouteTable(p.routes(), p.handler.idempotent)
+	return p
+}
+
+func (s *scriptDB) answer(st scriptStatement) scriptResult {
+	s.mu.Lock()
+	s.log = append(s.log, st)
+	rules := s.rules
+	s.mu.Unlock()
+	for _, rule := range rules {
+		if strings.Contains(st.query, rule.fragment) {
+			return rule.answer(st)
+		}
+	}
+	return scriptResult{affected: 1}
+}
+
+func (s *scriptDB) Connect(context.Context) (driver.Conn, error) {
+	return &scriptConn{db: s, settings: map[string]string{}}, nil
+}
+
+func (s *scriptDB) Driver() driver.Driver { return scriptDriver{} }
+
+type scriptDriver struct{}
+
+func (scriptDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("scriptDB connects through sql.OpenDB")
+}
+
+// scriptConn is a connection to a scriptDB. It is also its own transaction.
+type scriptConn struct {
+	db       *scriptDB
+	settings map[string]string
+}
+
+func (c *scriptConn) run(query string, named []driver.NamedValue) scriptResult {
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+	if strings.Contains(query, "set_config(") {
+		for i := 0; i+1 < len(args); i += 2 {
+			name, _ := args[i].(string)
+			value, _ := args[i+1].(string)
+			c.settings[name] = value
+		}
+	}
+	settings := map[string]string{}
+	for name, value := range c.settings {
+		settings[name] = value
+	}
+	return c.db.answer(scriptStatement{query: query, args: args, settings: settings})
+}
+
+func (c *scriptConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := c.run(query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &scriptRows{columns: res.columns, rows: res.rows}, nil
+}
+
+func (c *scriptConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res := c.run(query, args)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+// CheckNamedValue passes on values the default converter refuses, such as slices, as is
+func (c *scriptConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if v, err := driver.DefaultParameterConverter.ConvertValue(nv.Value); err == nil {
+		nv.Value = v
+	}
+	return nil
+}
+
+func (c *scriptConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("scriptDB does not prepare statements")
+}
+
+func (c *scriptConn) Begin() (driver.Tx, error) { return c, nil }
+
+func (c *scriptConn) Close() error { return nil }
+
+func (c *scriptConn) Commit() error {
+	c.db.mu.Lock()
+	c.db.commits++
+	c.db.mu.Unlock()
+	c.settings = map[string]string{}
+	return nil
+}
+
+func (c *scriptConn) Rollback() error {
+	c.settings = map[string]string{}
+	return nil
+}
+
+type scriptRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptRows) Columns() []string { return r.columns }
+
+func (r *scriptRows) Close() error { return nil }
+
+func (r *scriptRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// documentRow builds a row of a document column list such as invoiceColumns, taking the
+// values given by column name and defaulting the rest to those of a fresh draft
+func documentRow(columns string, values map[string]driver.Value) []driver.Value {
+	var row []driver.Value
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		if dot := strings.IndexByte(column, '.'); dot >= 0 {
+			column = column[dot+1:]
+		}
+		value, ok := values[column]
+		if !ok {
+			value = draftValue(column)
+		}
+		row = append(row, value)
+	}
+	return row
+}
+
+func draftValue(column string) driver.Value {
+	switch {
+	case column == "id" || column == "customer_id" || column == "created_by" || column == "version":
+		return int64(1)
+	case column == "status":
+		return "draft"
+	case column == "currency":
+		return "USD"
+	case column == "prices_include_tax":
+		return false
+	case strings.HasSuffix(column, "_number"):
+		return "DOC-1"
+	case strings.HasSuffix(column, "_date") || column == "valid_until" || column == "created_at" || column == "updated_at":
+		return time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	case column == "subtotal" || column == "balance_due" || strings.HasSuffix(column, "_amount") ||
+		strings.HasSuffix(column, "_percent"):
+		return "0"
+	}
+	return nil
+}
+
+// callerRequest is a request from the tenant of hostContext granted every permission
+func callerRequest(method, path, body string) *http.Request {
+	var grants []string
+	for _, tc := range routePermissions {
+		grants = append(grants, tc.permission)
+	}
+	return httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(hostContext(grants))
+}
+
+// serve runs req through the plugin's route for it
+func serve(t *testing.T, p *SalesPlugin, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	handler, err := p.GetHandler(req.URL.Path, req.Method)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// salesFixture describes the documents scriptSales gives the caller's tenant. Customer 1
+// and every product exist, and order 7 is confirmed with a line 11 of 3 units of product 5
+// at 10.00. Payments received get ID 51 and stay unapplied to the amount they were
+// recorded with. The customer has 50.00 of credit: 30.00 unapplied on payment 51 and 20.00
+// on credit note 61. Invoice 21 is sent for 30.00 plus 3.00 tax and its only line 41 bills 3
+// units at 10.00; credit notes issued get ID 61 and returns ID 71.
+type salesFixture struct {
+	// invoicedQuantity units of line 11, worth invoicedAmount, are already billed
+	invoicedQuantity int64
+	invoicedAmount   string
+	// shipped units of line 11 went out, of which returned are already on open returns
+	shipped, returned int64
+	// balances are the amounts due on sent invoices by ID, 100.00 on 21 and 30.00 on 22
+	// when not given
+	balances map[int64]string
+	// credited units of line 41 were credited before along with creditedTax
+	credited    int64
+	creditedTax string
+	// taxRate is what invoices are taxed at when their totals are recalculated, and
+	// invoiceLines the lines they are recalculated from
+	taxRate      driver.Value
+	invoiceLines [][]driver.Value
+}
+
+// newDocumentIDs are the IDs scriptSales gives documents inserted into each table
+var newDocumentIDs = map[string]int64{
+	"sales_orders":       31,
+	"sales_quotes":       31,
+	"sales_invoices":     31,
+	"sales_payments":     51,
+	"sales_credit_notes": 61,
+	"sales_returns":      71,
+}
+
+// scriptSales scripts the documents of f for a handler to read, numbers new documents from
+// a counter starting at 1 and answers their inserts with newDocumentIDs. Rules a test adds
+// before calling it take precedence.
+func scriptSales(db *scriptDB, f salesFixture) {
+	db.returns("SELECT 1 FROM", "found", []driver.Value{int64(1)})
+	var last int64
+	db.on("INSERT INTO sales_number_sequences", func(scriptStatement) scriptResult {
+		last++
+		return scriptResult{columns: []string{"last_number"}, rows: [][]driver.Value{{last}}}
+	})
+	var paid driver.Value
+	db.on("INSERT INTO sales_payments", func(st scriptStatement) scriptResult {
+		paid = st.args[5]
+		return insertedDocument(st)
+	})
+	db.on("SELECT customer_id, currency, unapplied_amount", func(scriptStatement) scriptResult {
+		return scriptResult{columns: []string{"customer_id", "currency", "unapplied_amount"},
+			rows: [][]driver.Value{{int64(1), "USD", paid}}}
+	})
+	db.on("INSERT INTO sales_", insertedDocument)
+	db.returns("FROM sales_customer_credit_ledger", "balance", []driver.Value{"50"})
+	db.returns("SELECT id, 0, unapplied_amount", "payment_id credit_note_id unapplied_amount",
+		[]driver.Value{int64(51), int64(0), "30"}, []driver.Value{int64(0), int64(61), "20"})
+
+	invoicedAmount := f.invoicedAmount
+	if invoicedAmount == "" {
+		invoicedAmount = "0"
+	}
+	db.returns("SELECT customer_id, status, currency, payment_terms",
+		"customer_id status currency payment_terms subtotal tax_rate tax_amount document_discount_amount "+
+			"shipping_amount prices_include_tax ship_to_country ship_to_region ship_to_city ship_to_postal_code "+
+			"seller_vat_id buyer_vat_id vat_treatment",
+		[]driver.Value{int64(1), "confirmed", "USD", nil, "30", "10", "3", "0", "0", false,
+			nil, nil, nil, nil, nil, nil, nil})
+	db.returns("tax_category, line_total, invoiced_quantity, invoiced_amount",
+		"id product_id quantity unit_price discount_percent discount_amount tax_category line_total "+
+			"invoiced_quantity invoiced_amount",
+		[]driver.Value{int64(11), int64(5), int64(3), "10", "0", "0", nil, "30", f.invoicedQuantity, invoicedAmount})
+	db.returns("SELECT COALESCE(SUM(shipping_amount), 0)", "sum", []driver.Value{"0"})
+	db.returns("SELECT customer_id, currency FROM sales_orders", "customer_id currency",
+		[]driver.Value{int64(1), "USD"})
+	db.returns("soi.shipped_quantity", "id product_id quantity line_total shipped_quantity returned_quantity",
+		[]driver.Value{int64(11), int64(5), int64(3), "30", f.shipped, f.returned})
+
+	balances := f.balances
+	if balances == nil {
+		balances = map[int64]string{21: "100", 22: "30"}
+	}
+	db.on("SELECT customer_id, status, currency, balance_due", func(st scriptStatement) scriptResult {
+		balance, ok := balances[st.args[0].(int64)]
+		if !ok {
+			return scriptResult{}
+		}
+		return scriptResult{columns: []string{"customer_id", "status", "currency", "balance_due"},
+			rows: [][]driver.Value{{int64(1), "sent", "USD", balance}}}
+	})
+	var open [][]driver.Value
+	for id, balance := range balances {
+		open = append(open, []driver.Value{id, balance})
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i][0].(int64) < open[j][0].(int64) })
+	db.returns("SELECT id, balance_due", "id balance_due", open...)
+
+	db.returns("SELECT customer_id, status, currency, subtotal",
+		"customer_id status currency subtotal document_discount_amount shipping_amount tax_amount "+
+			"prices_include_tax seller_vat_id buyer_vat_id vat_treatment balance_due",
+		[]driver.Value{int64(1), "sent", "USD", "30", "0", "0", "3", false, nil, nil, nil, balances[21]})
+	db.returns("SELECT id, product_id, quantity, unit_price, line_total, credited_quantity",
+		"id product_id quantity unit_price line_total credited_quantity",
+		[]driver.Value{int64(41), int64(5), int64(3), "10", "30", f.credited})
+	creditedTax := f.creditedTax
+	if creditedTax == "" {
+		creditedTax = "0"
+	}
+	db.returns("COALESCE(SUM(tax_amount), 0)", "discount shipping tax", []driver.Value{"0", "0", creditedTax})
+
+	taxRate := f.taxRate
+	if taxRate == nil {
+		taxRate = "10"
+	}
+	db.returns("SELECT currency, document_discount_percent",
+		"currency document_discount_percent document_discount_amount shipping_amount prices_include_tax "+
+			"tax_rate tax_amount customer_id invoice_date ship_to_country ship_to_region ship_to_city "+
+			"ship_to_postal_code seller_vat_id vat_treatment",
+		[]driver.Value{"USD", "0", "0", "0", false, taxRate, "0", int64(1),
+			time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), nil, nil, nil, nil, nil, nil})
+	db.returns("FROM sales_invoice_items i", "id quantity unit_price discount_percent discount_amount tax_category",
+		f.invoiceLines...)
+}
+
+// insertedDocument answers the insert of a document into a table of newDocumentIDs with
+// the columns it returns
+func insertedDocument(st scriptStatement) scriptResult {
+	table := strings.TrimPrefix(st.query[strings.Index(st.query, "INSERT INTO ")+len("INSERT INTO "):], " ")
+	if end := strings.IndexAny(table, " (\n\t"); end >= 0 {
+		table = table[:end]
+	}
+	id, ok := newDocumentIDs[table]
+	at := strings.LastIndex(st.query, "RETURNING ")
+	if !ok || at < 0 {
+		return scriptResult{affected: 1}
+	}
+	res := scriptResult{rows: [][]driver.Value{nil}, affected: 1}
+	for _, column := range strings.Split(st.query[at+len("RETURNING "):], ",") {
+		column = strings.TrimSpace(column)
+		res.columns = append(res.columns, column)
+		var value driver.Value = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+		if column == "id" {
+			value = id
+		}
+		res.rows[0] = append(res.rows[0], value)
+	}
+	return res
+}
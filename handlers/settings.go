@@ -0,0 +1,224 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// SalesSettings holds the module settings declared in module.yml
+type SalesSettings struct {
+	DefaultPaymentTerms   string  `json:"default_payment_terms"`
+	AutoGenerateInvoice   bool    `json:"auto_generate_invoice"`
+	AutoInvoiceOnStatus   string  `json:"auto_invoice_on_status"`
+	RequireApprovalAmount Decimal `json:"require_approval_amount"`
+	DefaultTaxRate        Decimal `json:"default_tax_rate"`
+	DefaultCurrency       string  `json:"default_currency"`
+	PricesIncludeTax      bool    `json:"prices_include_tax"`
+	EnableDiscounts       bool    `json:"enable_discounts"`
+	EnableCommissions     bool    `json:"enable_commissions"`
+	CommissionRate        Decimal `json:"commission_rate"`
+
+	// EU VAT: the seller's VAT ID, empty outside the EU, and whether sales to consumers in
+	// other member states are taxed at their rates under the One Stop Shop
+	SellerVATID      string `json:"seller_vat_id"`
+	VATOSSRegistered bool   `json:"vat_oss_registered"`
+
+	// NumberPatterns are the patterns each document type is numbered by, keyed by type
+	NumberPatterns map[string]string `json:"number_patterns"`
+
+	// IdempotencyWindowHours is how long the response to a request with an Idempotency-Key
+	// is kept for its retries
+	IdempotencyWindowHours int `json:"idempotency_window_hours"`
+
+	// Restocking fees by the condition a returned item arrives in, as a percentage of its value
+	RestockingFeeGoodPercent      Decimal `json:"restocking_fee_good_percent"`
+	RestockingFeeDamagedPercent   Decimal `json:"restocking_fee_damaged_percent"`
+	RestockingFeeDefectivePercent Decimal `json:"restocking_fee_defective_percent"`
+
+	// Rounding is how amounts derived line by line are rounded: roundingPerLine or
+	// roundingPerDocument
+	Rounding string `json:"rounding"`
+
+	// Record-level visibility: the roles granted each data scope, and the scope of callers
+	// with none of them
+	DataScopeAllRoles       []string `json:"data_scope_all_roles"`
+	DataScopeTerritoryRoles []string `json:"data_scope_territory_roles"`
+	DataScopeOwnRoles       []string `json:"data_scope_own_roles"`
+	DataScopeDefault        string   `json:"data_scope_default"`
+}
+
+// defaultSalesSettings returns the defaults declared in module.yml
+func defaultSalesSettings() SalesSettings {
+	return SalesSettings{
+		DefaultPaymentTerms:   "net_30",
+		AutoGenerateInvoice:   false,
+		AutoInvoiceOnStatus:   "shipped",
+		RequireApprovalAmount: decimalFromInt(1000),
+		DefaultTaxRate:        decimalFromInt(0),
+		DefaultCurrency:       "USD",
+		PricesIncludeTax:      false,
+		EnableDiscounts:       true,
+		EnableCommissions:     false,
+		CommissionRate:        decimalFromInt(5),
+
+		SellerVATID:      "",
+		VATOSSRegistered: false,
+
+		NumberPatterns: map[string]string{
+			numberQuote:      "SQ-{YYYY}-{seq:6}",
+			numberOrder:      "SO-{YYYY}-{seq:6}",
+			numberInvoice:    "INV-{YYYY}-{seq:6}",
+			numberCreditNote: "CN-{YYYY}-{seq:6}",
+			numberPayment:    "PAY-{YYYY}-{seq:6}",
+			numberReturn:     "RMA-{YYYY}-{seq:6}",
+		},
+
+		IdempotencyWindowHours: 24,
+
+		RestockingFeeGoodPercent:      decimalFromInt(15),
+		RestockingFeeDamagedPercent:   decimalFromInt(25),
+		RestockingFeeDefectivePercent: decimalFromInt(0),
+
+		Rounding: roundingPerLine,
+
+		DataScopeAllRoles:       []string{"admin"},
+		DataScopeTerritoryRoles: []string{"sales_manager"},
+		DataScopeOwnRoles:       []string{"sales_rep"},
+		DataScopeDefault:        dataScopeOwn,
+	}
+}
+
+// loadSettings reads the stored settings for a tenant, falling back to the module-wide
+// rows (tenant_id NULL) and then to the module.yml default for any key that is missing
+// or cannot be parsed. q must carry the tenant's session (see beginRequestTx).
+func (h *SalesHandler) loadSettings(q sqlx.Queryer, tenantID string) SalesSettings {
+	settings := defaultSalesSettings()
+
+	// Module-wide rows sort first so the tenant's own values win
+	rows, err := q.Query(`
+		SELECT key, value FROM sales_settings
+		WHERE tenant_id IS NULL OR tenant_id = $1
+		ORDER BY tenant_id NULLS FIRST
+	`, tenantID)
+	if err != nil {
+		h.logger.Warn("Failed to load sales settings, using defaults", zap.Error(err))
+		return settings
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var value *string
+		if err := rows.Scan(&key, &value); err != nil || value == nil {
+			continue
+		}
+
+		switch key {
+		case "default_payment_terms":
+			settings.DefaultPaymentTerms = *value
+		case "auto_generate_invoice":
+			if v, err := strconv.ParseBool(*value); err == nil {
+				settings.AutoGenerateInvoice = v
+			}
+		case "auto_invoice_on_status":
+			settings.AutoInvoiceOnStatus = *value
+		case "require_approval_amount":
+			if v, err := parseDecimal(*value); err == nil {
+				settings.RequireApprovalAmount = v
+			}
+		case "default_tax_rate":
+			if v, err := parseDecimal(*value); err == nil {
+				settings.DefaultTaxRate = v
+			}
+		case "default_currency":
+			if validCurrency(*value) {
+				settings.DefaultCurrency = strings.ToUpper(*value)
+			}
+		case "prices_include_tax":
+			if v, err := strconv.ParseBool(*value); err == nil {
+				settings.PricesIncludeTax = v
+			}
+		case "seller_vat_id":
+			if _, ok := vatIDCountry(*value); ok {
+				settings.SellerVATID = normalizeVATID(*value)
+			}
+		case "vat_oss_registered":
+			if v, err := strconv.ParseBool(*value); err == nil {
+				settings.VATOSSRegistered = v
+			}
+		case "quote_number_pattern", "order_number_pattern", "invoice_number_pattern",
+			"credit_note_number_pattern", "payment_number_pattern", "return_number_pattern":
+			if validNumberPattern(*value) {
+				settings.NumberPatterns[strings.TrimSuffix(key, "_number_pattern")] = *value
+			}
+		case "idempotency_window_hours":
+			if v, err := strconv.Atoi(*value); err == nil && v > 0 {
+				settings.IdempotencyWindowHours = v
+			}
+		case "enable_discounts":
+			if v, err := strconv.ParseBool(*value); err == nil {
+				settings.EnableDiscounts = v
+			}
+		case "enable_commissions":
+			if v, err := strconv.ParseBool(*value); err == nil {
+				settings.EnableCommissions = v
+			}
+		case "commission_rate":
+			if v, err := parseDecimal(*value); err == nil {
+				settings.CommissionRate = v
+			}
+		case "restocking_fee_good_percent":
+			if v, err := parseDecimal(*value); err == nil {
+				settings.RestockingFeeGoodPercent = v
+			}
+		case "restocking_fee_damaged_percent":
+			if v, err := parseDecimal(*value); err == nil {
+				settings.RestockingFeeDamagedPercent = v
+			}
+		case "restocking_fee_defective_percent":
+			if v, err := parseDecimal(*value); err == nil {
+				settings.RestockingFeeDefectivePercent = v
+			}
+		case "rounding":
+			if *value == roundingPerLine || *value == roundingPerDocument {
+				settings.Rounding = *value
+			}
+		case "data_scope_all_roles":
+			settings.DataScopeAllRoles = splitRoles(*value)
+		case "data_scope_territory_roles":
+			settings.DataScopeTerritoryRoles = splitRoles(*value)
+		case "data_scope_own_roles":
+			settings.DataScopeOwnRoles = splitRoles(*value)
+		case "data_scope_default":
+			settings.DataScopeDefault = *value
+		}
+	}
+
+	return settings
+}
+
+// vatTreatment determines the VAT treatment of a sale to buyerVATID shipping to
+// shipToCountry, returning the seller's VAT ID to record with it
+func (s SalesSettings) vatTreatment(buyerVATID, shipToCountry *string) (sellerVATID, treatment *string) {
+	if s.SellerVATID == "" {
+		return nil, nil
+	}
+	sellerVATID = &s.SellerVATID
+	return sellerVATID, determineVATTreatment(sellerVATID, buyerVATID, shipToCountry, s.VATOSSRegistered)
+}
+
+// restockingFeePercent returns the restocking fee charged for a returned item in condition
+func (s SalesSettings) restockingFeePercent(condition string) Decimal {
+	switch condition {
+	case "good":
+		return s.RestockingFeeGoodPercent
+	case "damaged":
+		return s.RestockingFeeDamagedPercent
+	case "defective":
+		return s.RestockingFeeDefectivePercent
+	}
+	return Decimal{}
+}
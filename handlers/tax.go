@@ -0,0 +1,258 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TaxJurisdiction is an area with taxes of its own: a country, or a region, city or range
+// of postal codes within one. Fields left empty cover the whole of the enclosing area.
+type TaxJurisdiction struct {
+	ID               int       `json:"id"`
+	Code             string    `json:"code"`
+	Name             string    `json:"name"`
+	Country          string    `json:"country"`
+	Region           *string   `json:"region"`
+	City             *string   `json:"city"`
+	PostalCodePrefix *string   `json:"postal_code_prefix"`
+	IsActive         bool      `json:"is_active"`
+	CreatedBy        int       `json:"created_by"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	UpdatedBy        *int      `json:"updated_by"`
+	Rates            []TaxRate `json:"rates,omitempty"`
+}
+
+// TaxRate is a rate a jurisdiction charges from ValidFrom until ValidTo. A rate with a tax
+// category applies to products of that category only; the others have none.
+type TaxRate struct {
+	ID             int        `json:"id"`
+	JurisdictionID int        `json:"jurisdiction_id"`
+	Name           string     `json:"name"`
+	TaxCategory    *string    `json:"tax_category"`
+	Rate           Decimal    `json:"rate"`
+	IsCompound     bool       `json:"is_compound"`
+	Priority       int        `json:"priority"`
+	ValidFrom      time.Time  `json:"valid_from"`
+	ValidTo        *time.Time `json:"valid_to"`
+	CreatedBy      int        `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	UpdatedBy      *int       `json:"updated_by"`
+}
+
+// TaxExemption is a customer's exemption certificate for one jurisdiction, or for every
+// jurisdiction when JurisdictionID is nil
+type TaxExemption struct {
+	ID                int        `json:"id"`
+	CustomerID        int        `json:"customer_id"`
+	CertificateNumber string     `json:"certificate_number"`
+	JurisdictionID    *int       `json:"jurisdiction_id"`
+	Reason            *string    `json:"reason"`
+	ValidFrom         time.Time  `json:"valid_from"`
+	ExpiresOn         *time.Time `json:"expires_on"`
+	CreatedBy         int        `json:"created_by"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	UpdatedBy         *int       `json:"updated_by"`
+}
+
+// taxRate is a rate charged on one document line. Exemption names the certificate that
+// exempts the customer from it.
+type taxRate struct {
+	Jurisdiction string  `json:"jurisdiction,omitempty"`
+	Name         string  `json:"name"`
+	Rate         Decimal `json:"rate"`
+	Compound     bool    `json:"compound,omitempty"`
+	Exemption    *string `json:"exemption_certificate,omitempty"`
+}
+
+// lineTax is one entry of a line's tax breakdown, as stored with the line
+type lineTax struct {
+	Jurisdiction  string  `json:"jurisdiction,omitempty"`
+	Name          string  `json:"name"`
+	Rate          Decimal `json:"rate"`
+	Compound      bool    `json:"compound,omitempty"`
+	TaxableAmount Decimal `json:"taxable_amount"`
+	TaxAmount     Decimal `json:"tax_amount"`
+	Exemption     *string `json:"exemption_certificate,omitempty"`
+}
+
+// taxAddress is the ship-to address of a document, which determines its tax
+type taxAddress struct {
+	Country    *string
+	Region     *string
+	City       *string
+	PostalCode *string
+}
+
+// covers reports whether addr lies within the jurisdiction. Names compare without regard
+// to case, postal codes without regard to case or spaces.
+func (j TaxJurisdiction) covers(addr taxAddress) bool {
+	matches := func(area, value *string) bool {
+		return area == nil || (value != nil && strings.EqualFold(strings.TrimSpace(*area), strings.TrimSpace(*value)))
+	}
+	if !matches(&j.Country, addr.Country) || !matches(j.Region, addr.Region) || !matches(j.City, addr.City) {
+		return false
+	}
+	if j.PostalCodePrefix == nil {
+		return true
+	}
+	return addr.PostalCode != nil &&
+		strings.HasPrefix(normalizePostalCode(*addr.PostalCode), normalizePostalCode(*j.PostalCodePrefix))
+}
+
+func normalizePostalCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(code, " ", ""))
+}
+
+// taxRules are the jurisdictions covering a document's address, each with the rates in
+// force on the document date, and the customer's exemptions valid on that date
+type taxRules struct {
+	jurisdictions []TaxJurisdiction
+	exemptions    []TaxExemption
+}
+
+// ratesFor lists the rates charged on a product of category, in priority order. Within
+// a jurisdiction, rates for the category replace the general ones.
+func (rules *taxRules) ratesFor(category *string) []taxRate {
+	type candidate struct {
+		jurisdiction TaxJurisdiction
+		rate         TaxRate
+	}
+	var candidates []candidate
+	for _, j := range rules.jurisdictions {
+		var general, specific []TaxRate
+		for _, rate := range j.Rates {
+			switch {
+			case rate.TaxCategory == nil:
+				general = append(general, rate)
+			case category != nil && strings.EqualFold(*rate.TaxCategory, *category):
+				specific = append(specific, rate)
+			}
+		}
+		if len(specific) == 0 {
+			specific = general
+		}
+		for _, rate := range specific {
+			candidates = append(candidates, candidate{j, rate})
+		}
+	}
+	sort.SliceStable(candidates, func(a, b int) bool {
+		return candidates[a].rate.Priority < candidates[b].rate.Priority
+	})
+
+	rates := make([]taxRate, 0, len(candidates))
+	for _, c := range candidates {
+		rates = append(rates, taxRate{
+			Jurisdiction: c.jurisdiction.Code,
+			Name:         c.rate.Name,
+			Rate:         c.rate.Rate,
+			Compound:     c.rate.IsCompound,
+			Exemption:    rules.exemption(c.jurisdiction.ID),
+		})
+	}
+	return rates
+}
+
+// exemption returns the number of the certificate exempting the customer from the taxes
+// of a jurisdiction, or nil
+func (rules *taxRules) exemption(jurisdictionID int) *string {
+	for _, e := range rules.exemptions {
+		if e.JurisdictionID == nil || *e.JurisdictionID == jurisdictionID {
+			number := e.CertificateNumber
+			return &number
+		}
+	}
+	return nil
+}
+
+// loadTaxRules looks up the tax of a document shipping to addr on date. It returns nil
+// when the address lies in no active jurisdiction, and the document falls back to its
+// own tax rate.
+func loadTaxRules(q sqlx.Queryer, tenantID string, addr taxAddress, customerID int, date time.Time) (*taxRules, error) {
+	if addr.Country == nil || strings.TrimSpace(*addr.Country) == "" {
+		return nil, nil
+	}
+
+	rows, err := q.Query(`
+		SELECT id, code, name, country, region, city, postal_code_prefix
+		FROM sales_tax_jurisdictions
+		WHERE tenant_id = $1 AND UPPER(country) = UPPER($2) AND is_active
+		ORDER BY id
+	`, tenantID, strings.TrimSpace(*addr.Country))
+	if err != nil {
+		return nil, err
+	}
+	rules := &taxRules{}
+	byID := map[int]int{}
+	for rows.Next() {
+		var j TaxJurisdiction
+		if err := rows.Scan(&j.ID, &j.Code, &j.Name, &j.Country, &j.Region, &j.City, &j.PostalCodePrefix); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if j.covers(addr) {
+			byID[j.ID] = len(rules.jurisdictions)
+			rules.jurisdictions = append(rules.jurisdictions, j)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(rules.jurisdictions) == 0 {
+		return nil, nil
+	}
+
+	rows, err = q.Query(`
+		SELECT r.id, r.jurisdiction_id, r.name, r.tax_category, r.rate, r.is_compound, r.priority
+		FROM sales_tax_rates r
+		JOIN sales_tax_jurisdictions j ON r.jurisdiction_id = j.id
+		WHERE r.tenant_id = $1 AND UPPER(j.country) = UPPER($2)
+		  AND r.valid_from <= $3 AND (r.valid_to IS NULL OR r.valid_to >= $3)
+		ORDER BY r.id
+	`, tenantID, strings.TrimSpace(*addr.Country), date)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var rate TaxRate
+		err := rows.Scan(&rate.ID, &rate.JurisdictionID, &rate.Name, &rate.TaxCategory, &rate.Rate,
+			&rate.IsCompound, &rate.Priority)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if i, ok := byID[rate.JurisdictionID]; ok {
+			rules.jurisdictions[i].Rates = append(rules.jurisdictions[i].Rates, rate)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`
+		SELECT id, certificate_number, jurisdiction_id
+		FROM sales_tax_exemptions
+		WHERE tenant_id = $1 AND customer_id = $2
+		  AND valid_from <= $3 AND (expires_on IS NULL OR expires_on >= $3)
+		ORDER BY id
+	`, tenantID, customerID, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e TaxExemption
+		if err := rows.Scan(&e.ID, &e.CertificateNumber, &e.JurisdictionID); err != nil {
+			return nil, err
+		}
+		rules.exemptions = append(rules.exemptions, e)
+	}
+	return rules, rows.Err()
+}
@@ -0,0 +1,937 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+const taxJurisdictionColumns = `
+	j.id, j.code, j.name, j.country, j.region, j.city, j.postal_code_prefix, j.is_active,
+	j.created_by, j.created_at, j.updated_at, j.updated_by
+`
+
+func scanTaxJurisdiction(row rowScanner, j *TaxJurisdiction) error {
+	return row.Scan(&j.ID, &j.Code, &j.Name, &j.Country, &j.Region, &j.City, &j.PostalCodePrefix,
+		&j.IsActive, &j.CreatedBy, &j.CreatedAt, &j.UpdatedAt, &j.UpdatedBy)
+}
+
+const taxRateColumns = `
+	r.id, r.jurisdiction_id, r.name, r.tax_category, r.rate, r.is_compound, r.priority, r.valid_from,
+	r.valid_to, r.created_by, r.created_at, r.updated_at, r.updated_by
+`
+
+func scanTaxRate(row rowScanner, rate *TaxRate) error {
+	return row.Scan(&rate.ID, &rate.JurisdictionID, &rate.Name, &rate.TaxCategory, &rate.Rate,
+		&rate.IsCompound, &rate.Priority, &rate.ValidFrom, &rate.ValidTo, &rate.CreatedBy, &rate.CreatedAt,
+		&rate.UpdatedAt, &rate.UpdatedBy)
+}
+
+const taxExemptionColumns = `
+	e.id, e.customer_id, e.certificate_number, e.jurisdiction_id, e.reason, e.valid_from, e.expires_on,
+	e.created_by, e.created_at, e.updated_at, e.updated_by
+`
+
+func scanTaxExemption(row rowScanner, e *TaxExemption) error {
+	return row.Scan(&e.ID, &e.CustomerID, &e.CertificateNumber, &e.JurisdictionID, &e.Reason, &e.ValidFrom,
+		&e.ExpiresOn, &e.CreatedBy, &e.CreatedAt, &e.UpdatedAt, &e.UpdatedBy)
+}
+
+// ProductTaxCategory assigns a product the rates of a tax category
+type ProductTaxCategory struct {
+	ProductID   int       `json:"product_id"`
+	TaxCategory string    `json:"tax_category"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UpdatedBy   *int      `json:"updated_by"`
+}
+
+// parseOptionalDate parses a date the validator has already checked, keeping nil as nil
+func parseOptionalDate(value *string) *time.Time {
+	if value == nil {
+		return nil
+	}
+	d, _ := time.Parse("2006-01-02", *value)
+	return &d
+}
+
+// GetTaxJurisdictions retrieves the tenant's tax jurisdictions with their rates
+func (h *SalesHandler) GetTaxJurisdictions(w http.ResponseWriter, r *http.Request) {
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax jurisdictions")
+		return
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + taxJurisdictionColumns + ` FROM sales_tax_jurisdictions j WHERE j.tenant_id = $1`
+	args := []interface{}{tenantID}
+	if country := r.URL.Query().Get("country"); country != "" {
+		query += " AND UPPER(j.country) = UPPER($2)"
+		args = append(args, country)
+	}
+	query += " ORDER BY j.country, j.code"
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch tax jurisdictions", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax jurisdictions")
+		return
+	}
+
+	jurisdictions := []TaxJurisdiction{}
+	byID := map[int]int{}
+	for rows.Next() {
+		var j TaxJurisdiction
+		if err := scanTaxJurisdiction(rows, &j); err != nil {
+			h.logger.Error("Failed to scan tax jurisdiction", zap.Error(err))
+			continue
+		}
+		j.Rates = []TaxRate{}
+		byID[j.ID] = len(jurisdictions)
+		jurisdictions = append(jurisdictions, j)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`SELECT `+taxRateColumns+` FROM sales_tax_rates r WHERE r.tenant_id = $1
+		ORDER BY r.priority, r.valid_from, r.id`, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to fetch tax rates", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax jurisdictions")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate TaxRate
+		if err := scanTaxRate(rows, &rate); err != nil {
+			h.logger.Error("Failed to scan tax rate", zap.Error(err))
+			continue
+		}
+		if i, ok := byID[rate.JurisdictionID]; ok {
+			jurisdictions[i].Rates = append(jurisdictions[i].Rates, rate)
+		}
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"jurisdictions": jurisdictions,
+		"count":         len(jurisdictions),
+	})
+}
+
+// GetTaxJurisdiction retrieves a single tax jurisdiction with its rates
+func (h *SalesHandler) GetTaxJurisdiction(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid jurisdiction ID")
+		return
+	}
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax jurisdiction")
+		return
+	}
+	defer tx.Rollback()
+
+	var j TaxJurisdiction
+	err = scanTaxJurisdiction(tx.QueryRow(`SELECT `+taxJurisdictionColumns+` FROM sales_tax_jurisdictions j
+		WHERE j.id = $1 AND j.tenant_id = $2`, id, tenantID), &j)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Tax jurisdiction not found")
+			return
+		}
+		h.logger.Error("Failed to fetch tax jurisdiction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax jurisdiction")
+		return
+	}
+
+	rows, err := tx.Query(`SELECT `+taxRateColumns+` FROM sales_tax_rates r
+		WHERE r.jurisdiction_id = $1 AND r.tenant_id = $2
+		ORDER BY r.priority, r.valid_from, r.id`, id, tenantID)
+	if err != nil {
+		h.logger.Error("Failed to fetch tax rates", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax jurisdiction")
+		return
+	}
+	defer rows.Close()
+
+	j.Rates = []TaxRate{}
+	for rows.Next() {
+		var rate TaxRate
+		if err := scanTaxRate(rows, &rate); err != nil {
+			h.logger.Error("Failed to scan tax rate", zap.Error(err))
+			continue
+		}
+		j.Rates = append(j.Rates, rate)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, j)
+}
+
+// createTaxJurisdictionRequest is the body of CreateTaxJurisdiction
+type createTaxJurisdictionRequest struct {
+	Code             string  `json:"code" validate:"required"`
+	Name             string  `json:"name" validate:"required"`
+	Country          string  `json:"country" validate:"required,country"`
+	Region           *string `json:"region"`
+	City             *string `json:"city"`
+	PostalCodePrefix *string `json:"postal_code_prefix"`
+	IsActive         *bool   `json:"is_active"`
+}
+
+// CreateTaxJurisdiction adds a tax jurisdiction; its rates are added separately
+func (h *SalesHandler) CreateTaxJurisdiction(w http.ResponseWriter, r *http.Request) {
+	var req createTaxJurisdictionRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax jurisdiction")
+		return
+	}
+	defer tx.Rollback()
+
+	tenantID := requestTenant(r)
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM sales_tax_jurisdictions WHERE tenant_id = $1 AND code = $2)`,
+		tenantID, req.Code).Scan(&exists)
+	if err != nil {
+		h.logger.Error("Failed to check tax jurisdiction code", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax jurisdiction")
+		return
+	}
+	if exists {
+		writeError(w, http.StatusConflict, fmt.Sprintf("Tax jurisdiction %s already exists", req.Code))
+		return
+	}
+
+	var j TaxJurisdiction
+	err = scanTaxJurisdiction(tx.QueryRow(`
+		INSERT INTO sales_tax_jurisdictions AS j (tenant_id, code, name, country, region, city,
+		                                          postal_code_prefix, is_active, created_by)
+		VALUES ($1, $2, $3, UPPER($4), $5, $6, $7, $8, $9)
+		RETURNING `+taxJurisdictionColumns,
+		tenantID, req.Code, req.Name, req.Country, req.Region, req.City, req.PostalCodePrefix, isActive,
+		requestUser(r)), &j)
+	if err != nil {
+		h.logger.Error("Failed to create tax jurisdiction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax jurisdiction")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax jurisdiction")
+		return
+	}
+
+	j.Rates = []TaxRate{}
+	sdk.WriteJSON(w, http.StatusCreated, j)
+}
+
+// updateTaxJurisdictionRequest is the body of UpdateTaxJurisdiction. An empty region,
+// city or postal code prefix widens the jurisdiction to the whole enclosing area.
+type updateTaxJurisdictionRequest struct {
+	Name             *string `json:"name"`
+	Region           *string `json:"region"`
+	City             *string `json:"city"`
+	PostalCodePrefix *string `json:"postal_code_prefix"`
+	IsActive         *bool   `json:"is_active"`
+}
+
+// UpdateTaxJurisdiction changes the area or state of a tax jurisdiction
+func (h *SalesHandler) UpdateTaxJurisdiction(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid jurisdiction ID")
+		return
+	}
+
+	var req updateTaxJurisdictionRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.Name != nil {
+		setParts = append(setParts, fmt.Sprintf("name = $%d", argIndex))
+		args = append(args, *req.Name)
+		argIndex++
+	}
+	for _, field := range []struct {
+		column string
+		value  *string
+	}{
+		{"region", req.Region},
+		{"city", req.City},
+		{"postal_code_prefix", req.PostalCodePrefix},
+	} {
+		if field.value != nil {
+			setParts = append(setParts, fmt.Sprintf("%s = NULLIF($%d, '')", field.column, argIndex))
+			args = append(args, *field.value)
+			argIndex++
+		}
+	}
+	if req.IsActive != nil {
+		setParts = append(setParts, fmt.Sprintf("is_active = $%d", argIndex))
+		args = append(args, *req.IsActive)
+		argIndex++
+	}
+
+	if len(setParts) == 0 {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax jurisdiction")
+		return
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE sales_tax_jurisdictions j SET %s WHERE j.id = $%d AND j.tenant_id = $%d RETURNING %s",
+		strings.Join(setParts, ", "), argIndex, argIndex+1, taxJurisdictionColumns)
+	args = append(args, id, requestTenant(r))
+
+	var j TaxJurisdiction
+	if err = scanTaxJurisdiction(tx.QueryRow(query, args...), &j); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Tax jurisdiction not found")
+			return
+		}
+		h.logger.Error("Failed to update tax jurisdiction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax jurisdiction")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax jurisdiction")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, j)
+}
+
+// DeleteTaxJurisdiction removes a tax jurisdiction along with its rates and the exemptions
+// from it. Documents already taxed keep their tax breakdown.
+func (h *SalesHandler) DeleteTaxJurisdiction(w http.ResponseWriter, r *http.Request) {
+	h.deleteTaxRecord(w, r, "sales_tax_jurisdictions", "jurisdiction", "Tax jurisdiction")
+}
+
+// deleteTaxRecord deletes the row of table named by the id URL parameter. table is
+// always a constant.
+func (h *SalesHandler) deleteTaxRecord(w http.ResponseWriter, r *http.Request, table, idName, noun string) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid %s ID", idName))
+		return
+	}
+	failure := fmt.Sprintf("Failed to delete %s", strings.ToLower(noun))
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, failure)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = $1 AND tenant_id = $2", table), id, requestTenant(r))
+	if err != nil {
+		h.logger.Error(failure, zap.Error(err))
+		writeError(w, http.StatusInternalServerError, failure)
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		h.logger.Error("Failed to get rows affected", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, failure)
+		return
+	}
+	if rowsAffected == 0 {
+		writeError(w, http.StatusNotFound, noun+" not found")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, failure)
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]string{
+		"message": noun + " deleted successfully",
+	})
+}
+
+// createTaxRateRequest is the body of CreateTaxRate
+type createTaxRateRequest struct {
+	Name        string  `json:"name" validate:"required"`
+	TaxCategory *string `json:"tax_category"`
+	Rate        Decimal `json:"rate" validate:"gte=0,lte=100"`
+	IsCompound  bool    `json:"is_compound"`
+	Priority    int     `json:"priority"`
+	ValidFrom   string  `json:"valid_from" validate:"required,date"`
+	ValidTo     *string `json:"valid_to" validate:"date"`
+}
+
+func (req createTaxRateRequest) validate() []FieldError {
+	return validityErrors("valid_to", req.ValidFrom, req.ValidTo)
+}
+
+// validityErrors rejects a period that ends before it starts
+func validityErrors(field, from string, to *string) []FieldError {
+	start, end := parseOptionalDate(&from), parseOptionalDate(to)
+	if end != nil && end.Before(*start) {
+		return []FieldError{{field, fieldInvalid, "must not be before " + from}}
+	}
+	return nil
+}
+
+// CreateTaxRate adds a rate to a tax jurisdiction
+func (h *SalesHandler) CreateTaxRate(w http.ResponseWriter, r *http.Request) {
+	jurisdictionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid jurisdiction ID")
+		return
+	}
+
+	var req createTaxRateRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax rate")
+		return
+	}
+	defer tx.Rollback()
+
+	tenantID := requestTenant(r)
+	var found int
+	err = tx.QueryRow("SELECT 1 FROM sales_tax_jurisdictions WHERE id = $1 AND tenant_id = $2",
+		jurisdictionID, tenantID).Scan(&found)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Tax jurisdiction not found")
+			return
+		}
+		h.logger.Error("Failed to fetch tax jurisdiction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax rate")
+		return
+	}
+
+	var rate TaxRate
+	err = scanTaxRate(tx.QueryRow(`
+		INSERT INTO sales_tax_rates AS r (tenant_id, jurisdiction_id, name, tax_category, rate, is_compound,
+		                                  priority, valid_from, valid_to, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10)
+		RETURNING `+taxRateColumns,
+		tenantID, jurisdictionID, req.Name, req.TaxCategory, req.Rate, req.IsCompound, req.Priority,
+		parseOptionalDate(&req.ValidFrom), parseOptionalDate(req.ValidTo), requestUser(r)), &rate)
+	if err != nil {
+		h.logger.Error("Failed to create tax rate", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax rate")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax rate")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, rate)
+}
+
+// updateTaxRateRequest is the body of UpdateTaxRate. A rate change is best recorded by
+// closing the old rate with valid_to and adding a new one, so documents recalculated
+// later still see the rate of their own date.
+type updateTaxRateRequest struct {
+	Name        *string  `json:"name"`
+	TaxCategory *string  `json:"tax_category"`
+	Rate        *Decimal `json:"rate" validate:"gte=0,lte=100"`
+	IsCompound  *bool    `json:"is_compound"`
+	Priority    *int     `json:"priority"`
+	ValidFrom   *string  `json:"valid_from" validate:"date"`
+	ValidTo     *string  `json:"valid_to" validate:"date"`
+}
+
+// UpdateTaxRate changes a tax rate
+func (h *SalesHandler) UpdateTaxRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid rate ID")
+		return
+	}
+
+	var req updateTaxRateRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.Name != nil {
+		setParts = append(setParts, fmt.Sprintf("name = $%d", argIndex))
+		args = append(args, *req.Name)
+		argIndex++
+	}
+	if req.TaxCategory != nil {
+		setParts = append(setParts, fmt.Sprintf("tax_category = NULLIF($%d, '')", argIndex))
+		args = append(args, *req.TaxCategory)
+		argIndex++
+	}
+	if req.Rate != nil {
+		setParts = append(setParts, fmt.Sprintf("rate = $%d", argIndex))
+		args = append(args, *req.Rate)
+		argIndex++
+	}
+	if req.IsCompound != nil {
+		setParts = append(setParts, fmt.Sprintf("is_compound = $%d", argIndex))
+		args = append(args, *req.IsCompound)
+		argIndex++
+	}
+	if req.Priority != nil {
+		setParts = append(setParts, fmt.Sprintf("priority = $%d", argIndex))
+		args = append(args, *req.Priority)
+		argIndex++
+	}
+	if req.ValidFrom != nil {
+		setParts = append(setParts, fmt.Sprintf("valid_from = $%d", argIndex))
+		args = append(args, parseOptionalDate(req.ValidFrom))
+		argIndex++
+	}
+	if req.ValidTo != nil {
+		setParts = append(setParts, fmt.Sprintf("valid_to = $%d", argIndex))
+		args = append(args, parseOptionalDate(req.ValidTo))
+		argIndex++
+	}
+
+	if len(setParts) == 0 {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax rate")
+		return
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE sales_tax_rates r SET %s WHERE r.id = $%d AND r.tenant_id = $%d RETURNING %s",
+		strings.Join(setParts, ", "), argIndex, argIndex+1, taxRateColumns)
+	args = append(args, id, requestTenant(r))
+
+	var rate TaxRate
+	if err = scanTaxRate(tx.QueryRow(query, args...), &rate); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Tax rate not found")
+			return
+		}
+		h.logger.Error("Failed to update tax rate", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax rate")
+		return
+	}
+	if rate.ValidTo != nil && rate.ValidTo.Before(rate.ValidFrom) {
+		writeValidationError(w, []FieldError{{"valid_to", fieldInvalid,
+			"must not be before " + rate.ValidFrom.Format("2006-01-02")}})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax rate")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, rate)
+}
+
+// DeleteTaxRate removes a tax rate
+func (h *SalesHandler) DeleteTaxRate(w http.ResponseWriter, r *http.Request) {
+	h.deleteTaxRecord(w, r, "sales_tax_rates", "rate", "Tax rate")
+}
+
+// GetTaxExemptions retrieves the tenant's tax exemption certificates, optionally for one customer
+func (h *SalesHandler) GetTaxExemptions(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + taxExemptionColumns + ` FROM sales_tax_exemptions e WHERE e.tenant_id = $1`
+	args := []interface{}{requestTenant(r)}
+	if customerID := r.URL.Query().Get("customer_id"); customerID != "" {
+		id, err := strconv.Atoi(customerID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid customer ID")
+			return
+		}
+		query += " AND e.customer_id = $2"
+		args = append(args, id)
+	}
+	query += " ORDER BY e.customer_id, e.valid_from, e.id"
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax exemptions")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch tax exemptions", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch tax exemptions")
+		return
+	}
+	defer rows.Close()
+
+	exemptions := []TaxExemption{}
+	for rows.Next() {
+		var e TaxExemption
+		if err := scanTaxExemption(rows, &e); err != nil {
+			h.logger.Error("Failed to scan tax exemption", zap.Error(err))
+			continue
+		}
+		exemptions = append(exemptions, e)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"exemptions": exemptions,
+		"count":      len(exemptions),
+	})
+}
+
+// createTaxExemptionRequest is the body of CreateTaxExemption. Without a jurisdiction the
+// certificate exempts the customer everywhere.
+type createTaxExemptionRequest struct {
+	CustomerID        int     `json:"customer_id" validate:"required"`
+	CertificateNumber string  `json:"certificate_number" validate:"required"`
+	JurisdictionID    *int    `json:"jurisdiction_id"`
+	Reason            *string `json:"reason"`
+	ValidFrom         string  `json:"valid_from" validate:"required,date"`
+	ExpiresOn         *string `json:"expires_on" validate:"date"`
+}
+
+func (req createTaxExemptionRequest) validate() []FieldError {
+	return validityErrors("expires_on", req.ValidFrom, req.ExpiresOn)
+}
+
+// CreateTaxExemption records a customer's tax exemption certificate
+func (h *SalesHandler) CreateTaxExemption(w http.ResponseWriter, r *http.Request) {
+	var req createTaxExemptionRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax exemption")
+		return
+	}
+	defer tx.Rollback()
+
+	tenantID := requestTenant(r)
+	if err := checkReferences(tx, tenantID, reference{"customer_id", "customers", req.CustomerID}); err != nil {
+		h.writeRequestError(w, err, "Failed to create tax exemption")
+		return
+	}
+	if req.JurisdictionID != nil {
+		if err := checkTenantRef(tx, "sales_tax_jurisdictions", *req.JurisdictionID, tenantID, "Tax jurisdiction not found"); err != nil {
+			h.writeRequestError(w, err, "Failed to create tax exemption")
+			return
+		}
+	}
+
+	var e TaxExemption
+	err = scanTaxExemption(tx.QueryRow(`
+		INSERT INTO sales_tax_exemptions AS e (tenant_id, customer_id, certificate_number, jurisdiction_id,
+		                                       reason, valid_from, expires_on, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+taxExemptionColumns,
+		tenantID, req.CustomerID, req.CertificateNumber, req.JurisdictionID, req.Reason,
+		parseOptionalDate(&req.ValidFrom), parseOptionalDate(req.ExpiresOn), requestUser(r)), &e)
+	if err != nil {
+		h.logger.Error("Failed to create tax exemption", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax exemption")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create tax exemption")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, e)
+}
+
+// updateTaxExemptionRequest is the body of UpdateTaxExemption
+type updateTaxExemptionRequest struct {
+	CertificateNumber *string `json:"certificate_number"`
+	Reason            *string `json:"reason"`
+	ValidFrom         *string `json:"valid_from" validate:"date"`
+	ExpiresOn         *string `json:"expires_on" validate:"date"`
+}
+
+// UpdateTaxExemption changes a tax exemption certificate, typically to renew it
+func (h *SalesHandler) UpdateTaxExemption(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid exemption ID")
+		return
+	}
+
+	var req updateTaxExemptionRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.CertificateNumber != nil {
+		setParts = append(setParts, fmt.Sprintf("certificate_number = $%d", argIndex))
+		args = append(args, *req.CertificateNumber)
+		argIndex++
+	}
+	if req.Reason != nil {
+		setParts = append(setParts, fmt.Sprintf("reason = $%d", argIndex))
+		args = append(args, *req.Reason)
+		argIndex++
+	}
+	if req.ValidFrom != nil {
+		setParts = append(setParts, fmt.Sprintf("valid_from = $%d", argIndex))
+		args = append(args, parseOptionalDate(req.ValidFrom))
+		argIndex++
+	}
+	if req.ExpiresOn != nil {
+		setParts = append(setParts, fmt.Sprintf("expires_on = $%d", argIndex))
+		args = append(args, parseOptionalDate(req.ExpiresOn))
+		argIndex++
+	}
+
+	if len(setParts) == 0 {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax exemption")
+		return
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE sales_tax_exemptions e SET %s WHERE e.id = $%d AND e.tenant_id = $%d RETURNING %s",
+		strings.Join(setParts, ", "), argIndex, argIndex+1, taxExemptionColumns)
+	args = append(args, id, requestTenant(r))
+
+	var e TaxExemption
+	if err = scanTaxExemption(tx.QueryRow(query, args...), &e); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Tax exemption not found")
+			return
+		}
+		h.logger.Error("Failed to update tax exemption", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax exemption")
+		return
+	}
+	if e.ExpiresOn != nil && e.ExpiresOn.Before(e.ValidFrom) {
+		writeValidationError(w, []FieldError{{"expires_on", fieldInvalid,
+			"must not be before " + e.ValidFrom.Format("2006-01-02")}})
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update tax exemption")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, e)
+}
+
+// DeleteTaxExemption removes a tax exemption certificate
+func (h *SalesHandler) DeleteTaxExemption(w http.ResponseWriter, r *http.Request) {
+	h.deleteTaxRecord(w, r, "sales_tax_exemptions", "exemption", "Tax exemption")
+}
+
+// GetProductTaxCategories retrieves the products assigned a tax category
+func (h *SalesHandler) GetProductTaxCategories(w http.ResponseWriter, r *http.Request) {
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch product tax categories")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT product_id, tax_category, updated_at, updated_by
+		FROM sales_product_tax_categories
+		WHERE tenant_id = $1
+		ORDER BY product_id
+	`, requestTenant(r))
+	if err != nil {
+		h.logger.Error("Failed to fetch product tax categories", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch product tax categories")
+		return
+	}
+	defer rows.Close()
+
+	categories := []ProductTaxCategory{}
+	for rows.Next() {
+		var c ProductTaxCategory
+		if err := rows.Scan(&c.ProductID, &c.TaxCategory, &c.UpdatedAt, &c.UpdatedBy); err != nil {
+			h.logger.Error("Failed to scan product tax category", zap.Error(err))
+			continue
+		}
+		categories = append(categories, c)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"product_tax_categories": categories,
+		"count":                  len(categories),
+	})
+}
+
+// setProductTaxCategoryRequest is the body of SetProductTaxCategory
+type setProductTaxCategoryRequest struct {
+	TaxCategory string `json:"tax_category" validate:"required"`
+}
+
+// SetProductTaxCategory assigns a product a tax category, replacing any it had
+func (h *SalesHandler) SetProductTaxCategory(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	var req setProductTaxCategoryRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to set product tax category")
+		return
+	}
+	defer tx.Rollback()
+
+	tenantID := requestTenant(r)
+	if err := checkReferences(tx, tenantID, reference{"product_id", "products", productID}); err != nil {
+		h.writeRequestError(w, err, "Failed to set product tax category")
+		return
+	}
+
+	c := ProductTaxCategory{ProductID: productID}
+	err = tx.QueryRow(`
+		INSERT INTO sales_product_tax_categories (tenant_id, product_id, tax_category)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id, product_id) DO UPDATE SET tax_category = EXCLUDED.tax_category
+		RETURNING tax_category, updated_at, updated_by
+	`, tenantID, productID, req.TaxCategory).Scan(&c.TaxCategory, &c.UpdatedAt, &c.UpdatedBy)
+	if err != nil {
+		h.logger.Error("Failed to set product tax category", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to set product tax category")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to set product tax category")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, c)
+}
+
+// DeleteProductTaxCategory returns a product to the general tax rates
+func (h *SalesHandler) DeleteProductTaxCategory(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productId"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid product ID")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete product tax category")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM sales_product_tax_categories WHERE product_id = $1 AND tenant_id = $2",
+		productID, requestTenant(r))
+	if err != nil {
+		h.logger.Error("Failed to delete product tax category", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete product tax category")
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		h.logger.Error("Failed to get rows affected", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete product tax category")
+		return
+	}
+	if rowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "Product has no tax category")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete product tax category")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]string{
+		"message": "Product tax category deleted successfully",
+	})
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// totalsLine is a document line as entered. A discount percentage takes precedence over
+// the discount amount, which is then worked out from it. Taxes, when not nil, are the
+// rates charged on the line, in the order they apply. TaxAmount fixes the tax of the
+// line, as on a credit note reversing the tax an invoice charged.
+type totalsLine struct {
+	Quantity        int       `json:"quantity"`
+	UnitPrice       Decimal   `json:"unit_price"`
+	DiscountPercent Decimal   `json:"discount_percent"`
+	DiscountAmount  Decimal   `json:"discount_amount"`
+	Taxes           []taxRate `json:"taxes,omitempty"`
+	TaxAmount       *Decimal  `json:"tax_amount,omitempty"`
+}
+
+// totalsInput is everything the totals of a document are calculated from. The document
+// discount works like a line discount, on the subtotal. Tax is charged on each line's
+// value after the document discount, at the line's own rates or else at TaxRate percent;
+// without either, TaxAmount is taken as entered and shared across the lines. When
+// PricesIncludeTax is set, line values include their tax, which is worked back out of them.
+type totalsInput struct {
+	Currency                string       `json:"currency"`
+	Rounding                string       `json:"rounding"`
+	Lines                   []totalsLine `json:"lines"`
+	DocumentDiscountPercent Decimal      `json:"document_discount_percent"`
+	DocumentDiscountAmount  Decimal      `json:"document_discount_amount"`
+	ShippingAmount          Decimal      `json:"shipping_amount"`
+	PricesIncludeTax        bool         `json:"prices_include_tax"`
+	TaxRate                 *Decimal     `json:"tax_rate"`
+	TaxAmount               Decimal      `json:"tax_amount"`
+}
+
+// lineTotals are the calculated amounts of one line. LineTotal matches the generated
+// line_total column; DocumentDiscount is the line's share of the document discount and
+// TaxableAmount the value tax is charged on, net of any tax included in the price.
+type lineTotals struct {
+	DiscountAmount   Decimal   `json:"discount_amount"`
+	LineTotal        Decimal   `json:"line_total"`
+	DocumentDiscount Decimal   `json:"document_discount"`
+	TaxableAmount    Decimal   `json:"taxable_amount"`
+	TaxAmount        Decimal   `json:"tax_amount"`
+	Taxes            []lineTax `json:"taxes"`
+}
+
+// documentTotals are the calculated amounts of a document. Subtotal is the sum of the line
+// totals and DiscountAmount every discount given, on the lines and on the document.
+type documentTotals struct {
+	Lines                  []lineTotals `json:"lines"`
+	Subtotal               Decimal      `json:"subtotal"`
+	DocumentDiscountAmount Decimal      `json:"document_discount_amount"`
+	DiscountAmount         Decimal      `json:"discount_amount"`
+	ShippingAmount         Decimal      `json:"shipping_amount"`
+	TaxAmount              Decimal      `json:"tax_amount"`
+	TotalAmount            Decimal      `json:"total_amount"`
+}
+
+// calculateTotals works out the totals of a quote, order, invoice or credit note:
+//
+//	total = subtotal - document discount + shipping + tax
+//
+// leaving out the tax when prices include it. Every amount is rounded to the currency,
+// and tax under the rounding policy.
+func calculateTotals(in totalsInput) documentTotals {
+	rounding := newMoneyRounding(in.Currency, in.Rounding)
+	t := documentTotals{Lines: make([]lineTotals, len(in.Lines))}
+
+	lineValues := make([]Decimal, len(in.Lines))
+	for i, line := range in.Lines {
+		gross := line.UnitPrice.MulInt(line.Quantity)
+		discount := rounding.round(line.DiscountAmount)
+		if line.DiscountPercent.Sign() > 0 {
+			discount = gross.Percent(line.DiscountPercent, rounding.places)
+		}
+		discount = minDecimal(discount, gross)
+
+		lineValues[i] = gross.Sub(discount)
+		t.Lines[i].DiscountAmount = discount
+		t.Lines[i].LineTotal = lineValues[i]
+		t.Subtotal = t.Subtotal.Add(lineValues[i])
+		t.DiscountAmount = t.DiscountAmount.Add(discount)
+	}
+
+	documentDiscount := rounding.round(in.DocumentDiscountAmount)
+	if in.DocumentDiscountPercent.Sign() > 0 {
+		documentDiscount = t.Subtotal.Percent(in.DocumentDiscountPercent, rounding.places)
+	}
+	t.DocumentDiscountAmount = minDecimal(documentDiscount, t.Subtotal)
+	t.DiscountAmount = t.DiscountAmount.Add(t.DocumentDiscountAmount)
+
+	taxable := make([]Decimal, len(in.Lines))
+	for i, share := range rounding.allocate(t.DocumentDiscountAmount, lineValues) {
+		t.Lines[i].DocumentDiscount = share
+		taxable[i] = lineValues[i].Sub(share)
+		t.Lines[i].TaxableAmount = taxable[i]
+	}
+
+	lineTaxes := make([]Decimal, len(in.Lines))
+	if in.TaxRate != nil || hasLineRates(in.Lines) {
+		calculateLineTaxes(in, taxable, rounding, t.Lines)
+		for i := range t.Lines {
+			for _, tax := range t.Lines[i].Taxes {
+				lineTaxes[i] = lineTaxes[i].Add(tax.TaxAmount)
+			}
+		}
+	} else {
+		lineTaxes = rounding.allocate(rounding.round(in.TaxAmount), taxable)
+	}
+
+	for i := range t.Lines {
+		if in.Lines[i].TaxAmount != nil {
+			lineTaxes[i] = rounding.round(*in.Lines[i].TaxAmount)
+		}
+		if t.Lines[i].Taxes == nil {
+			t.Lines[i].Taxes = []lineTax{}
+		}
+		t.Lines[i].TaxAmount = lineTaxes[i]
+		t.TaxAmount = t.TaxAmount.Add(lineTaxes[i])
+		if in.PricesIncludeTax {
+			t.Lines[i].TaxableAmount = taxable[i].Sub(lineTaxes[i])
+		}
+	}
+
+	t.ShippingAmount = rounding.round(in.ShippingAmount)
+	t.TotalAmount = t.Subtotal.Sub(t.DocumentDiscountAmount).Add(t.ShippingAmount)
+	if !in.PricesIncludeTax {
+		t.TotalAmount = t.TotalAmount.Add(t.TaxAmount)
+	}
+	return t
+}
+
+// hasLineRates reports whether any line carries rates of its own
+func hasLineRates(lines []totalsLine) bool {
+	for _, line := range lines {
+		if line.Taxes != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// calculateLineTaxes charges each line's rates, or TaxRate for lines without rates of
+// their own, on the line's taxable value and records the breakdown in totals. Compound
+// rates are charged on the value plus the taxes before them. Every tax of the document
+// is rounded together under the rounding policy, so the breakdown adds up to the tax.
+func calculateLineTaxes(in totalsInput, taxable []Decimal, rounding moneyRounding, totals []lineTotals) {
+	var exact []Decimal
+	for i, line := range in.Lines {
+		rates := line.Taxes
+		if rates == nil && in.TaxRate != nil {
+			rates = []taxRate{{Name: "Tax", Rate: *in.TaxRate}}
+		}
+
+		base := taxable[i]
+		if in.PricesIncludeTax {
+			base = taxable[i].MulDiv(decimalFromInt(1), decimalFromInt(1).Add(inclusiveTaxFactor(rates)), decimalPlaces)
+		}
+
+		var charged Decimal
+		totals[i].Taxes = make([]lineTax, len(rates))
+		for j, rate := range rates {
+			on := base
+			if rate.Compound {
+				on = on.Add(charged)
+			}
+			var tax Decimal
+			if rate.Exemption == nil {
+				tax = on.Percent(rate.Rate, decimalPlaces)
+			}
+			charged = charged.Add(tax)
+			exact = append(exact, tax)
+			totals[i].Taxes[j] = lineTax{
+				Jurisdiction: rate.Jurisdiction,
+				Name:         rate.Name,
+				Rate:         rate.Rate,
+				Compound:     rate.Compound,
+				Exemption:    rate.Exemption,
+			}
+		}
+	}
+
+	rounded, _ := rounding.lines(exact)
+	for i := range totals {
+		var total Decimal
+		for j := range totals[i].Taxes {
+			totals[i].Taxes[j].TaxAmount = rounded[0]
+			total = total.Add(rounded[0])
+			rounded = rounded[1:]
+		}
+
+		// Tax included in the price comes out of the line value once it is rounded
+		net := taxable[i]
+		if in.PricesIncludeTax {
+			net = net.Sub(total)
+		}
+		var charged Decimal
+		for j := range totals[i].Taxes {
+			tax := &totals[i].Taxes[j]
+			tax.TaxableAmount = net
+			if tax.Compound {
+				tax.TaxableAmount = net.Add(charged)
+			}
+			charged = charged.Add(tax.TaxAmount)
+		}
+	}
+}
+
+// inclusiveTaxFactor is the tax charged by rates per unit of value, compounding included
+func inclusiveTaxFactor(rates []taxRate) Decimal {
+	var factor Decimal
+	for _, rate := range rates {
+		if rate.Exemption != nil {
+			continue
+		}
+		on := decimalFromInt(1)
+		if rate.Compound {
+			on = on.Add(factor)
+		}
+		factor = factor.Add(on.Percent(rate.Rate, decimalPlaces))
+	}
+	return factor
+}
+
+// documentTable names the tables of a kind of document whose totals are stored, and the
+// column holding the date its tax is determined on. Lines matching fixedLines keep their
+// discount amount, whatever their discount percentage.
+type documentTable struct {
+	header     string
+	items      string
+	parent     string
+	dateColumn string
+	fixedLines string
+}
+
+var (
+	quoteDocument = documentTable{"sales_quotes", "sales_quote_items", "quote_id", "quote_date", "false"}
+	orderDocument = documentTable{"sales_orders", "sales_order_items", "order_id", "order_date", "false"}
+
+	// Invoice lines billing an order line carry the value billed, not the order discount
+	invoiceDocument = documentTable{"sales_invoices", "sales_invoice_items", "invoice_id", "invoice_date",
+		"order_item_id IS NOT NULL"}
+)
+
+// recalculateTotals recalculates a document from its stored lines and header inputs and
+// writes the line discounts, line taxes and header totals back. Unless the document has
+// a fixed tax amount, lines are taxed at the rates of the jurisdictions its ship-to
+// address lies in, or at its own tax rate when there are none. Call it inside the
+// transaction that changed any of them.
+func (h *SalesHandler) recalculateTotals(tx *sqlx.Tx, tenantID string, doc documentTable, id int) (documentTotals, error) {
+	in := totalsInput{Rounding: h.loadSettings(tx, tenantID).Rounding}
+	var customerID int
+	var date time.Time
+	var addr taxAddress
+	var sellerVATID, vatTreatment *string
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT currency, document_discount_percent, document_discount_amount, shipping_amount, prices_include_tax,
+		       tax_rate, tax_amount, customer_id, %s, ship_to_country, ship_to_region, ship_to_city,
+		       ship_to_postal_code, seller_vat_id, vat_treatment
+		FROM %s
+		WHERE id = $1 AND tenant_id = $2
+	`, doc.dateColumn, doc.header), id, tenantID).Scan(&in.Currency, &in.DocumentDiscountPercent,
+		&in.DocumentDiscountAmount, &in.ShippingAmount, &in.PricesIncludeTax, &in.TaxRate, &in.TaxAmount,
+		&customerID, &date, &addr.Country, &addr.Region, &addr.City, &addr.PostalCode, &sellerVATID, &vatTreatment)
+	if err != nil {
+		return documentTotals{}, err
+	}
+
+	// Distance sales below the OSS threshold are taxed where the seller is
+	if vatTreatment != nil && *vatTreatment == vatDistanceSale && sellerVATID != nil {
+		if country, ok := vatIDCountry(*sellerVATID); ok {
+			addr = taxAddress{Country: &country}
+		}
+	}
+
+	var rules *taxRules
+	if in.TaxRate != nil && !vatZeroRated(vatTreatment) {
+		if rules, err = loadTaxRules(tx, tenantID, addr, customerID, date); err != nil {
+			return documentTotals{}, err
+		}
+	}
+
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT i.id, i.quantity, i.unit_price, CASE WHEN %s THEN 0 ELSE i.discount_percent END, i.discount_amount,
+		       COALESCE(i.tax_category, ptc.tax_category)
+		FROM %s i
+		LEFT JOIN sales_product_tax_categories ptc ON ptc.product_id = i.product_id AND ptc.tenant_id = i.tenant_id
+		WHERE i.%s = $1 AND i.tenant_id = $2
+		ORDER BY i.id
+	`, doc.fixedLines, doc.items, doc.parent), id, tenantID)
+	if err != nil {
+		return documentTotals{}, err
+	}
+	var lineIDs []int
+	for rows.Next() {
+		var lineID int
+		var line totalsLine
+		var category *string
+		err := rows.Scan(&lineID, &line.Quantity, &line.UnitPrice, &line.DiscountPercent, &line.DiscountAmount, &category)
+		if err != nil {
+			rows.Close()
+			return documentTotals{}, err
+		}
+		switch {
+		case vatZeroRated(vatTreatment):
+			line.Taxes = []taxRate{vatZeroRate(*vatTreatment)}
+		case rules != nil:
+			line.Taxes = rules.ratesFor(category)
+		}
+		lineIDs = append(lineIDs, lineID)
+		in.Lines = append(in.Lines, line)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return documentTotals{}, err
+	}
+
+	totals := calculateTotals(in)
+
+	for i, line := range totals.Lines {
+		taxes, err := json.Marshal(line.Taxes)
+		if err != nil {
+			return documentTotals{}, err
+		}
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %s SET discount_amount = $1, tax_amount = $2, taxes = $3 WHERE id = $4 AND tenant_id = $5",
+			doc.items), line.DiscountAmount, line.TaxAmount, string(taxes), lineIDs[i], tenantID)
+		if err != nil {
+			return documentTotals{}, err
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %s
+		SET subtotal = $1, document_discount_amount = $2, discount_amount = $3, shipping_amount = $4,
+		    tax_amount = $5, total_amount = $6
+		WHERE id = $7 AND tenant_id = $8
+	`, doc.header), totals.Subtotal, totals.DocumentDiscountAmount, totals.DiscountAmount, totals.ShippingAmount,
+		totals.TaxAmount, totals.TotalAmount, id, tenantID)
+	return totals, err
+}
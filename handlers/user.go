@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// hostUserKey is the request context key under which the host ERP passes the
+// authenticated user's ID to module handlers.
+const hostUserKey = "user_id"
+
+type userContextKey struct{}
+
+// userFromHost reads the user ID supplied by the host, accepting it as an integer or as
+// a decimal string
+func userFromHost(ctx context.Context) (int, bool) {
+	var userID int
+	switch v := ctx.Value(hostUserKey).(type) {
+	case int:
+		userID = v
+	case int64:
+		userID = int(v)
+	case string:
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, false
+		}
+		userID = id
+	default:
+		return 0, false
+	}
+	return userID, userID > 0
+}
+
+// requireUser resolves the acting user before the handler runs and rejects requests the
+// host did not authenticate. Documents record this user as created_by and updated_by.
+func requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := userFromHost(r.Context())
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "Missing or invalid user context")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, userID)))
+	}
+}
+
+// requestUser returns the user resolved by requireUser
+func requestUser(r *http.Request) int {
+	userID, _ := r.Context().Value(userContextKey{}).(int)
+	return userID
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangesAreAttributedToTheCaller(t *testing.T) {
+	// The triggers of migrations 000011 and 000012 read the settings beginRequestTx binds
+	for migration, settings := range map[string][]string{
+		"000011_add_user_attribution": {userSetting},
+		"000012_add_audit_trail":      {userSetting, requestIDSetting},
+	} {
+		sql, err := os.ReadFile(filepath.Join("..", "migrations", migration+".up.sql"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, setting := range settings {
+			if !strings.Contains(string(sql), "current_setting('"+setting+"'") {
+				t.Errorf("%s does not read %s", migration, setting)
+			}
+		}
+	}
+
+	db := &scriptDB{}
+	db.returns("SELECT status FROM sales_orders", "status", []driver.Value{"pending"})
+	db.returns("BOOL_OR(invoiced_amount > 0)", "count invoiced", []driver.Value{int64(1), false})
+	db.returns("SELECT 1 FROM sales_orders", "found", []driver.Value{int64(1)})
+
+	// Stands in for the audit trigger: the change is recorded with the settings it ran under
+	var audited [][]driver.Value
+	db.on("UPDATE sales_orders", func(st scriptStatement) scriptResult {
+		audited = append(audited, []driver.Value{int64(len(audited) + 1), "order", int64(7), "sales_orders",
+			int64(7), "UPDATE", []byte(`{"status":{"before":"pending","after":"confirmed"}}`),
+			st.settings[userSetting], st.settings[requestIDSetting], time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)})
+		return scriptResult{affected: 1}
+	})
+	db.on("FROM sales_audit_log", func(st scriptStatement) scriptResult {
+		return scriptResult{
+			columns: strings.Fields("id document_type document_id table_name row_id action changes actor_id request_id changed_at"),
+			rows:    audited,
+		}
+	})
+	p := db.plugin()
+
+	req := callerRequest("POST", "/orders/7/confirm", "")
+	req = req.WithContext(context.WithValue(req.Context(), hostRequestIDKey, "req-7f3a"))
+	if rec := serve(t, p, req); rec.Code != http.StatusOK {
+		t.Fatalf("confirm: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for _, st := range db.ran("UPDATE sales_orders") {
+		if st.settings[userSetting] != "42" || st.settings[requestIDSetting] != "req-7f3a" {
+			t.Errorf("update ran as user %q in request %q, want 42 in req-7f3a",
+				st.settings[userSetting], st.settings[requestIDSetting])
+		}
+	}
+
+	rec := serve(t, p, callerRequest("GET", "/orders/7/history", ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("history: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var got struct {
+		History []AuditEntry `json:"history"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.History) != 1 {
+		t.Fatalf("history has %d entries, want 1", len(got.History))
+	}
+	entry := got.History[0]
+	if entry.ActorID == nil || *entry.ActorID != 42 {
+		t.Errorf("actor = %v, want 42", entry.ActorID)
+	}
+	if entry.RequestID == nil || *entry.RequestID != "req-7f3a" {
+		t.Errorf("request = %v, want req-7f3a", entry.RequestID)
+	}
+	if change := entry.Changes["status"]; string(change.After) != `"confirmed"` {
+		t.Errorf("status changed to %s, want \"confirmed\"", change.After)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// requestValidator is implemented by request types with rules that span several fields.
+// validate returns the fields that break them, named relative to the value.
+type requestValidator interface {
+	validate() []FieldError
+}
+
+// validateRequest checks v, a pointer to a decoded request body, against the validate tags
+// of its fields, then against its own rules. Nested structs and slices are checked too,
+// and errors name each field by its JSON path.
+//
+// Supported rules, comma-separated in the tag:
+//
+//	required   the field is present and not zero or empty
+//	gt=N       numbers greater than N
+//	gte=N      numbers at least N
+//	lte=N      numbers at most N
+//	date       strings in YYYY-MM-DD form
+//	currency   strings that are an ISO 4217 currency code
+//	oneof=a b  strings equal to one of the listed values
+//
+// Rules other than required skip absent optional fields.
+func validateRequest(v interface{}) []FieldError {
+	var errs []FieldError
+	validateValue(reflect.ValueOf(v), "", &errs)
+	return errs
+}
+
+func validateValue(v reflect.Value, path string, errs *[]FieldError) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType || v.Type() == decimalType {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fieldPath := joinPath(path, name)
+			if checkRules(v.Field(i), f.Tag.Get("validate"), fieldPath, errs) {
+				validateValue(v.Field(i), fieldPath, errs)
+			}
+		}
+
+		if validator, ok := v.Interface().(requestValidator); ok {
+			for _, fe := range validator.validate() {
+				fe.Field = joinPath(path, fe.Field)
+				*errs = append(*errs, fe)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// checkRules applies the rules of tag to v, recording at most one error for the field.
+// It reports whether v passed, so nested values are only checked when their parent is valid.
+func checkRules(v reflect.Value, tag, path string, errs *[]FieldError) bool {
+	if tag == "" {
+		return true
+	}
+
+	// Absent optional values have nothing to check
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		if strings.Contains(","+tag+",", ",required,") {
+			*errs = append(*errs, FieldError{path, fieldRequired, "is required"})
+			return false
+		}
+		return true
+	}
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		if fe := checkRule(v, name, arg); fe != nil {
+			fe.Field = path
+			*errs = append(*errs, *fe)
+			return false
+		}
+	}
+	return true
+}
+
+func checkRule(v reflect.Value, rule, arg string) *FieldError {
+	switch rule {
+	case "required":
+		if isEmpty(v) {
+			return &FieldError{Code: fieldRequired, Message: "is required"}
+		}
+	case "gt", "gte", "lte":
+		n, ok := numberOf(v)
+		limit, err := parseDecimal(arg)
+		if !ok || err != nil {
+			panic(fmt.Sprintf("validate: rule %s=%s on a %s", rule, arg, v.Kind()))
+		}
+		switch cmp := n.Cmp(limit); {
+		case rule == "gt" && cmp <= 0:
+			return &FieldError{Code: fieldTooSmall, Message: "must be greater than " + arg}
+		case rule == "gte" && cmp < 0:
+			return &FieldError{Code: fieldTooSmall, Message: "must be at least " + arg}
+		case rule == "lte" && cmp > 0:
+			return &FieldError{Code: fieldTooLarge, Message: "must be at most " + arg}
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", v.String()); err != nil {
+			return &FieldError{Code: fieldInvalid, Message: "must be a date in YYYY-MM-DD form"}
+		}
+	case "currency":
+		if !validCurrency(v.String()) {
+			return &FieldError{Code: fieldInvalid, Message: "must be an ISO 4217 currency code such as EUR"}
+		}
+	case "country":
+		code := v.String()
+		if len(code) != 2 || strings.IndexFunc(code, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+			return &FieldError{Code: fieldInvalid, Message: "must be a two-letter ISO 3166 country code"}
+		}
+	case "vat_id":
+		if _, ok := vatIDCountry(v.String()); !ok {
+			return &FieldError{Code: fieldInvalid, Message: "must be an EU VAT identification number such as DE123456789"}
+		}
+	case "oneof":
+		options := strings.Fields(arg)
+		for _, option := range options {
+			if v.String() == option {
+				return nil
+			}
+		}
+		return &FieldError{Code: fieldInvalid, Message: "must be one of " + strings.Join(options, ", ")}
+	default:
+		panic("validate: unknown rule " + rule)
+	}
+	return nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// numberOf returns the value of an integer, float or Decimal field as a Decimal
+func numberOf(v reflect.Value) (Decimal, bool) {
+	if v.Type() == decimalType {
+		return v.Interface().(Decimal), true
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return decimalFromInt(int(v.Int())), true
+	case reflect.Float32, reflect.Float64:
+		d, err := parseDecimal(strconv.FormatFloat(v.Float(), 'f', -1, 64))
+		return d, err == nil
+	}
+	return Decimal{}, false
+}
+
+// discountErrors checks that the discount amount of a document line is no more than the
+// line is worth. Lines whose quantity or price is already invalid are left to their tags.
+func discountErrors(quantity int, unitPrice, discountAmount Decimal) []FieldError {
+	if quantity <= 0 || unitPrice.Sign() < 0 {
+		return nil
+	}
+	if lineValue := unitPrice.MulInt(quantity); discountAmount.GreaterThan(lineValue) {
+		return []FieldError{{"discount_amount", fieldTooLarge,
+			fmt.Sprintf("must not exceed the line value of %.2f", lineValue)}}
+	}
+	return nil
+}
+
+func (item SalesOrderItem) validate() []FieldError {
+	return discountErrors(item.Quantity, item.UnitPrice, item.DiscountAmount)
+}
+
+func (item SalesQuoteItem) validate() []FieldError {
+	return discountErrors(item.Quantity, item.UnitPrice, item.DiscountAmount)
+}
+
+func (item SalesInvoiceItem) validate() []FieldError {
+	return discountErrors(item.Quantity, item.UnitPrice, item.DiscountAmount)
+}
+
+// reference is an ID in a request that must name an existing row of table
+type reference struct {
+	field string
+	table string
+	id    int
+}
+
+// documentReferences lists the customer and the line products of a new document
+func documentReferences(customerID int, productIDs []int) []reference {
+	refs := []reference{{"customer_id", "customers", customerID}}
+	for i, id := range productIDs {
+		refs = append(refs, reference{fmt.Sprintf("items[%d].product_id", i), "products", id})
+	}
+	return refs
+}
+
+// checkReferences returns a validationError naming every reference that does not exist for
+// the tenant, like checkTenantRef. Customers and products belong to the host ERP, which
+// scopes them by tenant_id as well; table is always a constant.
+func checkReferences(q sqlx.Queryer, tenantID string, refs ...reference) error {
+	var errs []FieldError
+	for _, ref := range refs {
+		var found int
+		err := q.QueryRowx(fmt.Sprintf("SELECT 1 FROM %s WHERE id = $1 AND tenant_id = $2", ref.table),
+			ref.id, tenantID).Scan(&found)
+		if err == sql.ErrNoRows {
+			errs = append(errs, FieldError{ref.field, fieldNotFound, "does not exist"})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return &validationError{errs}
+	}
+	return nil
+}
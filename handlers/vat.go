@@ -0,0 +1,169 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// VAT treatments of a sale by a seller registered for VAT in an EU member state,
+// determined when the document is created
+const (
+	vatDomestic      = "domestic"       // buyer in the seller's member state
+	vatReverseCharge = "reverse_charge" // business buyer in another member state
+	vatOSS           = "oss"            // consumer in another member state, taxed there under the One Stop Shop
+	vatDistanceSale  = "distance_sale"  // consumer in another member state, taxed in the seller's below the OSS threshold
+	vatExport        = "export"         // buyer outside the EU
+)
+
+// vatLegends are the notes the VAT Directive requires on invoices of each treatment
+var vatLegends = map[string]string{
+	vatReverseCharge: "Reverse charge: intra-Community supply exempt under Article 138 of Council Directive 2006/112/EC, VAT to be accounted for by the customer (Article 196)",
+	vatOSS:           "VAT charged at the rate of the member state of destination under the One Stop Shop scheme",
+	vatExport:        "Export outside the EU exempt from VAT under Article 146 of Council Directive 2006/112/EC",
+}
+
+// vatLegend returns the legend to print for a VAT treatment, or nil
+func vatLegend(treatment *string) *string {
+	if treatment == nil {
+		return nil
+	}
+	if legend, ok := vatLegends[*treatment]; ok {
+		return &legend
+	}
+	return nil
+}
+
+// vatZeroRated reports whether a treatment charges no VAT, leaving it to the buyer or
+// to the country of import
+func vatZeroRated(treatment *string) bool {
+	return treatment != nil && (*treatment == vatReverseCharge || *treatment == vatExport)
+}
+
+// vatZeroRate is the rate recorded on the lines of a zero-rated sale, so that their
+// value still shows in the VAT breakdown
+func vatZeroRate(treatment string) taxRate {
+	if treatment == vatExport {
+		return taxRate{Name: "VAT export", Rate: Decimal{}}
+	}
+	return taxRate{Name: "VAT reverse charge", Rate: Decimal{}}
+}
+
+// vatIDFormats are the formats of the VAT identification numbers of each member state,
+// after the country prefix. Greece uses the prefix EL.
+var vatIDFormats = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^U\d{8}$`),
+	"BE": regexp.MustCompile(`^[01]\d{9}$`),
+	"BG": regexp.MustCompile(`^\d{9,10}$`),
+	"CY": regexp.MustCompile(`^\d{8}[A-Z]$`),
+	"CZ": regexp.MustCompile(`^\d{8,10}$`),
+	"DE": regexp.MustCompile(`^\d{9}$`),
+	"DK": regexp.MustCompile(`^\d{8}$`),
+	"EE": regexp.MustCompile(`^\d{9}$`),
+	"EL": regexp.MustCompile(`^\d{9}$`),
+	"ES": regexp.MustCompile(`^[A-Z0-9]\d{7}[A-Z0-9]$`),
+	"FI": regexp.MustCompile(`^\d{8}$`),
+	"FR": regexp.MustCompile(`^[A-HJ-NP-Z0-9]{2}\d{9}$`),
+	"HR": regexp.MustCompile(`^\d{11}$`),
+	"HU": regexp.MustCompile(`^\d{8}$`),
+	"IE": regexp.MustCompile(`^(\d{7}[A-W][A-I]?|\d[A-Z+*]\d{5}[A-W])$`),
+	"IT": regexp.MustCompile(`^\d{11}$`),
+	"LT": regexp.MustCompile(`^(\d{9}|\d{12})$`),
+	"LU": regexp.MustCompile(`^\d{8}$`),
+	"LV": regexp.MustCompile(`^\d{11}$`),
+	"MT": regexp.MustCompile(`^\d{8}$`),
+	"NL": regexp.MustCompile(`^\d{9}B\d{2}$`),
+	"PL": regexp.MustCompile(`^\d{10}$`),
+	"PT": regexp.MustCompile(`^\d{9}$`),
+	"RO": regexp.MustCompile(`^[1-9]\d{1,9}$`),
+	"SE": regexp.MustCompile(`^\d{10}01$`),
+	"SI": regexp.MustCompile(`^\d{8}$`),
+	"SK": regexp.MustCompile(`^\d{10}$`),
+}
+
+// normalizeVATID upper-cases a VAT identification number and strips the spaces, dots
+// and dashes it is often written with
+func normalizeVATID(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '.', '-':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(id))
+}
+
+// vatIDCountry returns the ISO 3166 country of a well-formed EU VAT identification
+// number. Only the format is checked; whether the number is registered is not.
+func vatIDCountry(id string) (string, bool) {
+	id = normalizeVATID(id)
+	if len(id) < 3 {
+		return "", false
+	}
+	format, ok := vatIDFormats[id[:2]]
+	if !ok || !format.MatchString(id[2:]) {
+		return "", false
+	}
+	if id[:2] == "EL" {
+		return "GR", true
+	}
+	return id[:2], true
+}
+
+// euMemberState reports whether country is an EU member state
+func euMemberState(country string) bool {
+	country = strings.ToUpper(strings.TrimSpace(country))
+	if country == "GR" {
+		return true
+	}
+	_, ok := vatIDFormats[country]
+	return ok && country != "EL"
+}
+
+// determineVATTreatment works out the VAT treatment of a sale from the seller's VAT ID,
+// the buyer's and the country goods ship to. It returns nil when the seller has no EU
+// VAT ID, and the sale is taxed by the tax tables alone. A sale with no ship-to country
+// is taken to stay in the seller's member state.
+func determineVATTreatment(sellerVATID, buyerVATID, shipToCountry *string, ossRegistered bool) *string {
+	if sellerVATID == nil {
+		return nil
+	}
+	sellerCountry, ok := vatIDCountry(*sellerVATID)
+	if !ok {
+		return nil
+	}
+
+	var treatment string
+	destination := sellerCountry
+	if shipToCountry != nil && strings.TrimSpace(*shipToCountry) != "" {
+		destination = strings.ToUpper(strings.TrimSpace(*shipToCountry))
+	}
+	switch {
+	case !euMemberState(destination):
+		treatment = vatExport
+	case destination == sellerCountry:
+		treatment = vatDomestic
+	case buyerVATID != nil && isForeignVATID(*buyerVATID, sellerCountry):
+		treatment = vatReverseCharge
+	case ossRegistered:
+		treatment = vatOSS
+	default:
+		treatment = vatDistanceSale
+	}
+	return &treatment
+}
+
+// isForeignVATID reports whether id is a well-formed VAT ID of a member state other than
+// the seller's
+func isForeignVATID(id, sellerCountry string) bool {
+	country, ok := vatIDCountry(id)
+	return ok && country != sellerCountry
+}
+
+// normalizedVATID normalizes an optional VAT ID, keeping nil as nil
+func normalizedVATID(id *string) *string {
+	if id == nil {
+		return nil
+	}
+	normalized := normalizeVATID(*id)
+	return &normalized
+}
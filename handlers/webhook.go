@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Headers sent with every webhook delivery. The signature is the hex HMAC-SHA256, keyed
+// with the webhook's secret, of the timestamp, a dot and the raw body, prefixed "sha256=".
+// Receivers should recompute it and reject stale timestamps.
+const (
+	webhookIDHeader        = "X-Webhook-Id"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Webhook delivery statuses (migration 000014)
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryDead      = "dead"
+)
+
+const (
+	webhookPollInterval = 5 * time.Second
+	webhookBatchSize    = 20
+	webhookTimeout      = 10 * time.Second
+	webhookMaxAttempts  = 10
+	webhookMaxRetry     = 6 * time.Hour
+
+	// webhookLease keeps a claimed delivery from being claimed again while it is sent
+	webhookLease = time.Minute
+)
+
+// webhookMetadataIPs are cloud instance metadata endpoints outside the link-local and
+// private ranges blockedWebhookIP refuses anyway
+var webhookMetadataIPs = []net.IP{
+	net.ParseIP("100.100.100.200"), // Alibaba Cloud
+	net.ParseIP("192.0.0.192"),     // Oracle Cloud
+}
+
+// blockedWebhookIP reports whether ip is an address webhooks must not reach: loopback,
+// private (RFC 1918 and unique local), link-local, including the 169.254.169.254 metadata
+// endpoint, unspecified, multicast and the other cloud metadata endpoints. Webhook URLs
+// are chosen by tenants, and would otherwise let them probe the network the module runs in.
+func blockedWebhookIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, metadata := range webhookMetadataIPs {
+		if metadata.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDialControl refuses connections to blocked addresses. It runs after the host name
+// is resolved, for the address actually dialled, so a name that resolves to a blocked
+// address, or is rebound to one after the URL was checked, is refused too.
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || blockedWebhookIP(ip) {
+		return fmt.Errorf("webhook address %s is not allowed", host)
+	}
+	return nil
+}
+
+// newWebhookClient returns the client deliveries are sent with. It connects only to allowed
+// addresses, never through a proxy, which would connect on its behalf, and does not follow
+// redirects: a redirect is the receiver's response, and fails the delivery.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: webhookDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookBody is the JSON document POSTed for an event
+type webhookBody struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	TenantID   string          `json:"tenant_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// webhookDelivery is a claimed delivery about to be sent. Its url is the webhook's at the
+// time it is sent, not when it was queued.
+type webhookDelivery struct {
+	id        int64
+	tenantID  string
+	webhookID int
+	eventType string
+	url       string
+	body      []byte
+	attempts  int
+}
+
+// newWebhookSecret generates a random signing secret
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// signWebhook returns the signature header value for body sent at timestamp
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelay is the wait before retrying a delivery that has failed attempts times:
+// 30s, 1m, 2m, ... up to six hours
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < webhookMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxRetry {
+		delay = webhookMaxRetry
+	}
+	return delay
+}
+
+// failedDeliveryStatus is the status of a delivery whose attempts-th attempt failed:
+// dead once it has used all its attempts, pending for a retry otherwise
+func failedDeliveryStatus(attempts int) string {
+	if attempts >= webhookMaxAttempts {
+		return deliveryDead
+	}
+	return deliveryPending
+}
+
+// postWebhook sends one signed delivery and returns the receiver's status code. Any
+// response other than 2xx counts as a failure.
+func postWebhook(ctx context.Context, client *http.Client, d webhookDelivery, secret string, now time.Time) (int, error) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookIDHeader, strconv.FormatInt(d.id, 10))
+	req.Header.Set(webhookEventHeader, d.eventType)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(secret, timestamp, d.body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// WebhookSender queues a delivery for every webhook subscribed to an outbox event and
+// sends due deliveries in the background
+type WebhookSender struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+	client *http.Client
+}
+
+// NewWebhookSender creates a sender for the webhooks stored in db
+func NewWebhookSender(db *sqlx.DB, logger *zap.Logger) *WebhookSender {
+	return &WebhookSender{
+		db:     db,
+		logger: logger,
+		client: newWebhookClient(),
+	}
+}
+
+// Enqueue is the outbox subscriber that queues event for the tenant's active webhooks
+// subscribed to it. Redelivered events are queued only once per webhook.
+func (s *WebhookSender) Enqueue(ctx context.Context, event OutboxEvent) error {
+	body, err := json.Marshal(webhookBody{
+		ID:         event.ID,
+		Type:       event.Type,
+		TenantID:   event.TenantID,
+		OccurredAt: event.OccurredAt,
+		Data:       event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := beginTenantTx(ctx, s.db, event.TenantID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sales_webhook_deliveries (tenant_id, webhook_id, event_id, event_type, url, body)
+		SELECT tenant_id, id, $2, $3, url, $4
+		FROM sales_webhooks
+		WHERE tenant_id = $1 AND is_active
+		  AND (event_types = '[]'::jsonb OR event_types @> jsonb_build_array($3::text))
+		ON CONFLICT (webhook_id, event_id) WHERE replay_of IS NULL DO NOTHING
+	`, event.TenantID, event.ID, event.Type, string(body))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Run sends due deliveries until ctx is cancelled
+func (s *WebhookSender) Run(ctx context.Context) {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.SendDue(ctx)
+			if err != nil {
+				s.logger.Error("Failed to send sales webhooks", zap.Error(err))
+			}
+			if err != nil || n < webhookBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue claims a batch of due deliveries, sends them and records the outcome. Claiming
+// leases the deliveries, so a sender that dies mid-batch leaves them to be retried.
+func (s *WebhookSender) SendDue(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE sales_webhook_deliveries
+		SET next_attempt_at = CURRENT_TIMESTAMP + $1 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM sales_webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at, id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, tenant_id, webhook_id, event_type, body, attempts
+	`, int(webhookLease.Seconds()), webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var claimed []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.id, &d.tenantID, &d.webhookID, &d.eventType, &d.body, &d.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		claimed = append(claimed, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range claimed {
+		if err := s.send(ctx, d); err != nil {
+			return 0, err
+		}
+	}
+	return len(claimed), nil
+}
+
+// send delivers d to its webhook's current URL, signed with its current secret, and records
+// the attempt along with the URL it went to. Changing a webhook thus redirects its pending
+// deliveries too. Deliveries of webhooks that were deactivated since they were queued are
+// marked dead without being sent.
+func (s *WebhookSender) send(ctx context.Context, d webhookDelivery) error {
+	var secret string
+	var active bool
+
+	tx, err := beginTenantTx(ctx, s.db, d.tenantID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, "SELECT url, secret, is_active FROM sales_webhooks WHERE id = $1 AND tenant_id = $2",
+		d.webhookID, d.tenantID).Scan(&d.url, &secret, &active)
+	tx.Rollback()
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if !active {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE sales_webhook_deliveries
+			SET status = 'dead', last_error = 'Webhook is inactive'
+			WHERE id = $1
+		`, d.id)
+		return err
+	}
+
+	statusCode, sendErr := postWebhook(ctx, s.client, d, secret, time.Now())
+	var code *int
+	if statusCode != 0 {
+		code = &statusCode
+	}
+
+	if sendErr == nil {
+		_, err = s.db.ExecContext(ctx, `
+			UPDATE sales_webhook_deliveries
+			SET status = 'delivered', attempts = attempts + 1, last_attempt_at = CURRENT_TIMESTAMP,
+			    last_status_code = $1, last_error = NULL, delivered_at = CURRENT_TIMESTAMP, url = $2
+			WHERE id = $3
+		`, code, d.url, d.id)
+		return err
+	}
+
+	attempts := d.attempts + 1
+	s.logger.Warn("Sales webhook delivery failed",
+		zap.Int64("delivery_id", d.id), zap.Int("attempt", attempts), zap.Error(sendErr))
+
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE sales_webhook_deliveries
+		SET status = $1, attempts = $2, last_attempt_at = CURRENT_TIMESTAMP,
+		    last_status_code = $3, last_error = $4,
+		    next_attempt_at = CURRENT_TIMESTAMP + $5 * INTERVAL '1 second', url = $6
+		WHERE id = $7
+	`, failedDeliveryStatus(attempts), attempts, code, sendErr.Error(),
+		int(webhookRetryDelay(attempts).Seconds()), d.url, d.id)
+	return err
+}
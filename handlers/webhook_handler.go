@@ -0,0 +1,557 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	sdk "github.com/linearbits/erp-backend/pkg/module-sdk"
+	"go.uber.org/zap"
+)
+
+// Webhook is a tenant's subscription of a URL to sales events. The secret is only
+// returned when the webhook is created or its secret rotated.
+type Webhook struct {
+	ID          int       `json:"id"`
+	URL         string    `json:"url"`
+	Description *string   `json:"description"`
+	EventTypes  []string  `json:"event_types"`
+	Secret      string    `json:"secret,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	CreatedBy   int       `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UpdatedBy   *int      `json:"updated_by"`
+}
+
+// WebhookDelivery is one entry of a webhook's delivery log
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	EventID        int64           `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	URL            string          `json:"url"`
+	Body           json.RawMessage `json:"body"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	ReplayOf       *int64          `json:"replay_of"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// webhookEventTypes are the event types a webhook may filter on
+var webhookEventTypes = map[string]bool{
+	EventOrderCreated:       true,
+	EventOrderStatusChanged: true,
+	EventQuoteConverted:     true,
+	EventInvoiceIssued:      true,
+	EventPaymentReceived:    true,
+	EventReturnProcessed:    true,
+}
+
+const webhookColumns = `
+	wh.id, wh.url, wh.description, wh.event_types, wh.is_active, wh.created_by, wh.created_at,
+	wh.updated_at, wh.updated_by
+`
+
+func scanWebhook(row rowScanner, webhook *Webhook) error {
+	var eventTypes []byte
+	err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Description, &eventTypes, &webhook.IsActive,
+		&webhook.CreatedBy, &webhook.CreatedAt, &webhook.UpdatedAt, &webhook.UpdatedBy)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(eventTypes, &webhook.EventTypes)
+}
+
+const webhookDeliveryColumns = `
+	d.id, d.webhook_id, d.event_id, d.event_type, d.url, d.body, d.status, d.attempts,
+	d.next_attempt_at, d.last_attempt_at, d.last_status_code, d.last_error, d.delivered_at,
+	d.replay_of, d.created_at
+`
+
+func scanWebhookDelivery(row rowScanner, delivery *WebhookDelivery) error {
+	var body []byte
+	var nextAttemptAt time.Time
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType,
+		&delivery.URL, &body, &delivery.Status, &delivery.Attempts, &nextAttemptAt,
+		&delivery.LastAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &delivery.DeliveredAt,
+		&delivery.ReplayOf, &delivery.CreatedAt)
+	if err != nil {
+		return err
+	}
+	delivery.Body = body
+	if delivery.Status == deliveryPending {
+		delivery.NextAttemptAt = &nextAttemptAt
+	}
+	return nil
+}
+
+// checkWebhookURL accepts absolute http and https URLs, except to localhost and blocked IP
+// addresses. Host names are resolved only when a delivery is sent, by newWebhookClient,
+// which refuses blocked addresses as it connects.
+func checkWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return &requestError{http.StatusBadRequest, "Webhook URL must be an absolute http or https URL"}
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if ip := net.ParseIP(host); (ip != nil && blockedWebhookIP(ip)) || host == "localhost" ||
+		strings.HasSuffix(host, ".localhost") {
+		return &requestError{http.StatusBadRequest, "Webhook URL must not point to a local, private or metadata address"}
+	}
+	return nil
+}
+
+// encodeEventTypes validates an event filter and encodes it for storage; an empty filter
+// subscribes to every event type
+func encodeEventTypes(eventTypes []string) (string, error) {
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	for _, eventType := range eventTypes {
+		if !webhookEventTypes[eventType] {
+			return "", &requestError{http.StatusBadRequest, fmt.Sprintf("Unknown event type: %s", eventType)}
+		}
+	}
+	encoded, err := json.Marshal(eventTypes)
+	return string(encoded), err
+}
+
+// GetWebhooks retrieves the tenant's webhooks
+func (h *SalesHandler) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch webhooks")
+		return
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT `+webhookColumns+` FROM sales_webhooks wh WHERE wh.tenant_id = $1 ORDER BY wh.id`,
+		requestTenant(r))
+	if err != nil {
+		h.logger.Error("Failed to fetch webhooks", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch webhooks")
+		return
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		var webhook Webhook
+		if err := scanWebhook(rows, &webhook); err != nil {
+			h.logger.Error("Failed to scan webhook", zap.Error(err))
+			continue
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"webhooks": webhooks,
+		"count":    len(webhooks),
+	})
+}
+
+// GetWebhook retrieves a single webhook
+func (h *SalesHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch webhook")
+		return
+	}
+	defer tx.Rollback()
+
+	var webhook Webhook
+	err = scanWebhook(tx.QueryRow(`SELECT `+webhookColumns+` FROM sales_webhooks wh WHERE wh.id = $1 AND wh.tenant_id = $2`,
+		id, requestTenant(r)), &webhook)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		h.logger.Error("Failed to fetch webhook", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch webhook")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, webhook)
+}
+
+// createWebhookRequest is the body of CreateWebhook
+type createWebhookRequest struct {
+	URL         string   `json:"url" validate:"required"`
+	Description *string  `json:"description"`
+	EventTypes  []string `json:"event_types"`
+	IsActive    *bool    `json:"is_active"`
+}
+
+// CreateWebhook subscribes a URL to sales events and returns its signing secret
+func (h *SalesHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	if err := checkWebhookURL(req.URL); err != nil {
+		h.writeRequestError(w, err, "Failed to create webhook")
+		return
+	}
+	eventTypes, err := encodeEventTypes(req.EventTypes)
+	if err != nil {
+		h.writeRequestError(w, err, "Failed to create webhook")
+		return
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		h.logger.Error("Failed to generate webhook secret", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+	defer tx.Rollback()
+
+	var webhook Webhook
+	err = scanWebhook(tx.QueryRow(`
+		INSERT INTO sales_webhooks AS wh (tenant_id, url, description, event_types, secret, is_active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+webhookColumns,
+		requestTenant(r), req.URL, req.Description, eventTypes, secret, isActive, requestUser(r)), &webhook)
+	if err != nil {
+		h.logger.Error("Failed to create webhook", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+
+	webhook.Secret = secret
+	sdk.WriteJSON(w, http.StatusCreated, webhook)
+}
+
+// updateWebhookRequest is the body of UpdateWebhook
+type updateWebhookRequest struct {
+	URL          *string   `json:"url"`
+	Description  *string   `json:"description"`
+	EventTypes   *[]string `json:"event_types"`
+	IsActive     *bool     `json:"is_active"`
+	RotateSecret bool      `json:"rotate_secret"`
+}
+
+// UpdateWebhook changes a webhook's URL, event filter or state, and rotates its secret on
+// request. Pending deliveries go to the new URL, signed with the new secret.
+func (h *SalesHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	var req updateWebhookRequest
+
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	setParts := []string{}
+	args := []interface{}{}
+	argIndex := 1
+
+	if req.URL != nil {
+		if err := checkWebhookURL(*req.URL); err != nil {
+			h.writeRequestError(w, err, "Failed to update webhook")
+			return
+		}
+		setParts = append(setParts, fmt.Sprintf("url = $%d", argIndex))
+		args = append(args, *req.URL)
+		argIndex++
+	}
+	if req.Description != nil {
+		setParts = append(setParts, fmt.Sprintf("description = $%d", argIndex))
+		args = append(args, *req.Description)
+		argIndex++
+	}
+	if req.EventTypes != nil {
+		eventTypes, err := encodeEventTypes(*req.EventTypes)
+		if err != nil {
+			h.writeRequestError(w, err, "Failed to update webhook")
+			return
+		}
+		setParts = append(setParts, fmt.Sprintf("event_types = $%d", argIndex))
+		args = append(args, eventTypes)
+		argIndex++
+	}
+	if req.IsActive != nil {
+		setParts = append(setParts, fmt.Sprintf("is_active = $%d", argIndex))
+		args = append(args, *req.IsActive)
+		argIndex++
+	}
+
+	var secret string
+	if req.RotateSecret {
+		secret, err = newWebhookSecret()
+		if err != nil {
+			h.logger.Error("Failed to generate webhook secret", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update webhook")
+			return
+		}
+		setParts = append(setParts, fmt.Sprintf("secret = $%d", argIndex))
+		args = append(args, secret)
+		argIndex++
+	}
+
+	if len(setParts) == 0 {
+		writeError(w, http.StatusBadRequest, "No fields to update")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update webhook")
+		return
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE sales_webhooks wh SET %s WHERE wh.id = $%d AND wh.tenant_id = $%d RETURNING %s",
+		strings.Join(setParts, ", "), argIndex, argIndex+1, webhookColumns)
+	args = append(args, id, requestTenant(r))
+
+	var webhook Webhook
+	if err = scanWebhook(tx.QueryRow(query, args...), &webhook); err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		h.logger.Error("Failed to update webhook", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update webhook")
+		return
+	}
+
+	// Pending deliveries are sent to the webhook's URL of the time (see WebhookSender.send);
+	// show the new one in their log right away
+	if req.URL != nil {
+		_, err = tx.Exec(`
+			UPDATE sales_webhook_deliveries SET url = $1
+			WHERE webhook_id = $2 AND tenant_id = $3 AND status = 'pending'
+		`, webhook.URL, id, requestTenant(r))
+		if err != nil {
+			h.logger.Error("Failed to update pending webhook deliveries", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, "Failed to update webhook")
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to update webhook")
+		return
+	}
+
+	webhook.Secret = secret
+	sdk.WriteJSON(w, http.StatusOK, webhook)
+}
+
+// DeleteWebhook removes a webhook along with its delivery log
+func (h *SalesHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM sales_webhooks WHERE id = $1 AND tenant_id = $2", id, requestTenant(r))
+	if err != nil {
+		h.logger.Error("Failed to delete webhook", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		h.logger.Error("Failed to get rows affected", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	if rowsAffected == 0 {
+		writeError(w, http.StatusNotFound, "Webhook not found")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// GetWebhookDeliveries retrieves a webhook's delivery log, newest first
+func (h *SalesHandler) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	limit := r.URL.Query().Get("limit")
+	if limit == "" {
+		limit = "50"
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch webhook deliveries")
+		return
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRow("SELECT 1 FROM sales_webhooks WHERE id = $1 AND tenant_id = $2", id, tenantID).Scan(&found)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		h.logger.Error("Failed to fetch webhook", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch webhook deliveries")
+		return
+	}
+
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM sales_webhook_deliveries d WHERE d.webhook_id = $1 AND d.tenant_id = $2`
+	args := []interface{}{id, tenantID}
+	argIndex := 3
+
+	if status != "" {
+		query += fmt.Sprintf(" AND d.status = $%d", argIndex)
+		args = append(args, status)
+		argIndex++
+	}
+
+	query += fmt.Sprintf(" ORDER BY d.id DESC LIMIT $%d", argIndex)
+	args = append(args, limit)
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		h.logger.Error("Failed to fetch webhook deliveries", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to fetch webhook deliveries")
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := scanWebhookDelivery(rows, &delivery); err != nil {
+			h.logger.Error("Failed to scan webhook delivery", zap.Error(err))
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	sdk.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"deliveries": deliveries,
+		"count":      len(deliveries),
+	})
+}
+
+// ReplayWebhookDelivery queues a copy of a logged delivery to be sent again, to the
+// webhook's current URL and with a fresh signature. The original stays in the log.
+func (h *SalesHandler) ReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	deliveryID, err := strconv.ParseInt(chi.URLParam(r, "deliveryId"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	tenantID := requestTenant(r)
+
+	tx, err := h.beginRequestTx(r)
+	if err != nil {
+		h.logger.Error("Failed to begin transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to replay webhook delivery")
+		return
+	}
+	defer tx.Rollback()
+
+	var delivery WebhookDelivery
+	err = scanWebhookDelivery(tx.QueryRow(`
+		INSERT INTO sales_webhook_deliveries AS d (tenant_id, webhook_id, event_id, event_type, url, body, replay_of)
+		SELECT orig.tenant_id, orig.webhook_id, orig.event_id, orig.event_type, wh.url, orig.body, orig.id
+		FROM sales_webhook_deliveries orig
+		JOIN sales_webhooks wh ON wh.id = orig.webhook_id
+		WHERE orig.id = $1 AND orig.webhook_id = $2 AND orig.tenant_id = $3
+		RETURNING `+webhookDeliveryColumns,
+		deliveryID, id, tenantID), &delivery)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			writeError(w, http.StatusNotFound, "Webhook delivery not found")
+			return
+		}
+		h.logger.Error("Failed to replay webhook delivery", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to replay webhook delivery")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		h.logger.Error("Failed to commit transaction", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "Failed to replay webhook delivery")
+		return
+	}
+
+	sdk.WriteJSON(w, http.StatusCreated, delivery)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWebhookValidation(t *testing.T) {
+	for _, rawURL := range []string{"ftp://example.com/hook", "/relative", "https://"} {
+		if err := checkWebhookURL(rawURL); err == nil {
+			t.Errorf("URL %q accepted, want error", rawURL)
+		}
+	}
+	if err := checkWebhookURL("https://example.com/hook"); err != nil {
+		t.Errorf("https URL rejected: %v", err)
+	}
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://api.localhost./hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.10/hook",
+		"http://[::1]/hook",
+		"http://[::ffff:172.16.0.1]/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[fd00:ec2::254]/latest/meta-data/",
+		"http://100.100.100.200/latest/meta-data/",
+		"http://0.0.0.0/hook",
+	} {
+		if err := checkWebhookURL(rawURL); err == nil {
+			t.Errorf("URL %q accepted, want error", rawURL)
+		}
+	}
+	if err := checkWebhookURL("http://203.0.113.7:8443/hook"); err != nil {
+		t.Errorf("public address rejected: %v", err)
+	}
+
+	if encoded, err := encodeEventTypes(nil); err != nil || encoded != "[]" {
+		t.Errorf("empty filter = %q, %v; want []", encoded, err)
+	}
+	if _, err := encodeEventTypes([]string{"sales.order.shipped"}); err == nil {
+		t.Error("unknown event type accepted, want error")
+	}
+}
+
+func TestUpdateWebhookRedirectsPendingDeliveries(t *testing.T) {
+	for _, tt := range []struct {
+		body       string
+		redirected bool
+	}{
+		{`{"url":"https://example.com/new"}`, true},
+		{`{"rotate_secret":true}`, false},
+	} {
+		db := &scriptDB{}
+		created := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+		db.returns("UPDATE sales_webhooks wh",
+			"id url description event_types is_active created_by created_at updated_at updated_by",
+			[]driver.Value{int64(7), "https://example.com/new", nil, []byte(`[]`), true, int64(42), created, created, nil})
+
+		rec := serve(t, db.plugin(), callerRequest("PUT", "/webhooks/7", tt.body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", tt.body, rec.Code, rec.Body.String())
+		}
+		pending := db.ran("UPDATE sales_webhook_deliveries SET url")
+		if !tt.redirected {
+			if len(pending) != 0 {
+				t.Errorf("%s: pending deliveries updated: %v", tt.body, pending)
+			}
+			continue
+		}
+		if len(pending) != 1 || pending[0].args[0] != "https://example.com/new" || pending[0].args[1] != int64(7) ||
+			pending[0].args[2] != hostTenant {
+			t.Errorf("%s: pending deliveries updated with %v, want the new URL for webhook 7", tt.body, pending)
+		}
+	}
+}
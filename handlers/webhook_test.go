@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+func TestPostWebhookSignsBody(t *testing.T) {
+	var gotBody, gotSignature, gotTimestamp, gotEvent string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		gotSignature = r.Header.Get(webhookSignatureHeader)
+		gotTimestamp = r.Header.Get(webhookTimestampHeader)
+		gotEvent = r.Header.Get(webhookEventHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	d := webhookDelivery{id: 3, eventType: EventOrderCreated, url: receiver.URL, body: []byte(`{"id":1}`)}
+	code, err := postWebhook(context.Background(), receiver.Client(), d, "secret", time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusNoContent {
+		t.Errorf("status code = %d, want %d", code, http.StatusNoContent)
+	}
+	if gotBody != `{"id":1}` || gotEvent != EventOrderCreated || gotTimestamp != "1700000000" {
+		t.Errorf("received body %q, event %q, timestamp %q", gotBody, gotEvent, gotTimestamp)
+	}
+	if want := signWebhook("secret", "1700000000", []byte(`{"id":1}`)); gotSignature != want {
+		t.Errorf("signature = %q, want %q", gotSignature, want)
+	}
+}
+
+func TestPostWebhookFailsOnErrorStatus(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	d := webhookDelivery{id: 3, eventType: EventOrderCreated, url: receiver.URL, body: []byte(`{}`)}
+	code, err := postWebhook(context.Background(), receiver.Client(), d, "secret", time.Now())
+	if err == nil {
+		t.Error("503 response: delivery succeeded, want error")
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("status code = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestWebhookRetriesUntilDead(t *testing.T) {
+	for _, tc := range []struct {
+		attempts   int
+		wantDelay  time.Duration
+		wantStatus string
+	}{
+		{1, 30 * time.Second, deliveryPending},
+		{3, 2 * time.Minute, deliveryPending},
+		{webhookMaxAttempts - 1, 128 * time.Minute, deliveryPending},
+		{webhookMaxAttempts, 256 * time.Minute, deliveryDead},
+		{20, webhookMaxRetry, deliveryDead},
+	} {
+		if got := webhookRetryDelay(tc.attempts); got != tc.wantDelay {
+			t.Errorf("attempt %d: delay = %v, want %v", tc.attempts, got, tc.wantDelay)
+		}
+		if got := failedDeliveryStatus(tc.attempts); got != tc.wantStatus {
+			t.Errorf("attempt %d: status = %q, want %q", tc.attempts, got, tc.wantStatus)
+		}
+	}
+}
+
+func TestWebhookClientRefusesBlockedAddresses(t *testing.T) {
+	var hits int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer receiver.Close()
+
+	// The receiver listens on loopback, like an internal service the module must not reach
+	d := webhookDelivery{id: 3, eventType: EventOrderCreated, url: receiver.URL, body: []byte(`{}`)}
+	if _, err := postWebhook(context.Background(), newWebhookClient(), d, "secret", time.Now()); err == nil {
+		t.Error("delivery to loopback succeeded, want error")
+	}
+	if hits != 0 {
+		t.Errorf("receiver was reached %d times", hits)
+	}
+
+	for _, address := range []string{"127.0.0.1:80", "[::1]:443", "10.0.0.1:80", "169.254.169.254:80", "[fe80::1]:80"} {
+		if err := webhookDialControl("tcp", address, nil); err == nil {
+			t.Errorf("dial to %s allowed, want error", address)
+		}
+	}
+	if err := webhookDialControl("tcp", "203.0.113.7:443", nil); err != nil {
+		t.Errorf("dial to public address refused: %v", err)
+	}
+}
+
+func TestWebhookClientDoesNotFollowRedirects(t *testing.T) {
+	var internalHits int
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			internalHits++
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	}))
+	defer receiver.Close()
+
+	// Loopback is allowed here only so the test can serve the redirect
+	client := newWebhookClient()
+	client.Transport = receiver.Client().Transport
+
+	d := webhookDelivery{id: 3, eventType: EventOrderCreated, url: receiver.URL + "/hook", body: []byte(`{}`)}
+	code, err := postWebhook(context.Background(), client, d, "secret", time.Now())
+	if err == nil {
+		t.Error("redirected delivery succeeded, want error")
+	}
+	if code != http.StatusFound {
+		t.Errorf("status code = %d, want %d", code, http.StatusFound)
+	}
+	if internalHits != 0 {
+		t.Errorf("redirect was followed %d times", internalHits)
+	}
+}
+
+func TestWebhookSentToCurrentURL(t *testing.T) {
+	var gotSignature, gotTimestamp string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotSignature = r.Header.Get(webhookSignatureHeader)
+		gotTimestamp = r.Header.Get(webhookTimestampHeader)
+	}))
+	defer receiver.Close()
+
+	// Delivery 3 was queued before webhook 4 moved to the receiver and had its secret rotated
+	db := &scriptDB{}
+	db.returns("RETURNING id, tenant_id, webhook_id", "id tenant_id webhook_id event_type body attempts",
+		[]driver.Value{int64(3), hostTenant, int64(4), EventOrderCreated, []byte(`{"id":1}`), int64(0)})
+	db.returns("SELECT url, secret, is_active FROM sales_webhooks", "url secret is_active",
+		[]driver.Value{receiver.URL, "rotated", true})
+
+	s := NewWebhookSender(sqlx.NewDb(sql.OpenDB(db), "postgres"), zap.NewNop())
+	s.client = receiver.Client()
+	if n, err := s.SendDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("sent %d deliveries: %v", n, err)
+	}
+
+	if want := signWebhook("rotated", gotTimestamp, []byte(`{"id":1}`)); gotSignature != want {
+		t.Errorf("signature = %q, want %q made with the current secret", gotSignature, want)
+	}
+	delivered := db.ran("SET status = 'delivered'")
+	if len(delivered) != 1 || delivered[0].args[1] != receiver.URL {
+		t.Errorf("delivery recorded as %v, want sent to %s", delivered, receiver.URL)
+	}
+}
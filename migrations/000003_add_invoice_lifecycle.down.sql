@@ -0,0 +1,8 @@
+-- Rollback invoice lifecycle tracking
+
+DROP INDEX IF EXISTS idx_sales_invoice_items_invoice;
+DROP INDEX IF EXISTS idx_sales_invoices_order;
+
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS void_reason;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS voided_at;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS sent_at;
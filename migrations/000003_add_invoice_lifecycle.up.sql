@@ -0,0 +1,9 @@
+-- Invoice lifecycle tracking
+-- Records when an invoice was sent to the customer and when/why it was voided
+
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS sent_at TIMESTAMP;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS voided_at TIMESTAMP;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS void_reason VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_sales_invoices_order ON sales_invoices(order_id);
+CREATE INDEX IF NOT EXISTS idx_sales_invoice_items_invoice ON sales_invoice_items(invoice_id);
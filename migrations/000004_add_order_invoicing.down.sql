@@ -0,0 +1,12 @@
+-- Rollback order invoicing
+
+DROP TABLE IF EXISTS sales_settings CASCADE;
+
+DROP INDEX IF EXISTS idx_sales_invoice_items_order_item;
+ALTER TABLE sales_invoice_items DROP COLUMN IF EXISTS billed_order_quantity;
+ALTER TABLE sales_invoice_items DROP COLUMN IF EXISTS order_item_id;
+
+ALTER TABLE sales_order_items DROP CONSTRAINT IF EXISTS sales_order_items_invoiced_amount_check;
+ALTER TABLE sales_order_items DROP CONSTRAINT IF EXISTS sales_order_items_invoiced_quantity_check;
+ALTER TABLE sales_order_items DROP COLUMN IF EXISTS invoiced_amount;
+ALTER TABLE sales_order_items DROP COLUMN IF EXISTS invoiced_quantity;
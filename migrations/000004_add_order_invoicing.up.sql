@@ -0,0 +1,27 @@
+-- Order invoicing
+-- Tracks how much of each order line has been billed so a line can never be over-invoiced,
+-- links invoice lines back to the order line they bill, and stores module settings
+
+ALTER TABLE sales_order_items ADD COLUMN IF NOT EXISTS invoiced_quantity INTEGER DEFAULT 0;
+ALTER TABLE sales_order_items ADD COLUMN IF NOT EXISTS invoiced_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_order_items ADD CONSTRAINT sales_order_items_invoiced_quantity_check
+    CHECK (invoiced_quantity >= 0 AND invoiced_quantity <= quantity);
+ALTER TABLE sales_order_items ADD CONSTRAINT sales_order_items_invoiced_amount_check
+    CHECK (invoiced_amount >= 0 AND invoiced_amount <= line_total);
+
+ALTER TABLE sales_invoice_items ADD COLUMN IF NOT EXISTS order_item_id INTEGER REFERENCES sales_order_items(id);
+ALTER TABLE sales_invoice_items ADD COLUMN IF NOT EXISTS billed_order_quantity INTEGER DEFAULT 0; -- 0 for progress billing lines
+CREATE INDEX IF NOT EXISTS idx_sales_invoice_items_order_item ON sales_invoice_items(order_item_id);
+
+-- Module settings (values declared under settings in module.yml)
+CREATE TABLE IF NOT EXISTS sales_settings (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID,
+    key VARCHAR(100) NOT NULL,
+    value TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT sales_settings_tenant_key_unique UNIQUE(tenant_id, key)
+);
+
+CREATE TRIGGER update_sales_settings_updated_at BEFORE UPDATE ON sales_settings FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
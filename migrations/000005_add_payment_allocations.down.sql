@@ -0,0 +1,9 @@
+-- Rollback payment allocations
+
+ALTER TABLE sales_invoices DROP CONSTRAINT IF EXISTS sales_invoices_paid_amount_check;
+
+DROP TABLE IF EXISTS sales_payment_allocations CASCADE;
+
+ALTER TABLE sales_payments DROP CONSTRAINT IF EXISTS sales_payments_unapplied_amount_check;
+ALTER TABLE sales_payments DROP COLUMN IF EXISTS unapplied_amount;
+ALTER TABLE sales_payments DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,25 @@
+-- Payment allocations
+-- A single receipt can be spread across several invoices; whatever is not allocated
+-- stays on the payment as unapplied customer credit
+
+ALTER TABLE sales_payments ADD COLUMN IF NOT EXISTS currency VARCHAR(3) DEFAULT 'USD';
+ALTER TABLE sales_payments ADD COLUMN IF NOT EXISTS unapplied_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_payments ADD CONSTRAINT sales_payments_unapplied_amount_check
+    CHECK (unapplied_amount >= 0 AND unapplied_amount <= amount);
+
+CREATE TABLE IF NOT EXISTS sales_payment_allocations (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID,
+    payment_id INTEGER NOT NULL REFERENCES sales_payments(id),
+    invoice_id INTEGER NOT NULL REFERENCES sales_invoices(id),
+    amount DECIMAL(12,2) NOT NULL CHECK (amount > 0),
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_payment_allocations_payment ON sales_payment_allocations(payment_id);
+CREATE INDEX IF NOT EXISTS idx_sales_payment_allocations_invoice ON sales_payment_allocations(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_sales_payment_allocations_tenant ON sales_payment_allocations(tenant_id);
+
+ALTER TABLE sales_invoices ADD CONSTRAINT sales_invoices_paid_amount_check
+    CHECK (paid_amount >= 0 AND paid_amount <= total_amount);
@@ -0,0 +1,3 @@
+-- Rollback customer credit ledger
+
+DROP TABLE IF EXISTS sales_customer_credit_ledger CASCADE;
@@ -0,0 +1,28 @@
+-- Customer credit ledger
+-- Append-only record of credit owed to each customer: overpayments add credit,
+-- refunds and applications to invoices consume it. The balance is the sum of amounts.
+
+CREATE TABLE IF NOT EXISTS sales_customer_credit_ledger (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID,
+    customer_id INTEGER NOT NULL, -- references customers table
+    entry_type VARCHAR(20) NOT NULL, -- overpayment, credit_note, refund, application
+    amount DECIMAL(12,2) NOT NULL, -- positive adds credit, negative consumes it
+    currency VARCHAR(3) DEFAULT 'USD',
+    payment_id INTEGER REFERENCES sales_payments(id),
+    invoice_id INTEGER REFERENCES sales_invoices(id),
+    refund_method VARCHAR(50),
+    reference_number VARCHAR(100),
+    notes TEXT,
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_customer_credit_ledger_customer ON sales_customer_credit_ledger(customer_id, currency);
+CREATE INDEX IF NOT EXISTS idx_sales_customer_credit_ledger_tenant ON sales_customer_credit_ledger(tenant_id);
+
+-- Carry over credit already sitting unapplied on payments
+INSERT INTO sales_customer_credit_ledger (tenant_id, customer_id, entry_type, amount, currency, payment_id, created_by)
+SELECT tenant_id, customer_id, 'overpayment', unapplied_amount, currency, id, created_by
+FROM sales_payments
+WHERE unapplied_amount > 0;
@@ -0,0 +1,17 @@
+-- Rollback credit notes
+
+ALTER TABLE sales_customer_credit_ledger DROP COLUMN IF EXISTS credit_note_id;
+
+ALTER TABLE sales_invoices DROP CONSTRAINT IF EXISTS sales_invoices_settled_amount_check;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS balance_due;
+ALTER TABLE sales_invoices ADD COLUMN balance_due DECIMAL(12,2)
+    GENERATED ALWAYS AS (total_amount - paid_amount) STORED;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS credited_amount;
+ALTER TABLE sales_invoices ADD CONSTRAINT sales_invoices_paid_amount_check
+    CHECK (paid_amount >= 0 AND paid_amount <= total_amount);
+
+ALTER TABLE sales_invoice_items DROP CONSTRAINT IF EXISTS sales_invoice_items_credited_quantity_check;
+ALTER TABLE sales_invoice_items DROP COLUMN IF EXISTS credited_quantity;
+
+DROP TABLE IF EXISTS sales_credit_note_items CASCADE;
+DROP TABLE IF EXISTS sales_credit_notes CASCADE;
@@ -0,0 +1,66 @@
+-- Credit notes
+-- A credit note reverses all or part of an invoice. Its value first reduces what is
+-- still due on the invoice; anything beyond that becomes customer credit.
+
+CREATE TABLE IF NOT EXISTS sales_credit_notes (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID,
+    credit_note_number VARCHAR(50) NOT NULL,
+    invoice_id INTEGER NOT NULL REFERENCES sales_invoices(id),
+    return_id INTEGER REFERENCES sales_returns(id),
+    customer_id INTEGER NOT NULL, -- references customers table
+    credit_date DATE NOT NULL,
+    status VARCHAR(20) DEFAULT 'issued', -- issued
+    reason VARCHAR(255),
+    subtotal DECIMAL(12,2) DEFAULT 0.00,
+    tax_amount DECIMAL(12,2) DEFAULT 0.00,
+    total_amount DECIMAL(12,2) DEFAULT 0.00,
+    applied_amount DECIMAL(12,2) DEFAULT 0.00, -- applied against invoice balances
+    unapplied_amount DECIMAL(12,2) DEFAULT 0.00, -- remaining customer credit
+    currency VARCHAR(3) DEFAULT 'USD',
+    notes TEXT,
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT sales_credit_notes_tenant_number_unique UNIQUE(tenant_id, credit_note_number),
+    CONSTRAINT sales_credit_notes_unapplied_amount_check CHECK (unapplied_amount >= 0)
+);
+
+CREATE TABLE IF NOT EXISTS sales_credit_note_items (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID,
+    credit_note_id INTEGER NOT NULL REFERENCES sales_credit_notes(id),
+    invoice_item_id INTEGER NOT NULL REFERENCES sales_invoice_items(id),
+    product_id INTEGER NOT NULL, -- references products table
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    unit_price DECIMAL(12,2) NOT NULL,
+    discount_amount DECIMAL(12,2) DEFAULT 0.00,
+    tax_amount DECIMAL(12,2) DEFAULT 0.00,
+    line_total DECIMAL(12,2) GENERATED ALWAYS AS (quantity * unit_price - discount_amount) STORED,
+    notes TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_credit_notes_invoice ON sales_credit_notes(invoice_id);
+CREATE INDEX IF NOT EXISTS idx_sales_credit_notes_customer ON sales_credit_notes(customer_id);
+CREATE INDEX IF NOT EXISTS idx_sales_credit_notes_date ON sales_credit_notes(credit_date);
+CREATE INDEX IF NOT EXISTS idx_sales_credit_notes_tenant ON sales_credit_notes(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_sales_credit_note_items_credit_note ON sales_credit_note_items(credit_note_id);
+
+-- Track how much of each invoice line has been credited
+ALTER TABLE sales_invoice_items ADD COLUMN IF NOT EXISTS credited_quantity INTEGER DEFAULT 0;
+ALTER TABLE sales_invoice_items ADD CONSTRAINT sales_invoice_items_credited_quantity_check
+    CHECK (credited_quantity >= 0 AND credited_quantity <= quantity);
+
+-- Credit applied to an invoice reduces its balance alongside payments
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS credited_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS balance_due;
+ALTER TABLE sales_invoices ADD COLUMN balance_due DECIMAL(12,2)
+    GENERATED ALWAYS AS (total_amount - paid_amount - credited_amount) STORED;
+ALTER TABLE sales_invoices DROP CONSTRAINT IF EXISTS sales_invoices_paid_amount_check;
+ALTER TABLE sales_invoices ADD CONSTRAINT sales_invoices_settled_amount_check
+    CHECK (paid_amount >= 0 AND credited_amount >= 0 AND paid_amount + credited_amount <= total_amount);
+
+ALTER TABLE sales_customer_credit_ledger ADD COLUMN IF NOT EXISTS credit_note_id INTEGER REFERENCES sales_credit_notes(id);
+
+CREATE TRIGGER update_sales_credit_notes_updated_at BEFORE UPDATE ON sales_credit_notes FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
@@ -0,0 +1,23 @@
+-- Rollback sales return workflow
+
+DROP TABLE IF EXISTS sales_restock_instructions CASCADE;
+
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS restocking_fee;
+
+DROP INDEX IF EXISTS idx_sales_returns_status;
+DROP INDEX IF EXISTS idx_sales_return_items_return;
+DROP INDEX IF EXISTS idx_sales_return_items_order_item;
+DROP INDEX IF EXISTS idx_sales_return_items_tenant;
+
+ALTER TABLE sales_return_items DROP CONSTRAINT IF EXISTS sales_return_items_quantity_check;
+ALTER TABLE sales_return_items DROP COLUMN IF EXISTS restocking_fee;
+ALTER TABLE sales_return_items DROP COLUMN IF EXISTS order_item_id;
+ALTER TABLE sales_return_items DROP COLUMN IF EXISTS tenant_id;
+
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS credit_note_id;
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS restocking_fee;
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS processed_at;
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS received_at;
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS rejection_reason;
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS rejected_at;
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS approved_at;
@@ -0,0 +1,41 @@
+-- Sales return (RMA) workflow
+-- Returns move pending -> approved/rejected -> received -> processed. Each returned line
+-- points at the order line it was shipped on, is inspected on receipt, and may carry a
+-- restocking fee that is withheld from the credit note issued when the return is processed.
+
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS approved_at TIMESTAMP;
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS rejected_at TIMESTAMP;
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS rejection_reason TEXT;
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS received_at TIMESTAMP;
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS processed_at TIMESTAMP;
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS restocking_fee DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS credit_note_id INTEGER REFERENCES sales_credit_notes(id);
+
+ALTER TABLE sales_return_items ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE sales_return_items ADD COLUMN IF NOT EXISTS order_item_id INTEGER REFERENCES sales_order_items(id);
+ALTER TABLE sales_return_items ADD COLUMN IF NOT EXISTS restocking_fee DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_return_items ADD CONSTRAINT sales_return_items_quantity_check CHECK (quantity > 0);
+
+CREATE INDEX IF NOT EXISTS idx_sales_returns_status ON sales_returns(status);
+CREATE INDEX IF NOT EXISTS idx_sales_return_items_return ON sales_return_items(return_id);
+CREATE INDEX IF NOT EXISTS idx_sales_return_items_order_item ON sales_return_items(order_item_id);
+CREATE INDEX IF NOT EXISTS idx_sales_return_items_tenant ON sales_return_items(tenant_id);
+
+-- Restocking fees withheld from a credit note
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS restocking_fee DECIMAL(12,2) DEFAULT 0.00;
+
+-- Instructions for inventory to put returned goods back on the shelf
+CREATE TABLE IF NOT EXISTS sales_restock_instructions (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID,
+    return_id INTEGER NOT NULL REFERENCES sales_returns(id),
+    return_item_id INTEGER NOT NULL REFERENCES sales_return_items(id),
+    product_id INTEGER NOT NULL, -- references products table
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    status VARCHAR(20) DEFAULT 'pending', -- pending, completed
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_restock_instructions_status ON sales_restock_instructions(status);
+CREATE INDEX IF NOT EXISTS idx_sales_restock_instructions_tenant ON sales_restock_instructions(tenant_id);
@@ -0,0 +1,18 @@
+-- Rollback tenant scoping for child rows
+-- Backfilled tenant_id values are left in place, as the row-level security policies of 000010
+-- depend on the line item columns
+
+ALTER TABLE sales_quote_items DROP CONSTRAINT IF EXISTS sales_quote_items_tenant_fk;
+ALTER TABLE sales_order_items DROP CONSTRAINT IF EXISTS sales_order_items_tenant_fk;
+ALTER TABLE sales_invoice_items DROP CONSTRAINT IF EXISTS sales_invoice_items_tenant_fk;
+ALTER TABLE sales_return_items DROP CONSTRAINT IF EXISTS sales_return_items_tenant_fk;
+
+DROP INDEX IF EXISTS idx_sales_quote_items_tenant;
+DROP INDEX IF EXISTS idx_sales_order_items_tenant;
+DROP INDEX IF EXISTS idx_sales_invoice_items_tenant;
+DROP INDEX IF EXISTS idx_sales_return_items_tenant;
+DROP INDEX IF EXISTS idx_sales_orders_tenant_date;
+DROP INDEX IF EXISTS idx_sales_quotes_tenant_date;
+DROP INDEX IF EXISTS idx_sales_invoices_tenant_date;
+DROP INDEX IF EXISTS idx_sales_payments_tenant_customer;
+DROP INDEX IF EXISTS idx_sales_returns_tenant_date;
@@ -0,0 +1,68 @@
+-- Tenant scoping for child rows
+-- Every query is now filtered by tenant, so line items and other child rows written before
+-- the module recorded tenant_id inherit it from the document they belong to
+
+-- Migration 000002 named the line item tables sales_*_lines, but they are the sales_*_items
+-- tables created by 000001. This gives the tables in use the tenant_id column 000002 meant
+-- to add, before it is backfilled below.
+ALTER TABLE sales_quote_items ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE sales_order_items ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE sales_invoice_items ADD COLUMN IF NOT EXISTS tenant_id UUID;
+ALTER TABLE sales_return_items ADD COLUMN IF NOT EXISTS tenant_id UUID;
+
+UPDATE sales_quote_items qi SET tenant_id = q.tenant_id
+FROM sales_quotes q WHERE qi.quote_id = q.id AND qi.tenant_id IS NULL;
+
+UPDATE sales_order_items oi SET tenant_id = o.tenant_id
+FROM sales_orders o WHERE oi.order_id = o.id AND oi.tenant_id IS NULL;
+
+UPDATE sales_invoice_items ii SET tenant_id = i.tenant_id
+FROM sales_invoices i WHERE ii.invoice_id = i.id AND ii.tenant_id IS NULL;
+
+UPDATE sales_return_items ri SET tenant_id = r.tenant_id
+FROM sales_returns r WHERE ri.return_id = r.id AND ri.tenant_id IS NULL;
+
+UPDATE sales_payment_allocations pa SET tenant_id = p.tenant_id
+FROM sales_payments p WHERE pa.payment_id = p.id AND pa.tenant_id IS NULL;
+
+UPDATE sales_credit_note_items ci SET tenant_id = c.tenant_id
+FROM sales_credit_notes c WHERE ci.credit_note_id = c.id AND ci.tenant_id IS NULL;
+
+UPDATE sales_restock_instructions ri SET tenant_id = r.tenant_id
+FROM sales_returns r WHERE ri.return_id = r.id AND ri.tenant_id IS NULL;
+
+-- Tenant-first indexes for the list endpoints
+CREATE INDEX IF NOT EXISTS idx_sales_orders_tenant_date ON sales_orders(tenant_id, order_date DESC);
+CREATE INDEX IF NOT EXISTS idx_sales_quotes_tenant_date ON sales_quotes(tenant_id, quote_date DESC);
+CREATE INDEX IF NOT EXISTS idx_sales_invoices_tenant_date ON sales_invoices(tenant_id, invoice_date DESC);
+CREATE INDEX IF NOT EXISTS idx_sales_payments_tenant_customer ON sales_payments(tenant_id, customer_id);
+CREATE INDEX IF NOT EXISTS idx_sales_returns_tenant_date ON sales_returns(tenant_id, return_date DESC);
+
+-- The line item index and tenants foreign key 000002 meant to add
+CREATE INDEX IF NOT EXISTS idx_sales_quote_items_tenant ON sales_quote_items(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_sales_order_items_tenant ON sales_order_items(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_sales_invoice_items_tenant ON sales_invoice_items(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_sales_return_items_tenant ON sales_return_items(tenant_id);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'sales_quote_items_tenant_fk') THEN
+        ALTER TABLE sales_quote_items ADD CONSTRAINT sales_quote_items_tenant_fk
+        FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'sales_order_items_tenant_fk') THEN
+        ALTER TABLE sales_order_items ADD CONSTRAINT sales_order_items_tenant_fk
+        FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'sales_invoice_items_tenant_fk') THEN
+        ALTER TABLE sales_invoice_items ADD CONSTRAINT sales_invoice_items_tenant_fk
+        FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'sales_return_items_tenant_fk') THEN
+        ALTER TABLE sales_return_items ADD CONSTRAINT sales_return_items_tenant_fk
+        FOREIGN KEY (tenant_id) REFERENCES tenants(id) ON DELETE CASCADE;
+    END IF;
+END $$;
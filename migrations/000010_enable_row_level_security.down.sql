@@ -0,0 +1,60 @@
+-- Rollback row-level security for sales tables
+
+DROP POLICY IF EXISTS sales_territories_tenant_isolation ON sales_territories;
+DROP POLICY IF EXISTS sales_representatives_tenant_isolation ON sales_representatives;
+DROP POLICY IF EXISTS price_lists_tenant_isolation ON price_lists;
+DROP POLICY IF EXISTS price_list_items_tenant_isolation ON price_list_items;
+DROP POLICY IF EXISTS sales_quotes_tenant_isolation ON sales_quotes;
+DROP POLICY IF EXISTS sales_quote_items_tenant_isolation ON sales_quote_items;
+DROP POLICY IF EXISTS sales_orders_tenant_isolation ON sales_orders;
+DROP POLICY IF EXISTS sales_order_items_tenant_isolation ON sales_order_items;
+DROP POLICY IF EXISTS sales_invoices_tenant_isolation ON sales_invoices;
+DROP POLICY IF EXISTS sales_invoice_items_tenant_isolation ON sales_invoice_items;
+DROP POLICY IF EXISTS sales_payments_tenant_isolation ON sales_payments;
+DROP POLICY IF EXISTS sales_payment_allocations_tenant_isolation ON sales_payment_allocations;
+DROP POLICY IF EXISTS sales_customer_credit_ledger_tenant_isolation ON sales_customer_credit_ledger;
+DROP POLICY IF EXISTS sales_credit_notes_tenant_isolation ON sales_credit_notes;
+DROP POLICY IF EXISTS sales_credit_note_items_tenant_isolation ON sales_credit_note_items;
+DROP POLICY IF EXISTS sales_returns_tenant_isolation ON sales_returns;
+DROP POLICY IF EXISTS sales_return_items_tenant_isolation ON sales_return_items;
+DROP POLICY IF EXISTS sales_restock_instructions_tenant_isolation ON sales_restock_instructions;
+DROP POLICY IF EXISTS sales_settings_tenant_isolation ON sales_settings;
+
+ALTER TABLE sales_territories NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_territories DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_representatives NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_representatives DISABLE ROW LEVEL SECURITY;
+ALTER TABLE price_lists NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE price_lists DISABLE ROW LEVEL SECURITY;
+ALTER TABLE price_list_items NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE price_list_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_quotes NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_quotes DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_quote_items NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_quote_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_orders NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_orders DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_order_items NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_order_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoices NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoices DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoice_items NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoice_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_payments NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_payments DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_payment_allocations NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_payment_allocations DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_customer_credit_ledger NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_customer_credit_ledger DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_notes NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_notes DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_note_items NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_note_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_returns NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_returns DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_return_items NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_return_items DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_restock_instructions NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_restock_instructions DISABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_settings NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_settings DISABLE ROW LEVEL SECURITY;
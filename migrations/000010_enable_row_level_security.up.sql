@@ -0,0 +1,91 @@
+-- Row-level security for sales tables
+-- Defense in depth on top of the tenant_id filters: the module binds each transaction to
+-- its tenant through the app.current_tenant setting, and rows of any other tenant are
+-- invisible and unwritable even if a query forgets its WHERE clause. FORCE applies the
+-- policies to the table owner too; maintenance that must span tenants needs a role with
+-- BYPASSRLS.
+
+-- Enable row-level security
+ALTER TABLE sales_territories ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_territories FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_representatives ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_representatives FORCE ROW LEVEL SECURITY;
+ALTER TABLE price_lists ENABLE ROW LEVEL SECURITY;
+ALTER TABLE price_lists FORCE ROW LEVEL SECURITY;
+ALTER TABLE price_list_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE price_list_items FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_quotes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_quotes FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_quote_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_quote_items FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_orders ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_orders FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_order_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_order_items FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoices ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoices FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoice_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_invoice_items FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_payments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_payments FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_payment_allocations ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_payment_allocations FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_customer_credit_ledger ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_customer_credit_ledger FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_notes ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_notes FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_note_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_credit_note_items FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_returns ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_returns FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_return_items ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_return_items FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_restock_instructions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_restock_instructions FORCE ROW LEVEL SECURITY;
+ALTER TABLE sales_settings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_settings FORCE ROW LEVEL SECURITY;
+
+-- Rows are visible and writable only by the tenant named in the session setting;
+-- an unset setting matches nothing
+CREATE POLICY sales_territories_tenant_isolation ON sales_territories
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_representatives_tenant_isolation ON sales_representatives
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY price_lists_tenant_isolation ON price_lists
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY price_list_items_tenant_isolation ON price_list_items
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_quotes_tenant_isolation ON sales_quotes
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_quote_items_tenant_isolation ON sales_quote_items
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_orders_tenant_isolation ON sales_orders
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_order_items_tenant_isolation ON sales_order_items
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_invoices_tenant_isolation ON sales_invoices
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_invoice_items_tenant_isolation ON sales_invoice_items
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_payments_tenant_isolation ON sales_payments
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_payment_allocations_tenant_isolation ON sales_payment_allocations
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_customer_credit_ledger_tenant_isolation ON sales_customer_credit_ledger
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_credit_notes_tenant_isolation ON sales_credit_notes
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_credit_note_items_tenant_isolation ON sales_credit_note_items
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_returns_tenant_isolation ON sales_returns
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_return_items_tenant_isolation ON sales_return_items
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+CREATE POLICY sales_restock_instructions_tenant_isolation ON sales_restock_instructions
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+
+-- Module-wide settings (tenant_id NULL) are readable by every tenant but only a tenant's
+-- own rows can be written
+CREATE POLICY sales_settings_tenant_isolation ON sales_settings
+    USING (tenant_id IS NULL OR tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid)
+    WITH CHECK (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
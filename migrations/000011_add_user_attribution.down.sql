@@ -0,0 +1,17 @@
+-- Rollback user attribution
+
+DROP TRIGGER IF EXISTS set_sales_quotes_updated_by ON sales_quotes;
+DROP TRIGGER IF EXISTS set_sales_orders_updated_by ON sales_orders;
+DROP TRIGGER IF EXISTS set_sales_invoices_updated_by ON sales_invoices;
+DROP TRIGGER IF EXISTS set_sales_payments_updated_by ON sales_payments;
+DROP TRIGGER IF EXISTS set_sales_returns_updated_by ON sales_returns;
+DROP TRIGGER IF EXISTS set_sales_credit_notes_updated_by ON sales_credit_notes;
+
+DROP FUNCTION IF EXISTS sales_set_updated_by();
+
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE sales_payments DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE sales_returns DROP COLUMN IF EXISTS updated_by;
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS updated_by;
@@ -0,0 +1,27 @@
+-- User attribution
+-- Documents record the user who last changed them. The module binds each transaction to
+-- the acting user through the app.current_user setting, and a trigger stamps updated_by
+-- on every insert and update, including those made by helper statements. Documents last
+-- changed before this migration keep updated_by NULL.
+
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+ALTER TABLE sales_payments ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+ALTER TABLE sales_returns ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS updated_by INTEGER;
+
+CREATE OR REPLACE FUNCTION sales_set_updated_by()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_by = COALESCE(NULLIF(current_setting('app.current_user', true), '')::INTEGER, NEW.updated_by);
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER set_sales_quotes_updated_by BEFORE INSERT OR UPDATE ON sales_quotes FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_orders_updated_by BEFORE INSERT OR UPDATE ON sales_orders FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_invoices_updated_by BEFORE INSERT OR UPDATE ON sales_invoices FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_payments_updated_by BEFORE INSERT OR UPDATE ON sales_payments FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_returns_updated_by BEFORE INSERT OR UPDATE ON sales_returns FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_credit_notes_updated_by BEFORE INSERT OR UPDATE ON sales_credit_notes FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
@@ -0,0 +1,19 @@
+-- Rollback audit trail for sales documents
+
+DROP TRIGGER IF EXISTS audit_sales_quotes ON sales_quotes;
+DROP TRIGGER IF EXISTS audit_sales_quote_items ON sales_quote_items;
+DROP TRIGGER IF EXISTS audit_sales_orders ON sales_orders;
+DROP TRIGGER IF EXISTS audit_sales_order_items ON sales_order_items;
+DROP TRIGGER IF EXISTS audit_sales_invoices ON sales_invoices;
+DROP TRIGGER IF EXISTS audit_sales_invoice_items ON sales_invoice_items;
+DROP TRIGGER IF EXISTS audit_sales_payments ON sales_payments;
+DROP TRIGGER IF EXISTS audit_sales_payment_allocations ON sales_payment_allocations;
+DROP TRIGGER IF EXISTS audit_sales_returns ON sales_returns;
+DROP TRIGGER IF EXISTS audit_sales_return_items ON sales_return_items;
+DROP TRIGGER IF EXISTS audit_sales_credit_notes ON sales_credit_notes;
+DROP TRIGGER IF EXISTS audit_sales_credit_note_items ON sales_credit_note_items;
+
+DROP FUNCTION IF EXISTS sales_audit_change();
+
+DROP TABLE IF EXISTS sales_audit_log CASCADE;
+DROP FUNCTION IF EXISTS sales_audit_log_immutable();
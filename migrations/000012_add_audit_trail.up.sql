@@ -0,0 +1,98 @@
+-- Audit trail for sales documents
+-- Every insert, update and delete of a document or one of its lines appends a row to
+-- sales_audit_log with the field-level before/after values. Triggers record the change,
+-- so statements made by helpers are captured as well as those of the handlers. The actor
+-- and request come from the app.current_user and app.request_id settings the module
+-- binds to each transaction. The log is append-only.
+
+CREATE TABLE IF NOT EXISTS sales_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id UUID NOT NULL, -- no foreign key: entries outlive the documents and tenant they describe
+    document_type VARCHAR(20) NOT NULL, -- order, quote, invoice, payment, return, credit_note
+    document_id INTEGER NOT NULL,
+    table_name VARCHAR(63) NOT NULL,
+    row_id INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL, -- create, update, status_change, delete
+    changes JSONB NOT NULL, -- {"field": {"before": ..., "after": ...}}
+    actor_id INTEGER, -- references users table
+    request_id TEXT,
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_audit_log_document ON sales_audit_log(tenant_id, document_type, document_id, id);
+
+-- Records a change to a document table. TG_ARGV[0] is the document type and TG_ARGV[1]
+-- the column holding the document's ID: id for headers, the parent key for lines.
+CREATE OR REPLACE FUNCTION sales_audit_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    old_row JSONB := '{}';
+    new_row JSONB := '{}';
+    row_data JSONB;
+    diff JSONB;
+    change_action VARCHAR(20);
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_row := to_jsonb(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_row := to_jsonb(NEW);
+    END IF;
+
+    -- Bookkeeping columns change with everything and are recorded on the row itself
+    SELECT jsonb_object_agg(k.key, jsonb_build_object('before', old_row -> k.key, 'after', new_row -> k.key))
+    INTO diff
+    FROM jsonb_object_keys(old_row || new_row) AS k(key)
+    WHERE k.key NOT IN ('updated_at', 'updated_by')
+      AND (old_row -> k.key) IS DISTINCT FROM (new_row -> k.key);
+
+    IF diff IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    change_action := CASE TG_OP
+        WHEN 'INSERT' THEN 'create'
+        WHEN 'DELETE' THEN 'delete'
+        ELSE CASE WHEN diff ? 'status' THEN 'status_change' ELSE 'update' END
+    END;
+
+    row_data := CASE WHEN TG_OP = 'DELETE' THEN old_row ELSE new_row END;
+
+    INSERT INTO sales_audit_log (tenant_id, document_type, document_id, table_name, row_id,
+                                 action, changes, actor_id, request_id)
+    VALUES ((row_data ->> 'tenant_id')::uuid, TG_ARGV[0], (row_data ->> TG_ARGV[1])::INTEGER,
+            TG_TABLE_NAME, (row_data ->> 'id')::INTEGER, change_action, diff,
+            NULLIF(current_setting('app.current_user', true), '')::INTEGER,
+            NULLIF(current_setting('app.request_id', true), ''));
+
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER audit_sales_quotes AFTER INSERT OR UPDATE OR DELETE ON sales_quotes FOR EACH ROW EXECUTE FUNCTION sales_audit_change('quote', 'id');
+CREATE TRIGGER audit_sales_quote_items AFTER INSERT OR UPDATE OR DELETE ON sales_quote_items FOR EACH ROW EXECUTE FUNCTION sales_audit_change('quote', 'quote_id');
+CREATE TRIGGER audit_sales_orders AFTER INSERT OR UPDATE OR DELETE ON sales_orders FOR EACH ROW EXECUTE FUNCTION sales_audit_change('order', 'id');
+CREATE TRIGGER audit_sales_order_items AFTER INSERT OR UPDATE OR DELETE ON sales_order_items FOR EACH ROW EXECUTE FUNCTION sales_audit_change('order', 'order_id');
+CREATE TRIGGER audit_sales_invoices AFTER INSERT OR UPDATE OR DELETE ON sales_invoices FOR EACH ROW EXECUTE FUNCTION sales_audit_change('invoice', 'id');
+CREATE TRIGGER audit_sales_invoice_items AFTER INSERT OR UPDATE OR DELETE ON sales_invoice_items FOR EACH ROW EXECUTE FUNCTION sales_audit_change('invoice', 'invoice_id');
+CREATE TRIGGER audit_sales_payments AFTER INSERT OR UPDATE OR DELETE ON sales_payments FOR EACH ROW EXECUTE FUNCTION sales_audit_change('payment', 'id');
+CREATE TRIGGER audit_sales_payment_allocations AFTER INSERT OR UPDATE OR DELETE ON sales_payment_allocations FOR EACH ROW EXECUTE FUNCTION sales_audit_change('payment', 'payment_id');
+CREATE TRIGGER audit_sales_returns AFTER INSERT OR UPDATE OR DELETE ON sales_returns FOR EACH ROW EXECUTE FUNCTION sales_audit_change('return', 'id');
+CREATE TRIGGER audit_sales_return_items AFTER INSERT OR UPDATE OR DELETE ON sales_return_items FOR EACH ROW EXECUTE FUNCTION sales_audit_change('return', 'return_id');
+CREATE TRIGGER audit_sales_credit_notes AFTER INSERT OR UPDATE OR DELETE ON sales_credit_notes FOR EACH ROW EXECUTE FUNCTION sales_audit_change('credit_note', 'id');
+CREATE TRIGGER audit_sales_credit_note_items AFTER INSERT OR UPDATE OR DELETE ON sales_credit_note_items FOR EACH ROW EXECUTE FUNCTION sales_audit_change('credit_note', 'credit_note_id');
+
+-- The log is append-only: entries can be neither changed nor removed
+CREATE OR REPLACE FUNCTION sales_audit_log_immutable()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'sales_audit_log is append-only';
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER sales_audit_log_append_only BEFORE UPDATE OR DELETE ON sales_audit_log FOR EACH ROW EXECUTE FUNCTION sales_audit_log_immutable();
+
+ALTER TABLE sales_audit_log ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_audit_log FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_audit_log_tenant_isolation ON sales_audit_log
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
@@ -0,0 +1,3 @@
+-- Rollback transactional outbox for sales domain events
+
+DROP TABLE IF EXISTS sales_outbox CASCADE;
@@ -0,0 +1,25 @@
+-- Transactional outbox for sales domain events
+-- Handlers append an event in the same transaction as the change it describes, so an
+-- event exists exactly when its change was committed. The dispatcher delivers pending
+-- events to in-process subscribers and marks them dispatched once all have accepted them;
+-- failed deliveries are retried with backoff, so subscribers may see an event twice.
+--
+-- The dispatcher works across tenants, so the outbox has no row-level security policy.
+-- Handlers only ever insert into it.
+
+CREATE TABLE IF NOT EXISTS sales_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    aggregate_type VARCHAR(20) NOT NULL,
+    aggregate_id INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    dispatched_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_outbox_pending ON sales_outbox(next_attempt_at, id) WHERE dispatched_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_sales_outbox_aggregate ON sales_outbox(tenant_id, aggregate_type, aggregate_id);
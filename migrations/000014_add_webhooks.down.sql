@@ -0,0 +1,4 @@
+-- Rollback outbound webhooks for sales domain events
+
+DROP TABLE IF EXISTS sales_webhook_deliveries CASCADE;
+DROP TABLE IF EXISTS sales_webhooks CASCADE;
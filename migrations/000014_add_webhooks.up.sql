@@ -0,0 +1,63 @@
+-- Outbound webhooks for sales domain events
+-- Tenants subscribe URLs to some or all event types. Every event recorded in the outbox
+-- becomes one delivery per matching webhook; deliveries are POSTed with an HMAC-SHA256
+-- signature over the timestamp and body, retried with exponential backoff on failure and
+-- marked dead once their attempts run out. Deliveries are kept as a log and can be
+-- replayed, which queues a copy of the original.
+--
+-- Like the outbox, deliveries are claimed by the sender across tenants, so they have no
+-- row-level security policy; handlers always filter them by tenant_id. Webhooks, which hold
+-- the signing secrets, are tenant-isolated and read by the sender under each delivery's
+-- tenant.
+
+CREATE TABLE IF NOT EXISTS sales_webhooks (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    description TEXT,
+    event_types JSONB NOT NULL DEFAULT '[]', -- empty for every event type
+    secret VARCHAR(64) NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_webhooks_tenant ON sales_webhooks(tenant_id);
+
+CREATE TABLE IF NOT EXISTS sales_webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    webhook_id INTEGER NOT NULL REFERENCES sales_webhooks(id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL REFERENCES sales_outbox(id),
+    event_type VARCHAR(50) NOT NULL,
+    url TEXT NOT NULL,
+    body JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP,
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMP,
+    replay_of BIGINT REFERENCES sales_webhook_deliveries(id),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- An event is queued once per webhook however often the outbox delivers it; replays are
+-- extra copies
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sales_webhook_deliveries_event
+    ON sales_webhook_deliveries(webhook_id, event_id) WHERE replay_of IS NULL;
+CREATE INDEX IF NOT EXISTS idx_sales_webhook_deliveries_due
+    ON sales_webhook_deliveries(next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_sales_webhook_deliveries_webhook
+    ON sales_webhook_deliveries(tenant_id, webhook_id, id DESC);
+
+CREATE TRIGGER update_sales_webhooks_updated_at BEFORE UPDATE ON sales_webhooks FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER set_sales_webhooks_updated_by BEFORE INSERT OR UPDATE ON sales_webhooks FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+
+ALTER TABLE sales_webhooks ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_webhooks FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_webhooks_tenant_isolation ON sales_webhooks
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
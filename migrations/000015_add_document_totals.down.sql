@@ -0,0 +1,22 @@
+-- Rollback document totals
+
+ALTER TABLE sales_invoice_items DROP COLUMN IF EXISTS tax_amount;
+ALTER TABLE sales_order_items DROP COLUMN IF EXISTS tax_amount;
+ALTER TABLE sales_quote_items DROP COLUMN IF EXISTS tax_amount;
+
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS shipping_amount;
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS document_discount_amount;
+
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS shipping_amount;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS document_discount_amount;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS document_discount_percent;
+
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS document_discount_amount;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS document_discount_percent;
+
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS tax_rate;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS shipping_amount;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS document_discount_amount;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS document_discount_percent;
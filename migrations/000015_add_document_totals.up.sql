@@ -0,0 +1,29 @@
+-- Document totals
+-- Quotes, orders and invoices carry a document-level discount (a percentage of the
+-- subtotal or a flat amount), shipping and the tax rate their tax is worked out from.
+-- Header totals are recalculated from the lines whenever a line changes:
+--   total_amount = subtotal - document_discount_amount + shipping_amount + tax_amount
+-- A NULL tax_rate keeps tax_amount as entered and shares it across the lines.
+
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS document_discount_percent DECIMAL(5,2) DEFAULT 0.00;
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS document_discount_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS shipping_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS tax_rate DECIMAL(7,4);
+
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS document_discount_percent DECIMAL(5,2) DEFAULT 0.00;
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS document_discount_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS tax_rate DECIMAL(7,4);
+
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS document_discount_percent DECIMAL(5,2) DEFAULT 0.00;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS document_discount_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS shipping_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS tax_rate DECIMAL(7,4);
+
+-- Credit notes reverse their share of the invoice's document discount and shipping
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS document_discount_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS shipping_amount DECIMAL(12,2) DEFAULT 0.00;
+
+-- The tax worked out for each line
+ALTER TABLE sales_quote_items ADD COLUMN IF NOT EXISTS tax_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_order_items ADD COLUMN IF NOT EXISTS tax_amount DECIMAL(12,2) DEFAULT 0.00;
+ALTER TABLE sales_invoice_items ADD COLUMN IF NOT EXISTS tax_amount DECIMAL(12,2) DEFAULT 0.00;
@@ -0,0 +1,33 @@
+-- Rollback tax engine
+
+ALTER TABLE sales_invoice_items DROP COLUMN IF EXISTS taxes;
+ALTER TABLE sales_invoice_items DROP COLUMN IF EXISTS tax_category;
+ALTER TABLE sales_order_items DROP COLUMN IF EXISTS taxes;
+ALTER TABLE sales_order_items DROP COLUMN IF EXISTS tax_category;
+ALTER TABLE sales_quote_items DROP COLUMN IF EXISTS taxes;
+ALTER TABLE sales_quote_items DROP COLUMN IF EXISTS tax_category;
+
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS prices_include_tax;
+
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS ship_to_postal_code;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS ship_to_city;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS ship_to_region;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS ship_to_country;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS prices_include_tax;
+
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS ship_to_postal_code;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS ship_to_city;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS ship_to_region;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS ship_to_country;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS prices_include_tax;
+
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS ship_to_postal_code;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS ship_to_city;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS ship_to_region;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS ship_to_country;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS prices_include_tax;
+
+DROP TABLE IF EXISTS sales_product_tax_categories CASCADE;
+DROP TABLE IF EXISTS sales_tax_exemptions CASCADE;
+DROP TABLE IF EXISTS sales_tax_rates CASCADE;
+DROP TABLE IF EXISTS sales_tax_jurisdictions CASCADE;
@@ -0,0 +1,135 @@
+-- Tax engine
+-- Tax is determined from the ship-to address of a document. Every active jurisdiction the
+-- address falls in applies (a country, a state within it, a city or a range of postal
+-- codes), each with the rates in force on the document date. A jurisdiction's rates for a
+-- product's tax category replace its general rates (tax_category NULL) for that product.
+-- Compound rates are charged on the line value plus the taxes applied before them, in
+-- priority order. A customer's exemption certificate, while valid, exempts them from the
+-- rates of its jurisdiction, or of every jurisdiction when it names none.
+--
+-- Documents priced with tax included carry prices_include_tax; their line totals include
+-- the tax, which is then worked back out of them. Each line stores its tax breakdown.
+
+CREATE TABLE IF NOT EXISTS sales_tax_jurisdictions (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    code VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    country VARCHAR(2) NOT NULL, -- ISO 3166-1 alpha-2
+    region VARCHAR(100), -- state or province; NULL for the whole country
+    city VARCHAR(100), -- NULL for the whole region
+    postal_code_prefix VARCHAR(20), -- NULL for every postal code
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER,
+    UNIQUE (tenant_id, code)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_tax_jurisdictions_country ON sales_tax_jurisdictions(tenant_id, country);
+
+CREATE TABLE IF NOT EXISTS sales_tax_rates (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    jurisdiction_id INTEGER NOT NULL REFERENCES sales_tax_jurisdictions(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    tax_category VARCHAR(50), -- NULL for products without a rate of their own
+    rate DECIMAL(7,4) NOT NULL CHECK (rate >= 0),
+    is_compound BOOLEAN NOT NULL DEFAULT false,
+    priority INTEGER NOT NULL DEFAULT 0,
+    valid_from DATE NOT NULL,
+    valid_to DATE, -- NULL while in force
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER,
+    CHECK (valid_to IS NULL OR valid_to >= valid_from)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_tax_rates_jurisdiction ON sales_tax_rates(tenant_id, jurisdiction_id);
+
+CREATE TABLE IF NOT EXISTS sales_tax_exemptions (
+    id SERIAL PRIMARY KEY,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    customer_id INTEGER NOT NULL, -- references customers table
+    certificate_number VARCHAR(100) NOT NULL,
+    jurisdiction_id INTEGER REFERENCES sales_tax_jurisdictions(id) ON DELETE CASCADE, -- NULL for every jurisdiction
+    reason TEXT,
+    valid_from DATE NOT NULL,
+    expires_on DATE, -- NULL if it does not expire
+    created_by INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER,
+    CHECK (expires_on IS NULL OR expires_on >= valid_from)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_tax_exemptions_customer ON sales_tax_exemptions(tenant_id, customer_id);
+
+-- The tax category of each product, for the products whose tax differs from the general rates
+CREATE TABLE IF NOT EXISTS sales_product_tax_categories (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    product_id INTEGER NOT NULL, -- references products table
+    tax_category VARCHAR(50) NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER,
+    PRIMARY KEY (tenant_id, product_id)
+);
+
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS ship_to_country VARCHAR(2);
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS ship_to_region VARCHAR(100);
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS ship_to_city VARCHAR(100);
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS ship_to_postal_code VARCHAR(20);
+
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS ship_to_country VARCHAR(2);
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS ship_to_region VARCHAR(100);
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS ship_to_city VARCHAR(100);
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS ship_to_postal_code VARCHAR(20);
+
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS ship_to_country VARCHAR(2);
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS ship_to_region VARCHAR(100);
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS ship_to_city VARCHAR(100);
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS ship_to_postal_code VARCHAR(20);
+
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS prices_include_tax BOOLEAN NOT NULL DEFAULT false;
+
+-- tax_category overrides the product's category for one line
+ALTER TABLE sales_quote_items ADD COLUMN IF NOT EXISTS tax_category VARCHAR(50);
+ALTER TABLE sales_quote_items ADD COLUMN IF NOT EXISTS taxes JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE sales_order_items ADD COLUMN IF NOT EXISTS tax_category VARCHAR(50);
+ALTER TABLE sales_order_items ADD COLUMN IF NOT EXISTS taxes JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE sales_invoice_items ADD COLUMN IF NOT EXISTS tax_category VARCHAR(50);
+ALTER TABLE sales_invoice_items ADD COLUMN IF NOT EXISTS taxes JSONB NOT NULL DEFAULT '[]';
+
+CREATE TRIGGER update_sales_tax_jurisdictions_updated_at BEFORE UPDATE ON sales_tax_jurisdictions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_sales_tax_rates_updated_at BEFORE UPDATE ON sales_tax_rates FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_sales_tax_exemptions_updated_at BEFORE UPDATE ON sales_tax_exemptions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER update_sales_product_tax_categories_updated_at BEFORE UPDATE ON sales_product_tax_categories FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+CREATE TRIGGER set_sales_tax_jurisdictions_updated_by BEFORE INSERT OR UPDATE ON sales_tax_jurisdictions FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_tax_rates_updated_by BEFORE INSERT OR UPDATE ON sales_tax_rates FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_tax_exemptions_updated_by BEFORE INSERT OR UPDATE ON sales_tax_exemptions FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+CREATE TRIGGER set_sales_product_tax_categories_updated_by BEFORE INSERT OR UPDATE ON sales_product_tax_categories FOR EACH ROW EXECUTE FUNCTION sales_set_updated_by();
+
+ALTER TABLE sales_tax_jurisdictions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_tax_jurisdictions FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_tax_jurisdictions_tenant_isolation ON sales_tax_jurisdictions
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+
+ALTER TABLE sales_tax_rates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_tax_rates FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_tax_rates_tenant_isolation ON sales_tax_rates
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+
+ALTER TABLE sales_tax_exemptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_tax_exemptions FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_tax_exemptions_tenant_isolation ON sales_tax_exemptions
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
+
+ALTER TABLE sales_product_tax_categories ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_product_tax_categories FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_product_tax_categories_tenant_isolation ON sales_product_tax_categories
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
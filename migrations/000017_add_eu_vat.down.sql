@@ -0,0 +1,19 @@
+-- Rollback EU VAT
+
+DROP INDEX IF EXISTS idx_sales_invoices_vat;
+
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS vat_treatment;
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS buyer_vat_id;
+ALTER TABLE sales_credit_notes DROP COLUMN IF EXISTS seller_vat_id;
+
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS vat_treatment;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS buyer_vat_id;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS seller_vat_id;
+
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS vat_treatment;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS buyer_vat_id;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS seller_vat_id;
+
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS vat_treatment;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS buyer_vat_id;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS seller_vat_id;
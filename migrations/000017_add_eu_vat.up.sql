@@ -0,0 +1,33 @@
+-- EU VAT
+-- A seller registered for VAT in an EU member state (the seller_vat_id setting) records
+-- its VAT ID and the buyer's on each document, and the VAT treatment determined when the
+-- document is created:
+--   domestic        buyer in the seller's member state
+--   reverse_charge  business buyer with a VAT ID of another member state; no VAT charged
+--   oss             consumer in another member state, taxed at its rates (One Stop Shop)
+--   distance_sale   consumer in another member state, taxed at the seller's rates
+--   export          goods leaving the EU; no VAT charged
+-- The treatment is NULL for sellers without an EU VAT ID.
+
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS seller_vat_id VARCHAR(20);
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS buyer_vat_id VARCHAR(20);
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS vat_treatment VARCHAR(20)
+    CHECK (vat_treatment IN ('domestic', 'reverse_charge', 'oss', 'distance_sale', 'export'));
+
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS seller_vat_id VARCHAR(20);
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS buyer_vat_id VARCHAR(20);
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS vat_treatment VARCHAR(20)
+    CHECK (vat_treatment IN ('domestic', 'reverse_charge', 'oss', 'distance_sale', 'export'));
+
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS seller_vat_id VARCHAR(20);
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS buyer_vat_id VARCHAR(20);
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS vat_treatment VARCHAR(20)
+    CHECK (vat_treatment IN ('domestic', 'reverse_charge', 'oss', 'distance_sale', 'export'));
+
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS seller_vat_id VARCHAR(20);
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS buyer_vat_id VARCHAR(20);
+ALTER TABLE sales_credit_notes ADD COLUMN IF NOT EXISTS vat_treatment VARCHAR(20)
+    CHECK (vat_treatment IN ('domestic', 'reverse_charge', 'oss', 'distance_sale', 'export'));
+
+-- The VAT summary report groups issued invoices by treatment and country
+CREATE INDEX IF NOT EXISTS idx_sales_invoices_vat ON sales_invoices(tenant_id, invoice_date, vat_treatment, ship_to_country);
@@ -0,0 +1,3 @@
+-- Rollback document numbering
+
+DROP TABLE IF EXISTS sales_number_sequences;
@@ -0,0 +1,24 @@
+-- Document numbering
+-- Each tenant numbers each document type from its own sequence, formatted by the type's
+-- number pattern setting. A pattern with the year or month restarts its sequence every
+-- year or month, counted here per period ('' for a sequence that never restarts).
+--
+-- A number is allocated by advancing the counter inside the transaction that creates the
+-- document, which locks the row until it commits: documents of a type are numbered one at
+-- a time, and the number of a document rolled back is reused, leaving no gaps.
+
+CREATE TABLE IF NOT EXISTS sales_number_sequences (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    document_type VARCHAR(20) NOT NULL
+        CHECK (document_type IN ('quote', 'order', 'invoice', 'credit_note', 'payment', 'return')),
+    period VARCHAR(7) NOT NULL DEFAULT '', -- YYYY or YYYY-MM
+    last_number BIGINT NOT NULL CHECK (last_number > 0),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, document_type, period)
+);
+
+ALTER TABLE sales_number_sequences ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_number_sequences FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_number_sequences_tenant_isolation ON sales_number_sequences
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
@@ -0,0 +1,3 @@
+-- Rollback idempotency keys
+
+DROP TABLE IF EXISTS sales_idempotency_keys;
@@ -0,0 +1,25 @@
+-- Idempotency keys
+-- A client retrying a create request sends the same Idempotency-Key header. The first
+-- successful response to the key is kept here until expires_at (the idempotency_window_hours
+-- setting), and replayed to retries whose method, path and body hash to the same
+-- request_hash. Keys are scoped to the user who sent them.
+
+CREATE TABLE IF NOT EXISTS sales_idempotency_keys (
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL, -- SHA-256 of method, path and body
+    status_code INTEGER, -- NULL until the request completes
+    content_type VARCHAR(100),
+    response_body BYTEA,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, user_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sales_idempotency_keys_expiry ON sales_idempotency_keys(tenant_id, expires_at);
+
+ALTER TABLE sales_idempotency_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE sales_idempotency_keys FORCE ROW LEVEL SECURITY;
+CREATE POLICY sales_idempotency_keys_tenant_isolation ON sales_idempotency_keys
+    USING (tenant_id = NULLIF(current_setting('app.current_tenant', true), '')::uuid);
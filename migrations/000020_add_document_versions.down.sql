@@ -0,0 +1,59 @@
+-- Rollback optimistic concurrency
+
+CREATE OR REPLACE FUNCTION sales_audit_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    old_row JSONB := '{}';
+    new_row JSONB := '{}';
+    row_data JSONB;
+    diff JSONB;
+    change_action VARCHAR(20);
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_row := to_jsonb(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_row := to_jsonb(NEW);
+    END IF;
+
+    -- Bookkeeping columns change with everything and are recorded on the row itself
+    SELECT jsonb_object_agg(k.key, jsonb_build_object('before', old_row -> k.key, 'after', new_row -> k.key))
+    INTO diff
+    FROM jsonb_object_keys(old_row || new_row) AS k(key)
+    WHERE k.key NOT IN ('updated_at', 'updated_by')
+      AND (old_row -> k.key) IS DISTINCT FROM (new_row -> k.key);
+
+    IF diff IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    change_action := CASE TG_OP
+        WHEN 'INSERT' THEN 'create'
+        WHEN 'DELETE' THEN 'delete'
+        ELSE CASE WHEN diff ? 'status' THEN 'status_change' ELSE 'update' END
+    END;
+
+    row_data := CASE WHEN TG_OP = 'DELETE' THEN old_row ELSE new_row END;
+
+    INSERT INTO sales_audit_log (tenant_id, document_type, document_id, table_name, row_id,
+                                 action, changes, actor_id, request_id)
+    VALUES ((row_data ->> 'tenant_id')::uuid, TG_ARGV[0], (row_data ->> TG_ARGV[1])::INTEGER,
+            TG_TABLE_NAME, (row_data ->> 'id')::INTEGER, change_action, diff,
+            NULLIF(current_setting('app.current_user', true), '')::INTEGER,
+            NULLIF(current_setting('app.request_id', true), ''));
+
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS set_sales_orders_version ON sales_orders;
+DROP TRIGGER IF EXISTS set_sales_quotes_version ON sales_quotes;
+DROP TRIGGER IF EXISTS set_sales_invoices_version ON sales_invoices;
+DROP TRIGGER IF EXISTS set_price_lists_version ON price_lists;
+
+DROP FUNCTION IF EXISTS sales_bump_version();
+
+ALTER TABLE price_lists DROP COLUMN IF EXISTS version;
+ALTER TABLE sales_invoices DROP COLUMN IF EXISTS version;
+ALTER TABLE sales_quotes DROP COLUMN IF EXISTS version;
+ALTER TABLE sales_orders DROP COLUMN IF EXISTS version;
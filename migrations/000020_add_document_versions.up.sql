@@ -0,0 +1,75 @@
+-- Optimistic concurrency
+-- Orders, quotes, invoices and price lists carry a version, raised by one whenever their
+-- row changes. It is served as the ETag of the document, and an update must name the
+-- version it was made against (If-Match); a document changed in between is not updated.
+-- Writes that leave the row as it was, bookkeeping columns aside, keep the version.
+
+ALTER TABLE sales_orders ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE sales_quotes ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE sales_invoices ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE price_lists ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION sales_bump_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF to_jsonb(NEW) - 'updated_at' - 'updated_by' - 'version'
+       IS DISTINCT FROM to_jsonb(OLD) - 'updated_at' - 'updated_by' - 'version' THEN
+        NEW.version = OLD.version + 1;
+    ELSE
+        NEW.version = OLD.version;
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER set_sales_orders_version BEFORE UPDATE ON sales_orders FOR EACH ROW EXECUTE FUNCTION sales_bump_version();
+CREATE TRIGGER set_sales_quotes_version BEFORE UPDATE ON sales_quotes FOR EACH ROW EXECUTE FUNCTION sales_bump_version();
+CREATE TRIGGER set_sales_invoices_version BEFORE UPDATE ON sales_invoices FOR EACH ROW EXECUTE FUNCTION sales_bump_version();
+CREATE TRIGGER set_price_lists_version BEFORE UPDATE ON price_lists FOR EACH ROW EXECUTE FUNCTION sales_bump_version();
+
+-- The version changes with everything, like updated_at, and is left out of the audit trail
+CREATE OR REPLACE FUNCTION sales_audit_change()
+RETURNS TRIGGER AS $$
+DECLARE
+    old_row JSONB := '{}';
+    new_row JSONB := '{}';
+    row_data JSONB;
+    diff JSONB;
+    change_action VARCHAR(20);
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        old_row := to_jsonb(OLD);
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        new_row := to_jsonb(NEW);
+    END IF;
+
+    -- Bookkeeping columns change with everything and are recorded on the row itself
+    SELECT jsonb_object_agg(k.key, jsonb_build_object('before', old_row -> k.key, 'after', new_row -> k.key))
+    INTO diff
+    FROM jsonb_object_keys(old_row || new_row) AS k(key)
+    WHERE k.key NOT IN ('updated_at', 'updated_by', 'version')
+      AND (old_row -> k.key) IS DISTINCT FROM (new_row -> k.key);
+
+    IF diff IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    change_action := CASE TG_OP
+        WHEN 'INSERT' THEN 'create'
+        WHEN 'DELETE' THEN 'delete'
+        ELSE CASE WHEN diff ? 'status' THEN 'status_change' ELSE 'update' END
+    END;
+
+    row_data := CASE WHEN TG_OP = 'DELETE' THEN old_row ELSE new_row END;
+
+    INSERT INTO sales_audit_log (tenant_id, document_type, document_id, table_name, row_id,
+                                 action, changes, actor_id, request_id)
+    VALUES ((row_data ->> 'tenant_id')::uuid, TG_ARGV[0], (row_data ->> TG_ARGV[1])::INTEGER,
+            TG_TABLE_NAME, (row_data ->> 'id')::INTEGER, change_action, diff,
+            NULLIF(current_setting('app.current_user', true), '')::INTEGER,
+            NULLIF(current_setting('app.request_id', true), ''));
+
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
@@ -0,0 +1,3 @@
+-- Rollback headers of idempotent responses
+
+ALTER TABLE sales_idempotency_keys DROP COLUMN IF EXISTS response_headers;
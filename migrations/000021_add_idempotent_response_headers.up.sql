@@ -0,0 +1,5 @@
+-- Headers of idempotent responses
+-- A replayed response carries the Location and ETag headers of the original along with its
+-- Content-Type, kept here as a JSON object of header name to value
+
+ALTER TABLE sales_idempotency_keys ADD COLUMN IF NOT EXISTS response_headers JSONB;
@@ -0,0 +1,3 @@
+-- Rollback deliveries of outbox events
+
+DROP TABLE IF EXISTS sales_outbox_deliveries;
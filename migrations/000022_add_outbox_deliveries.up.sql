@@ -0,0 +1,14 @@
+-- Deliveries of outbox events to each subscriber
+-- The dispatcher records every subscriber that accepts an event, so when another fails and
+-- the event is retried only the subscribers that have not accepted it see it again. The
+-- event is marked dispatched once every subscriber has.
+--
+-- Like the outbox it is read across tenants by the dispatcher and has no row-level
+-- security policy.
+
+CREATE TABLE IF NOT EXISTS sales_outbox_deliveries (
+    event_id BIGINT NOT NULL REFERENCES sales_outbox(id) ON DELETE CASCADE,
+    subscriber VARCHAR(100) NOT NULL,
+    delivered_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_id, subscriber)
+);